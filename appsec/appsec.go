@@ -13,7 +13,9 @@ package appsec
 
 import (
 	"context"
+	"net/http"
 
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/appsec"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/appsec/dyngo/instrumentation/httpsec"
 )
@@ -30,3 +32,39 @@ func MonitorParsedHTTPBody(ctx context.Context, body interface{}) {
 	}
 	// bonus: use sync.Once to log a debug message once if AppSec is disabled
 }
+
+// MonitorHTTPBody reads and parses the body of the given HTTP request
+// according to its content type, and runs the security monitoring rules on
+// the parsed body. JSON, URL-encoded and multipart form bodies are supported,
+// and the bodies of the other content types are ignored. The request body is
+// restored so that it can still be read by the handler. To avoid reading
+// unbounded bodies, those larger than maxSize bytes, or 1MB when maxSize is
+// not positive, are not monitored and an error is returned. Calls to this
+// function are ignored if AppSec is disabled, or if the request is not
+// monitored by an HTTP middleware function.
+func MonitorHTTPBody(r *http.Request, maxSize int64) error {
+	if !appsec.Enabled() {
+		return nil
+	}
+	return httpsec.MonitorBody(r, maxSize)
+}
+
+// SetUser associates the given user information, such as its email or role,
+// to the service entry span of the given context, so that the security
+// signals of the request can be attributed to the user. It is the root span of
+// the trace the span of the given context belongs to, and the options are the
+// ones of tracer.SetUser(). When AppSec is enabled, the user id is also
+// monitored by the security rules of the request, so that the requests of
+// known attackers can be detected. The given context must be the HTTP request
+// context as returned by the Context() method of an HTTP request for the user
+// id to be monitored.
+func SetUser(ctx context.Context, id string, opts ...tracer.UserMonitoringOption) {
+	span := getRootSpan(ctx)
+	if span == nil {
+		return
+	}
+	tracer.SetUser(span, id, opts...)
+	if appsec.Enabled() {
+		httpsec.MonitorUser(ctx, id)
+	}
+}
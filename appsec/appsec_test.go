@@ -0,0 +1,42 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2022 Datadog, Inc.
+
+package appsec_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"gopkg.in/DataDog/dd-trace-go.v1/appsec"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/mocktracer"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+)
+
+func TestSetUser(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	root, ctx := tracer.StartSpanFromContext(context.Background(), "http.request")
+	child, ctx := tracer.StartSpanFromContext(ctx, "auth")
+	appsec.SetUser(ctx, "user id", tracer.WithUserEmail("user@example.com"), tracer.WithUserRole("admin"))
+	child.Finish()
+	root.Finish()
+
+	spans := mt.FinishedSpans()
+	assert.Len(t, spans, 2)
+	assert.NotContains(t, spans[0].Tags(), "usr.id")
+	tags := spans[1].Tags()
+	assert.Equal(t, "user id", tags["usr.id"])
+	assert.Equal(t, "user@example.com", tags["usr.email"])
+	assert.Equal(t, "admin", tags["usr.role"])
+
+	t.Run("no-span", func(t *testing.T) {
+		assert.NotPanics(t, func() {
+			appsec.SetUser(context.Background(), "user id")
+		})
+	})
+}
@@ -0,0 +1,76 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2022 Datadog, Inc.
+
+package appsec
+
+import (
+	"context"
+	"strconv"
+
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+	"gopkg.in/DataDog/dd-trace-go.v1/internal/log"
+)
+
+// TrackUserLoginSuccessEvent sets a successful user login event, with the given
+// user id and optional metadata, as service entry span tags. It also calls
+// tracer.SetUser() to set the currently authenticated user, along with the
+// given tracer.UserMonitoringOption options.
+// The service entry span is the root span of the trace the span of the given
+// context belongs to, such as the span of the HTTP request being handled.
+func TrackUserLoginSuccessEvent(ctx context.Context, uid string, md map[string]string, opts ...tracer.UserMonitoringOption) {
+	span := getRootSpan(ctx)
+	if span == nil {
+		return
+	}
+	TrackCustomEvent(ctx, "users.login.success", md)
+	tracer.SetUser(span, uid, opts...)
+}
+
+// TrackUserLoginFailureEvent sets a failed user login event, with the given
+// user id and optional metadata, as service entry span tags. The exists
+// argument allows to distinguish whether the given user id actually exists or
+// not.
+func TrackUserLoginFailureEvent(ctx context.Context, uid string, exists bool, md map[string]string) {
+	span := getRootSpan(ctx)
+	if span == nil {
+		return
+	}
+	const tagPrefix = "appsec.events.users.login.failure."
+	span.SetTag(tagPrefix+"usr.id", uid)
+	span.SetTag(tagPrefix+"usr.exists", strconv.FormatBool(exists))
+	TrackCustomEvent(ctx, "users.login.failure", md)
+}
+
+// TrackCustomEvent sets a custom event, with the given name and optional
+// metadata, as service entry span tags, so that the backend can correlate the
+// business events of the application, such as the password resets, with its
+// security signals. The trace is kept to make sure the event is reported.
+func TrackCustomEvent(ctx context.Context, name string, md map[string]string) {
+	span := getRootSpan(ctx)
+	if span == nil {
+		return
+	}
+	tagPrefix := "appsec.events." + name + "."
+	span.SetTag(tagPrefix+"track", "true")
+	span.SetTag(ext.SamplingPriority, ext.PriorityUserKeep)
+	for k, v := range md {
+		span.SetTag(tagPrefix+k, v)
+	}
+}
+
+// getRootSpan returns the root span of the trace the span of ctx belongs to,
+// or nil if ctx has no span.
+func getRootSpan(ctx context.Context) tracer.Span {
+	span, ok := tracer.SpanFromContext(ctx)
+	if !ok {
+		log.Error("appsec: could not find a span in the given context to track the event")
+		return nil
+	}
+	if r, ok := span.(interface{ Root() tracer.Span }); ok {
+		return r.Root()
+	}
+	return span
+}
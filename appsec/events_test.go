@@ -0,0 +1,87 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2022 Datadog, Inc.
+
+package appsec_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"gopkg.in/DataDog/dd-trace-go.v1/appsec"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/mocktracer"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+)
+
+func TestTrackUserLoginSuccessEvent(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	root, ctx := tracer.StartSpanFromContext(context.Background(), "http.request")
+	child, ctx := tracer.StartSpanFromContext(ctx, "login")
+	appsec.TrackUserLoginSuccessEvent(ctx, "user id", map[string]string{"region": "us-east-1"}, tracer.WithUserName("username"))
+	child.Finish()
+	root.Finish()
+
+	spans := mt.FinishedSpans()
+	assert.Len(t, spans, 2)
+	// the event is tracked on the root span
+	assert.Empty(t, spans[0].Tag("appsec.events.users.login.success.track"))
+	tags := spans[1].Tags()
+	assert.Equal(t, "true", tags["appsec.events.users.login.success.track"])
+	assert.Equal(t, "us-east-1", tags["appsec.events.users.login.success.region"])
+	assert.Equal(t, ext.PriorityUserKeep, tags[ext.SamplingPriority])
+	assert.Equal(t, "user id", tags["usr.id"])
+	assert.Equal(t, "username", tags["usr.name"])
+}
+
+func TestTrackUserLoginFailureEvent(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	for _, exists := range []bool{true, false} {
+		span, ctx := tracer.StartSpanFromContext(context.Background(), "http.request")
+		appsec.TrackUserLoginFailureEvent(ctx, "user id", exists, map[string]string{"reason": "bad password"})
+		span.Finish()
+	}
+
+	spans := mt.FinishedSpans()
+	assert.Len(t, spans, 2)
+	for i, exists := range []string{"true", "false"} {
+		tags := spans[i].Tags()
+		assert.Equal(t, "true", tags["appsec.events.users.login.failure.track"])
+		assert.Equal(t, "user id", tags["appsec.events.users.login.failure.usr.id"])
+		assert.Equal(t, exists, tags["appsec.events.users.login.failure.usr.exists"])
+		assert.Equal(t, "bad password", tags["appsec.events.users.login.failure.reason"])
+		assert.Equal(t, ext.PriorityUserKeep, tags[ext.SamplingPriority])
+		// a failed login doesn't authenticate the user
+		assert.NotContains(t, tags, "usr.id")
+	}
+}
+
+func TestTrackCustomEvent(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	t.Run("span", func(t *testing.T) {
+		span, ctx := tracer.StartSpanFromContext(context.Background(), "http.request")
+		appsec.TrackCustomEvent(ctx, "password.reset", map[string]string{"method": "email"})
+		span.Finish()
+
+		spans := mt.FinishedSpans()
+		assert.Len(t, spans, 1)
+		tags := spans[0].Tags()
+		assert.Equal(t, "true", tags["appsec.events.password.reset.track"])
+		assert.Equal(t, "email", tags["appsec.events.password.reset.method"])
+	})
+
+	t.Run("no-span", func(t *testing.T) {
+		assert.NotPanics(t, func() {
+			appsec.TrackCustomEvent(context.Background(), "password.reset", nil)
+		})
+	})
+}
@@ -6,6 +6,8 @@
 package sarama
 
 import (
+	"strings"
+
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
 
 	"github.com/Shopify/sarama"
@@ -47,6 +49,43 @@ func (c ProducerMessageCarrier) Set(key, val string) {
 	})
 }
 
+// datadogHeaderPrefix is the prefix of the Datadog trace propagation headers.
+const datadogHeaderPrefix = "x-datadog-"
+
+// resetDatadogHeaders removes the Datadog trace propagation headers and the
+// baggage from the message, so that injecting a span context overwrites the ones
+// carried over from upstream instead of accumulating them on re-produced messages.
+func (c ProducerMessageCarrier) resetDatadogHeaders() {
+	headers := c.msg.Headers[:0]
+	for _, h := range c.msg.Headers {
+		key := strings.ToLower(string(h.Key))
+		if strings.HasPrefix(key, datadogHeaderPrefix) || strings.HasPrefix(key, tracer.DefaultBaggageHeaderPrefix) {
+			continue
+		}
+		headers = append(headers, h)
+	}
+	c.msg.Headers = headers
+}
+
+// hasDuplicateDatadogHeaders reports whether the message carries any of the
+// Datadog trace propagation headers more than once, as accumulated by buggy
+// upstreams re-producing messages, in which case the span context they hold
+// is ambiguous.
+func (c ProducerMessageCarrier) hasDuplicateDatadogHeaders() bool {
+	seen := make(map[string]bool)
+	for _, h := range c.msg.Headers {
+		key := strings.ToLower(string(h.Key))
+		if !strings.HasPrefix(key, datadogHeaderPrefix) {
+			continue
+		}
+		if seen[key] {
+			return true
+		}
+		seen[key] = true
+	}
+	return false
+}
+
 // NewProducerMessageCarrier creates a new ProducerMessageCarrier.
 func NewProducerMessageCarrier(msg *sarama.ProducerMessage) ProducerMessageCarrier {
 	return ProducerMessageCarrier{msg}
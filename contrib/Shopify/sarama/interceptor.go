@@ -0,0 +1,72 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2022 Datadog, Inc.
+
+package sarama
+
+import (
+	"gopkg.in/DataDog/dd-trace-go.v1/internal/log"
+
+	"github.com/Shopify/sarama"
+)
+
+// ProducerInterceptor traces the messages produced by the producers it is set
+// as an interceptor of, implementing the sarama.ProducerInterceptor interface
+// of sarama v1.26.0 and above, as an alternative to wrapping the producers.
+type ProducerInterceptor struct {
+	cfg *config
+}
+
+// NewProducerInterceptor returns a ProducerInterceptor to be added to the
+// Producer.Interceptors of a sarama.Config. As the interceptors are not notified
+// of the outcome of the sends, the produce spans are finished once the messages
+// are handed to the producer, and are not tagged with their partition, offset or
+// error; WrapSyncProducer and WrapAsyncProducer should be used when they are
+// needed.
+func NewProducerInterceptor(opts ...Option) *ProducerInterceptor {
+	cfg := new(config)
+	defaults(cfg)
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	log.Debug("contrib/Shopify/sarama: Creating Producer Interceptor: %#v", cfg)
+	return &ProducerInterceptor{cfg: cfg}
+}
+
+// OnSend implements sarama.ProducerInterceptor. It starts and finishes a produce
+// span for msg, injecting its context in the headers of msg.
+func (i *ProducerInterceptor) OnSend(msg *sarama.ProducerMessage) {
+	// the interceptors are supported by the versions of sarama supporting the
+	// headers, whose encoding is left to the version of the cluster
+	startProducerSpan(i.cfg, sarama.V0_11_0_0, msg).Finish()
+}
+
+// ConsumerInterceptor traces the messages consumed by the consumers it is set
+// as an interceptor of, implementing the sarama.ConsumerInterceptor interface
+// of sarama v1.26.0 and above, as an alternative to wrapping the consumers.
+type ConsumerInterceptor struct {
+	cfg *config
+}
+
+// NewConsumerInterceptor returns a ConsumerInterceptor to be added to the
+// Consumer.Interceptors of a sarama.Config. As the interceptors are not notified
+// of the end of the processing of the messages, the consume spans are finished
+// once the messages are received. Unless disabled with WithReinjectContext,
+// their context is injected in the headers of the messages, to continue the
+// traces from with tracer.Extract and a NewConsumerMessageCarrier.
+func NewConsumerInterceptor(opts ...Option) *ConsumerInterceptor {
+	cfg := new(config)
+	defaults(cfg)
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	log.Debug("contrib/Shopify/sarama: Creating Consumer Interceptor: %#v", cfg)
+	return &ConsumerInterceptor{cfg: cfg}
+}
+
+// OnConsume implements sarama.ConsumerInterceptor. It starts and finishes a
+// consume span for msg, child of the span context found in its headers.
+func (i *ConsumerInterceptor) OnConsume(msg *sarama.ConsumerMessage) {
+	startConsumerSpan(i.cfg, msg).Finish()
+}
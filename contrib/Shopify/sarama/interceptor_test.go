@@ -0,0 +1,58 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2022 Datadog, Inc.
+
+package sarama
+
+import (
+	"testing"
+
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/mocktracer"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+)
+
+// The interceptors are called by sarama v1.26.0 and above, before sending and
+// after receiving each message, which the tests reproduce.
+func TestInterceptors(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	producer := NewProducerInterceptor(WithServiceName("producer"))
+	consumer := NewConsumerInterceptor(WithServiceName("consumer"))
+
+	pmsg := &sarama.ProducerMessage{Topic: "test-topic", Value: sarama.StringEncoder("hello")}
+	producer.OnSend(pmsg)
+
+	cmsg := &sarama.ConsumerMessage{Topic: "test-topic", Partition: 1, Offset: 2, Value: []byte("hello")}
+	for i := range pmsg.Headers {
+		cmsg.Headers = append(cmsg.Headers, &pmsg.Headers[i])
+	}
+	consumer.OnConsume(cmsg)
+
+	spans := mt.FinishedSpans()
+	assert.Len(t, spans, 2)
+	produce, consume := spans[0], spans[1]
+
+	assert.Equal(t, "kafka.produce", produce.OperationName())
+	assert.Equal(t, "producer", produce.Tag(ext.ServiceName))
+	assert.Equal(t, "Produce Topic test-topic", produce.Tag(ext.ResourceName))
+	assert.Equal(t, ext.SpanKindProducer, produce.Tag(ext.SpanKind))
+
+	assert.Equal(t, "kafka.consume", consume.OperationName())
+	assert.Equal(t, "consumer", consume.Tag(ext.ServiceName))
+	assert.Equal(t, "Consume Topic test-topic", consume.Tag(ext.ResourceName))
+	assert.Equal(t, int32(1), consume.Tag("partition"))
+	assert.Equal(t, int64(2), consume.Tag("offset"))
+	assert.Equal(t, produce.SpanID(), consume.ParentID(), "the consumer should continue the trace of the producer")
+	assert.Equal(t, produce.TraceID(), consume.TraceID())
+
+	// the context of the consume span is available to the consumer
+	spanctx, err := tracer.Extract(NewConsumerMessageCarrier(cmsg))
+	assert.NoError(t, err)
+	assert.Equal(t, consume.SpanID(), spanctx.SpanID())
+}
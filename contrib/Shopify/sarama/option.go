@@ -7,20 +7,36 @@ package sarama
 
 import (
 	"math"
+	"os"
+	"time"
 
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/globalconfig"
+
+	"github.com/Shopify/sarama"
 )
 
 type config struct {
 	consumerServiceName string
 	producerServiceName string
 	analyticsRate       float64
+	dataStreamsEnabled  bool
+	reinjectContext     bool
+	measured            bool
+	env                 string
+	errCheck            func(err error) bool
+	spanNamer           func(defaultName string) string
+	maxInflightSpans    int
+	inflightSpanTimeout time.Duration
+	producerDecorator   func(span ddtrace.Span, msg *sarama.ProducerMessage)
+	consumerDecorator   func(span ddtrace.Span, msg *sarama.ConsumerMessage)
 }
 
 func defaults(cfg *config) {
 	cfg.producerServiceName = "kafka"
 	cfg.consumerServiceName = "kafka"
+	cfg.env = os.Getenv("DD_ENV")
 	if svc := globalconfig.ServiceName(); svc != "" {
 		cfg.consumerServiceName = svc
 	}
@@ -30,6 +46,11 @@ func defaults(cfg *config) {
 	} else {
 		cfg.analyticsRate = math.NaN()
 	}
+	cfg.spanNamer = func(defaultName string) string { return defaultName }
+	cfg.maxInflightSpans = 10000
+	cfg.inflightSpanTimeout = time.Minute
+	cfg.measured = true
+	cfg.reinjectContext = true
 }
 
 // An Option is used to customize the config for the sarama tracer.
@@ -65,3 +86,93 @@ func WithAnalyticsRate(rate float64) Option {
 		}
 	}
 }
+
+// WithDataStreams enables the Data Streams Monitoring pathway checkpoints:
+// produced messages carry the pathway they are part of in their headers,
+// and consumed messages have their pathway recorded on their span. The
+// latencies of the checkpoints are reported to the agent by the started tracer.
+func WithDataStreams() Option {
+	return func(cfg *config) {
+		cfg.dataStreamsEnabled = true
+	}
+}
+
+// WithMeasured sets whether the consume spans are measured, computing trace
+// metrics for them. It defaults to true; disabling it on very high-volume
+// services limits the cost of the metrics.
+func WithMeasured(on bool) Option {
+	return func(cfg *config) {
+		cfg.measured = on
+	}
+}
+
+func (cfg *config) shouldIgnoreError(err error) bool {
+	return cfg != nil && cfg.errCheck != nil && !cfg.errCheck(err)
+}
+
+// WithErrorCheck specifies a function fn which determines whether the passed
+// error should be marked as an error. The fn is called whenever producing a
+// message fails, such as with sarama.ErrMessageSizeTooLarge when it is retried
+// at a higher layer. This only affects whether the span is marked as errored,
+// the calls to the sarama API still return the upstream error.
+func WithErrorCheck(fn func(err error) bool) Option {
+	return func(cfg *config) {
+		cfg.errCheck = fn
+	}
+}
+
+// WithSpanNamer sets the function used to name the spans started by the
+// integration. It is given the default operation name of the span, such as
+// "kafka.consume" or "kafka.produce", and returns the name to use instead.
+func WithSpanNamer(namer func(defaultName string) string) Option {
+	return func(cfg *config) {
+		if namer != nil {
+			cfg.spanNamer = namer
+		}
+	}
+}
+
+// WithInflightSpans sets the maximum number of spans of an async producer
+// waiting for the outcome of their messages, beyond which the oldest are
+// finished, and the time after which a span is finished if the outcome of its
+// message wasn't returned. They default to 10000 spans and a minute. Values
+// lower or equal to zero are ignored.
+func WithInflightSpans(size int, timeout time.Duration) Option {
+	return func(cfg *config) {
+		if size > 0 {
+			cfg.maxInflightSpans = size
+		}
+		if timeout > 0 {
+			cfg.inflightSpanTimeout = timeout
+		}
+	}
+}
+
+// WithSpanDecorator sets a function called with every produce span and its
+// message once the span is started, allowing to set custom tags on the span
+// from the message, such as a tenant ID stored in a header.
+func WithSpanDecorator(decorator func(span ddtrace.Span, msg *sarama.ProducerMessage)) Option {
+	return func(cfg *config) {
+		cfg.producerDecorator = decorator
+	}
+}
+
+// WithReinjectContext sets whether the context of the consume spans is injected
+// in the headers of the consumed messages, replacing the context of their
+// producers, which is enabled by default. It lets the consumers continue the
+// traces from the messages with tracer.Extract. Disabling it leaves the headers
+// of the consumed messages unchanged, e.g. when they are forwarded as they are.
+func WithReinjectContext(on bool) Option {
+	return func(cfg *config) {
+		cfg.reinjectContext = on
+	}
+}
+
+// WithConsumerSpanDecorator sets a function called with every consume span and
+// its message once the span is started. It is the consumer equivalent of
+// WithSpanDecorator.
+func WithConsumerSpanDecorator(decorator func(span ddtrace.Span, msg *sarama.ConsumerMessage)) Option {
+	return func(cfg *config) {
+		cfg.consumerDecorator = decorator
+	}
+}
@@ -7,11 +7,19 @@
 package sarama // import "gopkg.in/DataDog/dd-trace-go.v1/contrib/Shopify/sarama"
 
 import (
+	"container/list"
+	"context"
 	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+	"gopkg.in/DataDog/dd-trace-go.v1/internal/datastreams"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/log"
 
 	"github.com/Shopify/sarama"
@@ -20,6 +28,7 @@ import (
 type partitionConsumer struct {
 	sarama.PartitionConsumer
 	messages chan *sarama.ConsumerMessage
+	errors   chan *sarama.ConsumerError
 }
 
 // Messages returns the read channel for the messages that are returned by
@@ -28,8 +37,14 @@ func (pc *partitionConsumer) Messages() <-chan *sarama.ConsumerMessage {
 	return pc.messages
 }
 
+// Errors returns the read channel for the errors that are returned by the
+// broker.
+func (pc *partitionConsumer) Errors() <-chan *sarama.ConsumerError {
+	return pc.errors
+}
+
 // WrapPartitionConsumer wraps a sarama.PartitionConsumer causing each received
-// message to be traced.
+// message and each consume error to be traced.
 func WrapPartitionConsumer(pc sarama.PartitionConsumer, opts ...Option) sarama.PartitionConsumer {
 	cfg := new(config)
 	defaults(cfg)
@@ -40,33 +55,21 @@ func WrapPartitionConsumer(pc sarama.PartitionConsumer, opts ...Option) sarama.P
 	wrapped := &partitionConsumer{
 		PartitionConsumer: pc,
 		messages:          make(chan *sarama.ConsumerMessage),
+		errors:            make(chan *sarama.ConsumerError),
 	}
+	go func() {
+		for err := range pc.Errors() {
+			traceConsumerError(cfg, err)
+			wrapped.errors <- err
+		}
+		close(wrapped.errors)
+	}()
 	go func() {
 		msgs := pc.Messages()
 		var prev ddtrace.Span
 		for msg := range msgs {
 			// create the next span from the message
-			opts := []tracer.StartSpanOption{
-				tracer.ServiceName(cfg.consumerServiceName),
-				tracer.ResourceName("Consume Topic " + msg.Topic),
-				tracer.SpanType(ext.SpanTypeMessageConsumer),
-				tracer.Tag("partition", msg.Partition),
-				tracer.Tag("offset", msg.Offset),
-				tracer.Tag(ext.Component, "Shopify/sarama"),
-				tracer.Tag(ext.SpanKind, ext.SpanKindConsumer),
-				tracer.Measured(),
-			}
-			if !math.IsNaN(cfg.analyticsRate) {
-				opts = append(opts, tracer.Tag(ext.EventSampleRate, cfg.analyticsRate))
-			}
-			// kafka supports headers, so try to extract a span context
-			carrier := NewConsumerMessageCarrier(msg)
-			if spanctx, err := tracer.Extract(carrier); err == nil {
-				opts = append(opts, tracer.ChildOf(spanctx))
-			}
-			next := tracer.StartSpan("kafka.consume", opts...)
-			// reinject the span context so consumers can pick it up
-			tracer.Inject(next.Context(), carrier)
+			next := startConsumerSpan(cfg, msg)
 
 			wrapped.messages <- msg
 
@@ -85,6 +88,326 @@ func WrapPartitionConsumer(pc sarama.PartitionConsumer, opts ...Option) sarama.P
 	return wrapped
 }
 
+type consumerGroupHandler struct {
+	sarama.ConsumerGroupHandler
+	cfg *config
+
+	// rebalance, when not nil, is the span of the group rebalance preceding
+	// the session, started by a consumer group wrapped by WrapConsumerGroup,
+	// which wraps the handler given to each call to Consume, so that it is
+	// finished once, either by Setup or Consume.
+	rebalance     ddtrace.Span
+	rebalanceOnce sync.Once
+}
+
+// Setup calls the wrapped handler's Setup within a kafka.rebalance span tagged
+// with the session's generation ID and assigned partitions. When the handler
+// is used by a consumer group wrapped by WrapConsumerGroup, the span also
+// covers the group rebalance preceding the session.
+func (h *consumerGroupHandler) Setup(session sarama.ConsumerGroupSession) error {
+	span := h.rebalance
+	if span == nil {
+		// the handler may be reused across sessions, each having its own span
+		span = startRebalanceSpan(h.cfg)
+	}
+	span.SetTag("kafka.generation_id", session.GenerationID())
+	span.SetTag("kafka.member_id", session.MemberID())
+	span.SetTag("kafka.partitions", formatClaims(session.Claims()))
+	err := h.ConsumerGroupHandler.Setup(session)
+	if h.rebalance == nil {
+		span.Finish(tracer.WithError(err))
+	} else {
+		h.finishRebalance(span, err)
+	}
+	return err
+}
+
+// Cleanup calls the wrapped handler's Cleanup within a kafka.cleanup span
+// tagged with the session's generation ID.
+func (h *consumerGroupHandler) Cleanup(session sarama.ConsumerGroupSession) error {
+	opts := []tracer.StartSpanOption{
+		tracer.ServiceName(h.cfg.consumerServiceName),
+		tracer.ResourceName("Cleanup Session"),
+		tracer.SpanType(ext.SpanTypeMessageConsumer),
+		tracer.Tag("kafka.generation_id", session.GenerationID()),
+		tracer.Tag("kafka.member_id", session.MemberID()),
+		tracer.Tag(ext.Component, "Shopify/sarama"),
+		tracer.Tag(ext.SpanKind, ext.SpanKindConsumer),
+	}
+	span := tracer.StartSpan(h.cfg.spanNamer("kafka.cleanup"), opts...)
+	err := h.ConsumerGroupHandler.Cleanup(session)
+	span.Finish(tracer.WithError(err))
+	return err
+}
+
+// finishRebalance finishes the rebalance span started by the consumer group,
+// only once per session.
+func (h *consumerGroupHandler) finishRebalance(span ddtrace.Span, err error) {
+	h.rebalanceOnce.Do(func() {
+		span.Finish(tracer.WithError(err))
+	})
+}
+
+type consumerGroupClaim struct {
+	sarama.ConsumerGroupClaim
+	messages chan *sarama.ConsumerMessage
+}
+
+// Messages returns the read channel for the messages that are returned by
+// the broker.
+func (c *consumerGroupClaim) Messages() <-chan *sarama.ConsumerMessage {
+	return c.messages
+}
+
+// ConsumeClaim calls the wrapped handler's ConsumeClaim with a claim causing
+// each received message to be traced.
+func (h *consumerGroupHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	wrapped := &consumerGroupClaim{
+		ConsumerGroupClaim: claim,
+		messages:           make(chan *sarama.ConsumerMessage),
+	}
+	// done is closed when the handler returns, which may happen before
+	// the claim's messages channel is closed.
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		defer close(wrapped.messages)
+		msgs := claim.Messages()
+		var prev ddtrace.Span
+		defer func() {
+			// finish any remaining span
+			if prev != nil {
+				prev.Finish()
+			}
+		}()
+		for {
+			// the handler returning takes precedence over the messages left
+			// in the claim, which would be pulled and dropped as it won't
+			// receive them anymore
+			select {
+			case <-done:
+				return
+			default:
+			}
+			var msg *sarama.ConsumerMessage
+			select {
+			case m, ok := <-msgs:
+				if !ok {
+					return
+				}
+				msg = m
+			case <-done:
+				return
+			}
+			// create the next span from the message
+			next := startConsumerSpan(h.cfg, msg)
+			select {
+			case wrapped.messages <- msg:
+			case <-done:
+				next.Finish()
+				return
+			}
+			// if the next message was pulled, finish the previous span
+			if prev != nil {
+				prev.Finish()
+			}
+			prev = next
+		}
+	}()
+	err := h.ConsumerGroupHandler.ConsumeClaim(session, wrapped)
+	close(done)
+	<-stopped
+	return err
+}
+
+// WrapConsumerGroupHandler wraps a sarama.ConsumerGroupHandler causing each
+// message received through its claims to be traced. The span of a message is
+// finished when the next message is pulled from the claim, or when the claim
+// is done being consumed. The setup and cleanup of the sessions are traced
+// as well.
+func WrapConsumerGroupHandler(h sarama.ConsumerGroupHandler, opts ...Option) sarama.ConsumerGroupHandler {
+	cfg := new(config)
+	defaults(cfg)
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	log.Debug("contrib/Shopify/sarama: Wrapping Consumer Group Handler: %#v", cfg)
+	return &consumerGroupHandler{
+		ConsumerGroupHandler: h,
+		cfg:                  cfg,
+	}
+}
+
+type consumerGroup struct {
+	sarama.ConsumerGroup
+	cfg *config
+}
+
+// Consume calls sarama.ConsumerGroup.Consume with a handler tracing the
+// consumed messages, and traces the group rebalance preceding the session
+// with a kafka.rebalance span finished once the session is set up.
+func (cg *consumerGroup) Consume(ctx context.Context, topics []string, handler sarama.ConsumerGroupHandler) error {
+	h := &consumerGroupHandler{
+		ConsumerGroupHandler: handler,
+		cfg:                  cg.cfg,
+		rebalance:            startRebalanceSpan(cg.cfg, tracer.Tag("kafka.topics", strings.Join(topics, ","))),
+	}
+	err := cg.ConsumerGroup.Consume(ctx, topics, h)
+	// the session may fail before being set up
+	h.finishRebalance(h.rebalance, err)
+	return err
+}
+
+// WrapConsumerGroup wraps a sarama.ConsumerGroup so that the handlers given to
+// its Consume method are wrapped using WrapConsumerGroupHandler, and the group
+// rebalances preceding each session are traced.
+func WrapConsumerGroup(cg sarama.ConsumerGroup, opts ...Option) sarama.ConsumerGroup {
+	cfg := new(config)
+	defaults(cfg)
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	log.Debug("contrib/Shopify/sarama: Wrapping Consumer Group: %#v", cfg)
+	return &consumerGroup{
+		ConsumerGroup: cg,
+		cfg:           cfg,
+	}
+}
+
+func startRebalanceSpan(cfg *config, opts ...tracer.StartSpanOption) ddtrace.Span {
+	opts = append(opts,
+		tracer.ServiceName(cfg.consumerServiceName),
+		tracer.ResourceName("Setup Session"),
+		tracer.SpanType(ext.SpanTypeMessageConsumer),
+		tracer.Tag(ext.Component, "Shopify/sarama"),
+		tracer.Tag(ext.SpanKind, ext.SpanKindConsumer),
+	)
+	return tracer.StartSpan(cfg.spanNamer("kafka.rebalance"), opts...)
+}
+
+// formatClaims formats the partitions claimed by a session, sorted by topic,
+// as "topic1:0,1;topic2:0".
+func formatClaims(claims map[string][]int32) string {
+	topics := make([]string, 0, len(claims))
+	for topic := range claims {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	var sb strings.Builder
+	for i, topic := range topics {
+		if i > 0 {
+			sb.WriteByte(';')
+		}
+		sb.WriteString(topic)
+		sb.WriteByte(':')
+		for j, p := range claims[topic] {
+			if j > 0 {
+				sb.WriteByte(',')
+			}
+			sb.WriteString(strconv.Itoa(int(p)))
+		}
+	}
+	return sb.String()
+}
+
+func startConsumerSpan(cfg *config, msg *sarama.ConsumerMessage) ddtrace.Span {
+	opts := []tracer.StartSpanOption{
+		tracer.ServiceName(cfg.consumerServiceName),
+		tracer.ResourceName("Consume Topic " + msg.Topic),
+		tracer.SpanType(ext.SpanTypeMessageConsumer),
+		tracer.Tag("partition", msg.Partition),
+		tracer.Tag("offset", msg.Offset),
+		tracer.Tag("kafka.message_size", len(msg.Value)),
+		tracer.Tag(ext.Component, "Shopify/sarama"),
+		tracer.Tag(ext.SpanKind, ext.SpanKindConsumer),
+	}
+	if cfg.measured {
+		opts = append(opts, tracer.Measured())
+	}
+	if msg.Key != nil {
+		opts = append(opts, tracer.Tag("kafka.message_key_size", len(msg.Key)))
+	}
+	if len(msg.Value) == 0 {
+		// tombstones mark deletions on compacted topics
+		opts = append(opts, tracer.Tag("kafka.tombstone", true))
+	}
+	if !math.IsNaN(cfg.analyticsRate) {
+		opts = append(opts, tracer.Tag(ext.EventSampleRate, cfg.analyticsRate))
+	}
+	// kafka supports headers, so try to extract a span context
+	carrier := NewConsumerMessageCarrier(msg)
+	if spanctx, err := tracer.Extract(carrier); err == nil {
+		opts = append(opts, tracer.ChildOf(spanctx))
+	}
+	span := tracer.StartSpan(cfg.spanNamer("kafka.consume"), opts...)
+	if cfg.consumerDecorator != nil {
+		cfg.consumerDecorator(span, msg)
+	}
+	if cfg.reinjectContext {
+		// reinject the span context so consumers can pick it up
+		tracer.Inject(span.Context(), carrier)
+	}
+	if cfg.dataStreamsEnabled {
+		setConsumeCheckpoint(cfg, msg, span)
+	}
+	return span
+}
+
+// traceConsumerError records a consume error returned by the broker as an
+// error span tagged with the topic and partition it occurred on.
+func traceConsumerError(cfg *config, err *sarama.ConsumerError) {
+	opts := []tracer.StartSpanOption{
+		tracer.ServiceName(cfg.consumerServiceName),
+		tracer.ResourceName("Consume Topic " + err.Topic),
+		tracer.SpanType(ext.SpanTypeMessageConsumer),
+		tracer.Tag("partition", err.Partition),
+		tracer.Tag(ext.Component, "Shopify/sarama"),
+		tracer.Tag(ext.SpanKind, ext.SpanKindConsumer),
+	}
+	if !math.IsNaN(cfg.analyticsRate) {
+		opts = append(opts, tracer.Tag(ext.EventSampleRate, cfg.analyticsRate))
+	}
+	span := tracer.StartSpan(cfg.spanNamer("kafka.consume"), opts...)
+	span.Finish(tracer.WithError(err.Err))
+}
+
+// setConsumeCheckpoint adds the consume checkpoint to the pathway carried by
+// msg, records the resulting pathway on span, and re-sets it in the headers
+// of msg so that it can be continued by the consumer, unless the context is
+// not reinjected.
+func setConsumeCheckpoint(cfg *config, msg *sarama.ConsumerMessage, span ddtrace.Span) {
+	now := time.Now()
+	edgeTags := []string{"direction:in", "topic:" + msg.Topic, "type:kafka"}
+	var pathway datastreams.Pathway
+	if parent, ok := consumerMessagePathway(msg); ok {
+		pathway = parent.SetCheckpoint(now, cfg.consumerServiceName, cfg.env, edgeTags...)
+		span.SetTag("pathway.latency_ms", float64(now.Sub(parent.PathwayStart()))/float64(time.Millisecond))
+		span.SetTag("pathway.edge_latency_ms", float64(now.Sub(parent.EdgeStart()))/float64(time.Millisecond))
+	} else {
+		pathway = datastreams.NewPathway(now, cfg.consumerServiceName, cfg.env, edgeTags...)
+	}
+	span.SetTag("pathway.hash", strconv.FormatUint(pathway.Hash(), 10))
+	if cfg.reinjectContext {
+		NewConsumerMessageCarrier(msg).Set(datastreams.PropagationKey, string(pathway.Encode()))
+	}
+}
+
+// consumerMessagePathway returns the pathway carried by msg, if any.
+func consumerMessagePathway(msg *sarama.ConsumerMessage) (datastreams.Pathway, bool) {
+	for _, h := range msg.Headers {
+		if h != nil && string(h.Key) == datastreams.PropagationKey {
+			p, err := datastreams.Decode(h.Value)
+			if err != nil {
+				log.Debug("contrib/Shopify/sarama: %v", err)
+				return p, false
+			}
+			return p, true
+		}
+	}
+	return datastreams.Pathway{}, false
+}
+
 type consumer struct {
 	sarama.Consumer
 	opts []Option
@@ -119,21 +442,36 @@ type syncProducer struct {
 func (p *syncProducer) SendMessage(msg *sarama.ProducerMessage) (partition int32, offset int64, err error) {
 	span := startProducerSpan(p.cfg, p.version, msg)
 	partition, offset, err = p.SyncProducer.SendMessage(msg)
-	finishProducerSpan(span, partition, offset, err)
+	finishProducerSpan(p.cfg, span, partition, offset, err)
 	return partition, offset, err
 }
 
 // SendMessages calls sarama.SyncProducer.SendMessages and traces the requests.
 func (p *syncProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
 	// although there's only one call made to the SyncProducer, the messages are
-	// treated individually, so we create a span for each one
+	// treated individually, so we create a span for each one, linked to the
+	// span of the first message to tie the batch together
 	spans := make([]ddtrace.Span, len(msgs))
 	for i, msg := range msgs {
-		spans[i] = startProducerSpan(p.cfg, p.version, msg)
+		if i == 0 {
+			spans[i] = startProducerSpan(p.cfg, p.version, msg)
+			continue
+		}
+		spans[i] = startProducerSpan(p.cfg, p.version, msg, tracer.WithLink(spans[0].Context()))
 	}
 	err := p.SyncProducer.SendMessages(msgs)
+	// when possible, finish each span with the error of its own message
+	perrs, isProducerErrors := err.(sarama.ProducerErrors)
+	msgErrs := make(map[*sarama.ProducerMessage]error, len(perrs))
+	for _, perr := range perrs {
+		msgErrs[perr.Msg] = perr.Err
+	}
 	for i, span := range spans {
-		finishProducerSpan(span, msgs[i].Partition, msgs[i].Offset, err)
+		msgErr := err
+		if isProducerErrors {
+			msgErr = msgErrs[msgs[i]]
+		}
+		finishProducerSpan(p.cfg, span, msgs[i].Partition, msgs[i].Offset, msgErr)
 	}
 	return err
 }
@@ -183,7 +521,14 @@ func (p *asyncProducer) Errors() <-chan *sarama.ProducerError {
 // are traced. It requires the underlying sarama Config so we can know whether
 // or not successes will be returned. Tracing requires at least sarama.V0_11_0_0
 // version which is the first version that supports headers. Only spans of
-// successfully published messages have partition and offset tags set.
+// successfully published messages have partition and offset tags set. When
+// the errors are returned but not the successes, the spans of the failed
+// messages are marked as errored, while the others are assumed successful
+// after a minute, and finished as of the sending of their message. When the
+// successes are returned, the spans of the messages whose outcome wasn't
+// returned after a minute are finished with the kafka.delivery:unknown tag.
+// The delay and the number of spans waiting for an outcome are set using
+// WithInflightSpans.
 func WrapAsyncProducer(saramaConfig *sarama.Config, p sarama.AsyncProducer, opts ...Option) sarama.AsyncProducer {
 	cfg := new(config)
 	defaults(cfg)
@@ -204,7 +549,19 @@ func WrapAsyncProducer(saramaConfig *sarama.Config, p sarama.AsyncProducer, opts
 		errors:        make(chan *sarama.ProducerError),
 	}
 	go func() {
-		spans := make(map[uint64]ddtrace.Span)
+		var (
+			// inflight holds the spans of the messages waiting for their
+			// success or error to be returned, if any.
+			inflight *inflightSpans
+			expire   <-chan time.Time
+		)
+		if saramaConfig.Producer.Return.Successes || saramaConfig.Producer.Return.Errors {
+			inflight = newInflightSpans(!saramaConfig.Producer.Return.Successes, cfg.maxInflightSpans, cfg.inflightSpanTimeout)
+			ticker := time.NewTicker(cfg.inflightSpanTimeout)
+			defer ticker.Stop()
+			expire = ticker.C
+			defer inflight.evictAll()
+		}
 		defer close(wrapped.input)
 		defer close(wrapped.successes)
 		defer close(wrapped.errors)
@@ -213,25 +570,24 @@ func WrapAsyncProducer(saramaConfig *sarama.Config, p sarama.AsyncProducer, opts
 			case msg := <-wrapped.input:
 				span := startProducerSpan(cfg, saramaConfig.Version, msg)
 				p.Input() <- msg
-				if saramaConfig.Producer.Return.Successes {
-					spanID := span.Context().SpanID()
-					spans[spanID] = span
+				if inflight != nil {
+					inflight.add(span)
 				} else {
 					// if returning successes isn't enabled, we just finish the
 					// span right away because there's no way to know when it will
 					// be done
 					span.Finish()
 				}
+			case now := <-expire:
+				inflight.expire(now)
 			case msg, ok := <-p.Successes():
 				if !ok {
 					// producer was closed, so exit
 					return
 				}
 				if spanctx, spanFound := getSpanContext(msg); spanFound {
-					spanID := spanctx.SpanID()
-					if span, ok := spans[spanID]; ok {
-						delete(spans, spanID)
-						finishProducerSpan(span, msg.Partition, msg.Offset, nil)
+					if span, ok := inflight.remove(spanctx.SpanID()); ok {
+						finishProducerSpan(cfg, span, msg.Partition, msg.Offset, nil)
 					}
 				}
 				wrapped.successes <- msg
@@ -241,10 +597,12 @@ func WrapAsyncProducer(saramaConfig *sarama.Config, p sarama.AsyncProducer, opts
 					return
 				}
 				if spanctx, spanFound := getSpanContext(err.Msg); spanFound {
-					spanID := spanctx.SpanID()
-					if span, ok := spans[spanID]; ok {
-						delete(spans, spanID)
-						span.Finish(tracer.WithError(err))
+					if span, ok := inflight.remove(spanctx.SpanID()); ok {
+						var serr error = err
+						if cfg.shouldIgnoreError(err.Err) {
+							serr = nil
+						}
+						span.Finish(tracer.WithError(serr))
 					}
 				}
 				wrapped.errors <- err
@@ -254,7 +612,100 @@ func WrapAsyncProducer(saramaConfig *sarama.Config, p sarama.AsyncProducer, opts
 	return wrapped
 }
 
-func startProducerSpan(cfg *config, version sarama.KafkaVersion, msg *sarama.ProducerMessage) ddtrace.Span {
+// inflightSpans holds the spans of the messages produced through an async
+// producer until their success or error is returned, bounding their number
+// and their age so that they don't leak when the outcome is never returned.
+// It isn't safe for concurrent use.
+type inflightSpans struct {
+	// order holds the *inflightSpan in the order they were sent, so that the
+	// oldest ones are evicted in constant time.
+	order *list.List
+	spans map[uint64]*list.Element
+	// errorsOnly reports whether only the errors are returned, in which case
+	// the messages whose outcome wasn't returned are assumed delivered.
+	errorsOnly bool
+	maxSize    int           // the number of spans beyond which the oldest is evicted
+	timeout    time.Duration // the age after which a span is evicted
+}
+
+type inflightSpan struct {
+	span ddtrace.Span
+	sent time.Time
+}
+
+func newInflightSpans(errorsOnly bool, maxSize int, timeout time.Duration) *inflightSpans {
+	return &inflightSpans{
+		order:      list.New(),
+		spans:      make(map[uint64]*list.Element),
+		errorsOnly: errorsOnly,
+		maxSize:    maxSize,
+		timeout:    timeout,
+	}
+}
+
+// add adds the span of a message being sent, evicting the oldest span if the
+// maximum number of spans is reached.
+func (s *inflightSpans) add(span ddtrace.Span) {
+	if s.order.Len() >= s.maxSize {
+		s.evict(s.removeElement(s.order.Front()))
+	}
+	spanID := span.Context().SpanID()
+	if e, ok := s.spans[spanID]; ok {
+		s.order.Remove(e)
+	}
+	s.spans[spanID] = s.order.PushBack(&inflightSpan{span: span, sent: time.Now()})
+}
+
+// remove removes and returns the span with the given ID, if any.
+func (s *inflightSpans) remove(spanID uint64) (ddtrace.Span, bool) {
+	if s == nil {
+		return nil, false
+	}
+	e, ok := s.spans[spanID]
+	if !ok {
+		return nil, false
+	}
+	return s.removeElement(e).span, true
+}
+
+// removeElement removes the given element of s.order along with its span ID.
+func (s *inflightSpans) removeElement(e *list.Element) *inflightSpan {
+	is := s.order.Remove(e).(*inflightSpan)
+	delete(s.spans, is.span.Context().SpanID())
+	return is
+}
+
+// expire evicts the spans older than the timeout.
+func (s *inflightSpans) expire(now time.Time) {
+	for e := s.order.Front(); e != nil; e = s.order.Front() {
+		if now.Sub(e.Value.(*inflightSpan).sent) < s.timeout {
+			// the next spans were sent later
+			return
+		}
+		s.evict(s.removeElement(e))
+	}
+}
+
+// evictAll evicts all the spans, once the producer is closed.
+func (s *inflightSpans) evictAll() {
+	for e := s.order.Front(); e != nil; e = s.order.Front() {
+		s.evict(s.removeElement(e))
+	}
+}
+
+// evict finishes the span of a message whose outcome wasn't returned.
+func (s *inflightSpans) evict(is *inflightSpan) {
+	if s.errorsOnly {
+		// the message is assumed to be delivered; its span is finished as if
+		// it was right away, as when the errors aren't returned
+		is.span.Finish(tracer.FinishTime(is.sent))
+		return
+	}
+	is.span.SetTag("kafka.delivery", "unknown")
+	is.span.Finish()
+}
+
+func startProducerSpan(cfg *config, version sarama.KafkaVersion, msg *sarama.ProducerMessage, extraOpts ...tracer.StartSpanOption) ddtrace.Span {
 	carrier := NewProducerMessageCarrier(msg)
 	opts := []tracer.StartSpanOption{
 		tracer.ServiceName(cfg.producerServiceName),
@@ -263,22 +714,83 @@ func startProducerSpan(cfg *config, version sarama.KafkaVersion, msg *sarama.Pro
 		tracer.Tag(ext.Component, "Shopify/sarama"),
 		tracer.Tag(ext.SpanKind, ext.SpanKindProducer),
 	}
+	// the encoders know their length, which avoids encoding the message twice
+	var size int
+	if msg.Value != nil {
+		size = msg.Value.Length()
+	}
+	opts = append(opts, tracer.Tag("kafka.message_size", size))
+	if size == 0 {
+		// tombstones mark deletions on compacted topics
+		opts = append(opts, tracer.Tag("kafka.tombstone", true))
+	}
+	if msg.Key != nil {
+		opts = append(opts, tracer.Tag("kafka.message_key_size", msg.Key.Length()))
+	}
 	if !math.IsNaN(cfg.analyticsRate) {
 		opts = append(opts, tracer.Tag(ext.EventSampleRate, cfg.analyticsRate))
 	}
+	// the duplicate headers of buggy upstreams hold no reliable parent, so
+	// they are dropped along with the baggage before being replaced
+	if carrier.hasDuplicateDatadogHeaders() {
+		carrier.resetDatadogHeaders()
+	}
 	// if there's a span context in the headers, use that as the parent
 	if spanctx, err := tracer.Extract(carrier); err == nil {
 		opts = append(opts, tracer.ChildOf(spanctx))
 	}
-	span := tracer.StartSpan("kafka.produce", opts...)
+	opts = append(opts, extraOpts...)
+	span := tracer.StartSpan(cfg.spanNamer("kafka.produce"), opts...)
+	if cfg.producerDecorator != nil {
+		cfg.producerDecorator(span, msg)
+	}
 	if version.IsAtLeast(sarama.V0_11_0_0) {
-		// re-inject the span context so consumers can pick it up
+		// re-inject the span context so consumers can pick it up, replacing
+		// the headers set upstream
+		carrier.resetDatadogHeaders()
 		tracer.Inject(span.Context(), carrier)
+		if cfg.dataStreamsEnabled {
+			setProduceCheckpoint(cfg, msg, span)
+		}
 	}
 	return span
 }
 
-func finishProducerSpan(span ddtrace.Span, partition int32, offset int64, err error) {
+// setProduceCheckpoint adds the produce checkpoint to the pathway msg is part
+// of, if any, or starts a new one, and sets it in the headers of msg.
+func setProduceCheckpoint(cfg *config, msg *sarama.ProducerMessage, span ddtrace.Span) {
+	now := time.Now()
+	edgeTags := []string{"direction:out", "topic:" + msg.Topic, "type:kafka"}
+	var pathway datastreams.Pathway
+	if parent, ok := producerMessagePathway(msg); ok {
+		pathway = parent.SetCheckpoint(now, cfg.producerServiceName, cfg.env, edgeTags...)
+	} else {
+		pathway = datastreams.NewPathway(now, cfg.producerServiceName, cfg.env, edgeTags...)
+	}
+	span.SetTag("pathway.hash", strconv.FormatUint(pathway.Hash(), 10))
+	NewProducerMessageCarrier(msg).Set(datastreams.PropagationKey, string(pathway.Encode()))
+}
+
+// producerMessagePathway returns the pathway carried by msg, if any, such as
+// when forwarding a consumed message.
+func producerMessagePathway(msg *sarama.ProducerMessage) (datastreams.Pathway, bool) {
+	for _, h := range msg.Headers {
+		if string(h.Key) == datastreams.PropagationKey {
+			p, err := datastreams.Decode(h.Value)
+			if err != nil {
+				log.Debug("contrib/Shopify/sarama: %v", err)
+				return p, false
+			}
+			return p, true
+		}
+	}
+	return datastreams.Pathway{}, false
+}
+
+func finishProducerSpan(cfg *config, span ddtrace.Span, partition int32, offset int64, err error) {
+	if err != nil && cfg.shouldIgnoreError(err) {
+		err = nil
+	}
 	span.SetTag("partition", partition)
 	span.SetTag("offset", offset)
 	span.Finish(tracer.WithError(err))
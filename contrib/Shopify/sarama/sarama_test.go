@@ -7,14 +7,21 @@ package sarama
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
+	"gopkg.in/DataDog/dd-trace-go.v1/contrib/internal/agenttest"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/mocktracer"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
 
 	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -73,6 +80,7 @@ func TestConsumer(t *testing.T) {
 
 		assert.Equal(t, int32(0), s.Tag("partition"))
 		assert.Equal(t, int64(0), s.Tag("offset"))
+		assert.Equal(t, 5, s.Tag("kafka.message_size"))
 		assert.Equal(t, "kafka", s.Tag(ext.ServiceName))
 		assert.Equal(t, "Consume Topic test-topic", s.Tag(ext.ResourceName))
 		assert.Equal(t, "queue", s.Tag(ext.SpanType))
@@ -98,6 +106,636 @@ func TestConsumer(t *testing.T) {
 	}
 }
 
+type mockConsumerGroupSession struct {
+	sarama.ConsumerGroupSession
+	marked []*sarama.ConsumerMessage
+}
+
+func (s *mockConsumerGroupSession) Claims() map[string][]int32 {
+	return map[string][]int32{"test-topic": {0, 1}, "other-topic": {2}}
+}
+
+func (s *mockConsumerGroupSession) MemberID() string { return "member-1" }
+
+func (s *mockConsumerGroupSession) GenerationID() int32 { return 3 }
+
+func (s *mockConsumerGroupSession) MarkMessage(msg *sarama.ConsumerMessage, _ string) {
+	s.marked = append(s.marked, msg)
+}
+
+type mockConsumerGroupClaim struct {
+	sarama.ConsumerGroupClaim
+	messages chan *sarama.ConsumerMessage
+}
+
+func (c *mockConsumerGroupClaim) Topic() string { return "test-topic" }
+
+func (c *mockConsumerGroupClaim) Partition() int32 { return 0 }
+
+func (c *mockConsumerGroupClaim) Messages() <-chan *sarama.ConsumerMessage { return c.messages }
+
+// consumerGroupHandlerFunc is a sarama.ConsumerGroupHandler consuming claims
+// using the given function.
+type consumerGroupHandlerFunc func(sarama.ConsumerGroupSession, sarama.ConsumerGroupClaim) error
+
+func (consumerGroupHandlerFunc) Setup(sarama.ConsumerGroupSession) error   { return nil }
+func (consumerGroupHandlerFunc) Cleanup(sarama.ConsumerGroupSession) error { return nil }
+func (f consumerGroupHandlerFunc) ConsumeClaim(s sarama.ConsumerGroupSession, c sarama.ConsumerGroupClaim) error {
+	return f(s, c)
+}
+
+func TestPartitionConsumerErrors(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	cfg := sarama.NewConfig()
+	cfg.Consumer.Return.Errors = true
+	consumer := mocks.NewConsumer(t, cfg)
+	defer consumer.Close()
+	consumer.ExpectConsumePartition("test-topic", 0, sarama.OffsetOldest).
+		YieldError(sarama.ErrOffsetOutOfRange)
+
+	partitionConsumer, err := consumer.ConsumePartition("test-topic", 0, sarama.OffsetOldest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	partitionConsumer = WrapPartitionConsumer(partitionConsumer)
+
+	consumerErr := <-partitionConsumer.Errors()
+	assert.Equal(t, "test-topic", consumerErr.Topic)
+	assert.Equal(t, sarama.ErrOffsetOutOfRange, consumerErr.Err)
+
+	spans := mt.FinishedSpans()
+	if assert.Len(t, spans, 1) {
+		s := spans[0]
+		assert.Equal(t, "kafka.consume", s.OperationName())
+		assert.Equal(t, "kafka", s.Tag(ext.ServiceName))
+		assert.Equal(t, "Consume Topic test-topic", s.Tag(ext.ResourceName))
+		assert.Equal(t, "queue", s.Tag(ext.SpanType))
+		assert.Equal(t, int32(0), s.Tag("partition"))
+		assert.Equal(t, sarama.ErrOffsetOutOfRange, s.Tag(ext.Error))
+		assert.Equal(t, "Shopify/sarama", s.Tag(ext.Component))
+		assert.Equal(t, ext.SpanKindConsumer, s.Tag(ext.SpanKind))
+	}
+}
+
+func TestConsumerGroupHandler(t *testing.T) {
+	newClaim := func(n int) *mockConsumerGroupClaim {
+		claim := &mockConsumerGroupClaim{messages: make(chan *sarama.ConsumerMessage, n)}
+		for i := 0; i < n; i++ {
+			claim.messages <- &sarama.ConsumerMessage{
+				Topic:     "test-topic",
+				Partition: 0,
+				Offset:    int64(i),
+				Key:       []byte("key"),
+				Value:     []byte("hello"),
+			}
+		}
+		return claim
+	}
+
+	t.Run("claim", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		claim := newClaim(2)
+		close(claim.messages)
+		var traceIDs []uint64
+		handler := WrapConsumerGroupHandler(consumerGroupHandlerFunc(func(s sarama.ConsumerGroupSession, c sarama.ConsumerGroupClaim) error {
+			for msg := range c.Messages() {
+				spanctx, err := tracer.Extract(NewConsumerMessageCarrier(msg))
+				assert.NoError(t, err, "span context should be injected into the consumer message headers")
+				if err == nil {
+					traceIDs = append(traceIDs, spanctx.TraceID())
+				}
+				s.MarkMessage(msg, "")
+			}
+			return nil
+		}), WithServiceName("consumer"))
+		session := &mockConsumerGroupSession{}
+		err := handler.ConsumeClaim(session, claim)
+		assert.NoError(t, err)
+		assert.Len(t, session.marked, 2)
+
+		spans := mt.FinishedSpans()
+		assert.Len(t, spans, 2)
+		assert.Len(t, traceIDs, 2)
+		for i, s := range spans {
+			assert.Equal(t, traceIDs[i], s.TraceID())
+			assert.Equal(t, "kafka.consume", s.OperationName())
+			assert.Equal(t, "consumer", s.Tag(ext.ServiceName))
+			assert.Equal(t, "Consume Topic test-topic", s.Tag(ext.ResourceName))
+			assert.Equal(t, "queue", s.Tag(ext.SpanType))
+			assert.Equal(t, int32(0), s.Tag("partition"))
+			assert.Equal(t, int64(i), s.Tag("offset"))
+			assert.Equal(t, 5, s.Tag("kafka.message_size"))
+			assert.Equal(t, 3, s.Tag("kafka.message_key_size"))
+			assert.Equal(t, "Shopify/sarama", s.Tag(ext.Component))
+			assert.Equal(t, ext.SpanKindConsumer, s.Tag(ext.SpanKind))
+		}
+	})
+
+	t.Run("propagation", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		parent := tracer.StartSpan("kafka.produce")
+		msg := &sarama.ConsumerMessage{Topic: "test-topic", Value: []byte("hello")}
+		err := tracer.Inject(parent.Context(), NewConsumerMessageCarrier(msg))
+		assert.NoError(t, err)
+		parent.Finish()
+		claim := newClaim(0)
+		claim.messages = make(chan *sarama.ConsumerMessage, 1)
+		claim.messages <- msg
+		close(claim.messages)
+
+		handler := WrapConsumerGroupHandler(consumerGroupHandlerFunc(func(s sarama.ConsumerGroupSession, c sarama.ConsumerGroupClaim) error {
+			for range c.Messages() {
+			}
+			return nil
+		}))
+		err = handler.ConsumeClaim(&mockConsumerGroupSession{}, claim)
+		assert.NoError(t, err)
+
+		spans := mt.FinishedSpans()
+		assert.Len(t, spans, 2)
+		assert.Equal(t, "kafka.consume", spans[1].OperationName())
+		assert.Equal(t, parent.Context().TraceID(), spans[1].TraceID())
+		assert.Equal(t, parent.Context().SpanID(), spans[1].ParentID())
+	})
+
+	t.Run("tombstone", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		claim := newClaim(0)
+		claim.messages = make(chan *sarama.ConsumerMessage, 1)
+		claim.messages <- &sarama.ConsumerMessage{Topic: "test-topic", Key: []byte("key")}
+		close(claim.messages)
+		handler := WrapConsumerGroupHandler(consumerGroupHandlerFunc(func(s sarama.ConsumerGroupSession, c sarama.ConsumerGroupClaim) error {
+			for range c.Messages() {
+			}
+			return nil
+		}))
+		err := handler.ConsumeClaim(&mockConsumerGroupSession{}, claim)
+		assert.NoError(t, err)
+
+		spans := mt.FinishedSpans()
+		assert.Len(t, spans, 1)
+		assert.Equal(t, true, spans[0].Tag("kafka.tombstone"))
+		assert.Equal(t, 0, spans[0].Tag("kafka.message_size"))
+	})
+
+	t.Run("early-return", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		// the claim's channel is left open, the handler returning early must
+		// not leak any goroutine nor unfinished span.
+		claim := newClaim(3)
+		handler := WrapConsumerGroupHandler(consumerGroupHandlerFunc(func(s sarama.ConsumerGroupSession, c sarama.ConsumerGroupClaim) error {
+			<-c.Messages()
+			return errors.New("stop")
+		}))
+		err := handler.ConsumeClaim(&mockConsumerGroupSession{}, claim)
+		assert.EqualError(t, err, "stop")
+
+		var offsets []interface{}
+		for _, s := range mt.FinishedSpans() {
+			offsets = append(offsets, s.Tag("offset"))
+		}
+		assert.Contains(t, offsets, int64(0))
+		assert.Len(t, mt.OpenSpans(), 0)
+	})
+}
+
+// mockConsumerGroup is a sarama.ConsumerGroup running a single session
+// consuming the given claim, or failing with err before the session is set up.
+type mockConsumerGroup struct {
+	sarama.ConsumerGroup
+	claim *mockConsumerGroupClaim
+	err   error
+}
+
+func (g *mockConsumerGroup) Consume(_ context.Context, _ []string, h sarama.ConsumerGroupHandler) error {
+	if g.err != nil {
+		return g.err
+	}
+	session := &mockConsumerGroupSession{}
+	if err := h.Setup(session); err != nil {
+		return err
+	}
+	err := h.ConsumeClaim(session, g.claim)
+	if cerr := h.Cleanup(session); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func TestConsumerGroup(t *testing.T) {
+	drain := consumerGroupHandlerFunc(func(s sarama.ConsumerGroupSession, c sarama.ConsumerGroupClaim) error {
+		for range c.Messages() {
+		}
+		return nil
+	})
+
+	t.Run("session", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		claim := &mockConsumerGroupClaim{messages: make(chan *sarama.ConsumerMessage, 1)}
+		claim.messages <- &sarama.ConsumerMessage{Topic: "test-topic", Value: []byte("hello")}
+		close(claim.messages)
+		cg := WrapConsumerGroup(&mockConsumerGroup{claim: claim}, WithServiceName("consumer"))
+		err := cg.Consume(context.Background(), []string{"test-topic", "other-topic"}, drain)
+		assert.NoError(t, err)
+
+		spans := mt.FinishedSpans()
+		assert.Len(t, spans, 3)
+		rebalance, consume, cleanup := spans[0], spans[1], spans[2]
+		assert.Equal(t, "kafka.rebalance", rebalance.OperationName())
+		assert.Equal(t, "consumer", rebalance.Tag(ext.ServiceName))
+		assert.Equal(t, "test-topic,other-topic", rebalance.Tag("kafka.topics"))
+		assert.Equal(t, int32(3), rebalance.Tag("kafka.generation_id"))
+		assert.Equal(t, "member-1", rebalance.Tag("kafka.member_id"))
+		assert.Equal(t, "other-topic:2;test-topic:0,1", rebalance.Tag("kafka.partitions"))
+		assert.Equal(t, "Shopify/sarama", rebalance.Tag(ext.Component))
+		assert.Equal(t, "kafka.consume", consume.OperationName())
+		assert.Equal(t, "kafka.cleanup", cleanup.OperationName())
+		assert.Equal(t, int32(3), cleanup.Tag("kafka.generation_id"))
+	})
+
+	t.Run("setup-error", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		cg := WrapConsumerGroup(&mockConsumerGroup{claim: &mockConsumerGroupClaim{}})
+		err := cg.Consume(context.Background(), []string{"test-topic"}, failingSetupHandler{drain})
+		assert.EqualError(t, err, "setup failed")
+
+		spans := mt.FinishedSpans()
+		assert.Len(t, spans, 1)
+		assert.Equal(t, "kafka.rebalance", spans[0].OperationName())
+		assert.EqualError(t, spans[0].Tag(ext.Error).(error), "setup failed")
+	})
+
+	t.Run("join-error", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		cg := WrapConsumerGroup(&mockConsumerGroup{err: sarama.ErrClosedConsumerGroup})
+		err := cg.Consume(context.Background(), []string{"test-topic"}, drain)
+		assert.Equal(t, sarama.ErrClosedConsumerGroup, err)
+
+		spans := mt.FinishedSpans()
+		assert.Len(t, spans, 1)
+		assert.Equal(t, "kafka.rebalance", spans[0].OperationName())
+		assert.Equal(t, sarama.ErrClosedConsumerGroup, spans[0].Tag(ext.Error))
+		assert.Nil(t, spans[0].Tag("kafka.generation_id"))
+	})
+
+	t.Run("handler", func(t *testing.T) {
+		// the setup and cleanup are traced without the consumer group as well
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		h := WrapConsumerGroupHandler(drain)
+		session := &mockConsumerGroupSession{}
+		assert.NoError(t, h.Setup(session))
+		assert.NoError(t, h.Cleanup(session))
+
+		spans := mt.FinishedSpans()
+		assert.Len(t, spans, 2)
+		assert.Equal(t, "kafka.rebalance", spans[0].OperationName())
+		assert.Equal(t, "other-topic:2;test-topic:0,1", spans[0].Tag("kafka.partitions"))
+		assert.Equal(t, "kafka.cleanup", spans[1].OperationName())
+	})
+
+	t.Run("handler-sessions", func(t *testing.T) {
+		// a handler reused across sessions traces the setup of each of them
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		h := WrapConsumerGroupHandler(drain)
+		session := &mockConsumerGroupSession{}
+		for i := 0; i < 3; i++ {
+			assert.NoError(t, h.Setup(session))
+			assert.NoError(t, h.Cleanup(session))
+		}
+
+		spans := mt.FinishedSpans()
+		assert.Len(t, spans, 6)
+		for i := 0; i < 3; i++ {
+			assert.Equal(t, "kafka.rebalance", spans[2*i].OperationName())
+			assert.Equal(t, "kafka.cleanup", spans[2*i+1].OperationName())
+		}
+		assert.Empty(t, mt.OpenSpans())
+	})
+}
+
+// failingSetupHandler is a sarama.ConsumerGroupHandler failing to set up.
+type failingSetupHandler struct {
+	sarama.ConsumerGroupHandler
+}
+
+func (failingSetupHandler) Setup(sarama.ConsumerGroupSession) error {
+	return errors.New("setup failed")
+}
+
+func TestProducerHeadersOverwrite(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	cfg := new(config)
+	defaults(cfg)
+
+	// a message re-produced by a buggy upstream, carrying duplicate trace headers
+	msg := &sarama.ProducerMessage{
+		Topic: "test-topic",
+		Value: sarama.StringEncoder("hello"),
+		Headers: []sarama.RecordHeader{
+			{Key: []byte("x-datadog-trace-id"), Value: []byte("1")},
+			{Key: []byte("x-datadog-parent-id"), Value: []byte("2")},
+			{Key: []byte("X-Datadog-Trace-Id"), Value: []byte("3")},
+			{Key: []byte("x-datadog-origin"), Value: []byte("synthetics")},
+			{Key: []byte("ot-baggage-stale"), Value: []byte("value")},
+			{Key: []byte("custom"), Value: []byte("kept")},
+		},
+	}
+	span := startProducerSpan(cfg, sarama.V0_11_0_0, msg)
+	span.Finish()
+	// the ambiguous upstream headers aren't used as the parent
+	assert.Equal(t, uint64(0), mt.FinishedSpans()[0].ParentID())
+	assert.Equal(t, "", span.BaggageItem("stale"))
+
+	count := make(map[string]int)
+	values := make(map[string]string)
+	for _, h := range msg.Headers {
+		key := strings.ToLower(string(h.Key))
+		count[key]++
+		values[key] = string(h.Value)
+	}
+	assert.Equal(t, 1, count["x-datadog-trace-id"])
+	assert.Equal(t, 1, count["x-datadog-parent-id"])
+	assert.Equal(t, strconv.FormatUint(span.Context().TraceID(), 10), values["x-datadog-trace-id"])
+	assert.Equal(t, strconv.FormatUint(span.Context().SpanID(), 10), values["x-datadog-parent-id"])
+	assert.NotContains(t, count, "x-datadog-origin")
+	assert.NotContains(t, count, "ot-baggage-stale")
+	assert.Equal(t, "kept", values["custom"])
+}
+
+func TestTraceContextPropagation(t *testing.T) {
+	agent := agenttest.New()
+	defer agent.Close()
+	tracer.Start(
+		tracer.WithAgentAddr(agent.Addr()),
+		tracer.WithLogStartup(false),
+		tracer.WithPropagator(tracer.NewPropagator(&tracer.PropagatorConfig{TraceContext: true})),
+	)
+	defer tracer.Stop()
+
+	cfg := new(config)
+	defaults(cfg)
+
+	// a message produced within a trace of a W3C-instrumented upstream
+	pmsg := &sarama.ProducerMessage{
+		Topic: "test-topic",
+		Value: sarama.StringEncoder("hello"),
+		Headers: []sarama.RecordHeader{
+			{Key: []byte("traceparent"), Value: []byte("00-00000000000000000000000000000001-0000000000000002-01")},
+		},
+	}
+	produce := startProducerSpan(cfg, sarama.V0_11_0_0, pmsg)
+	produce.Finish()
+	assert.Equal(t, uint64(1), produce.Context().TraceID())
+
+	var traceparent []string
+	for _, h := range pmsg.Headers {
+		if string(h.Key) == "traceparent" {
+			traceparent = append(traceparent, string(h.Value))
+		}
+	}
+	assert.Equal(t, []string{fmt.Sprintf("00-%032x-%016x-01", uint64(1), produce.Context().SpanID())}, traceparent)
+
+	// the consumer continues the trace from the W3C headers
+	cmsg := &sarama.ConsumerMessage{Topic: "test-topic", Value: []byte("hello")}
+	for i := range pmsg.Headers {
+		cmsg.Headers = append(cmsg.Headers, &pmsg.Headers[i])
+	}
+	consume := startConsumerSpan(cfg, cmsg)
+	consume.Finish()
+	assert.NoError(t, tracer.FlushWithTimeout(5*time.Second))
+
+	spans := agent.Spans()
+	assert.Len(t, spans, 2)
+	for _, s := range spans {
+		assert.Equal(t, uint64(1), s.TraceID)
+		if s.Name == "kafka.consume" {
+			assert.Equal(t, produce.Context().SpanID(), s.ParentID)
+		}
+	}
+}
+
+func TestBaggagePropagation(t *testing.T) {
+	agent := agenttest.New()
+	defer agent.Close()
+	tracer.Start(
+		tracer.WithAgentAddr(agent.Addr()),
+		tracer.WithLogStartup(false),
+		tracer.WithPropagator(tracer.NewPropagator(&tracer.PropagatorConfig{MaxBaggageItems: 2})),
+	)
+	defer tracer.Stop()
+
+	cfg := new(config)
+	defaults(cfg)
+
+	// a message produced within a trace carrying baggage
+	parent := tracer.StartSpan("parent")
+	parent.SetBaggageItem("a", "1")
+	parent.SetBaggageItem("b", "2")
+	parent.SetBaggageItem("c", "3")
+	pmsg := &sarama.ProducerMessage{Topic: "test-topic", Value: sarama.StringEncoder("hello")}
+	assert.NoError(t, tracer.Inject(parent.Context(), NewProducerMessageCarrier(pmsg)))
+	produce := startProducerSpan(cfg, sarama.V0_11_0_0, pmsg)
+	produce.Finish()
+	parent.Finish()
+
+	headers := make(map[string]string)
+	for _, h := range pmsg.Headers {
+		headers[string(h.Key)] = string(h.Value)
+	}
+	assert.Equal(t, "1", headers["ot-baggage-a"])
+	assert.Equal(t, "2", headers["ot-baggage-b"])
+	assert.NotContains(t, headers, "ot-baggage-c")
+
+	// the consumer continues the trace along with its baggage
+	cmsg := &sarama.ConsumerMessage{Topic: "test-topic", Value: []byte("hello")}
+	for i := range pmsg.Headers {
+		cmsg.Headers = append(cmsg.Headers, &pmsg.Headers[i])
+	}
+	consume := startConsumerSpan(cfg, cmsg)
+	consume.Finish()
+	assert.Equal(t, "1", consume.BaggageItem("a"))
+	assert.Equal(t, "2", consume.BaggageItem("b"))
+	assert.Equal(t, "", consume.BaggageItem("c"))
+}
+
+func TestSamplingPriorityPropagation(t *testing.T) {
+	agent := agenttest.New()
+	defer agent.Close()
+	tracer.Start(tracer.WithAgentAddr(agent.Addr()), tracer.WithLogStartup(false))
+	defer tracer.Stop()
+
+	// the producer force-keeps the trace
+	cfg := new(config)
+	defaults(cfg)
+	WithSpanDecorator(func(span ddtrace.Span, _ *sarama.ProducerMessage) {
+		span.SetTag(ext.ManualKeep, true)
+	})(cfg)
+
+	pmsg := &sarama.ProducerMessage{Topic: "test-topic", Value: sarama.StringEncoder("hello")}
+	produce := startProducerSpan(cfg, sarama.V0_11_0_0, pmsg)
+	produce.Finish()
+
+	headers := make(map[string]string)
+	for _, h := range pmsg.Headers {
+		headers[string(h.Key)] = string(h.Value)
+	}
+	assert.Equal(t, strconv.Itoa(ext.PriorityUserKeep), headers[tracer.DefaultPriorityHeader])
+
+	// the consumer inherits the decision of the producer
+	cmsg := &sarama.ConsumerMessage{Topic: "test-topic", Value: []byte("hello")}
+	for i := range pmsg.Headers {
+		cmsg.Headers = append(cmsg.Headers, &pmsg.Headers[i])
+	}
+	consume := startConsumerSpan(cfg, cmsg)
+	consume.Finish()
+	assert.NoError(t, tracer.FlushWithTimeout(5*time.Second))
+
+	spans := agent.Spans()
+	assert.Len(t, spans, 2)
+	for _, s := range spans {
+		assert.Equal(t, produce.Context().TraceID(), s.TraceID)
+		assert.Equal(t, float64(ext.PriorityUserKeep), s.Metrics["_sampling_priority_v1"], s.Name)
+	}
+}
+
+func TestReinjectContext(t *testing.T) {
+	for name, tt := range map[string]struct {
+		opts      []Option
+		reinjects bool
+	}{
+		"default":  {reinjects: true},
+		"enabled":  {opts: []Option{WithReinjectContext(true)}, reinjects: true},
+		"disabled": {opts: []Option{WithReinjectContext(false), WithDataStreams()}, reinjects: false},
+	} {
+		t.Run(name, func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+
+			cfg := new(config)
+			defaults(cfg)
+			for _, opt := range tt.opts {
+				opt(cfg)
+			}
+
+			// a message produced within an upstream trace
+			parent := tracer.StartSpan("parent")
+			pmsg := &sarama.ProducerMessage{Topic: "test-topic", Value: sarama.StringEncoder("hello")}
+			assert.NoError(t, tracer.Inject(parent.Context(), NewProducerMessageCarrier(pmsg)))
+			parent.Finish()
+			msg := &sarama.ConsumerMessage{Topic: "test-topic", Value: []byte("hello")}
+			var headers []sarama.RecordHeader
+			for i := range pmsg.Headers {
+				msg.Headers = append(msg.Headers, &pmsg.Headers[i])
+				headers = append(headers, pmsg.Headers[i])
+			}
+
+			consume := startConsumerSpan(cfg, msg)
+			consume.Finish()
+			assert.Equal(t, parent.Context().SpanID(), mt.FinishedSpans()[1].ParentID())
+
+			spanctx, err := tracer.Extract(NewConsumerMessageCarrier(msg))
+			assert.NoError(t, err)
+			if tt.reinjects {
+				assert.Equal(t, consume.Context().SpanID(), spanctx.SpanID())
+				return
+			}
+			assert.Equal(t, parent.Context().SpanID(), spanctx.SpanID())
+			assert.Len(t, msg.Headers, len(headers))
+			for i, h := range msg.Headers {
+				assert.Equal(t, headers[i], *h, "the headers should be unchanged")
+			}
+		})
+	}
+}
+
+func TestPropagationStyle(t *testing.T) {
+	t.Setenv("DD_TRACE_PROPAGATION_STYLE_INJECT", "datadog,tracecontext,b3multi")
+	agent := agenttest.New()
+	defer agent.Close()
+	tracer.Start(tracer.WithAgentAddr(agent.Addr()), tracer.WithLogStartup(false))
+	defer tracer.Stop()
+
+	cfg := new(config)
+	defaults(cfg)
+	msg := &sarama.ProducerMessage{Topic: "test-topic", Value: sarama.StringEncoder("hello")}
+	startProducerSpan(cfg, sarama.V0_11_0_0, msg).Finish()
+
+	headers := make(map[string]string)
+	for _, h := range msg.Headers {
+		headers[string(h.Key)] = string(h.Value)
+	}
+	assert.Contains(t, headers, tracer.DefaultTraceIDHeader)
+	assert.Contains(t, headers, "traceparent")
+	assert.Contains(t, headers, "x-b3-traceid")
+}
+
+func TestDataStreams(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	cfg := new(config)
+	defaults(cfg)
+	WithDataStreams()(cfg)
+
+	// produce a message
+	pmsg := &sarama.ProducerMessage{Topic: "test-topic", Value: sarama.StringEncoder("hello")}
+	produce := startProducerSpan(cfg, sarama.V0_11_0_0, pmsg)
+	produce.Finish()
+	produced, ok := producerMessagePathway(pmsg)
+	assert.True(t, ok, "the pathway should be set in the produced message headers")
+	assert.Equal(t, strconv.FormatUint(produced.Hash(), 10), mt.FinishedSpans()[0].Tag("pathway.hash"))
+
+	// consume it, carrying the headers over
+	cmsg := &sarama.ConsumerMessage{Topic: "test-topic", Value: []byte("hello")}
+	for i := range pmsg.Headers {
+		cmsg.Headers = append(cmsg.Headers, &pmsg.Headers[i])
+	}
+	consume := startConsumerSpan(cfg, cmsg)
+	consume.Finish()
+	consumed, ok := consumerMessagePathway(cmsg)
+	assert.True(t, ok, "the pathway should be set in the consumed message headers")
+	assert.NotEqual(t, produced.Hash(), consumed.Hash())
+	assert.True(t, produced.PathwayStart().Equal(consumed.PathwayStart()))
+
+	spans := mt.FinishedSpans()
+	assert.Len(t, spans, 2)
+	s := spans[1]
+	assert.Equal(t, strconv.FormatUint(consumed.Hash(), 10), s.Tag("pathway.hash"))
+	assert.NotNil(t, s.Tag("pathway.latency_ms"))
+	assert.NotNil(t, s.Tag("pathway.edge_latency_ms"))
+
+	t.Run("disabled", func(t *testing.T) {
+		cfg := new(config)
+		defaults(cfg)
+		msg := &sarama.ProducerMessage{Topic: "test-topic", Value: sarama.StringEncoder("hello")}
+		startProducerSpan(cfg, sarama.V0_11_0_0, msg).Finish()
+		_, ok := producerMessagePathway(msg)
+		assert.False(t, ok)
+	})
+}
+
 func TestSyncProducer(t *testing.T) {
 	mt := mocktracer.Start()
 	defer mt.Stop()
@@ -129,6 +767,7 @@ func TestSyncProducer(t *testing.T) {
 
 	msg1 := &sarama.ProducerMessage{
 		Topic:    "my_topic",
+		Key:      sarama.StringEncoder("key"),
 		Value:    sarama.StringEncoder("test 1"),
 		Metadata: "test",
 	}
@@ -144,11 +783,238 @@ func TestSyncProducer(t *testing.T) {
 		assert.Equal(t, "kafka.produce", s.OperationName())
 		assert.Equal(t, int32(0), s.Tag("partition"))
 		assert.Equal(t, int64(0), s.Tag("offset"))
+		assert.Equal(t, 6, s.Tag("kafka.message_size"))
+		assert.Equal(t, 3, s.Tag("kafka.message_key_size"))
 		assert.Equal(t, "Shopify/sarama", s.Tag(ext.Component))
 		assert.Equal(t, ext.SpanKindProducer, s.Tag(ext.SpanKind))
 	}
 }
 
+func TestSpanNamer(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	seedBroker := sarama.NewMockBroker(t, 1)
+	defer seedBroker.Close()
+
+	leader := sarama.NewMockBroker(t, 2)
+	defer leader.Close()
+
+	metadataResponse := new(sarama.MetadataResponse)
+	metadataResponse.AddBroker(leader.Addr(), leader.BrokerID())
+	metadataResponse.AddTopicPartition("my_topic", 0, leader.BrokerID(), nil, nil, nil, sarama.ErrNoError)
+	seedBroker.Returns(metadataResponse)
+
+	prodSuccess := new(sarama.ProduceResponse)
+	prodSuccess.AddTopicPartition("my_topic", 0, sarama.ErrNoError)
+	leader.Returns(prodSuccess)
+
+	cfg := sarama.NewConfig()
+	cfg.Version = sarama.MinVersion
+	cfg.Producer.Return.Successes = true
+
+	producer, err := sarama.NewSyncProducer([]string{seedBroker.Addr()}, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	producer = WrapSyncProducer(cfg, producer, WithSpanNamer(func(defaultName string) string {
+		assert.Equal(t, "kafka.produce", defaultName)
+		return "my_topic send"
+	}))
+
+	producer.SendMessage(&sarama.ProducerMessage{
+		Topic: "my_topic",
+		Value: sarama.StringEncoder("test"),
+	})
+
+	spans := mt.FinishedSpans()
+	assert.Len(t, spans, 1)
+	assert.Equal(t, "my_topic send", spans[0].OperationName())
+	assert.Equal(t, "Produce Topic my_topic", spans[0].Tag(ext.ResourceName))
+}
+
+func TestSpanDecorator(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	cfg := new(config)
+	defaults(cfg)
+	WithSpanDecorator(func(span ddtrace.Span, msg *sarama.ProducerMessage) {
+		for _, h := range msg.Headers {
+			if string(h.Key) == "tenant" {
+				span.SetTag("tenant", string(h.Value))
+			}
+		}
+	})(cfg)
+	WithConsumerSpanDecorator(func(span ddtrace.Span, msg *sarama.ConsumerMessage) {
+		for _, h := range msg.Headers {
+			if string(h.Key) == "tenant" {
+				span.SetTag("tenant", string(h.Value))
+			}
+		}
+	})(cfg)
+
+	pmsg := &sarama.ProducerMessage{
+		Topic:   "test-topic",
+		Value:   sarama.StringEncoder("hello"),
+		Headers: []sarama.RecordHeader{{Key: []byte("tenant"), Value: []byte("acme")}},
+	}
+	startProducerSpan(cfg, sarama.V0_11_0_0, pmsg).Finish()
+	cmsg := &sarama.ConsumerMessage{Topic: "test-topic", Value: []byte("hello")}
+	for i := range pmsg.Headers {
+		cmsg.Headers = append(cmsg.Headers, &pmsg.Headers[i])
+	}
+	startConsumerSpan(cfg, cmsg).Finish()
+
+	spans := mt.FinishedSpans()
+	assert.Len(t, spans, 2)
+	for _, s := range spans {
+		assert.Equal(t, "acme", s.Tag("tenant"))
+	}
+}
+
+func TestMeasured(t *testing.T) {
+	for name, tc := range map[string]struct {
+		opts []Option
+		want interface{}
+	}{
+		"defaults": {want: 1},
+		"enabled":  {opts: []Option{WithMeasured(true)}, want: 1},
+		"disabled": {opts: []Option{WithMeasured(false)}, want: nil},
+	} {
+		t.Run(name, func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+
+			cfg := new(config)
+			defaults(cfg)
+			for _, opt := range tc.opts {
+				opt(cfg)
+			}
+			startConsumerSpan(cfg, &sarama.ConsumerMessage{Topic: "test-topic", Value: []byte("hello")}).Finish()
+
+			spans := mt.FinishedSpans()
+			assert.Len(t, spans, 1)
+			assert.Equal(t, tc.want, spans[0].Tag("_dd.measured"))
+		})
+	}
+}
+
+func TestSyncProducerTombstone(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	seedBroker := sarama.NewMockBroker(t, 1)
+	defer seedBroker.Close()
+	leader := sarama.NewMockBroker(t, 2)
+	defer leader.Close()
+
+	metadataResponse := new(sarama.MetadataResponse)
+	metadataResponse.AddBroker(leader.Addr(), leader.BrokerID())
+	metadataResponse.AddTopicPartition("my_topic", 0, leader.BrokerID(), nil, nil, nil, sarama.ErrNoError)
+	seedBroker.Returns(metadataResponse)
+
+	prodSuccess := new(sarama.ProduceResponse)
+	prodSuccess.AddTopicPartition("my_topic", 0, sarama.ErrNoError)
+	leader.Returns(prodSuccess)
+	leader.Returns(prodSuccess)
+
+	cfg := sarama.NewConfig()
+	cfg.Version = sarama.MinVersion
+	cfg.Producer.Return.Successes = true
+
+	producer, err := sarama.NewSyncProducer([]string{seedBroker.Addr()}, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	producer = WrapSyncProducer(cfg, producer)
+
+	tombstone := &sarama.ProducerMessage{
+		Topic: "my_topic",
+		Key:   sarama.StringEncoder("key"),
+	}
+	producer.SendMessage(tombstone)
+	msg := &sarama.ProducerMessage{
+		Topic: "my_topic",
+		Key:   sarama.StringEncoder("key"),
+		Value: sarama.StringEncoder("test 1"),
+	}
+	producer.SendMessage(msg)
+
+	spans := mt.FinishedSpans()
+	assert.Len(t, spans, 2)
+	assert.Equal(t, true, spans[0].Tag("kafka.tombstone"))
+	assert.Equal(t, 0, spans[0].Tag("kafka.message_size"))
+	assert.Nil(t, spans[1].Tag("kafka.tombstone"))
+	assert.Equal(t, 6, spans[1].Tag("kafka.message_size"))
+}
+
+func TestSyncProducerErrorCheck(t *testing.T) {
+	for name, tt := range map[string]struct {
+		opts    []Option
+		errored bool
+	}{
+		"default": {errored: true},
+		"ignored": {
+			opts: []Option{WithErrorCheck(func(err error) bool {
+				return err != sarama.ErrMessageSizeTooLarge
+			})},
+			errored: false,
+		},
+		"not-ignored": {
+			opts: []Option{WithErrorCheck(func(err error) bool {
+				return err != sarama.ErrNotLeaderForPartition
+			})},
+			errored: true,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+
+			seedBroker := sarama.NewMockBroker(t, 1)
+			defer seedBroker.Close()
+			leader := sarama.NewMockBroker(t, 2)
+			defer leader.Close()
+
+			metadataResponse := new(sarama.MetadataResponse)
+			metadataResponse.AddBroker(leader.Addr(), leader.BrokerID())
+			metadataResponse.AddTopicPartition("my_topic", 0, leader.BrokerID(), nil, nil, nil, sarama.ErrNoError)
+			seedBroker.Returns(metadataResponse)
+
+			prodFailure := new(sarama.ProduceResponse)
+			prodFailure.AddTopicPartition("my_topic", 0, sarama.ErrMessageSizeTooLarge)
+			leader.Returns(prodFailure)
+
+			cfg := sarama.NewConfig()
+			cfg.Version = sarama.MinVersion
+			cfg.Producer.Return.Successes = true
+			cfg.Producer.Retry.Max = 0
+
+			producer, err := sarama.NewSyncProducer([]string{seedBroker.Addr()}, cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer producer.Close()
+			producer = WrapSyncProducer(cfg, producer, tt.opts...)
+
+			_, _, err = producer.SendMessage(&sarama.ProducerMessage{
+				Topic: "my_topic",
+				Value: sarama.StringEncoder("test 1"),
+			})
+			assert.Equal(t, sarama.ErrMessageSizeTooLarge, err, "the error should always be returned")
+
+			spans := mt.FinishedSpans()
+			assert.Len(t, spans, 1)
+			if tt.errored {
+				assert.Equal(t, sarama.ErrMessageSizeTooLarge, spans[0].Tag(ext.Error))
+			} else {
+				assert.Nil(t, spans[0].Tag(ext.Error))
+			}
+		})
+	}
+}
+
 func TestSyncProducerSendMessages(t *testing.T) {
 	mt := mocktracer.Start()
 	defer mt.Stop()
@@ -197,9 +1063,147 @@ func TestSyncProducerSendMessages(t *testing.T) {
 		assert.Equal(t, "Produce Topic my_topic", s.Tag(ext.ResourceName))
 		assert.Equal(t, "kafka.produce", s.OperationName())
 		assert.Equal(t, int32(0), s.Tag("partition"))
+		assert.Equal(t, 6, s.Tag("kafka.message_size"))
 		assert.Equal(t, "Shopify/sarama", s.Tag(ext.Component))
 		assert.Equal(t, ext.SpanKindProducer, s.Tag(ext.SpanKind))
 	}
+	// the spans of the batch, finished in order, are linked to the span of
+	// the first message
+	first, second := spans[0].(mocktracer.LinkedSpan), spans[1].(mocktracer.LinkedSpan)
+	assert.Empty(t, first.Links())
+	if assert.Len(t, second.Links(), 1) {
+		assert.Equal(t, first.SpanID(), second.Links()[0].SpanID())
+	}
+}
+
+func TestAsyncProducerErrorsOnly(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	cfg := sarama.NewConfig()
+	cfg.Version = sarama.V0_11_0_0
+	cfg.Producer.Return.Successes = false
+	cfg.Producer.Return.Errors = true
+	mp := mocks.NewAsyncProducer(t, cfg)
+	mp.ExpectInputAndFail(errors.New("kafka is down"))
+	mp.ExpectInputAndSucceed()
+	producer := WrapAsyncProducer(cfg, mp, WithInflightSpans(0, 50*time.Millisecond))
+	defer producer.AsyncClose()
+
+	failed := &sarama.ProducerMessage{Topic: "my_topic", Value: sarama.StringEncoder("test 1")}
+	producer.Input() <- failed
+	perr := <-producer.Errors()
+	assert.Equal(t, failed, perr.Msg)
+
+	producer.Input() <- &sarama.ProducerMessage{Topic: "my_topic", Value: sarama.StringEncoder("test 2")}
+	waitForSpans(mt, 2, time.Second)
+
+	spans := mt.FinishedSpans()
+	assert.Len(t, spans, 2)
+	assert.Equal(t, perr, spans[0].Tag(ext.Error), "the span of the failed message should be errored")
+	assert.Nil(t, spans[1].Tag(ext.Error))
+}
+
+// lossyProducer is an AsyncProducer never returning the outcome of the
+// messages sent to it.
+type lossyProducer struct {
+	sarama.AsyncProducer
+	input     chan *sarama.ProducerMessage
+	successes chan *sarama.ProducerMessage
+	errors    chan *sarama.ProducerError
+}
+
+func newLossyProducer() *lossyProducer {
+	p := &lossyProducer{
+		input:     make(chan *sarama.ProducerMessage),
+		successes: make(chan *sarama.ProducerMessage),
+		errors:    make(chan *sarama.ProducerError),
+	}
+	go func() {
+		for range p.input {
+		}
+	}()
+	return p
+}
+
+func (p *lossyProducer) Input() chan<- *sarama.ProducerMessage     { return p.input }
+func (p *lossyProducer) Successes() <-chan *sarama.ProducerMessage { return p.successes }
+func (p *lossyProducer) Errors() <-chan *sarama.ProducerError      { return p.errors }
+
+func (p *lossyProducer) AsyncClose() {
+	close(p.input)
+	close(p.successes)
+	close(p.errors)
+}
+
+func TestAsyncProducerLostMessages(t *testing.T) {
+	cfg := sarama.NewConfig()
+	cfg.Version = sarama.V0_11_0_0
+	cfg.Producer.Return.Successes = true
+
+	t.Run("timeout", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		producer := WrapAsyncProducer(cfg, newLossyProducer(), WithInflightSpans(0, 50*time.Millisecond))
+		defer producer.AsyncClose()
+		producer.Input() <- &sarama.ProducerMessage{Topic: "my_topic", Value: sarama.StringEncoder("test 1")}
+		waitForSpans(mt, 1, time.Second)
+
+		spans := mt.FinishedSpans()
+		assert.Len(t, spans, 1)
+		assert.Equal(t, "unknown", spans[0].Tag("kafka.delivery"))
+	})
+
+	t.Run("max-size", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		producer := WrapAsyncProducer(cfg, newLossyProducer(), WithInflightSpans(1, 0))
+		defer producer.AsyncClose()
+		first := &sarama.ProducerMessage{Topic: "my_topic", Value: sarama.StringEncoder("test 1")}
+		producer.Input() <- first
+		producer.Input() <- &sarama.ProducerMessage{Topic: "my_topic", Value: sarama.StringEncoder("test 2")}
+		waitForSpans(mt, 1, time.Second)
+
+		spans := mt.FinishedSpans()
+		assert.Len(t, spans, 1, "only the oldest span should be evicted")
+		spanctx, ok := getSpanContext(first)
+		assert.True(t, ok)
+		assert.Equal(t, spanctx.SpanID(), spans[0].SpanID())
+		assert.Equal(t, "unknown", spans[0].Tag("kafka.delivery"))
+	})
+}
+
+func TestInflightSpans(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	inflight := newInflightSpans(false, 3, time.Minute)
+	var spans []ddtrace.Span
+	for i := 0; i < 3; i++ {
+		span := tracer.StartSpan("kafka.produce")
+		spans = append(spans, span)
+		inflight.add(span)
+	}
+	// the removed spans aren't evicted
+	span, ok := inflight.remove(spans[0].Context().SpanID())
+	assert.True(t, ok)
+	assert.Equal(t, spans[0], span)
+	_, ok = inflight.remove(spans[0].Context().SpanID())
+	assert.False(t, ok)
+
+	// the oldest span is evicted once the maximum is reached
+	inflight.add(tracer.StartSpan("kafka.produce"))
+	inflight.add(tracer.StartSpan("kafka.produce"))
+	finished := mt.FinishedSpans()
+	assert.Len(t, finished, 1)
+	assert.Equal(t, spans[1].Context().SpanID(), finished[0].SpanID())
+
+	inflight.expire(time.Now().Add(time.Minute))
+	assert.Len(t, mt.FinishedSpans(), 4)
+	assert.Empty(t, inflight.spans)
+	assert.Equal(t, 0, inflight.order.Len())
 }
 
 func TestAsyncProducer(t *testing.T) {
@@ -239,6 +1243,7 @@ func TestAsyncProducer(t *testing.T) {
 			assert.Equal(t, "kafka.produce", s.OperationName())
 			assert.Equal(t, int32(0), s.Tag("partition"))
 			assert.Equal(t, int64(0), s.Tag("offset"))
+			assert.Equal(t, 6, s.Tag("kafka.message_size"))
 			assert.Equal(t, "Shopify/sarama", s.Tag(ext.Component))
 			assert.Equal(t, ext.SpanKindProducer, s.Tag(ext.SpanKind))
 		}
@@ -279,6 +1284,7 @@ func TestAsyncProducer(t *testing.T) {
 			assert.Equal(t, "kafka.produce", s.OperationName())
 			assert.Equal(t, int32(0), s.Tag("partition"))
 			assert.Equal(t, int64(0), s.Tag("offset"))
+			assert.Equal(t, 6, s.Tag("kafka.message_size"))
 			assert.Equal(t, "Shopify/sarama", s.Tag(ext.Component))
 			assert.Equal(t, ext.SpanKindProducer, s.Tag(ext.SpanKind))
 		}
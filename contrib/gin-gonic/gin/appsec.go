@@ -31,7 +31,7 @@ func useAppSec(c *gin.Context, span tracer.Span) func() {
 	ctx, op := httpsec.StartOperation(req.Context(), args)
 	c.Request = req.WithContext(ctx)
 	return func() {
-		events := op.Finish(httpsec.HandlerOperationRes{Status: c.Writer.Status()})
+		events := op.Finish(httpsec.HandlerOperationRes{Status: c.Writer.Status(), Headers: c.Writer.Header()})
 		if len(events) > 0 {
 			remoteIP, _, err := net.SplitHostPort(req.RemoteAddr)
 			if err != nil {
@@ -10,17 +10,28 @@ import (
 	"context"
 	"fmt"
 	"math"
+	"net"
 	"strconv"
 	"strings"
+	"time"
 
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/log"
 
+	"github.com/DataDog/datadog-agent/pkg/obfuscate"
 	"github.com/gocql/gocql"
 )
 
+const (
+	// batchStatementName is the operation name of the spans of the statements
+	// of a batch, created with WithBatchChildSpans.
+	batchStatementName = "cassandra.batch.statement"
+	// tagBatchIndex is the index of the statement of a batch.
+	tagBatchIndex = "cassandra.batch.index"
+)
+
 // Query inherits from gocql.Query, it keeps the tracer and the context.
 type Query struct {
 	*gocql.Query
@@ -31,13 +42,19 @@ type Query struct {
 // Iter inherits from gocql.Iter and contains a span.
 type Iter struct {
 	*gocql.Iter
-	span ddtrace.Span
+	span   ddtrace.Span
+	query  *gocql.Query
+	config *queryConfig
+	// rows counts the rows fetched with MapScan and SliceMap.
+	rows int
 }
 
 // Scanner inherits from a gocql.Scanner derived from an Iter
 type Scanner struct {
 	gocql.Scanner
-	span ddtrace.Span
+	span   ddtrace.Span
+	query  *gocql.Query
+	config *queryConfig
 }
 
 // Batch inherits from gocql.Batch, it keeps the tracer and the context.
@@ -52,6 +69,8 @@ type params struct {
 	config    *queryConfig
 	keyspace  string
 	paginated bool
+	// args are the arguments bound with Query.Bind, recorded with WithQueryArgs.
+	args []interface{}
 }
 
 // WrapQuery wraps a gocql.Query into a traced Query under the given service name.
@@ -72,6 +91,9 @@ func WrapQuery(q *gocql.Query, opts ...WrapOption) *Query {
 	if cfg.resourceName == "" {
 		if parts := strings.SplitN(q.String(), "\"", 3); len(parts) == 3 {
 			cfg.resourceName = parts[1]
+			if cfg.normalizeQuery {
+				cfg.resourceName = normalizeQuery(cfg.resourceName)
+			}
 		}
 	}
 	log.Debug("contrib/gocql/gocql: Wrapping Query: %#v", cfg)
@@ -93,6 +115,14 @@ func (tq *Query) PageState(state []byte) *Query {
 	return tq
 }
 
+// Bind rewrites the original function so that the bound arguments can be
+// recorded by WithQueryArgs.
+func (tq *Query) Bind(v ...interface{}) *Query {
+	tq.params.args = v
+	tq.Query = tq.Query.Bind(v...)
+	return tq
+}
+
 // NewChildSpan creates a new span from the params and the context.
 func (tq *Query) newChildSpan(ctx context.Context) ddtrace.Span {
 	p := tq.params
@@ -108,11 +138,21 @@ func (tq *Query) newChildSpan(ctx context.Context) ddtrace.Span {
 	if !math.IsNaN(p.config.analyticsRate) {
 		opts = append(opts, tracer.Tag(ext.EventSampleRate, p.config.analyticsRate))
 	}
-	span, _ := tracer.StartSpanFromContext(ctx, ext.CassandraQuery, opts...)
+	if p.config.measured {
+		opts = append(opts, tracer.Measured())
+	}
+	if p.config.queryArgs {
+		opts = append(opts, tracer.Tag(ext.CassandraArgs, formatArgs(p.args)))
+	}
+	for k, v := range p.config.tags {
+		opts = append(opts, tracer.Tag(k, v))
+	}
+	span, _ := tracer.StartSpanFromContext(ctx, p.config.spanNamer(ext.CassandraQuery), opts...)
 	return span
 }
 
 func (tq *Query) finishSpan(span ddtrace.Span, err error) {
+	setCancelled(span, err)
 	if err != nil && tq.params.config.shouldIgnoreError(err) {
 		err = nil
 	}
@@ -123,6 +163,66 @@ func (tq *Query) finishSpan(span ddtrace.Span, err error) {
 	}
 }
 
+const (
+	// maxArgLength is the maximum length of a string argument recorded by formatArgs.
+	maxArgLength = 64
+	// maxArgsLength is the maximum length of the query arguments recorded by formatArgs.
+	maxArgsLength = 512
+)
+
+// formatArgs returns a sanitized and length-capped representation of the
+// given query arguments.
+func formatArgs(args []interface{}) string {
+	var sb strings.Builder
+	sb.WriteByte('[')
+	for i, arg := range args {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		switch v := arg.(type) {
+		case nil:
+			sb.WriteString("NULL")
+		case []byte:
+			fmt.Fprintf(&sb, "<%d bytes>", len(v))
+		case string:
+			if len(v) > maxArgLength {
+				v = v[:maxArgLength] + "..."
+			}
+			sb.WriteString(strconv.Quote(v))
+		default:
+			s := fmt.Sprintf("%v", v)
+			if len(s) > maxArgLength {
+				s = s[:maxArgLength] + "..."
+			}
+			sb.WriteString(s)
+		}
+		if sb.Len() > maxArgsLength {
+			break
+		}
+	}
+	sb.WriteByte(']')
+	if s := sb.String(); len(s) > maxArgsLength {
+		return s[:maxArgsLength] + "...]"
+	}
+	return sb.String()
+}
+
+// queryObfuscator obfuscates the queries normalized with WithQueryNormalization
+// as the agent obfuscates the resource names of the cassandra spans.
+var queryObfuscator = obfuscate.NewObfuscator(obfuscate.Config{})
+
+// normalizeQuery returns the given CQL query with its literals replaced by a ?
+// placeholder and its whitespaces collapsed. The queries which cannot be parsed
+// are returned unchanged.
+func normalizeQuery(query string) string {
+	oq, err := queryObfuscator.ObfuscateSQLString(query)
+	if err != nil {
+		log.Debug("contrib/gocql/gocql: cannot normalize query %q: %v", query, err)
+		return query
+	}
+	return oq.Query
+}
+
 // Exec is rewritten so that it passes by our custom Iter
 func (tq *Query) Exec() error {
 	return tq.Iter().Close()
@@ -163,10 +263,13 @@ func (tq *Query) Iter() *Iter {
 	if len(columns) > 0 {
 		span.SetTag(ext.CassandraKeyspace, columns[0].Keyspace)
 	}
-	tIter := &Iter{iter, span}
+	tIter := &Iter{Iter: iter, span: span, query: tq.Query, config: tq.params.config}
 	if tIter.Host() != nil {
-		tIter.span.SetTag(ext.TargetHost, tIter.Iter.Host().HostID())
-		tIter.span.SetTag(ext.TargetPort, strconv.Itoa(tIter.Iter.Host().Port()))
+		if tq.params.config.hostTags {
+			tIter.span.SetTag(ext.TargetHost, tIter.Iter.Host().HostID())
+			tIter.span.SetTag(ext.TargetPort, strconv.Itoa(tIter.Iter.Host().Port()))
+			tIter.span.SetTag(ext.CassandraCoordinator, coordinator(tIter.Iter.Host()))
+		}
 		tIter.span.SetTag(ext.CassandraCluster, tIter.Iter.Host().DataCenter())
 	}
 	return tIter
@@ -175,13 +278,37 @@ func (tq *Query) Iter() *Iter {
 // Close closes the Iter and finish the span created on Iter call.
 func (tIter *Iter) Close() error {
 	err := tIter.Iter.Close()
-	if err != nil {
+	if tIter.rows > 0 {
+		// replace the row count of the first page by the rows actually fetched
+		tIter.span.SetTag(ext.CassandraRowCount, strconv.Itoa(tIter.rows))
+	}
+	setCancelled(tIter.span, err)
+	if err != nil && !tIter.config.shouldIgnoreError(err) {
 		tIter.span.SetTag(ext.Error, err)
 	}
+	setAttempts(tIter.span, tIter.query)
 	tIter.span.Finish()
 	return err
 }
 
+// MapScan calls the wrapped Iter.MapScan, counting the fetched rows in the
+// cassandra.row_count tag of the span, which is finished by Close.
+func (tIter *Iter) MapScan(m map[string]interface{}) bool {
+	ok := tIter.Iter.MapScan(m)
+	if ok {
+		tIter.rows++
+	}
+	return ok
+}
+
+// SliceMap calls the wrapped Iter.SliceMap, counting the fetched rows in the
+// cassandra.row_count tag of the span, which is finished by Close.
+func (tIter *Iter) SliceMap() ([]map[string]interface{}, error) {
+	rows, err := tIter.Iter.SliceMap()
+	tIter.rows += len(rows)
+	return rows, err
+}
+
 // Scanner returns a row Scanner which provides an interface to scan rows in a
 // manner which is similar to database/sql. The Iter should NOT be used again after
 // calling this method.
@@ -189,19 +316,51 @@ func (tIter *Iter) Scanner() gocql.Scanner {
 	return &Scanner{
 		Scanner: tIter.Iter.Scanner(),
 		span:    tIter.span,
+		query:   tIter.query,
+		config:  tIter.config,
 	}
 }
 
 // Err calls the wrapped Scanner.Err, releasing the Scanner resources and closing the span.
 func (s *Scanner) Err() error {
 	err := s.Scanner.Err()
-	if err != nil {
+	setCancelled(s.span, err)
+	if err != nil && !s.config.shouldIgnoreError(err) {
 		s.span.SetTag(ext.Error, err)
 	}
+	setAttempts(s.span, s.query)
 	s.span.Finish()
 	return err
 }
 
+// coordinator returns the address of the given host, which coordinated a query.
+func coordinator(host *gocql.HostInfo) string {
+	return net.JoinHostPort(host.ConnectAddress().String(), strconv.Itoa(host.Port()))
+}
+
+// setCancelled tags the span of a query interrupted by the cancellation of
+// its context, to tell it apart from the errors of the Cassandra cluster.
+func setCancelled(span ddtrace.Span, err error) {
+	if isCancelled(err) {
+		span.SetTag(ext.CassandraErrorCancelled, true)
+	}
+}
+
+// setAttempts tags the span with the number of times the query was executed
+// and retried, once all its pages were fetched.
+func setAttempts(span ddtrace.Span, q *gocql.Query) {
+	if q == nil {
+		return
+	}
+	attempts := q.Attempts()
+	retries := attempts - 1
+	if retries < 0 {
+		retries = 0
+	}
+	span.SetTag(ext.CassandraAttempts, attempts)
+	span.SetTag(ext.CassandraRetries, retries)
+}
+
 // WrapBatch wraps a gocql.Batch into a traced Batch under the given service name.
 // Note that the returned Batch structure embeds the original gocql.Batch structure.
 // This means that any method returning the batch for chaining that is not part
@@ -241,11 +400,38 @@ func (tb *Batch) WithTimestamp(timestamp int64) *Batch {
 // ExecuteBatch calls session.ExecuteBatch on the Batch, tracing the execution.
 func (tb *Batch) ExecuteBatch(session *gocql.Session) error {
 	span := tb.newChildSpan(tb.ctx)
+	start := time.Now()
 	err := session.ExecuteBatch(tb.Batch)
+	if tb.params.config.batchChildSpans {
+		tb.statementSpans(span, start)
+	}
 	tb.finishSpan(span, err)
 	return err
 }
 
+// statementSpans creates a child span of the batch span for each statement of
+// the batch, covering the execution of the batch started at start.
+func (tb *Batch) statementSpans(span ddtrace.Span, start time.Time) {
+	cfg := tb.params.config
+	for i, entry := range tb.Entries {
+		resource := entry.Stmt
+		if cfg.normalizeQuery {
+			resource = normalizeQuery(resource)
+		}
+		child := tracer.StartSpan(cfg.spanNamer(batchStatementName),
+			tracer.ChildOf(span.Context()),
+			tracer.StartTime(start),
+			tracer.SpanType(ext.SpanTypeCassandra),
+			tracer.ServiceName(cfg.serviceName),
+			tracer.ResourceName(resource),
+			tracer.Tag(tagBatchIndex, i),
+			tracer.Tag(ext.Component, "gocql/gocql"),
+			tracer.Tag(ext.SpanKind, ext.SpanKindClient),
+		)
+		child.Finish()
+	}
+}
+
 // newChildSpan creates a new span from the params and the context.
 func (tb *Batch) newChildSpan(ctx context.Context) ddtrace.Span {
 	p := tb.params
@@ -253,19 +439,27 @@ func (tb *Batch) newChildSpan(ctx context.Context) ddtrace.Span {
 		tracer.SpanType(ext.SpanTypeCassandra),
 		tracer.ServiceName(p.config.serviceName),
 		tracer.ResourceName(p.config.resourceName),
-		tracer.Tag(ext.CassandraConsistencyLevel, tb.Cons.String()),
+		tracer.Tag(ext.CassandraConsistencyLevel, tb.GetConsistency().String()),
 		tracer.Tag(ext.CassandraKeyspace, tb.Keyspace()),
+		tracer.Tag(ext.CassandraBatchSize, tb.Size()),
 		tracer.Tag(ext.Component, "gocql/gocql"),
 		tracer.Tag(ext.SpanKind, ext.SpanKindClient),
 	}
 	if !math.IsNaN(p.config.analyticsRate) {
 		opts = append(opts, tracer.Tag(ext.EventSampleRate, p.config.analyticsRate))
 	}
-	span, _ := tracer.StartSpanFromContext(ctx, ext.CassandraBatch, opts...)
+	if p.config.measured {
+		opts = append(opts, tracer.Measured())
+	}
+	for k, v := range p.config.tags {
+		opts = append(opts, tracer.Tag(k, v))
+	}
+	span, _ := tracer.StartSpanFromContext(ctx, p.config.spanNamer(ext.CassandraBatch), opts...)
 	return span
 }
 
 func (tb *Batch) finishSpan(span ddtrace.Span, err error) {
+	setCancelled(span, err)
 	if err != nil && tb.params.config.shouldIgnoreError(err) {
 		err = nil
 	}
@@ -11,9 +11,12 @@ import (
 	"log"
 	"math"
 	"os"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
+	"gopkg.in/DataDog/dd-trace-go.v1/contrib/internal/agenttest"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/mocktracer"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
@@ -139,6 +142,56 @@ func TestChildWrapperSpan(t *testing.T) {
 	}
 }
 
+func TestGlobalTag(t *testing.T) {
+	agent := agenttest.New()
+	defer agent.Close()
+	tracer.Start(
+		tracer.WithAgentAddr(agent.Addr()),
+		tracer.WithLogStartup(false),
+		tracer.WithGlobalTag("region", "us-east-1"),
+		tracer.WithGlobalTag(ext.Component, "global"),
+	)
+	defer tracer.Stop()
+
+	cluster := newCassandraCluster()
+	session, err := cluster.CreateSession()
+	assert.Nil(t, err)
+	iter := WrapQuery(session.Query("SELECT * FROM trace.person")).Iter()
+	iter.Close()
+	assert.NoError(t, tracer.FlushWithTimeout(5*time.Second))
+
+	spans := agent.Spans()
+	assert.Len(t, spans, 1)
+	for _, s := range spans {
+		assert.Equal(t, ext.CassandraQuery, s.Name)
+		assert.Equal(t, "us-east-1", s.Meta["region"])
+		// the span options take precedence over the global tags
+		assert.Equal(t, "gocql/gocql", s.Meta[ext.Component])
+	}
+}
+
+func TestMaxTagValueLength(t *testing.T) {
+	agent := agenttest.New()
+	defer agent.Close()
+	tracer.Start(
+		tracer.WithAgentAddr(agent.Addr()),
+		tracer.WithLogStartup(false),
+		tracer.WithMaxTagValueLength(16),
+	)
+	defer tracer.Stop()
+
+	cluster := newCassandraCluster()
+	session, err := cluster.CreateSession()
+	assert.Nil(t, err)
+	iter := WrapQuery(session.Query("SELECT name, age, description FROM trace.person")).Iter()
+	iter.Close()
+	assert.NoError(t, tracer.FlushWithTimeout(5*time.Second))
+
+	spans := agent.Spans()
+	assert.Len(t, spans, 1)
+	assert.Equal(t, "SELECT name, age...", spans[0].Resource)
+}
+
 func TestErrNotFound(t *testing.T) {
 	assert := assert.New(t)
 	mt := mocktracer.Start()
@@ -194,6 +247,54 @@ func TestErrNotFound(t *testing.T) {
 	})
 }
 
+func TestContextCancelled(t *testing.T) {
+	cluster := newCassandraCluster()
+	session, err := cluster.CreateSession()
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	t.Run("default", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		err := WrapQuery(session.Query("SELECT * FROM trace.person")).WithContext(ctx).Exec()
+		assert.ErrorIs(t, err, context.Canceled)
+
+		spans := mt.FinishedSpans()
+		assert.Len(t, spans, 1)
+		assert.Equal(t, true, spans[0].Tag(ext.CassandraErrorCancelled))
+		assert.Nil(t, spans[0].Tag(ext.Error), "a cancellation is not an error of the cluster")
+	})
+
+	t.Run("WithErrorCheck", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		q := WrapQuery(session.Query("SELECT * FROM trace.person"), WithErrorCheck(func(error) bool { return true }))
+		err := q.WithContext(ctx).Exec()
+		assert.ErrorIs(t, err, context.Canceled)
+
+		spans := mt.FinishedSpans()
+		assert.Len(t, spans, 1)
+		assert.Equal(t, true, spans[0].Tag(ext.CassandraErrorCancelled))
+		assert.NotNil(t, spans[0].Tag(ext.Error))
+	})
+
+	t.Run("not-cancelled", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		err := WrapQuery(session.Query("SELECT * FROM trace.person")).Exec()
+		assert.NoError(t, err)
+
+		spans := mt.FinishedSpans()
+		assert.Len(t, spans, 1)
+		assert.Nil(t, spans[0].Tag(ext.CassandraErrorCancelled))
+	})
+}
+
 func TestAnalyticsSettings(t *testing.T) {
 	assertRate := func(t *testing.T, mt mocktracer.Tracer, rate float64, opts ...WrapOption) {
 		cluster := newCassandraCluster()
@@ -314,6 +415,81 @@ func TestIterScanner(t *testing.T) {
 
 }
 
+func TestIterMapScan(t *testing.T) {
+	cluster := newCassandraCluster()
+	session, err := cluster.CreateSession()
+	assert.NoError(t, err)
+
+	t.Run("MapScan", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		iter := WrapQuery(session.Query("SELECT * from trace.person")).Iter()
+		var n int
+		for m := map[string]interface{}{}; iter.MapScan(m); m = map[string]interface{}{} {
+			n++
+		}
+		assert.NoError(t, iter.Close())
+		assert.NotZero(t, n)
+
+		spans := mt.FinishedSpans()
+		assert.Len(t, spans, 1)
+		assert.Equal(t, ext.CassandraQuery, spans[0].OperationName())
+		assert.Equal(t, strconv.Itoa(n), spans[0].Tag(ext.CassandraRowCount))
+	})
+
+	t.Run("SliceMap", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		iter := WrapQuery(session.Query("SELECT * from trace.person")).Iter()
+		rows, err := iter.SliceMap()
+		assert.NoError(t, err)
+		assert.NoError(t, iter.Close())
+		assert.NotEmpty(t, rows)
+
+		spans := mt.FinishedSpans()
+		assert.Len(t, spans, 1)
+		assert.Equal(t, strconv.Itoa(len(rows)), spans[0].Tag(ext.CassandraRowCount))
+	})
+}
+
+func TestQueryAttempts(t *testing.T) {
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	cluster := newCassandraCluster()
+	session, err := cluster.CreateSession()
+	assert.NoError(err)
+
+	t.Run("iter", func(t *testing.T) {
+		mt.Reset()
+		q := session.Query("SELECT * from trace.person")
+		err := WrapQuery(q, WithServiceName("TestServiceName")).Iter().Close()
+		assert.NoError(err)
+
+		spans := mt.FinishedSpans()
+		assert.Len(spans, 1)
+		assert.GreaterOrEqual(spans[0].Tag(ext.CassandraAttempts), 1)
+		assert.Equal(spans[0].Tag(ext.CassandraAttempts).(int)-1, spans[0].Tag(ext.CassandraRetries))
+	})
+
+	t.Run("scanner", func(t *testing.T) {
+		mt.Reset()
+		q := session.Query("SELECT * from trace.person")
+		sc := WrapQuery(q, WithServiceName("TestServiceName")).Iter().Scanner()
+		for sc.Next() {
+		}
+		assert.NoError(sc.Err())
+
+		spans := mt.FinishedSpans()
+		assert.Len(spans, 1)
+		assert.GreaterOrEqual(spans[0].Tag(ext.CassandraAttempts), 1)
+		assert.Equal(spans[0].Tag(ext.CassandraAttempts).(int)-1, spans[0].Tag(ext.CassandraRetries))
+	})
+}
+
 func TestBatch(t *testing.T) {
 	assert := assert.New(t)
 	mt := mocktracer.Start()
@@ -354,6 +530,318 @@ func TestBatch(t *testing.T) {
 	assert.Equal(childSpan.OperationName(), ext.CassandraBatch)
 	assert.Equal(childSpan.Tag(ext.ResourceName), "BatchInsert")
 	assert.Equal(childSpan.Tag(ext.CassandraKeyspace), "trace")
+	assert.Equal(childSpan.Tag(ext.CassandraBatchSize), 2)
 	assert.Equal(childSpan.Tag(ext.Component), "gocql/gocql")
 	assert.Equal(childSpan.Tag(ext.SpanKind), ext.SpanKindClient)
 }
+
+func TestBatchChildSpans(t *testing.T) {
+	cluster := newCassandraCluster()
+	cluster.Keyspace = "trace"
+	session, err := cluster.CreateSession()
+	assert.NoError(t, err)
+
+	stmts := []string{
+		"INSERT INTO trace.person (name, age, description) VALUES (?, ?, ?)",
+		"UPDATE trace.person SET age = ? WHERE name = ?",
+	}
+	for name, tt := range map[string]struct {
+		opts     []WrapOption
+		children int
+	}{
+		"default":  {children: 0},
+		"enabled":  {opts: []WrapOption{WithBatchChildSpans(true)}, children: 2},
+		"disabled": {opts: []WrapOption{WithBatchChildSpans(false)}, children: 0},
+	} {
+		t.Run(name, func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+
+			tb := WrapBatch(session.NewBatch(gocql.UnloggedBatch), tt.opts...)
+			tb.Query(stmts[0], "Kate", 80, "Cassandra's sister")
+			tb.Query(stmts[1], 81, "Kate")
+			err := tb.ExecuteBatch(session)
+			assert.NoError(t, err)
+
+			spans := mt.FinishedSpans()
+			assert.Len(t, spans, tt.children+1)
+			batch := spans[len(spans)-1]
+			assert.Equal(t, ext.CassandraBatch, batch.OperationName())
+			for i, s := range spans[:tt.children] {
+				assert.Equal(t, "cassandra.batch.statement", s.OperationName())
+				assert.Equal(t, batch.SpanID(), s.ParentID())
+				assert.Equal(t, stmts[i], s.Tag(ext.ResourceName))
+				assert.Equal(t, i, s.Tag("cassandra.batch.index"))
+			}
+		})
+	}
+}
+
+func TestBatchConsistency(t *testing.T) {
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	cluster := newCassandraCluster()
+	cluster.Keyspace = "trace"
+	session, err := cluster.CreateSession()
+	assert.NoError(err)
+
+	b := session.NewBatch(gocql.UnloggedBatch)
+	b.SetConsistency(gocql.One)
+	tb := WrapBatch(b, WithServiceName("TestServiceName"), WithResourceName("BatchInsert"))
+	tb.Query("INSERT INTO trace.person (name, age, description) VALUES (?, ?, ?)", "Kate", 80, "Cassandra's sister")
+	err = tb.ExecuteBatch(session)
+	assert.NoError(err)
+
+	spans := mt.FinishedSpans()
+	assert.Len(spans, 1)
+	assert.Equal(ext.CassandraBatch, spans[0].OperationName())
+	assert.Equal(gocql.One.String(), spans[0].Tag(ext.CassandraConsistencyLevel))
+	assert.Equal(1, spans[0].Tag(ext.CassandraBatchSize))
+}
+
+func TestSpanNamer(t *testing.T) {
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	cluster := newCassandraCluster()
+	cluster.Keyspace = "trace"
+	session, err := cluster.CreateSession()
+	assert.NoError(err)
+
+	namer := WithSpanNamer(func(defaultName string) string {
+		return "db." + defaultName
+	})
+	q := session.Query("SELECT * from trace.person")
+	err = WrapQuery(q, namer).Exec()
+	assert.NoError(err)
+	tb := WrapBatch(session.NewBatch(gocql.UnloggedBatch), namer)
+	tb.Query("INSERT INTO trace.person (name, age, description) VALUES (?, ?, ?)", "Kate", 80, "Cassandra's sister")
+	err = tb.ExecuteBatch(session)
+	assert.NoError(err)
+
+	spans := mt.FinishedSpans()
+	assert.Len(spans, 2)
+	assert.Equal("db."+ext.CassandraQuery, spans[0].OperationName())
+	assert.Equal("db."+ext.CassandraBatch, spans[1].OperationName())
+}
+
+func TestMeasured(t *testing.T) {
+	for name, tc := range map[string]struct {
+		opts []WrapOption
+		want interface{}
+	}{
+		"defaults": {want: nil},
+		"enabled":  {opts: []WrapOption{WithMeasured(true)}, want: 1},
+		"disabled": {opts: []WrapOption{WithMeasured(false)}, want: nil},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			mt := mocktracer.Start()
+			defer mt.Stop()
+
+			cluster := newCassandraCluster()
+			cluster.Keyspace = "trace"
+			session, err := cluster.CreateSession()
+			assert.NoError(err)
+
+			q := session.Query("SELECT * from trace.person")
+			err = WrapQuery(q, tc.opts...).Exec()
+			assert.NoError(err)
+			tb := WrapBatch(session.NewBatch(gocql.UnloggedBatch), tc.opts...)
+			tb.Query("INSERT INTO trace.person (name, age, description) VALUES (?, ?, ?)", "Kate", 80, "Cassandra's sister")
+			err = tb.ExecuteBatch(session)
+			assert.NoError(err)
+
+			spans := mt.FinishedSpans()
+			assert.Len(spans, 2)
+			for _, s := range spans {
+				assert.Equal(tc.want, s.Tag("_dd.measured"))
+			}
+		})
+	}
+}
+
+func TestQueryArgs(t *testing.T) {
+	cluster := newCassandraCluster()
+	session, err := cluster.CreateSession()
+	assert.NoError(t, err)
+
+	t.Run("disabled", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		q := session.Query("SELECT name, age FROM trace.person WHERE name = ?", "Cassandra")
+		err := WrapQuery(q).Exec()
+		assert.NoError(t, err)
+
+		spans := mt.FinishedSpans()
+		assert.Len(t, spans, 1)
+		assert.Nil(t, spans[0].Tag(ext.CassandraArgs))
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		q := session.Query("SELECT name, age FROM trace.person WHERE name = ?")
+		err := WrapQuery(q, WithQueryArgs()).Bind("Cassandra").Exec()
+		assert.NoError(t, err)
+
+		spans := mt.FinishedSpans()
+		assert.Len(t, spans, 1)
+		assert.Equal(t, `["Cassandra"]`, spans[0].Tag(ext.CassandraArgs))
+	})
+}
+
+func TestHostTags(t *testing.T) {
+	cluster := newCassandraCluster()
+	session, err := cluster.CreateSession()
+	assert.NoError(t, err)
+
+	t.Run("enabled", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		iter := WrapQuery(session.Query("SELECT * FROM trace.person")).Iter()
+		assert.NoError(t, iter.Close())
+
+		spans := mt.FinishedSpans()
+		assert.Len(t, spans, 1)
+		if iter.Host() != nil {
+			assert.Equal(t, iter.Host().HostID(), spans[0].Tag(ext.TargetHost))
+			assert.Equal(t, "9042", spans[0].Tag(ext.TargetPort))
+			assert.Equal(t, cassandraHost, spans[0].Tag(ext.CassandraCoordinator))
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		iter := WrapQuery(session.Query("SELECT * FROM trace.person"), WithHostTags(false)).Iter()
+		assert.NoError(t, iter.Close())
+
+		spans := mt.FinishedSpans()
+		assert.Len(t, spans, 1)
+		assert.Nil(t, spans[0].Tag(ext.TargetHost))
+		assert.Nil(t, spans[0].Tag(ext.TargetPort))
+		assert.Nil(t, spans[0].Tag(ext.CassandraCoordinator))
+		if iter.Host() != nil {
+			assert.Equal(t, "datacenter1", spans[0].Tag(ext.CassandraCluster))
+		}
+	})
+}
+
+func TestCustomTag(t *testing.T) {
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	cluster := newCassandraCluster()
+	session, err := cluster.CreateSession()
+	assert.NoError(err)
+
+	opts := []WrapOption{WithCustomTag("foo", "bar"), WithCustomTag("answer", 42)}
+	err = WrapQuery(session.Query("SELECT * FROM trace.person"), opts...).Exec()
+	assert.NoError(err)
+	tb := WrapBatch(session.NewBatch(gocql.UnloggedBatch), opts...)
+	tb.Query("INSERT INTO trace.person (name, age, description) VALUES (?, ?, ?)", "Kate", 80, "Cassandra's sister")
+	err = tb.ExecuteBatch(session)
+	assert.NoError(err)
+
+	spans := mt.FinishedSpans()
+	assert.Len(spans, 2)
+	for _, s := range spans {
+		assert.Equal("bar", s.Tag("foo"))
+		assert.Equal(42, s.Tag("answer"))
+	}
+}
+
+func TestFormatArgs(t *testing.T) {
+	long := strings.Repeat("x", 100)
+	for _, tt := range []struct {
+		in  []interface{}
+		out string
+	}{
+		{in: nil, out: "[]"},
+		{in: []interface{}{"Cassandra", 100, nil}, out: `["Cassandra", 100, NULL]`},
+		{in: []interface{}{[]byte("secret")}, out: "[<6 bytes>]"},
+		{in: []interface{}{long}, out: `["` + long[:maxArgLength] + `..."]`},
+	} {
+		assert.Equal(t, tt.out, formatArgs(tt.in))
+	}
+
+	// the whole representation is capped
+	args := make([]interface{}, 100)
+	for i := range args {
+		args[i] = long
+	}
+	out := formatArgs(args)
+	assert.Len(t, out, maxArgsLength+len("...]"))
+	assert.True(t, strings.HasSuffix(out, "...]"))
+}
+
+func TestNormalizeQuery(t *testing.T) {
+	for _, tt := range []struct {
+		in, out string
+	}{
+		{
+			in:  "SELECT * FROM trace.person WHERE name = 'Cassandra' AND age = 42",
+			out: "SELECT * FROM trace.person WHERE name = ? AND age = ?",
+		},
+		{
+			in:  "SELECT  *\n FROM trace.person\tWHERE name = 'O''Brien' AND age = -1.5e-3",
+			out: "SELECT * FROM trace.person WHERE name = ? AND age = ?",
+		},
+		{
+			in:  "INSERT INTO t1 (id, b, x) VALUES ('123e4567-e89b-12d3-a456-426614174000', 0xcafe, -7)",
+			out: "INSERT INTO t1 ( id, b, x ) VALUES ( ? )",
+		},
+		{
+			in:  "SELECT name FROM ks.t WHERE id IN (1, 2, 3) AND v = ?",
+			out: "SELECT name FROM ks.t WHERE id IN ( ? ) AND v = ?",
+		},
+		{
+			in:  "UPDATE t SET m = m + {'a': 1} WHERE k = 'x' IF v = 3",
+			out: "UPDATE t SET m = m + ? WHERE k = ? IF v = ?",
+		},
+		{
+			// the queries which cannot be parsed are kept
+			in:  "SELECT * FROM t WHERE k = 'unterminated",
+			out: "SELECT * FROM t WHERE k = 'unterminated",
+		},
+	} {
+		assert.Equal(t, tt.out, normalizeQuery(tt.in))
+	}
+}
+
+func TestQueryNormalization(t *testing.T) {
+	cluster := newCassandraCluster()
+	session, err := cluster.CreateSession()
+	assert.NoError(t, err)
+
+	for name, tt := range map[string]struct {
+		opts     []WrapOption
+		resource string
+	}{
+		"disabled":   {resource: "SELECT name, age FROM trace.person WHERE name = 'Cassandra'"},
+		"enabled":    {opts: []WrapOption{WithQueryNormalization()}, resource: "SELECT name, age FROM trace.person WHERE name = ?"},
+		"overridden": {opts: []WrapOption{WithQueryNormalization(), WithResourceName("custom")}, resource: "custom"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+
+			q := session.Query("SELECT name, age FROM trace.person WHERE name = 'Cassandra'")
+			err := WrapQuery(q, tt.opts...).Exec()
+			assert.NoError(t, err)
+
+			spans := mt.FinishedSpans()
+			assert.Len(t, spans, 1)
+			assert.Equal(t, tt.resource, spans[0].Tag(ext.ResourceName))
+		})
+	}
+}
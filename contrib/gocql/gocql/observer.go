@@ -0,0 +1,146 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2022 Datadog, Inc.
+
+package gocql
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+	"gopkg.in/DataDog/dd-trace-go.v1/internal/log"
+
+	"github.com/gocql/gocql"
+)
+
+// Observer traces the queries and batches observed by the gocql driver,
+// creating a span for each of their attempts, including the retries.
+type Observer struct {
+	cfg *queryConfig
+}
+
+var _ interface {
+	gocql.QueryObserver
+	gocql.BatchObserver
+} = (*Observer)(nil)
+
+// NewObserver returns an Observer to be set as the QueryObserver and/or the
+// BatchObserver of a gocql.ClusterConfig, as an alternative to wrapping each
+// query and batch using WrapQuery and WrapBatch.
+func NewObserver(opts ...WrapOption) *Observer {
+	cfg := new(queryConfig)
+	defaults(cfg)
+	for _, fn := range opts {
+		fn(cfg)
+	}
+	log.Debug("contrib/gocql/gocql: Creating Observer: %#v", cfg)
+	return &Observer{cfg: cfg}
+}
+
+// ObserveQuery implements gocql.QueryObserver.
+func (o *Observer) ObserveQuery(ctx context.Context, q gocql.ObservedQuery) {
+	resource := o.cfg.resourceName
+	if resource == "" {
+		resource = q.Statement
+		if o.cfg.normalizeQuery {
+			resource = normalizeQuery(resource)
+		}
+	}
+	var onHost int
+	if q.Metrics != nil {
+		onHost = q.Metrics.Attempts
+	}
+	opts := o.spanOptions(resource, q.Keyspace, q.Attempt, onHost, q.Host)
+	opts = append(opts, tracer.StartTime(q.Start), tracer.Tag(ext.CassandraRowCount, strconv.Itoa(q.Rows)))
+	if o.cfg.queryArgs {
+		opts = append(opts, tracer.Tag(ext.CassandraArgs, formatArgs(q.Values)))
+	}
+	span, _ := tracer.StartSpanFromContext(ctx, o.cfg.spanNamer(ext.CassandraQuery), opts...)
+	o.finishSpan(span, q.End, q.Err)
+}
+
+// ObserveBatch implements gocql.BatchObserver.
+func (o *Observer) ObserveBatch(ctx context.Context, b gocql.ObservedBatch) {
+	resource := o.cfg.resourceName
+	if resource == "" {
+		statements := b.Statements
+		if o.cfg.normalizeQuery {
+			statements = make([]string, len(b.Statements))
+			for i, stmt := range b.Statements {
+				statements[i] = normalizeQuery(stmt)
+			}
+		}
+		resource = strings.Join(statements, "; ")
+	}
+	var onHost int
+	if b.Metrics != nil {
+		onHost = b.Metrics.Attempts
+	}
+	opts := o.spanOptions(resource, b.Keyspace, b.Attempt, onHost, b.Host)
+	opts = append(opts, tracer.StartTime(b.Start))
+	span, _ := tracer.StartSpanFromContext(ctx, o.cfg.spanNamer(ext.CassandraBatch), opts...)
+	o.finishSpan(span, b.End, b.Err)
+}
+
+// spanOptions returns the options of the span of an attempt, onHost being the
+// number of attempts on its node reported by the driver, including it.
+func (o *Observer) spanOptions(resource, keyspace string, attempt, onHost int, host *gocql.HostInfo) []ddtrace.StartSpanOption {
+	opts := []ddtrace.StartSpanOption{
+		tracer.SpanType(ext.SpanTypeCassandra),
+		tracer.ServiceName(o.cfg.serviceName),
+		tracer.ResourceName(resource),
+		tracer.Tag(ext.CassandraKeyspace, keyspace),
+		tracer.Tag(ext.CassandraRetries, attempt),
+		tracer.Tag(ext.Component, "gocql/gocql"),
+		tracer.Tag(ext.SpanKind, ext.SpanKindClient),
+	}
+	if o.cfg.measured {
+		opts = append(opts, tracer.Measured())
+	}
+	if host != nil {
+		if o.cfg.hostTags {
+			opts = append(opts,
+				tracer.Tag(ext.TargetHost, host.HostID()),
+				tracer.Tag(ext.TargetPort, strconv.Itoa(host.Port())),
+				tracer.Tag(ext.CassandraCoordinator, coordinator(host)),
+			)
+			if attempt > 0 && onHost > 0 {
+				opts = append(opts, tracer.Tag(ext.CassandraCoordinatorChanged, coordinatorChanged(attempt, onHost)))
+			}
+		}
+		opts = append(opts, tracer.Tag(ext.CassandraCluster, host.DataCenter()))
+	}
+	for k, v := range o.cfg.tags {
+		opts = append(opts, tracer.Tag(k, v))
+	}
+	if !math.IsNaN(o.cfg.analyticsRate) {
+		opts = append(opts, tracer.Tag(ext.EventSampleRate, o.cfg.analyticsRate))
+	}
+	return opts
+}
+
+// coordinatorChanged reports whether an earlier attempt of a query was
+// coordinated by another node than the current one, given the index of the
+// current attempt and the number of attempts on its node, including it.
+func coordinatorChanged(attempt, onHost int) bool {
+	return onHost <= attempt
+}
+
+func (o *Observer) finishSpan(span ddtrace.Span, end time.Time, err error) {
+	setCancelled(span, err)
+	if err != nil && o.cfg.shouldIgnoreError(err) {
+		err = nil
+	}
+	opts := []ddtrace.FinishOption{tracer.FinishTime(end), tracer.WithError(err)}
+	if o.cfg.noDebugStack {
+		opts = append(opts, tracer.NoDebugStack())
+	}
+	span.Finish(opts...)
+}
@@ -0,0 +1,113 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2022 Datadog, Inc.
+
+package gocql
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/mocktracer"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+
+	"github.com/gocql/gocql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestObserver(t *testing.T) {
+	t.Run("query", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		cluster := newCassandraCluster()
+		cluster.QueryObserver = NewObserver(WithServiceName("observed"))
+		session, err := cluster.CreateSession()
+		assert.NoError(t, err)
+		defer session.Close()
+
+		parent, ctx := tracer.StartSpanFromContext(context.Background(), "parent")
+		err = session.Query("SELECT * FROM trace.person").WithContext(ctx).Exec()
+		assert.NoError(t, err)
+		parent.Finish()
+
+		spans := mt.FinishedSpans()
+		assert.Len(t, spans, 2)
+		s := spans[0]
+		assert.Equal(t, ext.CassandraQuery, s.OperationName())
+		assert.Equal(t, parent.Context().SpanID(), s.ParentID())
+		assert.Equal(t, "observed", s.Tag(ext.ServiceName))
+		assert.Equal(t, "SELECT * FROM trace.person", s.Tag(ext.ResourceName))
+		assert.Equal(t, "trace", s.Tag(ext.CassandraKeyspace))
+		assert.Equal(t, 0, s.Tag(ext.CassandraRetries))
+		assert.Equal(t, "gocql/gocql", s.Tag(ext.Component))
+		assert.Equal(t, ext.SpanKindClient, s.Tag(ext.SpanKind))
+		assert.Equal(t, cassandraHost, s.Tag(ext.CassandraCoordinator))
+	})
+
+	t.Run("retries", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		o := NewObserver()
+		start := time.Now()
+		for attempt := 0; attempt < 3; attempt++ {
+			o.ObserveQuery(context.Background(), gocql.ObservedQuery{
+				Keyspace:  "trace",
+				Statement: "SELECT * FROM trace.person",
+				Start:     start,
+				End:       start.Add(time.Millisecond),
+				Err:       errors.New("timeout"),
+				Attempt:   attempt,
+			})
+		}
+
+		spans := mt.FinishedSpans()
+		assert.Len(t, spans, 3)
+		for i, s := range spans {
+			assert.Equal(t, i, s.Tag(ext.CassandraRetries))
+			assert.Equal(t, start, s.StartTime())
+			assert.Equal(t, start.Add(time.Millisecond), s.FinishTime())
+			assert.EqualError(t, s.Tag(ext.Error).(error), "timeout")
+		}
+	})
+
+	t.Run("batch", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		o := NewObserver(WithErrorCheck(func(error) bool { return false }))
+		o.ObserveBatch(context.Background(), gocql.ObservedBatch{
+			Keyspace:   "trace",
+			Statements: []string{"INSERT INTO trace.person (name) VALUES (?)", "DELETE FROM trace.person WHERE name = ?"},
+			Err:        errors.New("ignored"),
+			Attempt:    1,
+		})
+
+		spans := mt.FinishedSpans()
+		assert.Len(t, spans, 1)
+		s := spans[0]
+		assert.Equal(t, ext.CassandraBatch, s.OperationName())
+		assert.Equal(t, "INSERT INTO trace.person (name) VALUES (?); DELETE FROM trace.person WHERE name = ?", s.Tag(ext.ResourceName))
+		assert.Equal(t, 1, s.Tag(ext.CassandraRetries))
+		assert.Nil(t, s.Tag(ext.Error))
+	})
+}
+
+func TestCoordinatorChanged(t *testing.T) {
+	for _, tt := range []struct {
+		attempt, onHost int
+		changed         bool
+	}{
+		{attempt: 1, onHost: 2, changed: false},
+		{attempt: 1, onHost: 1, changed: true},
+		{attempt: 2, onHost: 3, changed: false},
+		{attempt: 2, onHost: 2, changed: true},
+	} {
+		assert.Equal(t, tt.changed, coordinatorChanged(tt.attempt, tt.onHost), "attempt %d, %d on host", tt.attempt, tt.onHost)
+	}
+}
@@ -6,6 +6,8 @@
 package gocql
 
 import (
+	"context"
+	"errors"
 	"math"
 
 	"gopkg.in/DataDog/dd-trace-go.v1/internal"
@@ -16,6 +18,13 @@ type queryConfig struct {
 	noDebugStack              bool
 	analyticsRate             float64
 	errCheck                  func(err error) bool
+	queryArgs                 bool
+	measured                  bool
+	spanNamer                 func(defaultName string) string
+	hostTags                  bool
+	normalizeQuery            bool
+	batchChildSpans           bool
+	tags                      map[string]interface{}
 }
 
 // WrapOption represents an option that can be passed to WrapQuery.
@@ -29,7 +38,9 @@ func defaults(cfg *queryConfig) {
 	} else {
 		cfg.analyticsRate = math.NaN()
 	}
-	cfg.errCheck = func(error) bool { return true }
+	cfg.errCheck = func(err error) bool { return !isCancelled(err) }
+	cfg.spanNamer = func(defaultName string) string { return defaultName }
+	cfg.hostTags = true
 }
 
 // WithServiceName sets the given service name for the returned query.
@@ -87,9 +98,16 @@ func (c *queryConfig) shouldIgnoreError(err error) bool {
 	return c != nil && c.errCheck != nil && !c.errCheck(err)
 }
 
+// isCancelled reports whether err results from the cancellation of the context
+// of the query, rather than from an error of the Cassandra cluster.
+func isCancelled(err error) bool {
+	return errors.Is(err, context.Canceled)
+}
+
 // WithErrorCheck specifies a function fn which determines whether the passed
 // error should be marked as an error. The fn is called whenever a CQL request
-// finishes with an error.
+// finishes with an error. By default, every error but the cancellation of the
+// context of the query is marked as an error.
 func WithErrorCheck(fn func(err error) bool) WrapOption {
 	return func(cfg *queryConfig) {
 		// When the error is explicitly marked as not-an-error, that is
@@ -104,3 +122,84 @@ func WithErrorCheck(fn func(err error) bool) WrapOption {
 		cfg.errCheck = fn
 	}
 }
+
+// WithQueryArgs enables recording the arguments bound to the traced queries in
+// the cassandra.args tag. As the arguments may contain sensitive data, they are
+// not recorded by default. When enabled, binary arguments are replaced by their
+// length, long strings are truncated and the whole tag is capped in size.
+//
+// Queries wrapped with WrapQuery only record the arguments bound with the Bind
+// method of the traced Query, as gocql doesn't expose the ones given to
+// Session.Query. The observer records the arguments of all the queries.
+func WithQueryArgs() WrapOption {
+	return func(cfg *queryConfig) {
+		cfg.queryArgs = true
+	}
+}
+
+// WithSpanNamer sets the function used to name the spans started by the
+// integration. It is given the default operation name of the span, either
+// "cassandra.query", "cassandra.batch" or "cassandra.batch.statement", and
+// returns the name to use instead.
+func WithSpanNamer(namer func(defaultName string) string) WrapOption {
+	return func(cfg *queryConfig) {
+		if namer != nil {
+			cfg.spanNamer = namer
+		}
+	}
+}
+
+// WithMeasured sets whether the spans are measured, computing trace metrics
+// for them, which is disabled by default. Disabling it on very high-volume
+// services limits the cost of the metrics.
+func WithMeasured(on bool) WrapOption {
+	return func(cfg *queryConfig) {
+		cfg.measured = on
+	}
+}
+
+// WithHostTags sets whether the spans are tagged with the host, the port and the
+// address of the node which coordinated the query, which is enabled by default.
+// As the retries of a query are traced with a span each by the Observer, the
+// spans of the retries are also tagged with cassandra.coordinator_changed,
+// telling whether an earlier attempt was coordinated by another node. Disabling it
+// avoids the high cardinality of these tags in large clusters.
+func WithHostTags(on bool) WrapOption {
+	return func(cfg *queryConfig) {
+		cfg.hostTags = on
+	}
+}
+
+// WithCustomTag will attach the value to the spans tagged by the key. It can be
+// passed several times to attach several tags.
+func WithCustomTag(key string, value interface{}) WrapOption {
+	return func(cfg *queryConfig) {
+		if cfg.tags == nil {
+			cfg.tags = make(map[string]interface{})
+		}
+		cfg.tags[key] = value
+	}
+}
+
+// WithQueryNormalization enables the normalization of the queries used as the
+// resource names of the spans, replacing their literals by a ? placeholder and
+// collapsing their whitespaces, such that the queries formatting their values
+// in the CQL string rather than binding them yield a stable resource name, e.g.
+// "SELECT * FROM trace.person WHERE name = ?". It has no effect on the resource
+// names set by WithResourceName.
+func WithQueryNormalization() WrapOption {
+	return func(cfg *queryConfig) {
+		cfg.normalizeQuery = true
+	}
+}
+
+// WithBatchChildSpans sets whether a child span of the batch span is created for
+// each statement of the traced batches, which is disabled by default. As the
+// statements of a batch are sent in a single request, the child spans share the
+// timing of the batch: they identify the statements it contains rather than
+// their individual durations.
+func WithBatchChildSpans(on bool) WrapOption {
+	return func(cfg *queryConfig) {
+		cfg.batchChildSpans = on
+	}
+}
@@ -49,6 +49,8 @@ func TestAppSec(t *testing.T) {
 		require.NotNil(t, event)
 		require.True(t, strings.Contains(event, "crs-941-110")) // XSS attack attempt
 		require.True(t, strings.Contains(event, "ua0-600-55x")) // canary rule attack attempt
+		// The targeted RPC method should be tagged
+		require.Equal(t, "/grpc.Fixture/Ping", finished[0].Tag("appsec.grpc.method"))
 	})
 
 	t.Run("stream", func(t *testing.T) {
@@ -92,5 +94,7 @@ func TestAppSec(t *testing.T) {
 		require.True(t, strings.Contains(event, "crs-941-110")) // XSS attack attempt
 		require.True(t, strings.Contains(event, "crs-942-100")) // SQL-injection attack attempt
 		require.True(t, strings.Contains(event, "ua0-600-55x")) // canary rule attack attempt
+		// The targeted RPC method should be tagged
+		require.Equal(t, "/grpc.Fixture/StreamPing", finished[5].Tag("appsec.grpc.method"))
 	})
 }
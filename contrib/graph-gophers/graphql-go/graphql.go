@@ -13,14 +13,20 @@ package graphql // import "gopkg.in/DataDog/dd-trace-go.v1/contrib/graph-gophers
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"math"
+	"math/rand"
+	"strings"
 
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+	"gopkg.in/DataDog/dd-trace-go.v1/internal/appsec"
+	"gopkg.in/DataDog/dd-trace-go.v1/internal/appsec/dyngo/instrumentation/httpsec"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/log"
 
+	graphql "github.com/graph-gophers/graphql-go"
 	"github.com/graph-gophers/graphql-go/errors"
 	"github.com/graph-gophers/graphql-go/introspection"
 	"github.com/graph-gophers/graphql-go/trace"
@@ -31,6 +37,14 @@ const (
 	tagGraphqlQuery         = "graphql.query"
 	tagGraphqlType          = "graphql.type"
 	tagGraphqlOperationName = "graphql.operation.name"
+	tagGraphqlOperationType = "graphql.operation.type"
+	tagGraphqlVariables     = "graphql.variables"
+	tagGraphqlErrorCount    = "graphql.error_count"
+	tagGraphqlErrorPath     = "graphql.error.path"
+	tagGraphqlErrorExt      = "graphql.error.extensions"
+	tagGraphqlDepth         = "graphql.depth"
+	tagGraphqlComplexity    = "graphql.complexity"
+	tagGraphqlResultCount   = "graphql.subscription.result_count"
 )
 
 // A Tracer implements the graphql-go/trace.Tracer interface by sending traces
@@ -39,52 +53,342 @@ type Tracer struct {
 	cfg *config
 }
 
-var _ trace.Tracer = (*Tracer)(nil)
+var (
+	_ trace.Tracer                  = (*Tracer)(nil)
+	_ trace.ValidationTracerContext = (*Tracer)(nil)
+)
 
 // TraceQuery traces a GraphQL query.
 func (t *Tracer) TraceQuery(ctx context.Context, queryString string, operationName string, variables map[string]interface{}, varTypes map[string]*introspection.Type) (context.Context, trace.TraceQueryFinishFunc) {
 	opts := []ddtrace.StartSpanOption{
 		tracer.ServiceName(t.cfg.serviceName),
+		tracer.ResourceName(t.cfg.resourceNamer(queryString, operationName)),
 		tracer.Tag(tagGraphqlQuery, queryString),
 		tracer.Tag(tagGraphqlOperationName, operationName),
 		tracer.Tag(ext.Component, "graph-gophers/graphql-go"),
-		tracer.Measured(),
+	}
+	if t.cfg.measured {
+		opts = append(opts, tracer.Measured())
 	}
 	if !math.IsNaN(t.cfg.analyticsRate) {
 		opts = append(opts, tracer.Tag(ext.EventSampleRate, t.cfg.analyticsRate))
 	}
-	span, ctx := tracer.StartSpanFromContext(ctx, "graphql.request", opts...)
+	if typ := operationType(queryString, operationName); typ != "" {
+		opts = append(opts, tracer.Tag(tagGraphqlOperationType, typ))
+	}
+	depth, complexity := measureQuery(queryString, t.cfg.queryComplexity)
+	opts = append(opts, tracer.Tag(tagGraphqlDepth, depth))
+	if t.cfg.queryComplexity {
+		opts = append(opts, tracer.Tag(tagGraphqlComplexity, complexity))
+	}
+	if t.cfg.traceVars && len(variables) > 0 {
+		if t.cfg.redactVars != nil {
+			// the redactor is given a copy, as the variables are still to be
+			// used to execute the query
+			variables = t.cfg.redactVars(copyVariables(variables))
+		}
+		if b, err := json.Marshal(variables); err == nil {
+			opts = append(opts, tracer.Tag(tagGraphqlVariables, string(b)))
+		} else {
+			log.Debug("contrib/graph-gophers/graphql-go: failed to serialize the query variables: %v", err)
+		}
+	}
+	span, ctx := tracer.StartSpanFromContext(ctx, t.cfg.spanNamer("graphql.request"), opts...)
 
 	return ctx, func(errs []*errors.QueryError) {
-		var err error
-		switch n := len(errs); n {
-		case 0:
-			// err = nil
-		case 1:
-			err = errs[0]
-		default:
-			err = fmt.Errorf("%s (and %d more errors)", errs[0], n-1)
+		finishWithErrors(span, errs)
+	}
+}
+
+// copyVariables returns a deep copy of the variables of a query, as decoded
+// from JSON.
+func copyVariables(vars map[string]interface{}) map[string]interface{} {
+	cp := make(map[string]interface{}, len(vars))
+	for k, v := range vars {
+		cp[k] = copyVariable(v)
+	}
+	return cp
+}
+
+func copyVariable(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return copyVariables(v)
+	case []interface{}:
+		cp := make([]interface{}, len(v))
+		for i, e := range v {
+			cp[i] = copyVariable(e)
 		}
-		span.Finish(tracer.WithError(err))
+		return cp
+	default:
+		return v
 	}
 }
 
-// TraceField traces a GraphQL field access.
+// TraceValidation traces the validation of a GraphQL query. As graphql-go
+// validates the queries before starting the query span, the validation span is
+// only created as the child of an existing span, such as the one of the HTTP
+// request, rather than as the root of a trace of its own.
+func (t *Tracer) TraceValidation(ctx context.Context) trace.TraceValidationFinishFunc {
+	if !t.cfg.traceValidation {
+		return func(errs []*errors.QueryError) {}
+	}
+	if _, ok := tracer.SpanFromContext(ctx); !ok {
+		return func(errs []*errors.QueryError) {}
+	}
+	opts := []ddtrace.StartSpanOption{
+		tracer.ServiceName(t.cfg.serviceName),
+		tracer.Tag(ext.Component, "graph-gophers/graphql-go"),
+	}
+	span, _ := tracer.StartSpanFromContext(ctx, t.cfg.spanNamer("graphql.validation"), opts...)
+
+	return func(errs []*errors.QueryError) {
+		finishWithErrors(span, errs)
+	}
+}
+
+// finishWithErrors finishes span with the first of errs, tagging it with the
+// number of errors.
+func finishWithErrors(span ddtrace.Span, errs []*errors.QueryError) {
+	span.SetTag(tagGraphqlErrorCount, len(errs))
+	if len(errs) > 0 {
+		setErrorTags(span, errs[0])
+	}
+	var err error
+	switch n := len(errs); n {
+	case 0:
+		// err = nil
+	case 1:
+		err = errs[0]
+	default:
+		err = fmt.Errorf("%s (and %d more errors)", errs[0], n-1)
+	}
+	span.Finish(tracer.WithError(err))
+}
+
+// setErrorTags tags span with the JSON-serialized path and extensions of err.
+func setErrorTags(span ddtrace.Span, err *errors.QueryError) {
+	if len(err.Path) > 0 {
+		if b, jerr := json.Marshal(err.Path); jerr == nil {
+			span.SetTag(tagGraphqlErrorPath, string(b))
+		}
+	}
+	if len(err.Extensions) > 0 {
+		if b, jerr := json.Marshal(err.Extensions); jerr == nil {
+			span.SetTag(tagGraphqlErrorExt, string(b))
+		}
+	}
+}
+
+// operationType returns the type (query, mutation or subscription) of the
+// operation of the given name in the query document, or of its first
+// operation if name is empty. It returns an empty string if no such operation
+// is found.
+func operationType(query, name string) string {
+	var (
+		first      string // type of the first operation of the document
+		kind       string // keyword of the current top-level definition
+		expectName bool   // whether the next name is the definition's name
+		braces     int
+		parens     int
+	)
+	for i := 0; i < len(query); i++ {
+		switch c := query[i]; {
+		case c == '#':
+			for i < len(query) && query[i] != '\n' {
+				i++
+			}
+		case c == '"':
+			if strings.HasPrefix(query[i:], `"""`) {
+				end := strings.Index(query[i+3:], `"""`)
+				if end < 0 {
+					return first
+				}
+				i += end + 5
+				continue
+			}
+			for i++; i < len(query) && query[i] != '"'; i++ {
+				if query[i] == '\\' {
+					i++
+				}
+			}
+		case c == '{':
+			if braces == 0 && parens == 0 {
+				if kind == "" {
+					// shorthand query
+					kind = "query"
+				}
+				if kind != "fragment" {
+					if first == "" {
+						first = kind
+					}
+					if name == "" {
+						return kind
+					}
+				}
+				kind, expectName = "", false
+			}
+			braces++
+		case c == '}':
+			braces--
+		case c == '(':
+			expectName = false
+			parens++
+		case c == ')':
+			parens--
+		case c == '@':
+			expectName = false
+		case isNameStart(c):
+			j := i + 1
+			for j < len(query) && (isNameStart(query[j]) || (query[j] >= '0' && query[j] <= '9')) {
+				j++
+			}
+			if braces == 0 && parens == 0 {
+				word := query[i:j]
+				switch {
+				case kind == "":
+					kind, expectName = word, true
+				case expectName:
+					expectName = false
+					if name != "" && word == name && kind != "fragment" {
+						return kind
+					}
+				}
+			}
+			i = j - 1
+		}
+	}
+	return first
+}
+
+// measureQuery returns the maximum nesting depth of the selection sets of the
+// query document and, if countFields is true, its approximate complexity as the
+// number of fields it selects. Fragments are measured where they are defined
+// rather than where they are spread.
+func measureQuery(query string, countFields bool) (depth, complexity int) {
+	var (
+		braces  int  // depth of the current selection set
+		objects int  // depth of the current object value, within arguments
+		parens  int  // depth of the current arguments
+		skip    int  // number of upcoming names which aren't fields
+		spread  bool // whether the previous token was a spread
+	)
+	for i := 0; i < len(query); i++ {
+		switch c := query[i]; {
+		case c == '#':
+			for i < len(query) && query[i] != '\n' {
+				i++
+			}
+		case c == '"':
+			if strings.HasPrefix(query[i:], `"""`) {
+				end := strings.Index(query[i+3:], `"""`)
+				if end < 0 {
+					return depth, complexity
+				}
+				i += end + 5
+				continue
+			}
+			for i++; i < len(query) && query[i] != '"'; i++ {
+				if query[i] == '\\' {
+					i++
+				}
+			}
+		case c == '{':
+			if parens > 0 {
+				objects++
+				continue
+			}
+			braces++
+			if braces > depth {
+				depth = braces
+			}
+			skip, spread = 0, false
+		case c == '}':
+			if objects > 0 {
+				objects--
+				continue
+			}
+			braces--
+		case c == '(':
+			parens++
+		case c == ')':
+			parens--
+		case c == '@':
+			// directive name
+			skip = 1
+		case c == '.' && strings.HasPrefix(query[i:], "..."):
+			// fragment spread name or inline fragment keyword
+			skip, spread = 1, true
+			i += 2
+		case isNameStart(c):
+			j := i + 1
+			for j < len(query) && (isNameStart(query[j]) || (query[j] >= '0' && query[j] <= '9')) {
+				j++
+			}
+			word := query[i:j]
+			i = j - 1
+			if !countFields || braces == 0 || parens > 0 {
+				continue
+			}
+			if skip > 0 {
+				skip--
+				if spread && word == "on" {
+					// type condition of an inline fragment
+					skip = 1
+				}
+				spread = false
+				continue
+			}
+			k := j
+			for k < len(query) && (query[k] == ' ' || query[k] == '\t' || query[k] == '\n' || query[k] == '\r' || query[k] == ',') {
+				k++
+			}
+			if k < len(query) && query[k] == ':' {
+				// alias of the field that follows
+				continue
+			}
+			complexity++
+		}
+	}
+	return depth, complexity
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// TraceField traces a GraphQL field access. When AppSec is enabled, the
+// arguments of the field's resolver are monitored as the
+// `graphql.server.resolver` address of the HTTP request serving the query,
+// which requires the request to be handled by a traced HTTP handler.
 func (t *Tracer) TraceField(ctx context.Context, label string, typeName string, fieldName string, trivial bool, args map[string]interface{}) (context.Context, trace.TraceFieldFinishFunc) {
+	if len(args) > 0 && appsec.Enabled() {
+		httpsec.MonitorResolver(ctx, fieldName, args)
+	}
 	if t.cfg.omitTrivial && trivial {
 		return ctx, func(queryError *errors.QueryError) {}
 	}
+	if t.cfg.tracedTypes != nil {
+		if _, ok := t.cfg.tracedTypes[typeName]; !ok {
+			return ctx, func(queryError *errors.QueryError) {}
+		}
+	}
+	if t.cfg.fieldRate < 1 && rand.Float64() >= t.cfg.fieldRate {
+		return ctx, func(queryError *errors.QueryError) {}
+	}
 	opts := []ddtrace.StartSpanOption{
 		tracer.ServiceName(t.cfg.serviceName),
 		tracer.Tag(tagGraphqlField, fieldName),
 		tracer.Tag(tagGraphqlType, typeName),
 		tracer.Tag(ext.Component, "graph-gophers/graphql-go"),
-		tracer.Measured(),
+	}
+	if t.cfg.measured {
+		opts = append(opts, tracer.Measured())
 	}
 	if !math.IsNaN(t.cfg.analyticsRate) {
 		opts = append(opts, tracer.Tag(ext.EventSampleRate, t.cfg.analyticsRate))
 	}
-	span, ctx := tracer.StartSpanFromContext(ctx, "graphql.field", opts...)
+	span, ctx := tracer.StartSpanFromContext(ctx, t.cfg.spanNamer("graphql.field"), opts...)
 
 	return ctx, func(err *errors.QueryError) {
 		// must explicitly check for nil, see issue golang/go#22729
@@ -96,6 +400,79 @@ func (t *Tracer) TraceField(ctx context.Context, label string, typeName string,
 	}
 }
 
+// TraceSubscription traces the GraphQL subscription whose responses are
+// delivered on c, as returned by graphql.Schema.Subscribe for the given query
+// and operation name. Since a subscription stays open for as long as it emits
+// results, the graphql.subscription span covers the whole stream: it is
+// finished when c is closed or ctx is done, tagged with the number of results
+// delivered and with the errors they held. The returned channel must be
+// consumed in place of c.
+func TraceSubscription(ctx context.Context, queryString, operationName string, c <-chan interface{}, opts ...Option) <-chan interface{} {
+	cfg := new(config)
+	defaults(cfg)
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	spanOpts := []ddtrace.StartSpanOption{
+		tracer.ServiceName(cfg.serviceName),
+		tracer.ResourceName(cfg.resourceNamer(queryString, operationName)),
+		tracer.Tag(tagGraphqlQuery, queryString),
+		tracer.Tag(tagGraphqlOperationName, operationName),
+		tracer.Tag(tagGraphqlOperationType, "subscription"),
+		tracer.Tag(ext.Component, "graph-gophers/graphql-go"),
+	}
+	if cfg.measured {
+		spanOpts = append(spanOpts, tracer.Measured())
+	}
+	if !math.IsNaN(cfg.analyticsRate) {
+		spanOpts = append(spanOpts, tracer.Tag(ext.EventSampleRate, cfg.analyticsRate))
+	}
+	span, _ := tracer.StartSpanFromContext(ctx, cfg.spanNamer("graphql.subscription"), spanOpts...)
+
+	out := make(chan interface{})
+	go func() {
+		var (
+			results  int
+			errCount int
+			firstErr *errors.QueryError
+		)
+		defer func() {
+			span.SetTag(tagGraphqlResultCount, results)
+			span.SetTag(tagGraphqlErrorCount, errCount)
+			if firstErr != nil {
+				setErrorTags(span, firstErr)
+				span.Finish(tracer.WithError(firstErr))
+			} else {
+				span.Finish()
+			}
+			close(out)
+		}()
+		for {
+			select {
+			case res, ok := <-c:
+				if !ok {
+					return
+				}
+				results++
+				if r, ok := res.(*graphql.Response); ok && len(r.Errors) > 0 {
+					if firstErr == nil {
+						firstErr = r.Errors[0]
+					}
+					errCount += len(r.Errors)
+				}
+				select {
+				case out <- res:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
 // NewTracer creates a new Tracer.
 func NewTracer(opts ...Option) trace.Tracer {
 	cfg := new(config)
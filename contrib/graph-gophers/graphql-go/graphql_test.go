@@ -6,17 +6,27 @@
 package graphql
 
 import (
+	"context"
+	"errors"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"strings"
 	"testing"
+	"time"
 
 	graphql "github.com/graph-gophers/graphql-go"
 	"github.com/graph-gophers/graphql-go/relay"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
+	"gopkg.in/DataDog/dd-trace-go.v1/contrib/internal/agenttest"
+	httptrace "gopkg.in/DataDog/dd-trace-go.v1/contrib/net/http"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/mocktracer"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+	"gopkg.in/DataDog/dd-trace-go.v1/internal/appsec"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/globalconfig"
 )
 
@@ -24,6 +34,14 @@ type testResolver struct{}
 
 func (*testResolver) Hello() string                    { return "Hello, world!" }
 func (*testResolver) HelloNonTrivial() (string, error) { return "Hello, world!", nil }
+func (*testResolver) Fail() (*string, error)           { return nil, &extError{"failed"} }
+func (*testResolver) FailToo() (*string, error)        { return nil, errors.New("failed too") }
+func (*testResolver) Greet(args struct {
+	Name     string
+	Password string
+}) string {
+	return "Hello, " + args.Name + "!"
+}
 
 func Test(t *testing.T) {
 	s := `
@@ -98,7 +116,7 @@ func Test(t *testing.T) {
 			assert.Nil(t, s.Tag(ext.Error))
 			assert.Equal(t, "test-graphql-service", s.Tag(ext.ServiceName))
 			assert.Equal(t, "graphql.request", s.OperationName())
-			assert.Equal(t, "graphql.request", s.Tag(ext.ResourceName))
+			assert.Equal(t, "TestQuery", s.Tag(ext.ResourceName))
 			assert.Equal(t, "graph-gophers/graphql-go", s.Tag(ext.Component))
 
 		}
@@ -133,13 +151,253 @@ func Test(t *testing.T) {
 			assert.Nil(t, s.Tag(ext.Error))
 			assert.Equal(t, "test-graphql-service", s.Tag(ext.ServiceName))
 			assert.Equal(t, "graphql.request", s.OperationName())
-			assert.Equal(t, "graphql.request", s.Tag(ext.ResourceName))
+			assert.Equal(t, "TestQuery", s.Tag(ext.ResourceName))
 			assert.Equal(t, "graph-gophers/graphql-go", s.Tag(ext.Component))
 
 		}
 	})
 }
 
+func TestFieldSampleRate(t *testing.T) {
+	s := `
+		schema {
+			query: Query
+		}
+		type Query {
+			hello: String!
+			helloNonTrivial: String!
+		}
+	`
+	makeRequest := func(opts ...Option) {
+		schema := graphql.MustParseSchema(s, new(testResolver),
+			graphql.Tracer(NewTracer(opts...)))
+		srv := httptest.NewServer(&relay.Handler{Schema: schema})
+		defer srv.Close()
+
+		http.Post(srv.URL, "application/json", strings.NewReader(`{
+		"query": "{ hello, helloNonTrivial }"
+	}`))
+	}
+
+	t.Run("0", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		makeRequest(WithFieldSampleRate(0))
+
+		spans := mt.FinishedSpans()
+		assert.Len(t, spans, 1)
+		assert.Equal(t, "graphql.request", spans[0].OperationName())
+	})
+
+	t.Run("1", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		makeRequest(WithFieldSampleRate(1))
+
+		spans := mt.FinishedSpans()
+		assert.Len(t, spans, 3)
+		assert.Equal(t, "graphql.field", spans[0].OperationName())
+		assert.Equal(t, "graphql.field", spans[1].OperationName())
+		assert.Equal(t, "graphql.request", spans[2].OperationName())
+	})
+}
+
+func TestTracedTypes(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	tr := NewTracer(WithTracedTypes([]string{"Query", "Mutation"}))
+	ctx, finish := tr.TraceQuery(context.Background(), "{ user { name } }", "", nil, nil)
+	ctxUser, finishUser := tr.TraceField(ctx, "", "Query", "user", false, nil)
+	_, finishName := tr.TraceField(ctxUser, "", "User", "name", false, nil)
+	finishName(nil)
+	finishUser(nil)
+	finish(nil)
+
+	spans := mt.FinishedSpans()
+	assert.Len(t, spans, 2)
+	assert.Equal(t, "graphql.field", spans[0].OperationName())
+	assert.Equal(t, "Query", spans[0].Tag(tagGraphqlType))
+	assert.Equal(t, "user", spans[0].Tag(tagGraphqlField))
+	assert.Equal(t, "graphql.request", spans[1].OperationName())
+}
+
+func TestResourceNamer(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		tr := NewTracer()
+		_, finish := tr.TraceQuery(context.Background(), "query TestQuery { hello }", "TestQuery", nil, nil)
+		finish(nil)
+		_, finish = tr.TraceQuery(context.Background(), "{ hello }", "", nil, nil)
+		finish(nil)
+
+		spans := mt.FinishedSpans()
+		assert.Len(t, spans, 2)
+		assert.Equal(t, "TestQuery", spans[0].Tag(ext.ResourceName))
+		assert.Equal(t, "graphql.request", spans[1].Tag(ext.ResourceName))
+	})
+
+	t.Run("custom", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		tr := NewTracer(WithResourceNamer(func(queryString, operationName string) string {
+			return "custom " + operationName
+		}))
+		_, finish := tr.TraceQuery(context.Background(), "query TestQuery { hello }", "TestQuery", nil, nil)
+		finish(nil)
+
+		spans := mt.FinishedSpans()
+		assert.Len(t, spans, 1)
+		assert.Equal(t, "custom TestQuery", spans[0].Tag(ext.ResourceName))
+	})
+}
+
+func TestMaxTagValueLength(t *testing.T) {
+	agent := agenttest.New()
+	defer agent.Close()
+	tracer.Start(
+		tracer.WithAgentAddr(agent.Addr()),
+		tracer.WithLogStartup(false),
+		tracer.WithMaxTagValueLength(16),
+	)
+	defer tracer.Stop()
+
+	tr := NewTracer()
+	_, finish := tr.TraceQuery(context.Background(), "query TestQuery { hello }", "TestQuery", nil, nil)
+	finish(nil)
+	assert.NoError(t, tracer.FlushWithTimeout(5*time.Second))
+
+	spans := agent.Spans()
+	assert.Len(t, spans, 1)
+	assert.Equal(t, "query TestQuery ...", spans[0].Meta[tagGraphqlQuery])
+	assert.Equal(t, "TestQuery", spans[0].Meta[tagGraphqlOperationName])
+}
+
+func TestSpanNamer(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	tr := NewTracer(WithSpanNamer(func(defaultName string) string {
+		return "custom." + defaultName
+	}))
+	ctx, finish := tr.TraceQuery(context.Background(), "{ hello }", "", nil, nil)
+	_, finishField := tr.TraceField(ctx, "", "Query", "hello", false, nil)
+	finishField(nil)
+	finish(nil)
+
+	spans := mt.FinishedSpans()
+	assert.Len(t, spans, 2)
+	assert.Equal(t, "custom.graphql.field", spans[0].OperationName())
+	assert.Equal(t, "custom.graphql.request", spans[1].OperationName())
+}
+
+func TestMeasured(t *testing.T) {
+	for name, tc := range map[string]struct {
+		opts []Option
+		want interface{}
+	}{
+		"defaults": {want: 1},
+		"enabled":  {opts: []Option{WithMeasured(true)}, want: 1},
+		"disabled": {opts: []Option{WithMeasured(false)}, want: nil},
+	} {
+		t.Run(name, func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+
+			tr := NewTracer(tc.opts...)
+			ctx, finish := tr.TraceQuery(context.Background(), "{ hello }", "", nil, nil)
+			_, finishField := tr.TraceField(ctx, "", "Query", "hello", false, nil)
+			finishField(nil)
+			finish(nil)
+
+			spans := mt.FinishedSpans()
+			assert.Len(t, spans, 2)
+			for _, s := range spans {
+				assert.Equal(t, tc.want, s.Tag("_dd.measured"))
+			}
+		})
+	}
+}
+
+func TestValidation(t *testing.T) {
+	s := `
+		schema {
+			query: Query
+		}
+		type Query {
+			hello: String!
+		}
+	`
+	exec := func(ctx context.Context, query string, opts ...Option) {
+		schema := graphql.MustParseSchema(s, new(testResolver), graphql.Tracer(NewTracer(opts...)))
+		schema.Exec(ctx, query, "", nil)
+	}
+
+	t.Run("parent", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		root, ctx := tracer.StartSpanFromContext(context.Background(), "http.request")
+		exec(ctx, "{ hello }")
+		root.Finish()
+
+		var validation []mocktracer.Span
+		for _, s := range mt.FinishedSpans() {
+			if s.OperationName() == "graphql.validation" {
+				validation = append(validation, s)
+			}
+		}
+		assert.Len(t, validation, 1)
+		assert.Equal(t, root.Context().SpanID(), validation[0].ParentID())
+		assert.Equal(t, 0, validation[0].Tag(tagGraphqlErrorCount))
+		assert.Equal(t, "graph-gophers/graphql-go", validation[0].Tag(ext.Component))
+	})
+
+	t.Run("error", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		root, ctx := tracer.StartSpanFromContext(context.Background(), "http.request")
+		exec(ctx, "{ unknown }")
+		root.Finish()
+
+		spans := mt.FinishedSpans()
+		assert.Len(t, spans, 2)
+		assert.Equal(t, "graphql.validation", spans[0].OperationName())
+		assert.Equal(t, 1, spans[0].Tag(tagGraphqlErrorCount))
+		assert.NotNil(t, spans[0].Tag(ext.Error))
+	})
+
+	t.Run("no-parent", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		exec(context.Background(), "{ hello }")
+
+		for _, s := range mt.FinishedSpans() {
+			assert.NotEqual(t, "graphql.validation", s.OperationName())
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		root, ctx := tracer.StartSpanFromContext(context.Background(), "http.request")
+		exec(ctx, "{ hello }", WithValidationTracing(false))
+		root.Finish()
+
+		for _, s := range mt.FinishedSpans() {
+			assert.NotEqual(t, "graphql.validation", s.OperationName())
+		}
+	})
+}
+
 func TestAnalyticsSettings(t *testing.T) {
 	s := `
 		schema {
@@ -210,3 +468,403 @@ func TestAnalyticsSettings(t *testing.T) {
 		assertRate(t, mt, 0.23, WithAnalyticsRate(0.23))
 	})
 }
+
+func TestVariables(t *testing.T) {
+	s := `
+		schema {
+			query: Query
+		}
+		type Query {
+			greet(name: String!, password: String!): String!
+		}
+	`
+	makeRequest := func(opts ...Option) string {
+		schema := graphql.MustParseSchema(s, new(testResolver),
+			graphql.Tracer(NewTracer(opts...)))
+		srv := httptest.NewServer(&relay.Handler{Schema: schema})
+		defer srv.Close()
+
+		resp, err := http.Post(srv.URL, "application/json", strings.NewReader(`{
+		"query": "query TestQuery($name: String!, $password: String!) { greet(name: $name, password: $password) }",
+		"operationName": "TestQuery",
+		"variables": {"name": "Bob", "password": "hunter2"}
+	}`))
+		if err != nil {
+			return ""
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return string(b)
+	}
+
+	requestSpan := func(t *testing.T, mt mocktracer.Tracer) mocktracer.Span {
+		for _, s := range mt.FinishedSpans() {
+			if s.OperationName() == "graphql.request" {
+				return s
+			}
+		}
+		t.Fatal("no graphql.request span")
+		return nil
+	}
+
+	t.Run("defaults", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		makeRequest()
+
+		assert.Nil(t, requestSpan(t, mt).Tag(tagGraphqlVariables))
+	})
+
+	t.Run("redacted", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		makeRequest(WithVariables(func(vars map[string]interface{}) map[string]interface{} {
+			redacted := make(map[string]interface{}, len(vars))
+			for k, v := range vars {
+				if k == "password" {
+					v = "REDACTED"
+				}
+				redacted[k] = v
+			}
+			return redacted
+		}))
+
+		assert.Equal(t, `{"name":"Bob","password":"REDACTED"}`, requestSpan(t, mt).Tag(tagGraphqlVariables))
+	})
+
+	t.Run("in-place", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		// the redactor is given a copy of the variables used by the query
+		body := makeRequest(WithVariables(func(vars map[string]interface{}) map[string]interface{} {
+			vars["name"] = "REDACTED"
+			delete(vars, "password")
+			return vars
+		}))
+
+		assert.Equal(t, `{"name":"REDACTED"}`, requestSpan(t, mt).Tag(tagGraphqlVariables))
+		assert.Equal(t, `{"data":{"greet":"Hello, Bob!"}}`, body)
+	})
+
+	t.Run("raw", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		makeRequest(WithVariables(nil))
+
+		assert.Equal(t, `{"name":"Bob","password":"hunter2"}`, requestSpan(t, mt).Tag(tagGraphqlVariables))
+	})
+}
+
+// extError is a resolver error carrying extensions.
+type extError struct{ msg string }
+
+func (e *extError) Error() string { return e.msg }
+
+func (e *extError) Extensions() map[string]interface{} {
+	return map[string]interface{}{"code": "FAILED"}
+}
+
+func TestErrors(t *testing.T) {
+	s := `
+		schema {
+			query: Query
+		}
+		type Query {
+			hello: String!
+			fail: String
+			failToo: String
+		}
+	`
+	makeRequest := func(query string) mocktracer.Span {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		schema := graphql.MustParseSchema(s, new(testResolver),
+			graphql.Tracer(NewTracer()))
+		srv := httptest.NewServer(&relay.Handler{Schema: schema})
+		defer srv.Close()
+		http.Post(srv.URL, "application/json", strings.NewReader(`{"query": "`+query+`"}`))
+
+		for _, s := range mt.FinishedSpans() {
+			if s.OperationName() == "graphql.request" {
+				return s
+			}
+		}
+		t.Fatal("no graphql.request span")
+		return nil
+	}
+
+	t.Run("none", func(t *testing.T) {
+		s := makeRequest("{ hello }")
+		assert.Equal(t, 0, s.Tag(tagGraphqlErrorCount))
+		assert.Nil(t, s.Tag(tagGraphqlErrorPath))
+		assert.Nil(t, s.Tag(tagGraphqlErrorExt))
+		assert.Nil(t, s.Tag(ext.Error))
+	})
+
+	t.Run("one", func(t *testing.T) {
+		s := makeRequest("{ hello, fail }")
+		assert.Equal(t, 1, s.Tag(tagGraphqlErrorCount))
+		assert.Equal(t, `["fail"]`, s.Tag(tagGraphqlErrorPath))
+		assert.Equal(t, `{"code":"FAILED"}`, s.Tag(tagGraphqlErrorExt))
+		assert.Contains(t, s.Tag(ext.Error).(error).Error(), "failed")
+	})
+
+	t.Run("multiple", func(t *testing.T) {
+		s := makeRequest("{ fail, failToo }")
+		assert.Equal(t, 2, s.Tag(tagGraphqlErrorCount))
+		assert.NotNil(t, s.Tag(tagGraphqlErrorPath))
+		assert.Contains(t, s.Tag(ext.Error).(error).Error(), "(and 1 more errors)")
+	})
+}
+
+func TestOperationType(t *testing.T) {
+	for _, tt := range []struct {
+		name, query, operation, want string
+	}{
+		{"query", "query TestQuery { hello }", "TestQuery", "query"},
+		{"mutation", "mutation SetHello($v: String!) { setHello(v: $v) }", "SetHello", "mutation"},
+		{"subscription", "subscription OnHello @live { hello }", "", "subscription"},
+		{"anonymous", "{ hello }", "", "query"},
+		{"anonymous-keyword", "query ($v: String) { hello }", "", "query"},
+		{"named", "query A { hello } mutation B { setHello }", "B", "mutation"},
+		{"first", "# comment mentioning mutation\nquery A { hello } mutation B { setHello }", "", "query"},
+		{"fragment", "fragment F on Query { hello } mutation M { ...F }", "", "mutation"},
+		{"strings", `mutation M { setHello(v: "} query Q {", w: """ { """) }`, "Q", "mutation"},
+		{"invalid", "not a query", "", ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, operationType(tt.query, tt.operation))
+		})
+	}
+}
+
+func TestOperationTypeTag(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	tr := NewTracer()
+	_, finish := tr.TraceQuery(context.Background(), "mutation SetHello { setHello }", "SetHello", nil, nil)
+	finish(nil)
+	_, finish = tr.TraceQuery(context.Background(), "{ hello }", "", nil, nil)
+	finish(nil)
+
+	spans := mt.FinishedSpans()
+	assert.Len(t, spans, 2)
+	assert.Equal(t, "mutation", spans[0].Tag(tagGraphqlOperationType))
+	assert.Equal(t, "query", spans[1].Tag(tagGraphqlOperationType))
+}
+
+func TestMeasureQuery(t *testing.T) {
+	for _, tt := range []struct {
+		name, query       string
+		depth, complexity int
+	}{
+		{"shallow", "{ hello }", 1, 1},
+		{"nested", "query Q { a { b { c { d } } } }", 4, 4},
+		{"aliases", "{ a: hello, b: hello }", 1, 2},
+		{"arguments", `query Q($v: In = {x: {y: 1}}) { hello(v: {w: "}"}) @skip(if: false) }`, 1, 1},
+		{"fragments", "fragment F on Query { a { b } } query { ...F ... on Query { c } ... @include(if: true) { d } }", 2, 4},
+		{"comments", "# { { {\n{ a, b, c }", 1, 3},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			depth, complexity := measureQuery(tt.query, true)
+			assert.Equal(t, tt.depth, depth)
+			assert.Equal(t, tt.complexity, complexity)
+		})
+	}
+}
+
+func TestQueryDepthTags(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		tr := NewTracer()
+		_, finish := tr.TraceQuery(context.Background(), "{ hello }", "", nil, nil)
+		finish(nil)
+		_, finish = tr.TraceQuery(context.Background(), "{ a { b { c { d { e } } } } }", "", nil, nil)
+		finish(nil)
+
+		spans := mt.FinishedSpans()
+		assert.Len(t, spans, 2)
+		assert.Equal(t, 1, spans[0].Tag(tagGraphqlDepth))
+		assert.Equal(t, 5, spans[1].Tag(tagGraphqlDepth))
+		assert.Nil(t, spans[1].Tag(tagGraphqlComplexity))
+	})
+
+	t.Run("complexity", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		tr := NewTracer(WithQueryComplexity())
+		_, finish := tr.TraceQuery(context.Background(), "{ a { b { c { d { e } } } } }", "", nil, nil)
+		finish(nil)
+
+		spans := mt.FinishedSpans()
+		assert.Len(t, spans, 1)
+		assert.Equal(t, 5, spans[0].Tag(tagGraphqlDepth))
+		assert.Equal(t, 5, spans[0].Tag(tagGraphqlComplexity))
+	})
+}
+
+type subscriptionResolver struct{}
+
+func (*subscriptionResolver) Hello() string { return "Hello, world!" }
+
+func (*subscriptionResolver) Count(ctx context.Context) <-chan int32 {
+	c := make(chan int32)
+	go func() {
+		defer close(c)
+		for i := int32(1); i <= 3; i++ {
+			select {
+			case c <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return c
+}
+
+func TestTraceSubscription(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	s := `
+		schema {
+			query: Query
+			subscription: Subscription
+		}
+		type Query {
+			hello: String!
+		}
+		type Subscription {
+			count: Int!
+		}
+	`
+	schema := graphql.MustParseSchema(s, new(subscriptionResolver),
+		graphql.Tracer(NewTracer(WithServiceName("test-graphql-service"))))
+	q := "subscription Count { count }"
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c, err := schema.Subscribe(ctx, q, "Count", nil)
+	assert.NoError(t, err)
+
+	var results int
+	for res := range TraceSubscription(ctx, q, "Count", c, WithServiceName("test-graphql-service")) {
+		assert.Empty(t, res.(*graphql.Response).Errors)
+		results++
+	}
+	assert.Equal(t, 3, results)
+
+	var span mocktracer.Span
+	for _, sp := range mt.FinishedSpans() {
+		if sp.OperationName() == "graphql.subscription" {
+			span = sp
+		}
+	}
+	if !assert.NotNil(t, span) {
+		return
+	}
+	assert.Equal(t, 3, span.Tag(tagGraphqlResultCount))
+	assert.Equal(t, 0, span.Tag(tagGraphqlErrorCount))
+	assert.Equal(t, "subscription", span.Tag(tagGraphqlOperationType))
+	assert.Equal(t, "Count", span.Tag(ext.ResourceName))
+	assert.Equal(t, "test-graphql-service", span.Tag(ext.ServiceName))
+	assert.Nil(t, span.Tag(ext.Error))
+}
+
+func TestAppSecResolverArgs(t *testing.T) {
+	rules, err := os.CreateTemp("", "rules-*.json")
+	require.NoError(t, err)
+	defer func() {
+		rules.Close()
+		os.Remove(rules.Name())
+	}()
+	_, err = rules.WriteString(resolverRule)
+	require.NoError(t, err)
+
+	t.Setenv("DD_APPSEC_RULES", rules.Name())
+	appsec.Start()
+	defer appsec.Stop()
+
+	if !appsec.Enabled() {
+		t.Skip("appsec disabled")
+	}
+
+	s := `
+		schema {
+			query: Query
+		}
+		type Query {
+			greet(name: String!, password: String!): String!
+		}
+	`
+	schema := graphql.MustParseSchema(s, new(testResolver), graphql.Tracer(NewTracer()))
+	srv := httptest.NewServer(httptrace.WrapHandler(&relay.Handler{Schema: schema}, "graphql-server", "/graphql"))
+	defer srv.Close()
+
+	// sendQuery greets the given name and returns the resulting service entry span
+	sendQuery := func(t *testing.T, name string) mocktracer.Span {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+		res, err := http.Post(srv.URL, "application/json", strings.NewReader(`{
+			"query": "query TestQuery($name: String!) { greet(name: $name, password: \"secret\") }",
+			"variables": {"name": "`+name+`"}
+		}`))
+		require.NoError(t, err)
+		res.Body.Close()
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		for _, span := range mt.FinishedSpans() {
+			if span.OperationName() == "http.request" {
+				return span
+			}
+		}
+		require.FailNow(t, "missing http.request span")
+		return nil
+	}
+
+	t.Run("match", func(t *testing.T) {
+		span := sendQuery(t, "malicious")
+		event := span.Tag("_dd.appsec.json")
+		require.NotNil(t, event)
+		require.Contains(t, event, "graphql-resolver-001")
+		require.Contains(t, event, "graphql.server.resolver")
+	})
+
+	t.Run("no-match", func(t *testing.T) {
+		span := sendQuery(t, "legit")
+		require.Nil(t, span.Tag("_dd.appsec.json"))
+	})
+}
+
+const resolverRule = `{
+  "version": "2.1",
+  "rules": [
+    {
+      "id": "graphql-resolver-001",
+      "name": "Malicious resolver argument",
+      "tags": {
+        "type": "attack_tool",
+        "category": "attack_attempt"
+      },
+      "conditions": [
+        {
+          "operator": "match_regex",
+          "parameters": {
+            "inputs": [
+              { "address": "graphql.server.resolver" }
+            ],
+            "regex": "^malicious$"
+          }
+        }
+      ],
+      "transformers": []
+    }
+  ]
+}`
@@ -13,9 +13,18 @@ import (
 )
 
 type config struct {
-	serviceName   string
-	analyticsRate float64
-	omitTrivial   bool
+	serviceName     string
+	analyticsRate   float64
+	omitTrivial     bool
+	fieldRate       float64
+	resourceNamer   func(queryString, operationName string) string
+	spanNamer       func(defaultName string) string
+	traceVars       bool
+	redactVars      func(map[string]interface{}) map[string]interface{}
+	measured        bool
+	traceValidation bool
+	queryComplexity bool
+	tracedTypes     map[string]struct{}
 }
 
 // Option represents an option that can be used customize the Tracer.
@@ -32,6 +41,20 @@ func defaults(cfg *config) {
 	} else {
 		cfg.analyticsRate = math.NaN()
 	}
+	cfg.fieldRate = 1.0
+	cfg.measured = true
+	cfg.traceValidation = true
+	cfg.resourceNamer = defaultResourceNamer
+	cfg.spanNamer = func(defaultName string) string { return defaultName }
+}
+
+// defaultResourceNamer names the query spans after the operation, or uses
+// the span name for anonymous operations.
+func defaultResourceNamer(_, operationName string) string {
+	if operationName != "" {
+		return operationName
+	}
+	return "graphql.request"
 }
 
 // WithServiceName sets the given service name for the client.
@@ -70,3 +93,86 @@ func WithOmitTrivial() Option {
 		cfg.omitTrivial = true
 	}
 }
+
+// WithTracedTypes restricts the field spans to the fields of the given types,
+// such as "Query" or "Mutation". The fields of the other types are not traced.
+// By default, the fields of all types are traced.
+func WithTracedTypes(types []string) Option {
+	return func(cfg *config) {
+		cfg.tracedTypes = make(map[string]struct{}, len(types))
+		for _, typ := range types {
+			cfg.tracedTypes[typ] = struct{}{}
+		}
+	}
+}
+
+// WithFieldSampleRate sets the rate at which graphql fields are traced. Fields
+// which are not sampled don't get a span, whereas the query span is always
+// created. The rate must be between 0 and 1; it defaults to 1.
+func WithFieldSampleRate(rate float64) Option {
+	return func(cfg *config) {
+		if rate >= 0.0 && rate <= 1.0 {
+			cfg.fieldRate = rate
+		}
+	}
+}
+
+// WithValidationTracing sets whether the validation of the queries is traced
+// by a graphql.validation span, which is enabled by default. The span is only
+// created when the query is executed within an existing span.
+func WithValidationTracing(on bool) Option {
+	return func(cfg *config) {
+		cfg.traceValidation = on
+	}
+}
+
+// WithQueryComplexity enables tagging the query spans with the approximate
+// complexity of the query, as the number of fields it selects. It is disabled
+// by default because of the cost of scanning the whole query.
+func WithQueryComplexity() Option {
+	return func(cfg *config) {
+		cfg.queryComplexity = true
+	}
+}
+
+// WithResourceNamer sets the function used to compute the resource name of the
+// query spans from the query and its operation name. By default, the operation
+// name is used.
+func WithResourceNamer(namer func(queryString, operationName string) string) Option {
+	return func(cfg *config) {
+		if namer != nil {
+			cfg.resourceNamer = namer
+		}
+	}
+}
+
+// WithSpanNamer sets the function used to name the spans started by the
+// tracer. It is given the default operation name of the span, either
+// "graphql.request" or "graphql.field", and returns the name to use instead.
+func WithSpanNamer(namer func(defaultName string) string) Option {
+	return func(cfg *config) {
+		if namer != nil {
+			cfg.spanNamer = namer
+		}
+	}
+}
+
+// WithVariables enables tagging the query spans with the variables of the
+// query. As they may contain sensitive data, a copy of the variables is first
+// passed to the given redactor, whose result is recorded instead. A nil
+// redactor records the variables as is.
+func WithVariables(redactor func(map[string]interface{}) map[string]interface{}) Option {
+	return func(cfg *config) {
+		cfg.traceVars = true
+		cfg.redactVars = redactor
+	}
+}
+
+// WithMeasured sets whether the query and field spans are measured, computing
+// trace metrics for them. It defaults to true; disabling it on very high-volume
+// services limits the cost of the metrics.
+func WithMeasured(on bool) Option {
+	return func(cfg *config) {
+		cfg.measured = on
+	}
+}
@@ -0,0 +1,89 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2022 Datadog, Inc.
+
+// Package agenttest provides a fake trace agent recording the spans sent by
+// the tracer. Unlike the mock tracer, it allows testing the integrations with
+// the actual tracer and its start options.
+package agenttest // import "gopkg.in/DataDog/dd-trace-go.v1/contrib/internal/agenttest"
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	"github.com/tinylib/msgp/msgp"
+)
+
+// Span is a span received by the fake agent.
+type Span struct {
+	Name     string             `json:"name"`
+	Service  string             `json:"service"`
+	Resource string             `json:"resource"`
+	Type     string             `json:"type"`
+	Meta     map[string]string  `json:"meta"`
+	Metrics  map[string]float64 `json:"metrics"`
+	TraceID  uint64             `json:"trace_id"`
+	SpanID   uint64             `json:"span_id"`
+	ParentID uint64             `json:"parent_id"`
+}
+
+// Agent is a fake trace agent accepting the v0.4 trace payloads.
+type Agent struct {
+	srv *httptest.Server
+
+	mu    sync.Mutex
+	spans []Span
+}
+
+// New starts a new fake agent. It must be closed with Close.
+func New() *Agent {
+	a := new(Agent)
+	a.srv = httptest.NewServer(http.HandlerFunc(a.handle))
+	return a
+}
+
+func (a *Agent) handle(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/v0.4/traces" {
+		// the other endpoints are not supported, such as /info which makes
+		// the tracer fall back to the v0.4 payloads.
+		http.NotFound(w, r)
+		return
+	}
+	var buf bytes.Buffer
+	if _, err := msgp.CopyToJSON(&buf, r.Body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var traces [][]Span
+	if err := json.Unmarshal(buf.Bytes(), &traces); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	a.mu.Lock()
+	for _, t := range traces {
+		a.spans = append(a.spans, t...)
+	}
+	a.mu.Unlock()
+	w.Write([]byte("{}"))
+}
+
+// Addr returns the address of the agent, to be used with tracer.WithAgentAddr.
+func (a *Agent) Addr() string {
+	return a.srv.Listener.Addr().String()
+}
+
+// Spans returns the spans received by the agent so far.
+func (a *Agent) Spans() []Span {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]Span(nil), a.spans...)
+}
+
+// Close shuts the agent down.
+func (a *Agent) Close() {
+	a.srv.Close()
+}
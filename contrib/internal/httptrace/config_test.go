@@ -48,6 +48,15 @@ func TestConfig(t *testing.T) {
 				queryString: true,
 			},
 		},
+		{
+			name: "enable-client-ip",
+			env:  map[string]string{envClientIPEnabled: "true"},
+			cfg: config{
+				queryString:       true,
+				queryStringRegexp: defaultQueryStringRegexp,
+				clientIP:          true,
+			},
+		},
 	} {
 		t.Run(tc.name, func(t *testing.T) {
 			defer cleanEnv()()
@@ -57,6 +66,7 @@ func TestConfig(t *testing.T) {
 			c := newConfig()
 			require.Equal(t, tc.cfg.queryStringRegexp, c.queryStringRegexp)
 			require.Equal(t, tc.cfg.queryString, c.queryString)
+			require.Equal(t, tc.cfg.clientIP, c.clientIP)
 		})
 	}
 }
@@ -65,6 +75,7 @@ func cleanEnv() func() {
 	env := map[string]string{
 		envQueryStringDisabled: os.Getenv(envQueryStringDisabled),
 		envQueryStringRegexp:   os.Getenv(envQueryStringRegexp),
+		envClientIPEnabled:     os.Getenv(envClientIPEnabled),
 	}
 	for k := range env {
 		os.Unsetenv(k)
@@ -17,6 +17,8 @@ import (
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+	"gopkg.in/DataDog/dd-trace-go.v1/internal/appsec"
+	"gopkg.in/DataDog/dd-trace-go.v1/internal/appsec/dyngo/instrumentation/httpsec"
 )
 
 var cfg = newConfig()
@@ -24,6 +26,11 @@ var cfg = newConfig()
 // StartRequestSpan starts an HTTP request span with the standard list of HTTP request span tags (http.method, http.url,
 // http.useragent). Any further span start option can be added with opts.
 func StartRequestSpan(r *http.Request, opts ...ddtrace.StartSpanOption) (tracer.Span, context.Context) {
+	return StartNamedRequestSpan(r, "http.request", opts...)
+}
+
+// StartNamedRequestSpan is like StartRequestSpan but starts a span with the given operation name.
+func StartNamedRequestSpan(r *http.Request, name string, opts ...ddtrace.StartSpanOption) (tracer.Span, context.Context) {
 	// Append our span options before the given ones so that the caller can "overwrite" them.
 	// TODO(): rework span start option handling (https://github.com/DataDog/dd-trace-go/issues/1352)
 	opts = append([]ddtrace.StartSpanOption{
@@ -38,28 +45,44 @@ func StartRequestSpan(r *http.Request, opts ...ddtrace.StartSpanOption) (tracer.
 			tracer.Tag("http.host", r.Host),
 		}, opts...)
 	}
+	// The client IP is personal data: only collect it when explicitly enabled, as AppSec otherwise collects it itself
+	if cfg.clientIP && !appsec.Enabled() {
+		if ip := httpsec.ClientIP(r); ip != "" {
+			opts = append([]ddtrace.StartSpanOption{
+				tracer.Tag(ext.HTTPClientIP, ip),
+			}, opts...)
+		}
+	}
 	if spanctx, err := tracer.Extract(tracer.HTTPHeadersCarrier(r.Header)); err == nil {
 		opts = append(opts, tracer.ChildOf(spanctx))
 	}
-	return tracer.StartSpanFromContext(r.Context(), "http.request", opts...)
+	return tracer.StartSpanFromContext(r.Context(), name, opts...)
 }
 
 // FinishRequestSpan finishes the given HTTP request span and sets the expected response-related tags such as the status
 // code. Any further span finish option can be added with opts.
 func FinishRequestSpan(s tracer.Span, status int, opts ...tracer.FinishOption) {
-	var statusStr string
+	FinishRequestSpanWithStatusCheck(s, status, isServerError, opts...)
+}
+
+// FinishRequestSpanWithStatusCheck is like FinishRequestSpan but marks the span as an error when isStatusError reports
+// the status code as an error, instead of when it is a 5xx status code.
+func FinishRequestSpanWithStatusCheck(s tracer.Span, status int, isStatusError func(statusCode int) bool, opts ...tracer.FinishOption) {
 	if status == 0 {
-		statusStr = "200"
-	} else {
-		statusStr = strconv.Itoa(status)
+		status = http.StatusOK
 	}
+	statusStr := strconv.Itoa(status)
 	s.SetTag(ext.HTTPCode, statusStr)
-	if status >= 500 && status < 600 {
+	if isStatusError(status) {
 		s.SetTag(ext.Error, fmt.Errorf("%s: %s", statusStr, http.StatusText(status)))
 	}
 	s.Finish(opts...)
 }
 
+func isServerError(statusCode int) bool {
+	return statusCode >= 500 && statusCode < 600
+}
+
 // urlFromRequest returns the full URL from the HTTP request. If query params are collected, they are obfuscated granted
 // obfuscation is not disabled by the user (through DD_TRACE_OBFUSCATION_QUERY_STRING_REGEXP)
 // See https://docs.datadoghq.com/tracing/configure_data_security#redacting-the-query-in-the-url for more information.
@@ -80,11 +103,7 @@ func urlFromRequest(r *http.Request) string {
 		url = path
 	}
 	// Collect the query string if we are allowed to report it and obfuscate it if possible/allowed
-	if cfg.queryString && r.URL.RawQuery != "" {
-		query := r.URL.RawQuery
-		if cfg.queryStringRegexp != nil {
-			query = cfg.queryStringRegexp.ReplaceAllLiteralString(query, "<redacted>")
-		}
+	if query := QueryString(r); query != "" {
 		url = strings.Join([]string{url, query}, "?")
 	}
 	if frag := r.URL.EscapedFragment(); frag != "" {
@@ -92,3 +111,17 @@ func urlFromRequest(r *http.Request) string {
 	}
 	return url
 }
+
+// QueryString returns the query string of the HTTP request, obfuscated granted obfuscation is not disabled by the user
+// (through DD_TRACE_OBFUSCATION_QUERY_STRING_REGEXP). It returns an empty string when query string collection is
+// disabled (through DD_TRACE_HTTP_URL_QUERY_STRING_DISABLED).
+func QueryString(r *http.Request) string {
+	if !cfg.queryString || r.URL.RawQuery == "" {
+		return ""
+	}
+	query := r.URL.RawQuery
+	if cfg.queryStringRegexp != nil {
+		query = cfg.queryStringRegexp.ReplaceAllLiteralString(query, "<redacted>")
+	}
+	return query
+}
@@ -14,6 +14,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/mocktracer"
 )
 
@@ -29,6 +30,30 @@ func TestStartRequestSpan(t *testing.T) {
 	assert.Equal(t, "example.com", spans[0].Tag("http.host"))
 }
 
+func TestClientIPTag(t *testing.T) {
+	startSpan := func(t *testing.T) mocktracer.Span {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+		r := httptest.NewRequest(http.MethodGet, "/somePath", nil)
+		r.Header.Set("X-Forwarded-For", "10.0.0.1, 8.8.8.8, 8.8.4.4")
+		s, _ := StartRequestSpan(r)
+		s.Finish()
+		spans := mt.FinishedSpans()
+		require.Len(t, spans, 1)
+		return spans[0]
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		assert.Nil(t, startSpan(t).Tag(ext.HTTPClientIP))
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		defer func(c config) { cfg = c }(cfg)
+		cfg.clientIP = true
+		assert.Equal(t, "8.8.8.8", startSpan(t).Tag(ext.HTTPClientIP))
+	})
+}
+
 func TestURLTag(t *testing.T) {
 	type URLTestCase struct {
 		name, expectedURL, host, port, path, query, fragment string
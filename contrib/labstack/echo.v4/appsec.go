@@ -26,7 +26,7 @@ func useAppSec(c echo.Context, span tracer.Span) func() {
 	ctx, op := httpsec.StartOperation(req.Context(), args)
 	c.SetRequest(req.WithContext(ctx))
 	return func() {
-		events := op.Finish(httpsec.HandlerOperationRes{Status: c.Response().Status})
+		events := op.Finish(httpsec.HandlerOperationRes{Status: c.Response().Status, Headers: c.Response().Header()})
 		if len(events) > 0 {
 			remoteIP, _, err := net.SplitHostPort(req.RemoteAddr)
 			if err != nil {
@@ -8,13 +8,20 @@ package http // import "gopkg.in/DataDog/dd-trace-go.v1/contrib/net/http"
 
 import (
 	"net/http"
+	"strings"
 
+	"gopkg.in/DataDog/dd-trace-go.v1/contrib/internal/httptrace"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
 
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/log"
 )
 
+// tagRouteMatched is set to false on the spans of the requests matching none
+// of the patterns of a ServeMux configured with WithNotFoundResource.
+const tagRouteMatched = "http.route_matched"
+
 // ServeMux is an HTTP request multiplexer that traces all the incoming requests.
 type ServeMux struct {
 	*http.ServeMux
@@ -47,24 +54,47 @@ func (mux *ServeMux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 	// get the resource associated to this request
 	_, route := mux.Handler(r)
+	// the pattern is only empty when no registered pattern matches the request
+	notFound := route == "" && mux.cfg.notFound != ""
+	if mux.cfg.routeExtractor != nil {
+		if rt := mux.cfg.routeExtractor(r); rt != "" {
+			route = rt
+			notFound = false
+		}
+	}
 	resource := mux.cfg.resourceNamer(r)
 	if resource == "" {
-		resource = r.Method + " " + route
+		if notFound {
+			resource = r.Method + " " + mux.cfg.notFound
+		} else {
+			resource = r.Method + " " + route
+		}
 	}
 
 	mux.cfg.spanOpts = append(mux.cfg.spanOpts, tracer.Tag(ext.SpanKind, ext.SpanKindServer))
 	mux.cfg.spanOpts = append(mux.cfg.spanOpts, tracer.Tag(ext.Component, "net/http"))
 
+	spanOpts := mux.cfg.requestSpanOpts(r)
+	if notFound {
+		spanOpts = append(spanOpts, tracer.Tag(tagRouteMatched, false))
+	}
 	TraceAndServe(mux.ServeMux, w, r, &ServeConfig{
-		Service:  mux.cfg.serviceName,
-		Resource: resource,
-		SpanOpts: mux.cfg.spanOpts,
-		Route:    route,
+		Service:       mux.cfg.serviceName,
+		Resource:      resource,
+		SpanName:      mux.cfg.spanNamer("http.request"),
+		SpanOpts:      spanOpts,
+		Route:         route,
+		RouteParams:   patternPathParams(route, r),
+		IsStatusError: mux.cfg.isStatusError,
+		SlowThreshold: mux.cfg.slowThreshold,
 	})
 }
 
 // WrapHandler wraps an http.Handler with tracing using the given service and resource.
 // If the WithResourceNamer option is provided as part of opts, it will take precedence over the resource argument.
+// If the resource argument is empty and the WithRouteExtractor option is provided, the resource is made of the
+// request method and the extracted route. Otherwise, if the resource argument is empty, the routers wrapped by h
+// can provide the matched route with SetRoute.
 func WrapHandler(h http.Handler, service, resource string, opts ...Option) http.Handler {
 	cfg := new(config)
 	defaults(cfg)
@@ -77,18 +107,57 @@ func WrapHandler(h http.Handler, service, resource string, opts ...Option) http.
 			h.ServeHTTP(w, req)
 			return
 		}
+		var route string
+		if cfg.routeExtractor != nil {
+			route = cfg.routeExtractor(req)
+		}
+		resource := resource
 		if r := cfg.resourceNamer(req); r != "" {
 			resource = r
+		} else if resource == "" && route != "" {
+			resource = req.Method + " " + route
 		}
 
 		cfg.spanOpts = append(cfg.spanOpts, tracer.Tag(ext.SpanKind, ext.SpanKindServer))
 		cfg.spanOpts = append(cfg.spanOpts, tracer.Tag(ext.Component, "net/http"))
 
-		TraceAndServe(h, w, req, &ServeConfig{
-			Service:    service,
-			Resource:   resource,
-			FinishOpts: cfg.finishOpts,
-			SpanOpts:   cfg.spanOpts,
-		})
+		sc := &ServeConfig{
+			Service:       service,
+			Resource:      resource,
+			SpanName:      cfg.spanNamer("http.request"),
+			FinishOpts:    cfg.finishOpts,
+			SpanOpts:      cfg.requestSpanOpts(req),
+			IsStatusError: cfg.isStatusError,
+			SlowThreshold: cfg.slowThreshold,
+		}
+		if route != "" {
+			sc.Route = route
+			sc.RouteParams = patternPathParams(route, req)
+		}
+		TraceAndServe(h, w, req, sc)
 	})
 }
+
+// tagQueryString is the tag holding the request query string when WithQueryString is used.
+const tagQueryString = "http.url_details.queryString"
+
+// requestSpanOpts returns the span options of cfg along with the options
+// specific to the given request.
+func (cfg *config) requestSpanOpts(r *http.Request) []ddtrace.StartSpanOption {
+	if len(cfg.headerTags) == 0 && !cfg.queryString {
+		return cfg.spanOpts
+	}
+	// cap opts so that appending to it doesn't modify the configuration shared among requests
+	opts := cfg.spanOpts[:len(cfg.spanOpts):len(cfg.spanOpts)]
+	for _, h := range cfg.headerTags {
+		if v := r.Header.Values(h); len(v) > 0 {
+			opts = append(opts, tracer.Tag(ext.HTTPRequestHeaders+"."+h, strings.Join(v, ",")))
+		}
+	}
+	if cfg.queryString {
+		if q := httptrace.QueryString(r); q != "" {
+			opts = append(opts, tracer.Tag(tagQueryString, q))
+		}
+	}
+	return opts
+}
@@ -6,12 +6,16 @@
 package http
 
 import (
+	"context"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
+	"gopkg.in/DataDog/dd-trace-go.v1/contrib/internal/agenttest"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/mocktracer"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
@@ -166,6 +170,297 @@ func TestServeMuxUsesResourceNamer(t *testing.T) {
 	assert.Equal("net/http", s.Tag(ext.Component))
 }
 
+func TestServeMuxSpanNamer(t *testing.T) {
+	spanNamer := func(defaultName string) string {
+		assert.Equal(t, "http.request", defaultName)
+		return "http.server.request"
+	}
+
+	t.Run("mux", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		r := httptest.NewRequest("GET", "/200", nil)
+		w := httptest.NewRecorder()
+		router(WithSpanNamer(spanNamer)).ServeHTTP(w, r)
+
+		spans := mt.FinishedSpans()
+		assert.Len(t, spans, 1)
+		assert.Equal(t, "http.server.request", spans[0].OperationName())
+		assert.Equal(t, "GET /200", spans[0].Tag(ext.ResourceName))
+	})
+
+	t.Run("handler", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		r := httptest.NewRequest("GET", "/", nil)
+		w := httptest.NewRecorder()
+		WrapHandler(http.HandlerFunc(handler200), "my-service", "my-resource", WithSpanNamer(spanNamer)).ServeHTTP(w, r)
+
+		spans := mt.FinishedSpans()
+		assert.Len(t, spans, 1)
+		assert.Equal(t, "http.server.request", spans[0].OperationName())
+		assert.Equal(t, "my-resource", spans[0].Tag(ext.ResourceName))
+	})
+
+	t.Run("nil", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		r := httptest.NewRequest("GET", "/200", nil)
+		w := httptest.NewRecorder()
+		router(WithSpanNamer(nil)).ServeHTTP(w, r)
+
+		spans := mt.FinishedSpans()
+		assert.Len(t, spans, 1)
+		assert.Equal(t, "http.request", spans[0].OperationName())
+	})
+}
+
+func TestHeaderTags(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	r := httptest.NewRequest("GET", "/200", nil)
+	r.Header.Set("X-Request-Id", "abc")
+	r.Header.Add("Accept", "text/html")
+	r.Header.Add("Accept", "application/json")
+	r.Header.Set("Authorization", "secret")
+	w := httptest.NewRecorder()
+	router(WithHeaderTags([]string{"X-Request-Id", "accept", "X-Absent"})).ServeHTTP(w, r)
+
+	spans := mt.FinishedSpans()
+	assert.Len(t, spans, 1)
+	s := spans[0]
+	assert.Equal(t, "abc", s.Tag("http.request.headers.x-request-id"))
+	assert.Equal(t, "text/html,application/json", s.Tag("http.request.headers.accept"))
+	assert.Nil(t, s.Tag("http.request.headers.x-absent"))
+	assert.Nil(t, s.Tag("http.request.headers.authorization"))
+
+	t.Run("handler", func(t *testing.T) {
+		mt.Reset()
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("X-Request-Id", "def")
+		w := httptest.NewRecorder()
+		WrapHandler(http.HandlerFunc(handler200), "my-service", "my-resource", WithHeaderTags([]string{"x-request-id"})).ServeHTTP(w, r)
+
+		spans := mt.FinishedSpans()
+		assert.Len(t, spans, 1)
+		assert.Equal(t, "def", spans[0].Tag("http.request.headers.x-request-id"))
+	})
+}
+
+func TestQueryString(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	serve := func(opts ...Option) mocktracer.Span {
+		mt.Reset()
+		r := httptest.NewRequest("GET", "/200?user=bob&access_token=s3cr3t", nil)
+		w := httptest.NewRecorder()
+		router(opts...).ServeHTTP(w, r)
+		spans := mt.FinishedSpans()
+		assert.Len(t, spans, 1)
+		return spans[0]
+	}
+
+	t.Run("default", func(t *testing.T) {
+		assert.Nil(t, serve().Tag(tagQueryString))
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		q := serve(WithQueryString()).Tag(tagQueryString)
+		assert.NotNil(t, q)
+		assert.Contains(t, q, "user=bob")
+		assert.Contains(t, q, "<redacted>")
+		assert.NotContains(t, q, "s3cr3t")
+	})
+}
+
+func TestRouteExtractor(t *testing.T) {
+	extractor := WithRouteExtractor(func(r *http.Request) string {
+		return "/custom/route"
+	})
+
+	t.Run("mux", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		r := httptest.NewRequest("GET", "/200", nil)
+		w := httptest.NewRecorder()
+		router(extractor).ServeHTTP(w, r)
+
+		spans := mt.FinishedSpans()
+		assert.Len(t, spans, 1)
+		assert.Equal(t, "GET /custom/route", spans[0].Tag(ext.ResourceName))
+		assert.Equal(t, "/custom/route", spans[0].Tag(ext.HTTPRoute))
+	})
+
+	t.Run("handler-resource", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		r := httptest.NewRequest("GET", "/", nil)
+		w := httptest.NewRecorder()
+		WrapHandler(http.HandlerFunc(handler200), "my-service", "my-resource", extractor).ServeHTTP(w, r)
+
+		spans := mt.FinishedSpans()
+		assert.Len(t, spans, 1)
+		assert.Equal(t, "my-resource", spans[0].Tag(ext.ResourceName))
+		assert.Equal(t, "/custom/route", spans[0].Tag(ext.HTTPRoute))
+	})
+}
+
+func TestNotFoundResource(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		opts     []Option
+		url      string
+		resource string
+		matched  interface{}
+	}{
+		{"unknown", []Option{WithNotFoundResource("unknown")}, "/not-found", "GET unknown", false},
+		{"matched", []Option{WithNotFoundResource("unknown")}, "/200", "GET /200", nil},
+		{"default", nil, "/not-found", "GET ", nil},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+
+			r := httptest.NewRequest("GET", tt.url, nil)
+			w := httptest.NewRecorder()
+			router(tt.opts...).ServeHTTP(w, r)
+
+			spans := mt.FinishedSpans()
+			assert.Len(t, spans, 1)
+			assert.Equal(t, tt.resource, spans[0].Tag(ext.ResourceName))
+			assert.Equal(t, tt.matched, spans[0].Tag(tagRouteMatched))
+		})
+	}
+}
+
+func TestSetRoute(t *testing.T) {
+	// fakeRouter simulates a third-party router providing its matched route.
+	fakeRouter := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SetRoute(r.Context(), "/user/{id}")
+		handler200(w, r)
+	})
+
+	t.Run("resource", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		r := httptest.NewRequest("GET", "/user/42", nil)
+		w := httptest.NewRecorder()
+		WrapHandler(fakeRouter, "my-service", "").ServeHTTP(w, r)
+
+		spans := mt.FinishedSpans()
+		assert.Len(t, spans, 1)
+		assert.Equal(t, "GET /user/{id}", spans[0].Tag(ext.ResourceName))
+		assert.Equal(t, "/user/{id}", spans[0].Tag(ext.HTTPRoute))
+	})
+
+	t.Run("explicit-resource", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		r := httptest.NewRequest("GET", "/user/42", nil)
+		w := httptest.NewRecorder()
+		WrapHandler(fakeRouter, "my-service", "my-resource").ServeHTTP(w, r)
+
+		spans := mt.FinishedSpans()
+		assert.Len(t, spans, 1)
+		assert.Equal(t, "my-resource", spans[0].Tag(ext.ResourceName))
+		assert.Equal(t, "/user/{id}", spans[0].Tag(ext.HTTPRoute))
+	})
+
+	t.Run("untraced", func(t *testing.T) {
+		assert.False(t, SetRoute(context.Background(), "/user/{id}"))
+	})
+}
+
+func TestSlowThreshold(t *testing.T) {
+	slowHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		handler200(w, r)
+	})
+
+	for _, tt := range []struct {
+		name      string
+		handler   http.Handler
+		threshold time.Duration
+		slow      interface{}
+	}{
+		{"slow", slowHandler, 10 * time.Millisecond, true},
+		{"fast", http.HandlerFunc(handler200), time.Hour, nil},
+		{"disabled", slowHandler, 0, nil},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+
+			r := httptest.NewRequest("GET", "/", nil)
+			w := httptest.NewRecorder()
+			WrapHandler(tt.handler, "my-service", "my-resource", WithSlowThreshold(tt.threshold)).ServeHTTP(w, r)
+
+			spans := mt.FinishedSpans()
+			assert.Len(t, spans, 1)
+			assert.Equal(t, tt.slow, spans[0].Tag(tagSlow))
+		})
+	}
+}
+
+func TestStatusCheck(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	mux := NewServeMux(WithStatusCheck(func(statusCode int) bool {
+		return statusCode == http.StatusOK || statusCode >= 500
+	}))
+	mux.HandleFunc("/200", handler200)
+	mux.HandleFunc("/404", http.NotFound)
+
+	for _, tt := range []struct {
+		url   string
+		code  string
+		error bool
+	}{
+		{"/200", "200", true},
+		{"/404", "404", false},
+	} {
+		t.Run(tt.url, func(t *testing.T) {
+			mt.Reset()
+			r := httptest.NewRequest("GET", tt.url, nil)
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, r)
+
+			spans := mt.FinishedSpans()
+			assert.Len(t, spans, 1)
+			assert.Equal(t, tt.code, spans[0].Tag(ext.HTTPCode))
+			if tt.error {
+				assert.NotNil(t, spans[0].Tag(ext.Error))
+			} else {
+				assert.Nil(t, spans[0].Tag(ext.Error))
+			}
+		})
+	}
+
+	t.Run("handler", func(t *testing.T) {
+		mt.Reset()
+		r := httptest.NewRequest("GET", "/", nil)
+		w := httptest.NewRecorder()
+		WrapHandler(http.HandlerFunc(handler500), "my-service", "my-resource", WithStatusCheck(func(statusCode int) bool {
+			return false
+		})).ServeHTTP(w, r)
+
+		spans := mt.FinishedSpans()
+		assert.Len(t, spans, 1)
+		assert.Equal(t, "500", spans[0].Tag(ext.HTTPCode))
+		assert.Nil(t, spans[0].Tag(ext.Error))
+	})
+}
+
 func TestAnalyticsSettings(t *testing.T) {
 	tests := map[string]func(t *testing.T, mt mocktracer.Tracer, rate interface{}, opts ...Option){
 		"ServeMux": func(t *testing.T, mt mocktracer.Tracer, rate interface{}, opts ...Option) {
@@ -291,6 +586,112 @@ func TestIgnoreRequestOption(t *testing.T) {
 	}
 }
 
+func TestGlobalTag(t *testing.T) {
+	agent := agenttest.New()
+	defer agent.Close()
+	tracer.Start(
+		tracer.WithAgentAddr(agent.Addr()),
+		tracer.WithLogStartup(false),
+		tracer.WithGlobalTag("region", "us-east-1"),
+		tracer.WithGlobalTag("foo", "global"),
+	)
+	defer tracer.Stop()
+
+	r := httptest.NewRequest("GET", "/200", nil)
+	w := httptest.NewRecorder()
+	router().ServeHTTP(w, r)
+	assert.Equal(t, 200, w.Code)
+	assert.NoError(t, tracer.FlushWithTimeout(5*time.Second))
+
+	spans := agent.Spans()
+	assert.Len(t, spans, 1)
+	for _, s := range spans {
+		assert.Equal(t, "http.request", s.Name)
+		assert.Equal(t, "us-east-1", s.Meta["region"])
+		// the span options take precedence over the global tags
+		assert.Equal(t, "bar", s.Meta["foo"])
+	}
+}
+
+func TestTraceContextPropagation(t *testing.T) {
+	agent := agenttest.New()
+	defer agent.Close()
+	tracer.Start(
+		tracer.WithAgentAddr(agent.Addr()),
+		tracer.WithLogStartup(false),
+		tracer.WithPropagator(tracer.NewPropagator(&tracer.PropagatorConfig{TraceContext: true})),
+	)
+	defer tracer.Stop()
+
+	// the server continues the trace of the W3C headers
+	r := httptest.NewRequest("GET", "/200", nil)
+	r.Header.Set("traceparent", "00-00000000000000000000000000000001-0000000000000002-01")
+	w := httptest.NewRecorder()
+	router().ServeHTTP(w, r)
+	assert.Equal(t, 200, w.Code)
+
+	// the client injects the W3C headers
+	traceparent := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent <- r.Header.Get("traceparent")
+	}))
+	defer srv.Close()
+	resp, err := WrapClient(&http.Client{}).Get(srv.URL)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.NoError(t, tracer.FlushWithTimeout(5*time.Second))
+
+	spans := agent.Spans()
+	assert.Len(t, spans, 2)
+	for _, s := range spans {
+		if s.Meta[ext.SpanKind] == ext.SpanKindClient {
+			assert.Equal(t, fmt.Sprintf("00-%032x-%016x-01", s.TraceID, s.SpanID), <-traceparent)
+			continue
+		}
+		assert.Equal(t, uint64(1), s.TraceID)
+		assert.Equal(t, uint64(2), s.ParentID)
+	}
+}
+
+func TestBaggagePropagation(t *testing.T) {
+	agent := agenttest.New()
+	defer agent.Close()
+	tracer.Start(
+		tracer.WithAgentAddr(agent.Addr()),
+		tracer.WithLogStartup(false),
+		tracer.WithPropagator(tracer.NewPropagator(&tracer.PropagatorConfig{MaxBaggageItems: 2})),
+	)
+	defer tracer.Stop()
+
+	baggage := make(chan map[string]string, 1)
+	mux := NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		span, _ := tracer.SpanFromContext(r.Context())
+		items := make(map[string]string)
+		span.Context().ForeachBaggageItem(func(k, v string) bool {
+			items[k] = v
+			return true
+		})
+		baggage <- items
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	root, ctx := tracer.StartSpanFromContext(context.Background(), "root")
+	root.SetBaggageItem("a", "1")
+	root.SetBaggageItem("b", "2")
+	root.SetBaggageItem("c", "3")
+	req, err := http.NewRequestWithContext(ctx, "GET", srv.URL, nil)
+	assert.NoError(t, err)
+	resp, err := WrapClient(&http.Client{}).Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	root.Finish()
+
+	// the baggage items beyond the limit are dropped
+	assert.Equal(t, map[string]string{"a": "1", "b": "2"}, <-baggage)
+}
+
 func router(muxOpts ...Option) http.Handler {
 	defaultOpts := []Option{
 		WithServiceName("my-service"),
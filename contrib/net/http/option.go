@@ -8,6 +8,8 @@ package http
 import (
 	"math"
 	"net/http"
+	"strings"
+	"time"
 
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
@@ -17,12 +19,19 @@ import (
 )
 
 type config struct {
-	serviceName   string
-	analyticsRate float64
-	spanOpts      []ddtrace.StartSpanOption
-	finishOpts    []ddtrace.FinishOption
-	ignoreRequest func(*http.Request) bool
-	resourceNamer func(*http.Request) string
+	serviceName    string
+	analyticsRate  float64
+	spanOpts       []ddtrace.StartSpanOption
+	finishOpts     []ddtrace.FinishOption
+	ignoreRequest  func(*http.Request) bool
+	resourceNamer  func(*http.Request) string
+	spanNamer      func(defaultName string) string
+	headerTags     []string
+	queryString    bool
+	routeExtractor func(*http.Request) string
+	isStatusError  func(statusCode int) bool
+	notFound       string
+	slowThreshold  time.Duration
 }
 
 // MuxOption has been deprecated in favor of Option.
@@ -47,6 +56,7 @@ func defaults(cfg *config) {
 	}
 	cfg.ignoreRequest = func(_ *http.Request) bool { return false }
 	cfg.resourceNamer = func(_ *http.Request) string { return "" }
+	cfg.spanNamer = func(defaultName string) string { return defaultName }
 }
 
 // WithIgnoreRequest holds the function to use for determining if the
@@ -104,6 +114,87 @@ func WithResourceNamer(namer func(req *http.Request) string) Option {
 	}
 }
 
+// WithSpanNamer sets the function used to name the request spans. It is given
+// the default operation name of the spans, "http.request", and returns the name
+// to use instead.
+func WithSpanNamer(namer func(defaultName string) string) Option {
+	return func(cfg *config) {
+		if namer != nil {
+			cfg.spanNamer = namer
+		}
+	}
+}
+
+// WithHeaderTags specifies the request headers to attach as
+// http.request.headers.<name> tags to the request spans, <name> being the
+// lowercase header name. Multiple values of a header are joined with commas.
+// Only the listed headers are collected: beware of listing headers carrying
+// sensitive data such as authorization tokens.
+func WithHeaderTags(headers []string) Option {
+	return func(cfg *config) {
+		for _, h := range headers {
+			cfg.headerTags = append(cfg.headerTags, strings.ToLower(h))
+		}
+	}
+}
+
+// WithRouteExtractor specifies a function returning the route template matched
+// by a request, e.g. "/users/{id}" for the request path /users/123. The route
+// is used to tag the request span with http.route and, unless a resource is
+// given otherwise, to name its resource after the request method and route.
+//
+// WrapHandler has no notion of routes: when wrapping a handler serving several
+// paths without a fixed resource name, this option keeps the resource names
+// from having a high cardinality by grouping the requests per route rather than
+// per path.
+func WithRouteExtractor(f func(*http.Request) string) Option {
+	return func(cfg *config) {
+		cfg.routeExtractor = f
+	}
+}
+
+// WithNotFoundResource makes the ServeMux name the resource of the requests
+// which don't match any of its registered patterns, and are answered with a
+// 404 by its NotFoundHandler, after the request method followed by name, such
+// as "GET unknown", instead of the method alone. Their spans are also tagged
+// with http.route_matched set to false, so that they can be told apart from
+// the requests to the existing routes. A custom resource namer or route
+// extractor takes precedence. It has no effect on WrapHandler.
+func WithNotFoundResource(name string) Option {
+	return func(cfg *config) {
+		cfg.notFound = name
+	}
+}
+
+// WithSlowThreshold sets the http.slow tag to true on the request spans of the
+// handlers taking longer than d to serve the request, so that the requests
+// exceeding a latency budget can be filtered without post-processing their
+// durations. It is disabled by default.
+func WithSlowThreshold(d time.Duration) Option {
+	return func(cfg *config) {
+		cfg.slowThreshold = d
+	}
+}
+
+// WithQueryString enables tagging the request spans with the query string of
+// the request, as http.url_details.queryString. The query string is obfuscated
+// using the regular expression set by DD_TRACE_OBFUSCATION_QUERY_STRING_REGEXP,
+// which by default redacts values such as tokens, keys and passwords.
+func WithQueryString() Option {
+	return func(cfg *config) {
+		cfg.queryString = true
+	}
+}
+
+// WithStatusCheck specifies a function fn which reports whether the passed
+// statusCode should be considered an error. By default, 5xx status codes are
+// considered errors.
+func WithStatusCheck(fn func(statusCode int) bool) Option {
+	return func(cfg *config) {
+		cfg.isStatusError = fn
+	}
+}
+
 // NoDebugStack prevents stack traces from being attached to spans finishing
 // with an error. This is useful in situations where errors are frequent and
 // performance is critical.
@@ -127,14 +218,19 @@ type roundTripperConfig struct {
 	analyticsRate float64
 	serviceName   string
 	resourceNamer func(req *http.Request) string
+	spanNamer     func(req *http.Request) string
 	ignoreRequest func(*http.Request) bool
 	spanOpts      []ddtrace.StartSpanOption
+	resHeaderTags []string
+	attemptSpans  bool
+	errTranslator func(error) string
 }
 
 func newRoundTripperConfig() *roundTripperConfig {
 	return &roundTripperConfig{
 		analyticsRate: globalconfig.AnalyticsRate(),
 		resourceNamer: defaultResourceNamer,
+		spanNamer:     defaultSpanNamer,
 		ignoreRequest: func(_ *http.Request) bool { return false },
 	}
 }
@@ -179,6 +275,18 @@ func defaultResourceNamer(_ *http.Request) string {
 	return "http.request"
 }
 
+// RTWithSpanNamer specifies a function which will be used to
+// obtain the span operation name for a given request.
+func RTWithSpanNamer(namer func(req *http.Request) string) RoundTripperOption {
+	return func(cfg *roundTripperConfig) {
+		cfg.spanNamer = namer
+	}
+}
+
+func defaultSpanNamer(_ *http.Request) string {
+	return "http.request"
+}
+
 // RTWithServiceName sets the given service name for the RoundTripper.
 func RTWithServiceName(name string) RoundTripperOption {
 	return func(cfg *roundTripperConfig) {
@@ -209,10 +317,62 @@ func RTWithAnalyticsRate(rate float64) RoundTripperOption {
 	}
 }
 
+// WithResponseHeaderTags specifies the response headers to attach as
+// http.response.headers.<name> tags to the client spans, <name> being the
+// lowercase header name, such as Retry-After or X-RateLimit-Remaining to debug
+// the rate limiting of the upstream services. Multiple values of a header are
+// joined with commas, and the headers missing from the response are ignored.
+func WithResponseHeaderTags(headers []string) RoundTripperOption {
+	return func(cfg *roundTripperConfig) {
+		for _, h := range headers {
+			if h = strings.ToLower(strings.TrimSpace(h)); h != "" {
+				cfg.resHeaderTags = append(cfg.resHeaderTags, h)
+			}
+		}
+	}
+}
+
 // RTWithIgnoreRequest holds the function to use for determining if the
-// outgoing HTTP request should not be traced.
+// outgoing HTTP request should not be traced. No span is created and no
+// span context is injected into the headers of ignored requests.
 func RTWithIgnoreRequest(f func(*http.Request) bool) RoundTripperOption {
 	return func(cfg *roundTripperConfig) {
 		cfg.ignoreRequest = f
 	}
 }
+
+// RTWithAttemptSpans makes the retries of a transport visible by tracing each
+// RoundTrip invocation of the retried transport as an http.request.attempt
+// span, tagged with its attempt number and child of the http.request span of
+// the call. It must be set on both the RoundTripper wrapping the retrying
+// transport, which keeps starting one http.request span per call, and on the
+// RoundTripper wrapping the transport being retried:
+//
+//	inner := WrapRoundTripper(http.DefaultTransport, RTWithAttemptSpans())
+//	rt := WrapRoundTripper(newRetryingTransport(inner), RTWithAttemptSpans())
+//
+// The inner RoundTripper falls back to regular http.request spans when it is
+// not called through an outer one.
+func RTWithAttemptSpans() RoundTripperOption {
+	return func(cfg *roundTripperConfig) {
+		cfg.attemptSpans = true
+	}
+}
+
+// RTWithErrorTranslator sets the function classifying the errors returned by
+// the wrapped transport. When it returns a non-empty string, the span is tagged
+// with http.error_type set to it. This allows telling apart the requests which
+// failed from the ones which were never attempted, such as the ones rejected
+// by an open circuit breaker:
+//
+//	RTWithErrorTranslator(func(err error) string {
+//		if errors.Is(err, gobreaker.ErrOpenState) {
+//			return "circuit_open"
+//		}
+//		return ""
+//	})
+func RTWithErrorTranslator(f func(err error) string) RoundTripperOption {
+	return func(cfg *roundTripperConfig) {
+		cfg.errTranslator = f
+	}
+}
@@ -0,0 +1,17 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2022 Datadog, Inc.
+
+//go:build !go1.22
+// +build !go1.22
+
+package http
+
+import "net/http"
+
+// patternPathParams always returns nil as ServeMux patterns cannot have
+// wildcards before Go 1.22.
+func patternPathParams(_ string, _ *http.Request) map[string]string {
+	return nil
+}
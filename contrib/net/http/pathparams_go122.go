@@ -0,0 +1,66 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2022 Datadog, Inc.
+
+//go:build go1.22
+// +build go1.22
+
+package http
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// patternPathParams returns the values of the wildcards of the given ServeMux
+// pattern matched by the request, e.g. {"id": "123"} for the request path
+// /users/123 and the pattern /users/{id}. It returns nil when the pattern has
+// no wildcards.
+// Note that r.PathValue() cannot be used here since ServeMux.Handler() doesn't
+// set the matched wildcards into the request, so the pattern segments are
+// matched against the request path the same way ServeMux does.
+func patternPathParams(pattern string, r *http.Request) map[string]string {
+	// Remove the optional method and host parts of the pattern: [METHOD ][HOST]/[PATH]
+	if i := strings.IndexByte(pattern, ' '); i >= 0 {
+		pattern = strings.TrimLeft(pattern[i+1:], " \t")
+	}
+	i := strings.IndexByte(pattern, '/')
+	if i < 0 {
+		return nil
+	}
+	pattern = pattern[i:]
+	if !strings.Contains(pattern, "{") {
+		return nil
+	}
+
+	var (
+		params   map[string]string
+		patSegs  = strings.Split(pattern[1:], "/")
+		pathSegs = strings.Split(strings.TrimPrefix(r.URL.EscapedPath(), "/"), "/")
+	)
+	for i, seg := range patSegs {
+		if len(seg) < 2 || seg[0] != '{' || seg[len(seg)-1] != '}' {
+			continue
+		}
+		name := seg[1 : len(seg)-1]
+		if name == "$" || i >= len(pathSegs) {
+			continue
+		}
+		value := pathSegs[i]
+		if multi := strings.TrimSuffix(name, "..."); multi != name {
+			// A multi-segment wildcard matches the remainder of the path
+			name = multi
+			value = strings.Join(pathSegs[i:], "/")
+		}
+		if unescaped, err := url.PathUnescape(value); err == nil {
+			value = unescaped
+		}
+		if params == nil {
+			params = make(map[string]string, len(patSegs)-i)
+		}
+		params[name] = value
+	}
+	return params
+}
@@ -0,0 +1,99 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2022 Datadog, Inc.
+
+//go:build go1.22
+// +build go1.22
+
+// Use the Go 1.22 ServeMux patterns regardless of the go.mod Go version.
+//go:debug httpmuxgo121=0
+
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/mocktracer"
+)
+
+func TestPatternPathParams(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		pattern  string
+		path     string
+		expected map[string]string
+	}{
+		{name: "no-wildcard", pattern: "/users/", path: "/users/123", expected: nil},
+		{name: "wildcard", pattern: "/users/{id}", path: "/users/123", expected: map[string]string{"id": "123"}},
+		{name: "method", pattern: "GET /users/{id}", path: "/users/123", expected: map[string]string{"id": "123"}},
+		{name: "host", pattern: "GET example.com/users/{id}", path: "/users/123", expected: map[string]string{"id": "123"}},
+		{name: "multiple", pattern: "/users/{id}/posts/{post}", path: "/users/123/posts/abc", expected: map[string]string{"id": "123", "post": "abc"}},
+		{name: "multi-segment", pattern: "/files/{path...}", path: "/files/a/b/c", expected: map[string]string{"path": "a/b/c"}},
+		{name: "escaped", pattern: "/users/{id}", path: "/users/a%2Fb", expected: map[string]string{"id": "a/b"}},
+		{name: "end-anchor", pattern: "/users/{$}", path: "/users/", expected: nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tc.path, nil)
+			assert.Equal(t, tc.expected, patternPathParams(tc.pattern, r))
+		})
+	}
+}
+
+func TestServeMuxPathParams(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	var id string
+	mux := NewServeMux(WithServiceName("my-service"))
+	mux.HandleFunc("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id = r.PathValue("id")
+		w.Write([]byte("OK\n"))
+	})
+
+	r := httptest.NewRequest("GET", "/users/123", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+
+	assert := assert.New(t)
+	assert.Equal(200, w.Code)
+	assert.Equal("123", id)
+	assert.Equal(map[string]string{"id": "123"}, patternPathParams("/users/{id}", r))
+
+	spans := mt.FinishedSpans()
+	assert.Len(spans, 1)
+	assert.Equal("GET /users/{id}", spans[0].Tag(ext.ResourceName))
+	assert.Equal("/users/{id}", spans[0].Tag(ext.HTTPRoute))
+}
+
+func TestWrapHandlerRouteExtractor(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	// a single handler serving the users, having no route of its own
+	h := WrapHandler(http.HandlerFunc(handler200), "my-service", "", WithRouteExtractor(func(r *http.Request) string {
+		if strings.HasPrefix(r.URL.Path, "/users/") {
+			return "/users/{id}"
+		}
+		return ""
+	}))
+
+	for _, path := range []string{"/users/123", "/users/456"} {
+		r := httptest.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+	}
+
+	spans := mt.FinishedSpans()
+	assert.Len(t, spans, 2)
+	for _, s := range spans {
+		assert.Equal(t, "GET /users/{id}", s.Tag(ext.ResourceName))
+		assert.Equal(t, "/users/{id}", s.Tag(ext.HTTPRoute))
+	}
+}
@@ -6,17 +6,37 @@
 package http
 
 import (
+	"context"
 	"fmt"
 	"math"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
+	"sync/atomic"
 
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+	"gopkg.in/DataDog/dd-trace-go.v1/internal/appsec"
+	"gopkg.in/DataDog/dd-trace-go.v1/internal/appsec/dyngo/instrumentation/httpsec"
 )
 
+// attemptSpanName is the operation name of the spans started for each attempt
+// of a call when RTWithAttemptSpans is set.
+const attemptSpanName = "http.request.attempt"
+
+// tagAttempt holds the 1-based attempt number of an http.request.attempt span.
+const tagAttempt = "http.request.attempt_number"
+
+// tagErrorType holds the error type returned by the RTWithErrorTranslator
+// function for the errors of the wrapped transport.
+const tagErrorType = "http.error_type"
+
+// attemptsKey is the context key holding the *int32 counting the attempts of a
+// call traced with RTWithAttemptSpans.
+type attemptsKey struct{}
+
 type roundTripper struct {
 	base http.RoundTripper
 	cfg  *roundTripperConfig
@@ -26,6 +46,17 @@ func (rt *roundTripper) RoundTrip(req *http.Request) (res *http.Response, err er
 	if rt.cfg.ignoreRequest(req) {
 		return rt.base.RoundTrip(req)
 	}
+	var (
+		attempt  int32  // attempt number when tracing an attempt of an outer call
+		attempts *int32 // counter handed to the attempts of this call
+	)
+	if rt.cfg.attemptSpans {
+		if n, ok := req.Context().Value(attemptsKey{}).(*int32); ok {
+			attempt = atomic.AddInt32(n, 1)
+		} else {
+			attempts = new(int32)
+		}
+	}
 	resourceName := rt.cfg.resourceNamer(req)
 	opts := []ddtrace.StartSpanOption{
 		tracer.SpanType(ext.SpanTypeHTTP),
@@ -44,7 +75,15 @@ func (rt *roundTripper) RoundTrip(req *http.Request) (res *http.Response, err er
 	if len(rt.cfg.spanOpts) > 0 {
 		opts = append(opts, rt.cfg.spanOpts...)
 	}
-	span, ctx := tracer.StartSpanFromContext(req.Context(), "http.request", opts...)
+	spanName := rt.cfg.spanNamer(req)
+	if attempt > 0 {
+		spanName = attemptSpanName
+		opts = append(opts, tracer.Tag(tagAttempt, int(attempt)))
+	}
+	span, ctx := tracer.StartSpanFromContext(req.Context(), spanName, opts...)
+	if attempts != nil {
+		ctx = context.WithValue(ctx, attemptsKey{}, attempts)
+	}
 	defer func() {
 		if rt.cfg.after != nil {
 			rt.cfg.after(res, span)
@@ -54,6 +93,13 @@ func (rt *roundTripper) RoundTrip(req *http.Request) (res *http.Response, err er
 	if rt.cfg.before != nil {
 		rt.cfg.before(req, span)
 	}
+	if appsec.Enabled() {
+		// let the security rules of the request being handled, if any, abort
+		// the outbound request, such as a server-side request forgery
+		if err = httpsec.ProtectRoundTrip(req.Context(), req.URL.String()); err != nil {
+			return nil, err
+		}
+	}
 	r2 := req.Clone(ctx)
 	// inject the span context into the http request copy
 	err = tracer.Inject(span.Context(), tracer.HTTPHeadersCarrier(r2.Header))
@@ -65,8 +111,18 @@ func (rt *roundTripper) RoundTrip(req *http.Request) (res *http.Response, err er
 	if err != nil {
 		span.SetTag("http.errors", err.Error())
 		span.SetTag(ext.Error, err)
+		if rt.cfg.errTranslator != nil {
+			if typ := rt.cfg.errTranslator(err); typ != "" {
+				span.SetTag(tagErrorType, typ)
+			}
+		}
 	} else {
 		span.SetTag(ext.HTTPCode, strconv.Itoa(res.StatusCode))
+		for _, h := range rt.cfg.resHeaderTags {
+			if v := res.Header.Values(h); len(v) > 0 {
+				span.SetTag(ext.HTTPResponseHeaders+"."+h, strings.Join(v, ","))
+			}
+		}
 		// treat 5XX as errors
 		if res.StatusCode/100 == 5 {
 			span.SetTag("http.errors", res.Status)
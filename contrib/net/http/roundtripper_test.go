@@ -6,9 +6,12 @@
 package http
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"testing"
 	"time"
 
@@ -83,6 +86,112 @@ func TestRoundTripper(t *testing.T) {
 	assert.Equal(t, "net/http", s1.Tag(ext.Component))
 }
 
+func TestRoundTripperResponseHeaderTags(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "120")
+		w.Header().Add("X-Multi", "a")
+		w.Header().Add("X-Multi", "b")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer s.Close()
+
+	rt := WrapRoundTripper(http.DefaultTransport,
+		WithResponseHeaderTags([]string{"Retry-After", " x-multi ", "X-RateLimit-Remaining", ""}))
+	client := &http.Client{Transport: rt}
+	resp, err := client.Get(s.URL)
+	assert.NoError(t, err)
+	resp.Body.Close()
+
+	spans := mt.FinishedSpans()
+	assert.Len(t, spans, 1)
+	tags := spans[0].Tags()
+	assert.Equal(t, "120", tags["http.response.headers.retry-after"])
+	assert.Equal(t, "a,b", tags["http.response.headers.x-multi"])
+	// the headers missing from the response are ignored
+	assert.NotContains(t, tags, "http.response.headers.x-ratelimit-remaining")
+}
+
+// retryTransport retries the 5XX responses of base up to retries times.
+type retryTransport struct {
+	base    http.RoundTripper
+	retries int
+}
+
+func (rt *retryTransport) RoundTrip(req *http.Request) (res *http.Response, err error) {
+	for i := 0; ; i++ {
+		res, err = rt.base.RoundTrip(req)
+		if i == rt.retries || (err == nil && res.StatusCode/100 != 5) {
+			return res, err
+		}
+		if err == nil {
+			res.Body.Close()
+		}
+	}
+}
+
+func TestRoundTripperAttemptSpans(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	var calls int
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls++; calls < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("Hello World"))
+	}))
+	defer s.Close()
+
+	inner := WrapRoundTripper(http.DefaultTransport, RTWithAttemptSpans())
+	rt := WrapRoundTripper(&retryTransport{base: inner, retries: 2}, RTWithAttemptSpans())
+	client := &http.Client{Transport: rt}
+	resp, err := client.Get(s.URL)
+	assert.NoError(t, err)
+	resp.Body.Close()
+
+	spans := mt.FinishedSpans()
+	assert.Len(t, spans, 4)
+	parent := spans[3]
+	assert.Equal(t, "http.request", parent.OperationName())
+	assert.Equal(t, "200", parent.Tag(ext.HTTPCode))
+	for i, span := range spans[:3] {
+		assert.Equal(t, "http.request.attempt", span.OperationName())
+		assert.Equal(t, i+1, span.Tag("http.request.attempt_number"))
+		assert.Equal(t, parent.SpanID(), span.ParentID())
+	}
+	assert.Equal(t, "503", spans[0].Tag(ext.HTTPCode))
+	assert.Equal(t, "503", spans[1].Tag(ext.HTTPCode))
+	assert.Equal(t, "200", spans[2].Tag(ext.HTTPCode))
+}
+
+func TestRoundTripperAttemptSpansDefault(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer s.Close()
+
+	// without an outer RoundTripper, attempts are traced as regular calls
+	rt := &retryTransport{base: WrapRoundTripper(http.DefaultTransport, RTWithAttemptSpans()), retries: 1}
+	client := &http.Client{Transport: rt}
+	resp, err := client.Get(s.URL)
+	assert.NoError(t, err)
+	resp.Body.Close()
+
+	spans := mt.FinishedSpans()
+	assert.Len(t, spans, 2)
+	for _, span := range spans {
+		assert.Equal(t, "http.request", span.OperationName())
+		assert.Nil(t, span.Tag("http.request.attempt_number"))
+	}
+}
+
 func TestRoundTripperServerError(t *testing.T) {
 	mt := mocktracer.Start()
 	defer mt.Stop()
@@ -179,6 +288,59 @@ func TestRoundTripperNetworkError(t *testing.T) {
 	assert.Equal(t, "net/http", s0.Tag(ext.Component))
 }
 
+// roundTripperFunc is an http.RoundTripper calling itself.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestRoundTripperErrorTranslator(t *testing.T) {
+	errCircuitOpen := errors.New("circuit breaker is open")
+	// breaker simulates a circuit breaker rejecting the requests without any
+	// HTTP round trip.
+	breaker := roundTripperFunc(func(*http.Request) (*http.Response, error) {
+		return nil, fmt.Errorf("request rejected: %w", errCircuitOpen)
+	})
+	translator := RTWithErrorTranslator(func(err error) string {
+		if errors.Is(err, errCircuitOpen) {
+			return "circuit_open"
+		}
+		return ""
+	})
+
+	t.Run("circuit-open", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		client := &http.Client{Transport: WrapRoundTripper(breaker, translator)}
+		_, err := client.Get("http://localhost/hello")
+		assert.ErrorIs(t, err, errCircuitOpen)
+
+		spans := mt.FinishedSpans()
+		assert.Len(t, spans, 1)
+		assert.Equal(t, "circuit_open", spans[0].Tag(tagErrorType))
+		assert.NotNil(t, spans[0].Tag(ext.Error))
+	})
+
+	t.Run("other-error", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		failing := roundTripperFunc(func(*http.Request) (*http.Response, error) {
+			return nil, errors.New("connection refused")
+		})
+		client := &http.Client{Transport: WrapRoundTripper(failing, translator)}
+		_, err := client.Get("http://localhost/hello")
+		assert.Error(t, err)
+
+		spans := mt.FinishedSpans()
+		assert.Len(t, spans, 1)
+		assert.Nil(t, spans[0].Tag(tagErrorType))
+		assert.NotNil(t, spans[0].Tag(ext.Error))
+	})
+}
+
 func TestWrapClient(t *testing.T) {
 	c := WrapClient(http.DefaultClient)
 	assert.Equal(t, c, http.DefaultClient)
@@ -277,7 +439,9 @@ func TestRoundTripperIgnoreRequest(t *testing.T) {
 	mt := mocktracer.Start()
 	defer mt.Stop()
 
+	headers := make(map[string]http.Header)
 	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers[r.URL.Path] = r.Header.Clone()
 		w.Write([]byte("Hello World"))
 	}))
 	defer s.Close()
@@ -300,6 +464,12 @@ func TestRoundTripperIgnoreRequest(t *testing.T) {
 
 	spans := mt.FinishedSpans()
 	assert.Len(t, spans, 1)
+	assert.Equal(t, s.URL+"/hello", spans[0].Tag(ext.HTTPURL))
+
+	// No span context must be injected into the ignored request
+	assert.Empty(t, headers["/ignore"].Get(tracer.DefaultTraceIDHeader))
+	assert.Empty(t, headers["/ignore"].Get(tracer.DefaultParentIDHeader))
+	assert.Equal(t, strconv.FormatUint(spans[0].TraceID(), 10), headers["/hello"].Get(tracer.DefaultTraceIDHeader))
 }
 
 func TestServiceName(t *testing.T) {
@@ -395,3 +565,64 @@ func TestSpanOptions(t *testing.T) {
 	assert.Len(t, spans, 1)
 	assert.Equal(t, tagValue, spans[0].Tag(tagKey))
 }
+
+func TestSpanNamer(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Hello World"))
+	}))
+	defer s.Close()
+
+	t.Run("default", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+		client := &http.Client{Transport: WrapRoundTripper(http.DefaultTransport)}
+		client.Get(s.URL + "/hello/world")
+		spans := mt.FinishedSpans()
+		assert.Len(t, spans, 1)
+		assert.Equal(t, "http.request", spans[0].OperationName())
+	})
+
+	t.Run("custom", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+		customNamer := func(req *http.Request) string {
+			return "http.client." + req.Method
+		}
+		client := &http.Client{Transport: WrapRoundTripper(http.DefaultTransport, RTWithSpanNamer(customNamer))}
+		client.Get(s.URL + "/hello/world")
+		spans := mt.FinishedSpans()
+		assert.Len(t, spans, 1)
+		assert.Equal(t, "http.client.GET", spans[0].OperationName())
+	})
+}
+
+func TestRoundTripperDistributedTracing(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	var header http.Header
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Clone()
+		w.Write([]byte("Hello World"))
+	}))
+	defer s.Close()
+
+	parent, ctx := tracer.StartSpanFromContext(context.Background(), "parent")
+	req, err := http.NewRequestWithContext(ctx, "GET", s.URL+"/hello/world", nil)
+	assert.NoError(t, err)
+	client := &http.Client{Transport: WrapRoundTripper(http.DefaultTransport)}
+	_, err = client.Do(req)
+	assert.NoError(t, err)
+	parent.Finish()
+
+	spans := mt.FinishedSpans()
+	assert.Len(t, spans, 2)
+	child := spans[0]
+	assert.Equal(t, "http.request", child.OperationName())
+	assert.Equal(t, parent.Context().SpanID(), child.ParentID())
+	assert.Equal(t, parent.Context().TraceID(), child.TraceID())
+
+	// The client span context must have been sent over the wire
+	assert.Equal(t, strconv.FormatUint(child.TraceID(), 10), header.Get("X-Datadog-Trace-Id"))
+	assert.Equal(t, strconv.FormatUint(child.SpanID(), 10), header.Get("X-Datadog-Parent-Id"))
+}
@@ -8,7 +8,9 @@ package http // import "gopkg.in/DataDog/dd-trace-go.v1/contrib/net/http"
 //go:generate sh -c "go run make_responsewriter.go | gofmt > trace_gen.go"
 
 import (
+	"context"
 	"net/http"
+	"time"
 
 	"gopkg.in/DataDog/dd-trace-go.v1/contrib/internal/httptrace"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace"
@@ -18,6 +20,9 @@ import (
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/appsec/dyngo/instrumentation/httpsec"
 )
 
+// tagSlow is the tag set to true on the request spans exceeding the threshold set with WithSlowThreshold.
+const tagSlow = "http.slow"
+
 // ServeConfig specifies the tracing configuration when using TraceAndServe.
 type ServeConfig struct {
 	// Service specifies the service name to use. If left blank, the global service name
@@ -25,6 +30,8 @@ type ServeConfig struct {
 	Service string
 	// Resource optionally specifies the resource name for this request.
 	Resource string
+	// SpanName optionally specifies the operation name of the request span. It defaults to "http.request".
+	SpanName string
 	// QueryParams should be true in order to append the URL query values to the  "http.url" tag.
 	QueryParams bool
 	// Route is the request matched route if any, or is empty otherwise
@@ -37,6 +44,12 @@ type ServeConfig struct {
 	FinishOpts []ddtrace.FinishOption
 	// SpanOpts specifies any options to be applied to the request starting span.
 	SpanOpts []ddtrace.StartSpanOption
+	// IsStatusError optionally reports whether the response status code should mark the request span as an error.
+	// By default, 5xx status codes are errors.
+	IsStatusError func(statusCode int) bool
+	// SlowThreshold optionally specifies the duration above which serving the request sets the
+	// http.slow tag to true on the request span.
+	SlowThreshold time.Duration
 }
 
 // TraceAndServe serves the handler h using the given ResponseWriter and Request, applying tracing
@@ -47,27 +60,93 @@ func TraceAndServe(h http.Handler, w http.ResponseWriter, r *http.Request, cfg *
 	}
 	opts := append(cfg.SpanOpts, tracer.ServiceName(cfg.Service), tracer.ResourceName(cfg.Resource))
 	opts = append(opts, tracer.Tag(ext.HTTPRoute, cfg.Route))
-	span, ctx := httptrace.StartRequestSpan(r, opts...)
+	name := cfg.SpanName
+	if name == "" {
+		name = "http.request"
+	}
+	span, ctx := httptrace.StartNamedRequestSpan(r, name, opts...)
 	rw, ddrw := wrapResponseWriter(w)
+	rt := new(routeInfo)
+	start := time.Now()
 	defer func() {
-		httptrace.FinishRequestSpan(span, ddrw.status, cfg.FinishOpts...)
+		if cfg.SlowThreshold > 0 && time.Since(start) > cfg.SlowThreshold {
+			span.SetTag(tagSlow, true)
+		}
+		if rt.route != "" && rt.route != cfg.Route {
+			span.SetTag(ext.HTTPRoute, rt.route)
+			if cfg.Resource == "" {
+				span.SetTag(ext.ResourceName, r.Method+" "+rt.route)
+			}
+		}
+		if cfg.IsStatusError != nil {
+			httptrace.FinishRequestSpanWithStatusCheck(span, ddrw.status, cfg.IsStatusError, cfg.FinishOpts...)
+		} else {
+			httptrace.FinishRequestSpan(span, ddrw.status, cfg.FinishOpts...)
+		}
 	}()
 
 	if appsec.Enabled() {
 		h = httpsec.WrapHandler(h, span, cfg.RouteParams)
 	}
+	ctx = context.WithValue(ctx, responseInfoKey{}, ddrw)
+	ctx = context.WithValue(ctx, routeInfoKey{}, rt)
 	h.ServeHTTP(rw, r.WithContext(ctx))
 }
 
+// ResponseInfo gives access to the state of the response of a request traced
+// by TraceAndServe.
+type ResponseInfo interface {
+	// Status returns the status code of the response, or 0 if it wasn't
+	// written yet.
+	Status() int
+	// BytesWritten returns the number of bytes of the response body written so far.
+	BytesWritten() int
+}
+
+type responseInfoKey struct{}
+
+// ResponseInfoFromContext returns the ResponseInfo of the request traced by
+// TraceAndServe whose context is ctx. This allows handlers and middlewares to
+// read the response status the span is finished with.
+func ResponseInfoFromContext(ctx context.Context) (ResponseInfo, bool) {
+	info, ok := ctx.Value(responseInfoKey{}).(ResponseInfo)
+	return info, ok
+}
+
+type routeInfoKey struct{}
+
+// routeInfo holds the route set with SetRoute by the handlers of a request
+// traced by TraceAndServe.
+type routeInfo struct {
+	route string
+}
+
+// SetRoute sets the route pattern matched by a router, such as "/user/{id}",
+// for the request traced by TraceAndServe, or WrapHandler, whose context is
+// ctx. It is meant to be called by the routers the traced handler dispatches
+// the request to, which are the only ones knowing the matched route, so that
+// the http.route tag of the request span is set to it, and, when no resource
+// was given, its resource name is made of the request method and the route.
+// It returns false when the request isn't traced.
+func SetRoute(ctx context.Context, route string) bool {
+	rt, ok := ctx.Value(routeInfoKey{}).(*routeInfo)
+	if !ok {
+		return false
+	}
+	rt.route = route
+	return true
+}
+
 // responseWriter is a small wrapper around an http response writer that will
 // intercept and store the status of a request.
 type responseWriter struct {
 	http.ResponseWriter
-	status int
+	status  int
+	written int
 }
 
 func newResponseWriter(w http.ResponseWriter) *responseWriter {
-	return &responseWriter{w, 0}
+	return &responseWriter{ResponseWriter: w}
 }
 
 // Status returns the status code that was monitored.
@@ -75,6 +154,11 @@ func (w *responseWriter) Status() int {
 	return w.status
 }
 
+// BytesWritten returns the number of bytes written to the response body.
+func (w *responseWriter) BytesWritten() int {
+	return w.written
+}
+
 // Write writes the data to the connection as part of an HTTP reply.
 // We explicitly call WriteHeader with the 200 status code
 // in order to get it reported into the span.
@@ -82,7 +166,9 @@ func (w *responseWriter) Write(b []byte) (int, error) {
 	if w.status == 0 {
 		w.WriteHeader(http.StatusOK)
 	}
-	return w.ResponseWriter.Write(b)
+	n, err := w.ResponseWriter.Write(b)
+	w.written += n
+	return n, err
 }
 
 // WriteHeader sends an HTTP response header with status code.
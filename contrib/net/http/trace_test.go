@@ -6,6 +6,7 @@
 package http
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net/http"
@@ -355,3 +356,37 @@ func BenchmarkTraceAndServe(b *testing.B) {
 		TraceAndServe(handler, noopWriter{}, req, &cfg)
 	}
 }
+
+func TestResponseInfoFromContext(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	var (
+		status, written int
+		found           bool
+	)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("created\n"))
+		info, ok := ResponseInfoFromContext(r.Context())
+		if !ok {
+			return
+		}
+		found = true
+		status, written = info.Status(), info.BytesWritten()
+	})
+	r := httptest.NewRequest("POST", "/", nil)
+	TraceAndServe(handler, httptest.NewRecorder(), r, &ServeConfig{
+		Service:  "service",
+		Resource: "resource",
+	})
+
+	assert.True(t, found)
+	assert.Equal(t, http.StatusCreated, status)
+	assert.Equal(t, 8, written)
+	span := mt.FinishedSpans()[0]
+	assert.Equal(t, "201", span.Tag(ext.HTTPCode))
+
+	_, ok := ResponseInfoFromContext(context.Background())
+	assert.False(t, ok)
+}
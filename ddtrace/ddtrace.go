@@ -130,6 +130,10 @@ type StartSpanConfig struct {
 
 	// Context is the parent context where the span should be stored.
 	Context context.Context
+
+	// Links holds the span contexts the new span is causally related to,
+	// without being their child, such as the other spans of a batch.
+	Links []SpanContext
 }
 
 // Logger implementations are able to log given messages that the tracer or profiler might output.
@@ -138,7 +142,24 @@ type Logger interface {
 	Log(msg string)
 }
 
-// UseLogger sets l as the logger for all tracer and profiler logs.
+// LeveledLogger implementations are Loggers which receive the messages along
+// with their level rather than a line prefixed with it, allowing to route the
+// tracer and profiler logs to structured loggers such as zap or zerolog.
+type LeveledLogger interface {
+	Logger
+	// Debug prints the given debug message.
+	Debug(msg string)
+	// Info prints the given informational message.
+	Info(msg string)
+	// Warn prints the given warning message.
+	Warn(msg string)
+	// Error prints the given error message.
+	Error(msg string)
+}
+
+// UseLogger sets l as the logger for all tracer and profiler logs, including
+// the ones of the integrations and of AppSec. When l implements LeveledLogger,
+// the messages are passed to the method of their level rather than to Log.
 func UseLogger(l Logger) {
 	log.UseLogger(l)
 }
@@ -26,4 +26,28 @@ const (
 
 	// CassandraPaginated specifies the tag name for paginated queries.
 	CassandraPaginated = "cassandra.paginated"
+
+	// CassandraArgs specifies the tag name for the arguments bound to a query.
+	CassandraArgs = "cassandra.args"
+
+	// CassandraBatchSize specifies the tag name for the number of statements in a batch.
+	CassandraBatchSize = "cassandra.batch_size"
+
+	// CassandraAttempts specifies the tag name for the number of times a query was executed.
+	CassandraAttempts = "cassandra.attempts"
+
+	// CassandraRetries specifies the tag name for the number of times a query was retried.
+	CassandraRetries = "cassandra.retries"
+
+	// CassandraCoordinator specifies the tag name for the address of the node
+	// coordinating a query.
+	CassandraCoordinator = "cassandra.coordinator"
+
+	// CassandraCoordinatorChanged specifies the tag name for whether an earlier
+	// attempt of a query was coordinated by another node.
+	CassandraCoordinatorChanged = "cassandra.coordinator_changed"
+
+	// CassandraErrorCancelled specifies the tag name for the queries interrupted
+	// by the cancellation of their context.
+	CassandraErrorCancelled = "cassandra.error.cancelled"
 )
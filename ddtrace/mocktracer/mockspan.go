@@ -17,6 +17,7 @@ import (
 
 var _ ddtrace.Span = (*mockspan)(nil)
 var _ Span = (*mockspan)(nil)
+var _ LinkedSpan = (*mockspan)(nil)
 
 // Span is an interface that allows querying a span returned by the mock tracer.
 type Span interface {
@@ -51,6 +52,16 @@ type Span interface {
 	fmt.Stringer
 }
 
+// LinkedSpan is implemented by the spans returned by the mock tracer, and
+// allows querying the span contexts a span was linked to. It is obtained with
+// a type assertion on a Span.
+type LinkedSpan interface {
+	Span
+
+	// Links returns the span contexts this span was linked to when started.
+	Links() []ddtrace.SpanContext
+}
+
 func newSpan(t *mocktracer, operationName string, cfg *ddtrace.StartSpanConfig) *mockspan {
 	if cfg.Tags == nil {
 		cfg.Tags = make(map[string]interface{})
@@ -61,6 +72,7 @@ func newSpan(t *mocktracer, operationName string, cfg *ddtrace.StartSpanConfig)
 	s := &mockspan{
 		name:   operationName,
 		tracer: t,
+		links:  append([]ddtrace.SpanContext(nil), cfg.Links...),
 	}
 	if cfg.StartTime.IsZero() {
 		s.startTime = time.Now()
@@ -107,6 +119,7 @@ type mockspan struct {
 	parentID  uint64
 	context   *spanContext
 	tracer    *mocktracer
+	links     []ddtrace.SpanContext
 }
 
 // SetTag sets a given tag on the span.
@@ -155,6 +168,9 @@ func (s *mockspan) Tags() map[string]interface{} {
 	return cp
 }
 
+// Links returns the span contexts this span was linked to when started.
+func (s *mockspan) Links() []ddtrace.SpanContext { return s.links }
+
 func (s *mockspan) TraceID() uint64 { return s.context.traceID }
 
 func (s *mockspan) SpanID() uint64 { return s.context.spanID }
@@ -233,11 +249,16 @@ baggage: %#v
 // Context returns the SpanContext of this Span.
 func (s *mockspan) Context() ddtrace.SpanContext { return s.context }
 
-// SetUser associates user information to the current trace which the
-// provided span belongs to. The options can be used to tune which user
-// bit of information gets monitored. This mockup only sets the user
-// information as span tags of the root span of the current trace.
-func (s *mockspan) SetUser(id string, opts ...tracer.UserMonitoringOption) {
+// Root returns the root span of the trace the span belongs to, walking up the
+// open parent spans.
+func (s *mockspan) Root() tracer.Span {
+	if root := s.root(); root != nil {
+		return root
+	}
+	return s
+}
+
+func (s *mockspan) root() *mockspan {
 	// Walk the span up to the root parent span
 	openSpans := s.tracer.openSpans
 	var current Span = s
@@ -252,9 +273,17 @@ func (s *mockspan) SetUser(id string, opts ...tracer.UserMonitoringOption) {
 		}
 		current = parent
 	}
+	root, _ := current.(*mockspan)
+	return root
+}
 
-	root, ok := current.(*mockspan)
-	if !ok {
+// SetUser associates user information to the current trace which the
+// provided span belongs to. The options can be used to tune which user
+// bit of information gets monitored. This mockup only sets the user
+// information as span tags of the root span of the current trace.
+func (s *mockspan) SetUser(id string, opts ...tracer.UserMonitoringOption) {
+	root := s.root()
+	if root == nil {
 		return
 	}
 
@@ -167,6 +167,15 @@ func TestSpanContext(t *testing.T) {
 	})
 }
 
+func TestSpanLinks(t *testing.T) {
+	link := basicSpan("kafka.produce")
+	s := newSpan(&mocktracer{}, "kafka.produce", &ddtrace.StartSpanConfig{
+		Links: []ddtrace.SpanContext{link.Context()},
+	})
+	assert.Equal(t, []ddtrace.SpanContext{link.Context()}, s.Links())
+	assert.Empty(t, basicSpan("http.request").Links())
+}
+
 func TestSpanFinish(t *testing.T) {
 	s := basicSpan("http.request")
 	want := errors.New("some error")
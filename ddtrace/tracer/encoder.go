@@ -0,0 +1,93 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2022 Datadog, Inc.
+
+package tracer
+
+import (
+	"bytes"
+	"sync"
+
+	"gopkg.in/DataDog/dd-trace-go.v1/internal/log"
+)
+
+// encoder encodes the traces sent to the agent in a given format.
+type encoder interface {
+	// Encode encodes the given traces, returning the encoded payload along with
+	// its content type. The traces which cannot be encoded are skipped.
+	Encode(traces [][]*span) ([]byte, string)
+
+	// Endpoint returns the path of the agent endpoint receiving the payloads
+	// of the encoder, such as "/v0.4/traces".
+	Endpoint() string
+}
+
+// Names of the built-in encoders.
+const (
+	encoderV04 = "v0.4"
+	encoderV05 = "v0.5"
+)
+
+var (
+	encodersMu sync.RWMutex
+	encoders   = map[string]encoder{
+		encoderV04: msgpackEncoder{},
+		encoderV05: msgpackEncoder{v05: true},
+	}
+)
+
+// registerEncoder registers the encoder e under the given name, so that it can
+// be selected with WithTraceEncoder. It replaces any encoder already
+// registered under the same name. As the encoders work on the spans internal to
+// the tracer, the registry is only open to the formats implemented in this
+// package, such as experimental ones.
+func registerEncoder(name string, e encoder) {
+	encodersMu.Lock()
+	defer encodersMu.Unlock()
+	encoders[name] = e
+}
+
+// lookupEncoder returns the encoder registered under the given name.
+func lookupEncoder(name string) (encoder, bool) {
+	encodersMu.RLock()
+	defer encodersMu.RUnlock()
+	e, ok := encoders[name]
+	return e, ok
+}
+
+// msgpackEncoder is the default encoder, encoding the traces in the msgpack
+// v0.4 format, or in the v0.5 format when v05 is set. The trace writer streams
+// the traces into a payload of the same format instead of calling Encode.
+type msgpackEncoder struct {
+	v05 bool
+}
+
+// Encode implements encoder.
+func (e msgpackEncoder) Encode(traces [][]*span) ([]byte, string) {
+	p := e.newPayload()
+	for _, t := range traces {
+		if err := p.push(t); err != nil {
+			log.Error("Error encoding msgpack: %v", err)
+		}
+	}
+	var buf bytes.Buffer
+	buf.ReadFrom(p)
+	return buf.Bytes(), "application/msgpack"
+}
+
+// Endpoint implements encoder.
+func (e msgpackEncoder) Endpoint() string {
+	if e.v05 {
+		return "/v0.5/traces"
+	}
+	return "/v0.4/traces"
+}
+
+// newPayload returns a new payload streaming traces in the format of e.
+func (e msgpackEncoder) newPayload() *payload {
+	if e.v05 {
+		return newPayloadV05()
+	}
+	return newPayload()
+}
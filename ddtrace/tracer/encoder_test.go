@@ -0,0 +1,157 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2022 Datadog, Inc.
+
+package tracer
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tinylib/msgp/msgp"
+)
+
+// jsonEncoder is a fake encoder encoding the names of the spans of each trace
+// in JSON.
+type jsonEncoder struct{}
+
+func (jsonEncoder) Encode(traces [][]*span) ([]byte, string) {
+	names := make([][]string, len(traces))
+	for i, t := range traces {
+		for _, s := range t {
+			names[i] = append(names[i], s.Name)
+		}
+	}
+	b, _ := json.Marshal(names)
+	return b, "application/json"
+}
+
+func (jsonEncoder) Endpoint() string { return "/v0.1/test-json" }
+
+func TestEncoderRegistry(t *testing.T) {
+	e, ok := lookupEncoder(encoderV04)
+	assert.True(t, ok)
+	assert.Equal(t, msgpackEncoder{}, e)
+	e, ok = lookupEncoder(encoderV05)
+	assert.True(t, ok)
+	assert.Equal(t, msgpackEncoder{v05: true}, e)
+	_, ok = lookupEncoder("test-json")
+	assert.False(t, ok)
+
+	registerEncoder("test-json", jsonEncoder{})
+	e, ok = lookupEncoder("test-json")
+	assert.True(t, ok)
+	assert.Equal(t, jsonEncoder{}, e)
+}
+
+func TestWithTraceEncoder(t *testing.T) {
+	registerEncoder("test-json", jsonEncoder{})
+
+	t.Run("default", func(t *testing.T) {
+		c := newConfig()
+		assert.Nil(t, c.encoder)
+		p := newPayloadFor(c)
+		assert.Nil(t, p.enc)
+		assert.Nil(t, p.strings)
+	})
+
+	t.Run("v0.5", func(t *testing.T) {
+		p := newPayloadFor(newConfig(WithTraceEncoder(encoderV05)))
+		assert.Nil(t, p.enc)
+		assert.NotNil(t, p.strings)
+	})
+
+	t.Run("registered", func(t *testing.T) {
+		p := newPayloadFor(newConfig(WithTraceEncoder("test-json")))
+		assert.Equal(t, jsonEncoder{}, p.enc)
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		c := newConfig(WithTraceEncoder("unknown"))
+		assert.Nil(t, c.encoder)
+	})
+}
+
+func TestTransportEncoder(t *testing.T) {
+	var (
+		path        string
+		contentType string
+		body        []byte
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		contentType = r.Header.Get("Content-Type")
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer srv.Close()
+	trace := []*span{newBasicSpan("root"), newBasicSpan("child")}
+
+	t.Run("registered", func(t *testing.T) {
+		p := newEncoderPayload(jsonEncoder{})
+		assert.NoError(t, p.push(trace))
+		assert.NoError(t, p.push(trace[1:]))
+		_, err := newHTTPTransport(srv.URL, defaultClient).send(p)
+		assert.NoError(t, err)
+		assert.Equal(t, "/v0.1/test-json", path)
+		assert.Equal(t, "application/json", contentType)
+		assert.JSONEq(t, `[["root","child"],["child"]]`, string(body))
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		p := newEncoderPayload(jsonEncoder{})
+		assert.NoError(t, p.push(trace))
+		m := p.mark()
+		assert.NoError(t, p.push(trace))
+		p.rollback(m)
+		assert.Equal(t, 1, p.itemCount())
+		_, err := newHTTPTransport(srv.URL, defaultClient).send(p)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `[["root","child"]]`, string(body))
+	})
+
+	t.Run("default", func(t *testing.T) {
+		p := newPayload()
+		assert.NoError(t, p.push(trace))
+		_, err := newHTTPTransport(srv.URL, defaultClient).send(p)
+		assert.NoError(t, err)
+		assert.Equal(t, "/v0.4/traces", path)
+		assert.Equal(t, "application/msgpack", contentType)
+		var got spanLists
+		assert.NoError(t, msgp.Decode(bytes.NewReader(body), &got))
+		assert.Len(t, got, 1)
+	})
+
+	t.Run("msgpack-encoder", func(t *testing.T) {
+		b, ctype := msgpackEncoder{}.Encode([][]*span{trace})
+		assert.Equal(t, "application/msgpack", ctype)
+		assert.Equal(t, "/v0.4/traces", msgpackEncoder{}.Endpoint())
+		assert.Equal(t, "/v0.5/traces", msgpackEncoder{v05: true}.Endpoint())
+		var got spanLists
+		assert.NoError(t, msgp.Decode(bytes.NewReader(b), &got))
+		assert.Len(t, got, 1)
+		assert.Len(t, got[0], 2)
+	})
+}
+
+// TestEncoderSanitizeUTF8 tests that the traces pushed into the payload of an
+// encoder have their invalid UTF-8 meta sanitized, like the msgpack ones.
+func TestEncoderSanitizeUTF8(t *testing.T) {
+	registerEncoder("test-json", jsonEncoder{})
+	var tg testStatsdClient
+	c := newConfig(withTransport(newDummyTransport()), withStatsdClient(&tg), WithTraceEncoder("test-json"))
+	h := newAgentTraceWriter(c, newPrioritySampler())
+	defer h.stop()
+	trace := []*span{makeSpan(1)}
+	trace[0].Meta["http.url"] = "/path\xff"
+	h.add(trace)
+
+	assert.Equal(t, jsonEncoder{}, h.payload.enc)
+	assert.Equal(t, int64(1), tg.Counts()["datadog.tracer.invalid_utf8_meta"])
+	assert.Equal(t, "/path\uFFFD", trace[0].Meta["http.url"])
+}
@@ -8,6 +8,8 @@ package tracer_test
 import (
 	"context"
 	"fmt"
+	"math/rand"
+	"net/http"
 	"time"
 
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
@@ -51,3 +53,21 @@ func doSomething(ctx context.Context) (err error) {
 	}
 	return ctx.Err()
 }
+
+// An example showing how to make trace and span IDs stable across runs, e.g. for
+// snapshot tests of the propagation headers, by pinning both the source of random
+// numbers and the start time of the spans.
+func Example_randSource() {
+	tracer.Start(tracer.WithRandSource(rand.NewSource(42)), tracer.WithLogStartup(false))
+	defer tracer.Stop()
+
+	span := tracer.StartSpan("web.request", tracer.StartTime(time.Unix(0, 0)))
+	defer span.Finish()
+
+	headers := http.Header{}
+	if err := tracer.Inject(span.Context(), tracer.HTTPHeadersCarrier(headers)); err != nil {
+		panic(err)
+	}
+	fmt.Println(headers.Get("X-Datadog-Trace-Id"))
+	// Output: 3440579354231278675
+}
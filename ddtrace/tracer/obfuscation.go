@@ -0,0 +1,155 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2022 Datadog, Inc.
+
+package tracer
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
+	"gopkg.in/DataDog/dd-trace-go.v1/internal/log"
+
+	"github.com/DataDog/datadog-agent/pkg/obfuscate"
+)
+
+// ObfuscationConfig configures the obfuscation of the sensitive data held by
+// the spans, applied by the tracer when they finish, with WithObfuscation.
+type ObfuscationConfig struct {
+	// SQL replaces the literals of the queries with a ? placeholder, as the
+	// agent obfuscates them. It applies to the resource names of the sql and
+	// cassandra spans, and to the sql.query, cassandra.query and db.statement
+	// tags.
+	SQL bool
+
+	// URL redacts the values of the query string parameters of the http.url
+	// tag, and removes the password of its user information.
+	URL bool
+
+	// URLQueryKeyRegex, when set, limits the redaction of the query string
+	// parameters to the ones whose key matches it, or whose key and value
+	// match URLQueryValueRegex.
+	URLQueryKeyRegex string
+
+	// URLQueryValueRegex, when set, limits the redaction of the query string
+	// parameters to the ones whose key=value pair matches it, or whose key
+	// matches URLQueryKeyRegex.
+	URLQueryValueRegex string
+}
+
+// obfuscatedValue replaces the obfuscated literals and values.
+const obfuscatedValue = "?"
+
+// obfuscator obfuscates the spans as configured by an ObfuscationConfig.
+type obfuscator struct {
+	sql bool
+	url bool
+	// keyRE and valueRE select the query string parameters to redact; all of
+	// them are redacted when both are nil.
+	keyRE, valueRE *regexp.Regexp
+}
+
+// newObfuscator returns the obfuscator applying cfg. Invalid regular
+// expressions are ignored.
+func newObfuscator(cfg ObfuscationConfig) *obfuscator {
+	o := &obfuscator{sql: cfg.SQL, url: cfg.URL}
+	o.keyRE = compileObfuscationRegex("URLQueryKeyRegex", cfg.URLQueryKeyRegex)
+	o.valueRE = compileObfuscationRegex("URLQueryValueRegex", cfg.URLQueryValueRegex)
+	return o
+}
+
+func compileObfuscationRegex(name, expr string) *regexp.Regexp {
+	if expr == "" {
+		return nil
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		log.Error("Ignoring the invalid obfuscation %s %q: %v", name, expr, err)
+		return nil
+	}
+	return re
+}
+
+// sqlQueryTags are the tags holding queries, obfuscated like SQL.
+var sqlQueryTags = []string{ext.SQLQuery, ext.CassandraQuery, ext.DBStatement}
+
+// obfuscate obfuscates the resource name and the tags of s, using the SQL
+// obfuscator of the agent sqlo for the queries. It must be called with s locked.
+func (o *obfuscator) obfuscate(s *span, sqlo *obfuscate.Obfuscator) {
+	if o.sql {
+		if s.Type == ext.SpanTypeSQL || s.Type == ext.SpanTypeCassandra {
+			s.Resource = obfuscateSQL(sqlo, s.Resource)
+		}
+		for _, k := range sqlQueryTags {
+			if v, ok := s.Meta[k]; ok {
+				s.Meta[k] = obfuscateSQL(sqlo, v)
+			}
+		}
+	}
+	if o.url {
+		if v, ok := s.Meta[ext.HTTPURL]; ok {
+			s.Meta[ext.HTTPURL] = o.obfuscateURL(v)
+		}
+	}
+}
+
+// obfuscateSQL returns the query q with its literals replaced with a ?
+// placeholder by the obfuscator o of the agent, or textNonParsable when it
+// cannot be parsed, so that none of its literals are kept.
+func obfuscateSQL(o *obfuscate.Obfuscator, q string) string {
+	oq, err := o.ObfuscateSQLString(q)
+	if err != nil {
+		log.Debug("Error obfuscating query %q: %v", q, err)
+		return textNonParsable
+	}
+	return oq.Query
+}
+
+// obfuscateURL returns the URL u with the values of its query string
+// parameters selected by o redacted, and without the password of its user
+// information. Invalid URLs are entirely redacted.
+func (o *obfuscator) obfuscateURL(u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return obfuscatedValue
+	}
+	if _, ok := parsed.User.Password(); ok {
+		parsed.User = url.User(parsed.User.Username())
+	}
+	if parsed.RawQuery != "" {
+		params := strings.Split(parsed.RawQuery, "&")
+		for i, p := range params {
+			key := p
+			if j := strings.IndexByte(p, '='); j >= 0 {
+				key = p[:j]
+			}
+			if o.redactParam(key, p) {
+				params[i] = key + "=" + obfuscatedValue
+			}
+		}
+		parsed.RawQuery = strings.Join(params, "&")
+	}
+	return parsed.String()
+}
+
+// redactParam reports whether the query string parameter key, whose raw
+// key=value pair is param, must be redacted.
+func (o *obfuscator) redactParam(key, param string) bool {
+	if o.keyRE == nil && o.valueRE == nil {
+		return true
+	}
+	if o.keyRE != nil {
+		if k, err := url.QueryUnescape(key); err == nil && o.keyRE.MatchString(k) {
+			return true
+		}
+	}
+	if o.valueRE != nil {
+		if p, err := url.QueryUnescape(param); err == nil && o.valueRE.MatchString(p) {
+			return true
+		}
+	}
+	return false
+}
@@ -7,21 +7,26 @@ package tracer
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"math"
+	"math/rand"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal"
+	"gopkg.in/DataDog/dd-trace-go.v1/internal/appsec"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/globalconfig"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/log"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/traceprof"
@@ -41,6 +46,10 @@ var (
 
 	// defaultMaxTagsHeaderLen specifies the default maximum length of the X-Datadog-Tags header value.
 	defaultMaxTagsHeaderLen = 128
+
+	// defaultPartialFlushMinSpans specifies the default number of finished
+	// spans of a trace which triggers its partial flush.
+	defaultPartialFlushMinSpans = 1000
 )
 
 // config holds the tracer configuration.
@@ -52,6 +61,10 @@ type config struct {
 	// of the behaviour of the tracer.
 	agent agentFeatures
 
+	// agentCache holds the capabilities of the agent, refreshed periodically
+	// during the lifetime of the tracer.
+	agentCache *agentFeaturesCache
+
 	// featureFlags specifies any enabled feature flags.
 	featureFlags map[string]struct{}
 
@@ -89,6 +102,38 @@ type config struct {
 	// all spans.
 	globalTags map[string]interface{}
 
+	// monotonicDurations reports whether the span durations are computed
+	// from a monotonic clock.
+	monotonicDurations bool
+
+	// tagFilter, when set, drops or rewrites the tags of the spans when they
+	// finish.
+	tagFilter func(key string, value interface{}) (interface{}, bool)
+
+	// obfuscator, when set, obfuscates the sensitive data of the spans when
+	// they finish.
+	obfuscator *obfuscator
+
+	// maxTagValueLength, when positive, is the number of runes the string tag
+	// values and resource names are truncated to when finishing the spans.
+	maxTagValueLength int
+
+	// syncFlush reports whether the traces are sent synchronously when their
+	// root span finishes, instead of periodically in the background.
+	syncFlush bool
+
+	// traceBufferLimit is the maximum number of finished traces buffered while
+	// waiting to be sent; the oldest ones are dropped beyond it.
+	traceBufferLimit int
+
+	// partialFlushMinSpans, when positive, is the number of finished spans of
+	// an unfinished trace after which they are flushed.
+	partialFlushMinSpans int
+
+	// encoder, when set, is the encoder of the traces sent to the agent,
+	// instead of the most efficient format supported by the agent.
+	encoder encoder
+
 	// transport specifies the Transport interface which will be used to send data to the agent.
 	transport transport
 
@@ -98,6 +143,19 @@ type config struct {
 	// httpClient specifies the HTTP client to be used by the agent's transport.
 	httpClient *http.Client
 
+	// agentSocketDetected reports whether httpClient connects through the
+	// default UDS socket, which was found on disk, so that an explicitly
+	// configured agent address takes precedence over it.
+	agentSocketDetected bool
+
+	// proxyURL, when not nil, is the URL of the HTTP proxy through which the
+	// agent is reached.
+	proxyURL *url.URL
+
+	// tlsConfig, when not nil, is the TLS configuration used to connect to an
+	// agent served over HTTPS.
+	tlsConfig *tls.Config
+
 	// hostname is automatically assigned when the DD_TRACE_REPORT_HOSTNAME is set to true,
 	// and is added as a special tag to the root span of traces.
 	hostname string
@@ -141,6 +199,34 @@ type config struct {
 
 	// enabled reports whether tracing is enabled.
 	enabled bool
+
+	// traceID128Bit specifies whether new traces are given 128-bit trace IDs.
+	traceID128Bit bool
+
+	// payloadCompression specifies whether trace payloads should be gzip-compressed.
+	payloadCompression bool
+
+	// sendRetries is the number of times a failed trace payload send is retried.
+	sendRetries int
+
+	// retryInterval is the base interval between the retries of a failed trace
+	// payload send, growing exponentially.
+	retryInterval time.Duration
+
+	// sendObserver, when set, is called with the statistics of each trace
+	// payload send.
+	sendObserver func(SendStats)
+
+	// maxPayloadSize specifies the size in bytes above which a trace payload is
+	// flushed, so that no payload exceeds it unless made of a single trace.
+	maxPayloadSize int
+
+	// randSource, when not nil, is the source of random numbers used to generate
+	// trace and span IDs instead of the default crypto-seeded one.
+	randSource rand.Source
+
+	// appsecStartOptions controls the options used when starting appsec features.
+	appsecStartOptions []appsec.StartOption
 }
 
 // HasFeature reports whether feature f is enabled.
@@ -187,14 +273,21 @@ const maxPropagatedTagsLength = 512
 func newConfig(opts ...StartOption) *config {
 	c := new(config)
 	c.sampler = NewAllSampler()
+	c.maxPayloadSize = payloadSizeLimit
+	c.traceBufferLimit = payloadQueueSize
 	c.agentURL = "http://" + resolveAgentAddr()
 	c.httpClient = defaultHTTPClient()
+	c.agentSocketDetected = c.httpClient != defaultClient
 	if url := internal.AgentURLFromEnv(); url != nil {
 		if url.Scheme == "unix" {
 			c.httpClient = udsClient(url.Path)
 		} else {
 			c.agentURL = url.String()
+			if c.agentSocketDetected {
+				c.httpClient = defaultClient
+			}
 		}
+		c.agentSocketDetected = false
 	}
 	if internal.BoolEnv("DD_TRACE_ANALYTICS_ENABLED", false) {
 		globalconfig.SetAnalyticsRate(1.0)
@@ -241,6 +334,10 @@ func newConfig(opts ...StartOption) *config {
 	c.enabled = internal.BoolEnv("DD_TRACE_ENABLED", true)
 	c.profilerEndpoints = internal.BoolEnv(traceprof.EndpointEnvVar, true)
 	c.profilerHotspots = internal.BoolEnv(traceprof.CodeHotspotsEnvVar, true)
+	c.traceID128Bit = internal.BoolEnv("DD_TRACE_128_BIT_TRACEID_GENERATION_ENABLED", false)
+	if internal.BoolEnv("DD_TRACE_PARTIAL_FLUSH_ENABLED", false) {
+		WithPartialFlushing(internal.IntEnv("DD_TRACE_PARTIAL_FLUSH_MIN_SPANS", defaultPartialFlushMinSpans))(c)
+	}
 
 	for _, fn := range opts {
 		fn(c)
@@ -270,6 +367,12 @@ func newConfig(opts ...StartOption) *config {
 			c.serviceName = filepath.Base(os.Args[0])
 		}
 	}
+	if c.proxyURL == nil && strings.HasPrefix(c.agentURL, "https://") {
+		c.proxyURL = httpsProxyFromEnv()
+	}
+	if c.proxyURL != nil || c.tlsConfig != nil {
+		c.httpClient = configureHTTPClient(c.httpClient, c.proxyURL, c.tlsConfig)
+	}
 	if c.transport == nil {
 		c.transport = newHTTPTransport(c.agentURL, c.httpClient)
 	}
@@ -295,6 +398,12 @@ func newConfig(opts ...StartOption) *config {
 		log.SetLevel(log.LevelDebug)
 	}
 	c.loadAgentFeatures()
+	if t, ok := c.transport.(*httpTransport); ok {
+		t.retries = c.sendRetries
+		t.retryInterval = c.retryInterval
+		t.observer = c.sendObserver
+		t.compress = c.payloadCompression
+	}
 	if c.statsd == nil {
 		// configure statsd client
 		addr := c.dogstatsdAddr
@@ -328,7 +437,12 @@ func newConfig(opts ...StartOption) *config {
 }
 
 // defaultHTTPClient returns the default http.Client to start the tracer with.
+// It connects through the default UDS socket when it exists, unless the agent
+// host or port are set in the environment.
 func defaultHTTPClient() *http.Client {
+	if os.Getenv("DD_AGENT_HOST") != "" || os.Getenv("DD_TRACE_AGENT_PORT") != "" {
+		return defaultClient
+	}
 	if _, err := os.Stat(defaultSocketAPM); err == nil {
 		// we have the UDS socket file, use it
 		return udsClient(defaultSocketAPM)
@@ -356,6 +470,51 @@ func udsClient(socketPath string) *http.Client {
 	}
 }
 
+// httpsProxyFromEnv returns the proxy URL set in the HTTPS_PROXY environment
+// variable, or nil if it's not set or invalid.
+func httpsProxyFromEnv() *url.URL {
+	v := os.Getenv("HTTPS_PROXY")
+	if v == "" {
+		v = os.Getenv("https_proxy")
+	}
+	if v == "" {
+		return nil
+	}
+	u, err := url.Parse(v)
+	if err != nil || u.Host == "" {
+		log.Warn("Ignoring invalid HTTPS_PROXY %q.", v)
+		return nil
+	}
+	return u
+}
+
+// configureHTTPClient returns a copy of client using the given proxy and TLS
+// configuration, when not nil. The client is returned unchanged if its
+// transport is not an *http.Transport.
+func configureHTTPClient(client *http.Client, proxyURL *url.URL, tlsConfig *tls.Config) *http.Client {
+	rt := client.Transport
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	t, ok := rt.(*http.Transport)
+	if !ok {
+		log.Warn("Unable to configure the proxy and TLS settings of the HTTP client: its transport is a %T, not an *http.Transport.", rt)
+		return client
+	}
+	// we clone the transport and the client so as not to modify the default
+	// ones or the ones provided by the user.
+	t = t.Clone()
+	if proxyURL != nil {
+		t.Proxy = http.ProxyURL(proxyURL)
+	}
+	if tlsConfig != nil {
+		t.TLSClientConfig = tlsConfig
+	}
+	c := *client
+	c.Transport = t
+	return &c
+}
+
 // defaultDogstatsdAddr returns the default connection address for Dogstatsd.
 func defaultDogstatsdAddr() string {
 	envHost, envPort := os.Getenv("DD_AGENT_HOST"), os.Getenv("DD_DOGSTATSD_PORT")
@@ -389,6 +548,14 @@ type agentFeatures struct {
 	// If it's the default, it will be 0, which means 8125.
 	StatsdPort int
 
+	// V05 reports whether the agent can receive traces in the v0.5 format on
+	// the /v0.5/traces endpoint.
+	V05 bool
+
+	// DataStreams reports whether the agent can receive Data Streams Monitoring
+	// stats on the /v0.1/pipeline_stats endpoint.
+	DataStreams bool
+
 	// featureFlags specifies all the feature flags reported by the trace-agent.
 	featureFlags map[string]struct{}
 }
@@ -399,24 +566,45 @@ func (a *agentFeatures) HasFlag(feat string) bool {
 	return ok
 }
 
+// agentFeaturesTTL specifies the duration after which the agent features are
+// queried again.
+const agentFeaturesTTL = 5 * time.Minute
+
 // loadAgentFeatures queries the trace-agent for its capabilities and updates
 // the tracer's behaviour.
 func (c *config) loadAgentFeatures() {
 	c.agent = agentFeatures{}
+	if !c.logToStdout {
+		// when there is no agent, all features are off
+		if feats, err := c.fetchAgentFeatures(); err != nil {
+			log.Error("Loading features: %v", err)
+		} else {
+			c.agent = feats
+		}
+	}
+	c.agentCache = newAgentFeaturesCache(c.agent, agentFeaturesTTL, c.fetchAgentFeatures)
+}
+
+// fetchAgentFeatures queries the /info endpoint of the trace-agent for its
+// capabilities.
+func (c *config) fetchAgentFeatures() (agentFeatures, error) {
+	var feats agentFeatures
 	if c.logToStdout {
-		// there is no agent; all features off
-		return
+		return feats, nil
+	}
+	if _, ok := c.transport.(*TestTransport); ok {
+		// no agent is expected along the test transport
+		return feats, nil
 	}
 	resp, err := c.httpClient.Get(fmt.Sprintf("%s/info", c.agentURL))
 	if err != nil {
-		log.Error("Loading features: %v", err)
-		return
+		return feats, err
 	}
+	defer resp.Body.Close()
 	if resp.StatusCode == http.StatusNotFound {
 		// agent is older than 7.28.0, features not discoverable
-		return
+		return feats, nil
 	}
-	defer resp.Body.Close()
 	type infoResponse struct {
 		Endpoints     []string `json:"endpoints"`
 		ClientDropP0s bool     `json:"client_drop_p0s"`
@@ -425,29 +613,105 @@ func (c *config) loadAgentFeatures() {
 	}
 	var info infoResponse
 	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
-		log.Error("Decoding features: %v", err)
-		return
+		return feats, fmt.Errorf("decoding features: %v", err)
 	}
-	c.agent.DropP0s = info.ClientDropP0s
-	c.agent.StatsdPort = info.StatsdPort
+	feats.DropP0s = info.ClientDropP0s
+	feats.StatsdPort = info.StatsdPort
 	for _, endpoint := range info.Endpoints {
 		switch endpoint {
 		case "/v0.6/stats":
-			c.agent.Stats = true
+			feats.Stats = true
+		case "/v0.5/traces":
+			feats.V05 = true
+		case "/v0.1/pipeline_stats":
+			feats.DataStreams = true
 		}
 	}
-	c.agent.featureFlags = make(map[string]struct{}, len(info.FeatureFlags))
+	feats.featureFlags = make(map[string]struct{}, len(info.FeatureFlags))
 	for _, flag := range info.FeatureFlags {
-		c.agent.featureFlags[flag] = struct{}{}
+		feats.featureFlags[flag] = struct{}{}
+	}
+	return feats, nil
+}
+
+// agentFeaturesCache holds the capabilities of the agent and queries them
+// again once they are older than its TTL.
+type agentFeaturesCache struct {
+	mu         sync.Mutex
+	features   agentFeatures
+	updated    time.Time
+	ttl        time.Duration
+	refreshing bool
+	fetch      func() (agentFeatures, error)
+}
+
+func newAgentFeaturesCache(feats agentFeatures, ttl time.Duration, fetch func() (agentFeatures, error)) *agentFeaturesCache {
+	return &agentFeaturesCache{
+		features: feats,
+		updated:  time.Now(),
+		ttl:      ttl,
+		fetch:    fetch,
+	}
+}
+
+// current returns the capabilities of the agent, without querying them again
+// if they expired.
+func (a *agentFeaturesCache) current() agentFeatures {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.features
+}
+
+// get returns the capabilities of the agent, querying them first if they
+// expired. While they are being queried, concurrent callers get the expired
+// ones. If querying them fails, the expired ones are kept until the next TTL.
+func (a *agentFeaturesCache) get() agentFeatures {
+	a.mu.Lock()
+	if a.refreshing || time.Since(a.updated) < a.ttl {
+		defer a.mu.Unlock()
+		return a.features
+	}
+	a.refreshing = true
+	a.mu.Unlock()
+
+	feats, err := a.fetch()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.refreshing = false
+	a.updated = time.Now()
+	if err != nil {
+		log.Warn("Refreshing agent features: %v", err)
+	} else {
+		a.features = feats
 	}
+	return a.features
+}
+
+// features returns the current capabilities of the agent, querying them first
+// if they expired.
+func (c *config) features() agentFeatures {
+	if c.agentCache == nil {
+		return c.agent
+	}
+	return c.agentCache.get()
+}
+
+// currentFeatures returns the current capabilities of the agent, without
+// querying them again if they expired.
+func (c *config) currentFeatures() agentFeatures {
+	if c.agentCache == nil {
+		return c.agent
+	}
+	return c.agentCache.current()
 }
 
 func (c *config) canComputeStats() bool {
-	return c.agent.Stats && c.HasFeature("discovery")
+	return c.currentFeatures().Stats && c.HasFeature("discovery")
 }
 
 func (c *config) canDropP0s() bool {
-	return c.canComputeStats() && c.agent.DropP0s
+	return c.canComputeStats() && c.currentFeatures().DropP0s
 }
 
 func statsTags(c *config) []string {
@@ -536,6 +800,17 @@ func WithLambdaMode(enabled bool) StartOption {
 	}
 }
 
+// WithSyncFlush makes the tracer send the finished traces synchronously, when
+// their local root span finishes or when Flush is called, instead of on a
+// background timer. Finishing a root span then blocks until its trace is sent.
+// It is meant for serverless functions, whose runtime may be frozen between
+// invocations, losing the traces still buffered.
+func WithSyncFlush() StartOption {
+	return func(c *config) {
+		c.syncFlush = true
+	}
+}
+
 // WithPropagator sets an alternative propagator to be used by the tracer.
 func WithPropagator(p Propagator) StartOption {
 	return func(c *config) {
@@ -567,10 +842,17 @@ func WithService(name string) StartOption {
 }
 
 // WithAgentAddr sets the address where the agent is located. The default is
-// localhost:8126. It should contain both host and port.
+// localhost:8126, unless the agent UDS socket /var/run/datadog/apm.socket
+// exists, in which case it is used instead. It should contain both host and
+// port.
 func WithAgentAddr(addr string) StartOption {
 	return func(c *config) {
 		c.agentURL = "http://" + addr
+		if c.agentSocketDetected {
+			// the explicit address takes precedence over the default socket
+			c.httpClient = defaultClient
+			c.agentSocketDetected = false
+		}
 	}
 }
 
@@ -594,7 +876,8 @@ func WithServiceMapping(from, to string) StartOption {
 }
 
 // WithGlobalTag sets a key/value pair which will be set as a tag on all spans
-// created by tracer. This option may be used multiple times.
+// created by tracer. This option may be used multiple times. The tags given
+// when starting a span take precedence over the global tags.
 func WithGlobalTag(k string, v interface{}) StartOption {
 	return func(c *config) {
 		if c.globalTags == nil {
@@ -604,6 +887,104 @@ func WithGlobalTag(k string, v interface{}) StartOption {
 	}
 }
 
+// WithTagFilter sets a function applied to the tags of every span when it
+// finishes, before it is encoded and sent to the agent, in order to remove or
+// rewrite the tags which must not leave the process, such as the ones holding
+// sensitive data. The function is called with the key and the value of each
+// tag, and returns the value to set the tag to along with true, or false to
+// remove the tag. String values are set as string tags, numeric ones as
+// numeric tags, and the others are formatted as strings. The internal tags of
+// the tracer, prefixed with an underscore, and the span name, service, resource
+// and type are not filtered. The function is applied before the truncation of
+// WithMaxTagValueLength and must be safe for concurrent use.
+func WithTagFilter(f func(key string, value interface{}) (interface{}, bool)) StartOption {
+	return func(c *config) {
+		c.tagFilter = f
+	}
+}
+
+// WithObfuscation enables the obfuscation of the sensitive data held by the
+// spans, such as the literals of the SQL and CQL queries or the query strings
+// of the URLs, as configured by cfg. It is applied when the spans finish, after
+// the filter set with WithTagFilter, so that the data never leaves the process.
+func WithObfuscation(cfg ObfuscationConfig) StartOption {
+	return func(c *config) {
+		c.obfuscator = newObfuscator(cfg)
+	}
+}
+
+// WithMaxTagValueLength truncates the string tag values and the resource names
+// of the spans to n runes, followed by an ellipsis, when the spans finish. It
+// protects against large values, such as queries or message payloads, which
+// bloat the payloads and can exceed the agent limits. The tags internal to the
+// tracer, prefixed with "_dd.", are never truncated. A value of 0 or less, the
+// default, disables the truncation.
+func WithMaxTagValueLength(n int) StartOption {
+	return func(c *config) {
+		c.maxTagValueLength = n
+	}
+}
+
+// WithPartialFlushing enables the partial flushing of the traces: once numSpans
+// spans of a trace have finished, they are sent to the agent without waiting for
+// the rest of the trace, which the agent then reassembles. It bounds the memory
+// held by long-running traces, such as the ones of a streaming gRPC handler or
+// of a Kafka consume loop. It can also be enabled with the environment variables
+// DD_TRACE_PARTIAL_FLUSH_ENABLED and DD_TRACE_PARTIAL_FLUSH_MIN_SPANS,
+// which defaults to 1000 spans. A value of numSpans of 0 or less disables it.
+func WithPartialFlushing(numSpans int) StartOption {
+	return func(c *config) {
+		if numSpans <= 0 {
+			log.Warn("Invalid value %d for the partial flushing minimum number of spans, disabling partial flushing.", numSpans)
+			c.partialFlushMinSpans = 0
+			return
+		}
+		c.partialFlushMinSpans = numSpans
+	}
+}
+
+// WithTraceEncoder sets the format of the traces sent to the agent to the one
+// of the encoder registered under the given name, such as "v0.4" or "v0.5",
+// instead of the most efficient format supported by the agent, as reported by
+// its /info endpoint. Unknown names are ignored.
+func WithTraceEncoder(name string) StartOption {
+	return func(c *config) {
+		e, ok := lookupEncoder(name)
+		if !ok {
+			log.Warn("Unknown trace encoder %q, using the default one.", name)
+			return
+		}
+		c.encoder = e
+	}
+}
+
+// WithTraceBufferLimit sets the maximum number of finished traces buffered by
+// the tracer while waiting to be sent to the agent, which defaults to 1000. When
+// the agent is slow or unreachable and the buffer is full, the oldest traces are
+// dropped to make room for the new ones, and counted by the
+// datadog.tracer.traces_dropped metric with the reason:buffer_full tag.
+func WithTraceBufferLimit(n int) StartOption {
+	return func(c *config) {
+		if n <= 0 {
+			log.Warn("Invalid value %d for the trace buffer limit, using the default of %d.", n, payloadQueueSize)
+			c.traceBufferLimit = payloadQueueSize
+			return
+		}
+		c.traceBufferLimit = n
+	}
+}
+
+// WithMonotonicDurations computes the durations of the spans from a monotonic
+// clock, while still reporting their wall-clock start times. It prevents the
+// zero or wrong durations caused by the wall clock being adjusted, such as by
+// NTP, while the spans are running. It doesn't apply to the spans started or
+// finished with an explicit time.
+func WithMonotonicDurations() StartOption {
+	return func(c *config) {
+		c.monotonicDurations = true
+	}
+}
+
 // WithSampler sets the given sampler to be used with the tracer. By default
 // an all-permissive sampler is used.
 func WithSampler(s Sampler) StartOption {
@@ -625,6 +1006,7 @@ func WithHTTPRoundTripper(r http.RoundTripper) StartOption {
 func WithHTTPClient(client *http.Client) StartOption {
 	return func(c *config) {
 		c.httpClient = client
+		c.agentSocketDetected = false
 	}
 }
 
@@ -633,6 +1015,32 @@ func WithUDS(socketPath string) StartOption {
 	return WithHTTPClient(udsClient(socketPath))
 }
 
+// WithProxy configures the HTTP client to send traces to the agent through the
+// HTTP proxy at the given URL, such as "http://proxy.example.com:3128". When
+// not set and the agent is served over HTTPS, the proxy set in the HTTPS_PROXY
+// environment variable is used. It has no effect when the HTTP client set
+// using WithHTTPClient has a transport other than *http.Transport.
+func WithProxy(proxyURL string) StartOption {
+	return func(c *config) {
+		u, err := url.Parse(proxyURL)
+		if err != nil || u.Host == "" {
+			log.Warn("Ignoring invalid proxy URL %q.", proxyURL)
+			return
+		}
+		c.proxyURL = u
+	}
+}
+
+// WithTLSConfig sets the TLS configuration used by the HTTP client to connect
+// to an agent served over HTTPS, for example to trust a custom CA bundle. It has
+// no effect when the HTTP client set using WithHTTPClient has a transport other
+// than *http.Transport.
+func WithTLSConfig(cfg *tls.Config) StartOption {
+	return func(c *config) {
+		c.tlsConfig = cfg
+	}
+}
+
 // WithAnalytics allows specifying whether Trace Search & Analytics should be enabled
 // for integrations.
 func WithAnalytics(on bool) StartOption {
@@ -678,7 +1086,11 @@ func WithDogstatsdAddress(addr string) StartOption {
 }
 
 // WithSamplingRules specifies the sampling rates to apply to spans based on the
-// provided rules.
+// provided rules. Trace sampling rules are evaluated in order and the first
+// matching rule applies. When none matches, the rate set in DD_TRACE_SAMPLE_RATE
+// applies if any, otherwise the agent's rates do. Rules matching on span tags,
+// such as the ones created using TagsRule, are evaluated again when the root
+// span finishes, so that they apply to tags set after the span started.
 func WithSamplingRules(rules []SamplingRule) StartOption {
 	return func(cfg *config) {
 		for _, rule := range rules {
@@ -757,6 +1169,149 @@ func WithProfilerEndpoints(enabled bool) StartOption {
 	}
 }
 
+// WithTraceID128Bit enables the generation of 128-bit trace IDs for new traces.
+// The lower-order 64 bits remain the trace ID reported to the agent, while the
+// higher-order 64 bits are propagated downstream: in full in the B3 trace ID
+// header, and through the _dd.p.tid tag of the x-datadog-tags header. The
+// enabled value defaults to the value of the
+// DD_TRACE_128_BIT_TRACEID_GENERATION_ENABLED env variable or false.
+func WithTraceID128Bit(enabled bool) StartOption {
+	return func(c *config) {
+		c.traceID128Bit = enabled
+	}
+}
+
+// WithPayloadCompression enables the gzip compression of the trace payloads sent
+// to the agent, which reduces the egress traffic at the expense of some CPU time.
+// The agent does not report whether it accepts compressed payloads, so it must
+// only be enabled when the payloads are sent to an agent or a proxy decoding
+// the requests with a "gzip" Content-Encoding.
+func WithPayloadCompression(enabled bool) StartOption {
+	return func(c *config) {
+		c.payloadCompression = enabled
+	}
+}
+
+// WithSendRetries sets the number of times sending a trace payload to the agent
+// is retried after failing on a transient error: when the agent can't be reached,
+// times out, or responds with a 429, 502, 503 or 504 status code. The retries are
+// spaced using an exponential backoff starting at the given base interval, up to
+// 30 seconds, with some random jitter, unless the agent requests a given delay
+// using a Retry-After header. By default, failed payloads are not retried.
+func WithSendRetries(n int, base time.Duration) StartOption {
+	return func(c *config) {
+		if n < 0 {
+			n = 0
+		}
+		c.sendRetries = n
+		c.retryInterval = base
+	}
+}
+
+// WithSendObserver sets a function called with the statistics of each trace
+// payload sent to the agent, such as its size, number of traces and the status
+// code of the agent response, in order to monitor the health of the tracer. It
+// is called from the goroutine sending the payload, once the send and its
+// retries are done, and must not block. It has no effect when a custom
+// transport is used.
+func WithSendObserver(f func(SendStats)) StartOption {
+	return func(c *config) {
+		c.sendObserver = f
+	}
+}
+
+// WithMaxPayloadSize sets the maximum size in bytes of the trace payloads sent
+// to the agent. The traces are split into as many payloads as needed to stay
+// below it, without splitting any trace: a single trace larger than size is
+// sent on its own. Sizes above the maximum payload size accepted by the agent
+// are lowered to it. The default is 4.75MB.
+func WithMaxPayloadSize(size int) StartOption {
+	return func(c *config) {
+		switch {
+		case size <= 0:
+			log.Warn("Ignoring invalid maximum payload size %d.", size)
+		case size > payloadMaxLimit:
+			c.maxPayloadSize = payloadMaxLimit
+		default:
+			c.maxPayloadSize = size
+		}
+	}
+}
+
+// WithRandSource sets the source of random numbers used to generate trace and
+// span IDs. It is meant for tests requiring stable IDs across runs, such as
+// snapshot tests of propagation headers, and should not be used in production
+// as it increases the likelihood of ID collisions. Note that span IDs are also
+// derived from the span start time, which then needs to be pinned using the
+// StartTime option. By default, a source seeded using crypto/rand is used.
+func WithRandSource(source rand.Source) StartOption {
+	return func(c *config) {
+		c.randSource = source
+	}
+}
+
+// WithAppSecWAFTimeout sets the maximum execution time of the AppSec WAF for
+// every request, which is useful to bound the latency added by AppSec to
+// latency-sensitive services. It defaults to 4ms. The DD_APPSEC_WAF_TIMEOUT
+// env variable takes precedence over this option when both are set. It has no
+// effect when AppSec is disabled.
+func WithAppSecWAFTimeout(timeout time.Duration) StartOption {
+	return func(c *config) {
+		c.appsecStartOptions = append(c.appsecStartOptions, appsec.WithWAFTimeout(timeout))
+	}
+}
+
+// WithAppSecRateLimit sets the maximum number of AppSec security events
+// reported per second. It defaults to 100. The DD_APPSEC_TRACE_RATE_LIMIT env
+// variable takes precedence over this option when both are set. It has no
+// effect when AppSec is disabled.
+func WithAppSecRateLimit(rate int) StartOption {
+	return func(c *config) {
+		c.appsecStartOptions = append(c.appsecStartOptions, appsec.WithRateLimit(rate))
+	}
+}
+
+// WithAppSecObfuscatorKeyRegex sets the regular expression used by AppSec to
+// redact the values of the request parameters whose key matches it from the
+// security events. It defaults to the standard Datadog pattern, which matches
+// keys such as passwords, tokens and API keys. An invalid regular expression
+// is reported and prevents AppSec from starting. The
+// DD_APPSEC_OBFUSCATION_PARAMETER_KEY_REGEXP env variable takes precedence over
+// this option when both are set. It has no effect when AppSec is disabled.
+func WithAppSecObfuscatorKeyRegex(regex string) StartOption {
+	opt := appsec.WithObfuscatorKeyRegex(regex)
+	return func(c *config) {
+		c.appsecStartOptions = append(c.appsecStartOptions, opt)
+	}
+}
+
+// WithAppSecObfuscatorValueRegex sets the regular expression used by AppSec to
+// redact the request parameter values matching it from the security events.
+// It defaults to the standard Datadog pattern, which matches values such as
+// credentials, bearer tokens and private keys. An invalid regular expression
+// is reported and prevents AppSec from starting. The
+// DD_APPSEC_OBFUSCATION_PARAMETER_VALUE_REGEXP env variable takes precedence
+// over this option when both are set. It has no effect when AppSec is disabled.
+func WithAppSecObfuscatorValueRegex(regex string) StartOption {
+	opt := appsec.WithObfuscatorValueRegex(regex)
+	return func(c *config) {
+		c.appsecStartOptions = append(c.appsecStartOptions, opt)
+	}
+}
+
+// WithAppSecGRPCMetadataKeys sets the gRPC metadata keys whose values AppSec
+// monitors under their own rule address `grpc.server.request.metadata.<key>`,
+// so that security rules can target them individually. The whole metadata is
+// still monitored under the `grpc.server.request.metadata` address. The
+// DD_APPSEC_GRPC_METADATA_KEYS env variable, a comma-separated list of keys,
+// takes precedence over this option when both are set. It has no effect when
+// AppSec is disabled.
+func WithAppSecGRPCMetadataKeys(keys ...string) StartOption {
+	return func(c *config) {
+		c.appsecStartOptions = append(c.appsecStartOptions, appsec.WithGRPCMetadataKeys(keys...))
+	}
+}
+
 // StartSpanOption is a configuration option for StartSpan. It is aliased in order
 // to help godoc group all the functions returning it together. It is considered
 // more correct to refer to it as the type as the origin, ddtrace.StartSpanOption.
@@ -772,6 +1327,19 @@ func Tag(k string, v interface{}) StartSpanOption {
 	}
 }
 
+// WithLink links the started span to the given span context, which it is
+// causally related to without being its child, such as when a single operation
+// processes a batch of messages coming from other traces. It may be used
+// multiple times to add several links.
+func WithLink(ctx ddtrace.SpanContext) StartSpanOption {
+	return func(cfg *ddtrace.StartSpanConfig) {
+		if ctx == nil {
+			return
+		}
+		cfg.Links = append(cfg.Links, ctx)
+	}
+}
+
 // ServiceName sets the given service name on the started span. For example "http.server".
 func ServiceName(name string) StartSpanOption {
 	return Tag(ext.ServiceName, name)
@@ -16,6 +16,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -203,10 +204,36 @@ func TestLoadAgentFeatures(t *testing.T) {
 			"b": struct{}{},
 		})
 		assert.True(t, cfg.agent.Stats)
+		assert.False(t, cfg.agent.V05)
+		assert.False(t, cfg.agent.DataStreams)
 		assert.True(t, cfg.agent.HasFlag("a"))
 		assert.True(t, cfg.agent.HasFlag("b"))
 	})
 
+	t.Run("endpoints", func(t *testing.T) {
+		for _, tt := range []struct {
+			endpoints string
+			want      agentFeatures
+		}{
+			{`[]`, agentFeatures{}},
+			{`["/v0.4/traces","/v0.5/traces"]`, agentFeatures{V05: true}},
+			{`["/v0.6/stats","/v0.1/pipeline_stats"]`, agentFeatures{Stats: true, DataStreams: true}},
+			{`["/v0.5/traces","/v0.6/stats","/v0.1/pipeline_stats"]`, agentFeatures{V05: true, Stats: true, DataStreams: true}},
+		} {
+			t.Run(tt.endpoints, func(t *testing.T) {
+				srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+					w.Write([]byte(`{"endpoints":` + tt.endpoints + `}`))
+				}))
+				defer srv.Close()
+				cfg := newConfig(WithAgentAddr(strings.TrimPrefix(srv.URL, "http://")))
+				assert.Equal(t, tt.want.V05, cfg.agent.V05)
+				assert.Equal(t, tt.want.Stats, cfg.agent.Stats)
+				assert.Equal(t, tt.want.DataStreams, cfg.agent.DataStreams)
+				assert.Equal(t, cfg.agent, cfg.features())
+			})
+		}
+	})
+
 	t.Run("discovery", func(t *testing.T) {
 		defer func(old string) { os.Setenv("DD_TRACE_FEATURES", old) }(os.Getenv("DD_TRACE_FEATURES"))
 		os.Setenv("DD_TRACE_FEATURES", "discovery")
@@ -221,6 +248,57 @@ func TestLoadAgentFeatures(t *testing.T) {
 	})
 }
 
+func TestAgentFeaturesCache(t *testing.T) {
+	var (
+		mu        sync.Mutex
+		endpoints = `["/v0.4/traces"]`
+		status    = http.StatusOK
+		hits      int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		hits++
+		w.WriteHeader(status)
+		w.Write([]byte(`{"endpoints":` + endpoints + `}`))
+	}))
+	defer srv.Close()
+	setResponse := func(code int, eps string) {
+		mu.Lock()
+		defer mu.Unlock()
+		status, endpoints = code, eps
+	}
+	getHits := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return hits
+	}
+
+	cfg := newConfig(WithAgentAddr(strings.TrimPrefix(srv.URL, "http://")))
+	assert.False(t, cfg.features().V05)
+	assert.Equal(t, 1, getHits())
+
+	// the features are cached until they expire
+	setResponse(http.StatusOK, `["/v0.5/traces"]`)
+	assert.False(t, cfg.features().V05)
+	assert.Equal(t, 1, getHits())
+
+	cfg.agentCache.ttl = 0
+	assert.False(t, cfg.currentFeatures().V05)
+	assert.Equal(t, 1, getHits())
+	assert.True(t, cfg.features().V05)
+	assert.Equal(t, 2, getHits())
+
+	// the refreshed features are the ones used by the tracer
+	assert.True(t, cfg.currentFeatures().V05)
+	assert.Equal(t, 2, getHits())
+
+	// failing to refresh them keeps the previous ones
+	setResponse(http.StatusInternalServerError, `not json`)
+	assert.True(t, cfg.features().V05)
+	assert.Equal(t, 3, getHits())
+}
+
 func TestTracerOptionsDefaults(t *testing.T) {
 	t.Run("defaults", func(t *testing.T) {
 		assert := assert.New(t)
@@ -928,3 +1006,19 @@ func TestWithLogStartup(t *testing.T) {
 	WithLogStartup(true)(c)
 	assert.True(t, c.logStartup)
 }
+
+func TestWithTraceBufferLimit(t *testing.T) {
+	c := newConfig()
+	assert.Equal(t, payloadQueueSize, c.traceBufferLimit)
+	WithTraceBufferLimit(10)(c)
+	assert.Equal(t, 10, c.traceBufferLimit)
+	WithTraceBufferLimit(0)(c)
+	assert.Equal(t, payloadQueueSize, c.traceBufferLimit)
+}
+
+func TestWithSyncFlush(t *testing.T) {
+	c := newConfig()
+	assert.False(t, c.syncFlush)
+	WithSyncFlush()(c)
+	assert.True(t, c.syncFlush)
+}
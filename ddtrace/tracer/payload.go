@@ -8,8 +8,11 @@ package tracer
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
 	"io"
+	"strings"
 	"sync/atomic"
+	"unicode/utf8"
 
 	"github.com/tinylib/msgp/msgp"
 )
@@ -40,6 +43,31 @@ type payload struct {
 
 	// buf holds the sequence of msgpack-encoded items.
 	buf bytes.Buffer
+
+	// strings holds the string table of the payload when it is encoded in
+	// the v0.5 format, and is nil for the v0.4 format.
+	strings *stringTable
+
+	// prefix holds the encoded v0.5 payload array header and string table,
+	// which are read before the header once the payload starts being read.
+	prefix []byte
+
+	// enc, when set, is the encoder of the payload. The pushed traces are
+	// then held in traces until the payload is encoded, which happens once,
+	// when its content type is requested or when it starts being read.
+	enc encoder
+
+	// traces holds the traces pushed into a payload having an encoder.
+	traces [][]*span
+
+	// tracesSize is the estimated size of the traces held in traces.
+	tracesSize int
+
+	// encoded holds the remaining bytes of the encoded payload of an encoder.
+	encoded *bytes.Reader
+
+	// ctype holds the content type of the encoded payload of an encoder.
+	ctype string
 }
 
 var _ io.Reader = (*payload)(nil)
@@ -53,16 +81,115 @@ func newPayload() *payload {
 	return p
 }
 
-// push pushes a new item into the stream.
+// newPayloadV05 returns a ready to use payload encoded in the v0.5 format,
+// in which strings are deduplicated using a shared string table.
+func newPayloadV05() *payload {
+	p := newPayload()
+	p.strings = newStringTable()
+	return p
+}
+
+// newEncoderPayload returns a ready to use payload encoded by the encoder e.
+func newEncoderPayload(e encoder) *payload {
+	p := newPayload()
+	p.enc = e
+	return p
+}
+
+// push pushes a new item into the stream. The returned error, if any, is a
+// *pushError describing the trace which could not be encoded.
 func (p *payload) push(t spanList) error {
-	if err := msgp.Encode(&p.buf, t); err != nil {
-		return err
+	if p.enc != nil {
+		p.traces = append(p.traces, t)
+		p.tracesSize += t.Msgsize()
+		atomic.AddUint32(&p.count, 1)
+		return nil
+	}
+	var err error
+	if p.strings != nil {
+		encodeV05(&p.buf, t, p.strings)
+	} else {
+		err = msgp.Encode(&p.buf, t)
+	}
+	if err != nil {
+		return &pushError{index: p.itemCount(), spans: len(t), cause: err}
 	}
 	atomic.AddUint32(&p.count, 1)
 	p.updateHeader()
 	return nil
 }
 
+// pushError is returned when a trace cannot be pushed into a payload.
+type pushError struct {
+	index int   // index the trace would have had in the payload
+	spans int   // number of spans of the trace
+	cause error // encoding error
+}
+
+func (e *pushError) Error() string {
+	return fmt.Sprintf("cannot encode trace #%d of the payload (%d spans): %v", e.index, e.spans, e.cause)
+}
+
+func (e *pushError) Unwrap() error { return e.cause }
+
+// sanitizeUTF8 replaces the invalid UTF-8 sequences of the meta keys and values
+// of the spans of t, which the agent rejects, with the Unicode replacement
+// character. It returns the number of keys and values it sanitized, along with
+// the description of the first of them.
+func sanitizeUTF8(t spanList) (n int, first string) {
+	for i, s := range t {
+		for k, v := range s.Meta {
+			if utf8.ValidString(k) && utf8.ValidString(v) {
+				continue
+			}
+			if n == 0 {
+				first = fmt.Sprintf("span #%d (name %q, span id %d) has an invalid UTF-8 meta key or value: %q: %q", i, s.Name, s.SpanID, k, v)
+			}
+			n++
+			if !utf8.ValidString(k) {
+				delete(s.Meta, k)
+				k = strings.ToValidUTF8(k, "\uFFFD")
+			}
+			s.Meta[k] = strings.ToValidUTF8(v, "\uFFFD")
+		}
+	}
+	return n, first
+}
+
+// payloadMark records the state of a payload, so that the items pushed after
+// it was taken can be removed.
+type payloadMark struct {
+	len, count, strings, stringsSize, tracesSize int
+}
+
+// mark returns the current state of the payload.
+func (p *payload) mark() payloadMark {
+	m := payloadMark{len: p.buf.Len(), count: p.itemCount(), tracesSize: p.tracesSize}
+	if p.strings != nil {
+		m.strings, m.stringsSize = len(p.strings.strings), p.strings.size
+	}
+	return m
+}
+
+// rollback removes the items pushed since the mark m was taken. It must be
+// called before the payload starts being read.
+func (p *payload) rollback(m payloadMark) {
+	p.buf.Truncate(m.len)
+	if p.enc != nil {
+		p.traces = p.traces[:m.count]
+		p.tracesSize = m.tracesSize
+	}
+	atomic.StoreUint32(&p.count, uint32(m.count))
+	p.updateHeader()
+	if p.strings != nil {
+		for _, s := range p.strings.strings[m.strings:] {
+			delete(p.strings.index, s)
+		}
+		p.strings.strings = p.strings.strings[:m.strings]
+		p.strings.size = m.stringsSize
+	}
+}
+
 // itemCount returns the number of items available in the srteam.
 func (p *payload) itemCount() int {
 	return int(atomic.LoadUint32(&p.count))
@@ -71,7 +198,18 @@ func (p *payload) itemCount() int {
 // size returns the payload size in bytes. After the first read the value becomes
 // inaccurate by up to 8 bytes.
 func (p *payload) size() int {
-	return p.buf.Len() + len(p.header) - p.off
+	if p.enc != nil {
+		if p.encoded != nil {
+			return p.encoded.Len()
+		}
+		return p.tracesSize
+	}
+	n := p.buf.Len() + len(p.header) - p.off
+	if p.strings != nil && p.prefix == nil {
+		// the v0.5 array header and string table aren't encoded yet
+		n += 1 + p.strings.encodedSize()
+	}
+	return n + len(p.prefix)
 }
 
 // reset should *not* be used. It is not implemented and is only here to serve
@@ -118,17 +256,54 @@ func (p *payload) updateHeader() {
 	}
 }
 
+// contentType returns the content type of the payload, encoding it if it has
+// an encoder. It returns an empty string for the payloads streamed in the
+// msgpack format.
+func (p *payload) contentType() string {
+	if p.enc == nil {
+		return ""
+	}
+	p.encode()
+	return p.ctype
+}
+
+// encode encodes the traces of a payload having an encoder, if not done yet.
+func (p *payload) encode() {
+	if p.encoded != nil {
+		return
+	}
+	b, ctype := p.enc.Encode(p.traces)
+	p.encoded, p.ctype = bytes.NewReader(b), ctype
+	p.traces = nil
+}
+
 // Close implements io.Closer
 func (p *payload) Close() error {
 	// Once the payload has been read, clear the buffer for garbage collection to avoid
 	// a memory leak when references to this object may still be kept by faulty transport
 	// implementations or the standard library. See dd-trace-go#976
 	p.buf = bytes.Buffer{}
+	p.traces, p.encoded = nil, bytes.NewReader(nil)
 	return nil
 }
 
 // Read implements io.Reader. It reads from the msgpack-encoded stream.
 func (p *payload) Read(b []byte) (n int, err error) {
+	if p.enc != nil {
+		p.encode()
+		return p.encoded.Read(b)
+	}
+	if p.strings != nil {
+		if p.prefix == nil {
+			// [string table, traces]
+			p.prefix = p.strings.appendTo([]byte{msgpackArrayFix + 2})
+		}
+		if len(p.prefix) > 0 {
+			n = copy(b, p.prefix)
+			p.prefix = p.prefix[n:]
+			return n, nil
+		}
+	}
 	if p.off < len(p.header) {
 		// reading header
 		n = copy(b, p.header[p.off:])
@@ -7,6 +7,8 @@ package tracer
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"io"
 	"strconv"
 	"strings"
@@ -57,6 +59,38 @@ func TestPayloadIntegrity(t *testing.T) {
 	}
 }
 
+// TestPayloadPushError tests that the traces which cannot be encoded are
+// reported with enough context to find them.
+func TestPayloadPushError(t *testing.T) {
+	cause := errors.New("encoding failure")
+	var err error = &pushError{index: 1, spans: 3, cause: cause}
+	assert.Equal(t, "cannot encode trace #1 of the payload (3 spans): encoding failure", err.Error())
+	assert.True(t, errors.Is(err, cause))
+}
+
+// TestSanitizeUTF8 tests that the invalid UTF-8 meta keys and values, which
+// the agent rejects, are reported and sanitized instead of dropping the trace.
+func TestSanitizeUTF8(t *testing.T) {
+	assert := assert.New(t)
+	list := newSpanList(3)
+	n, _ := sanitizeUTF8(list)
+	assert.Zero(n)
+
+	list[1].Meta["http.url"] = "/path\xff\xfe"
+	list[2].Meta["key\xff"] = "value"
+	n, first := sanitizeUTF8(list)
+	assert.Equal(2, n)
+	assert.Contains(first, fmt.Sprintf(`span #1 (name "span.list.2", span id %d) has an invalid UTF-8 meta key or value: "http.url": "/path\xff\xfe"`, list[1].SpanID))
+	assert.Equal("/path\uFFFD", list[1].Meta["http.url"])
+	assert.Equal("value", list[2].Meta["key\uFFFD"])
+	assert.NotContains(list[2].Meta, "key\xff")
+
+	for _, p := range []*payload{newPayload(), newPayloadV05()} {
+		assert.NoError(p.push(list))
+		assert.Equal(1, p.itemCount())
+	}
+}
+
 // TestPayloadDecode ensures that whatever we push into the payload can
 // be decoded by the codec.
 func TestPayloadDecode(t *testing.T) {
@@ -108,3 +142,158 @@ func benchmarkPayloadThroughput(count int) func(*testing.B) {
 		}
 	}
 }
+
+// decodeV05 decodes a payload encoded in the v0.5 format.
+func decodeV05(b []byte) (spanLists, error) {
+	n, b, err := msgp.ReadArrayHeaderBytes(b)
+	if err != nil {
+		return nil, err
+	}
+	if n != 2 {
+		return nil, fmt.Errorf("unexpected payload array size %d", n)
+	}
+	n, b, err = msgp.ReadArrayHeaderBytes(b)
+	if err != nil {
+		return nil, err
+	}
+	table := make([]string, n)
+	for i := range table {
+		if table[i], b, err = msgp.ReadStringBytes(b); err != nil {
+			return nil, err
+		}
+	}
+	str := func() string {
+		var i uint32
+		if i, b, err = msgp.ReadUint32Bytes(b); err == nil && int(i) >= len(table) {
+			err = fmt.Errorf("string index %d out of range", i)
+		}
+		if err != nil {
+			return ""
+		}
+		return table[i]
+	}
+	ntraces, b, err := msgp.ReadArrayHeaderBytes(b)
+	if err != nil {
+		return nil, err
+	}
+	traces := make(spanLists, ntraces)
+	for i := range traces {
+		var nspans uint32
+		if nspans, b, err = msgp.ReadArrayHeaderBytes(b); err != nil {
+			return nil, err
+		}
+		traces[i] = make(spanList, nspans)
+		for j := range traces[i] {
+			if n, b, err = msgp.ReadArrayHeaderBytes(b); err != nil {
+				return nil, err
+			}
+			if n != 12 {
+				return nil, fmt.Errorf("unexpected span array size %d", n)
+			}
+			s := &span{Service: str(), Name: str(), Resource: str()}
+			if s.TraceID, b, err = msgp.ReadUint64Bytes(b); err != nil {
+				return nil, err
+			}
+			if s.SpanID, b, err = msgp.ReadUint64Bytes(b); err != nil {
+				return nil, err
+			}
+			if s.ParentID, b, err = msgp.ReadUint64Bytes(b); err != nil {
+				return nil, err
+			}
+			if s.Start, b, err = msgp.ReadInt64Bytes(b); err != nil {
+				return nil, err
+			}
+			if s.Duration, b, err = msgp.ReadInt64Bytes(b); err != nil {
+				return nil, err
+			}
+			if s.Error, b, err = msgp.ReadInt32Bytes(b); err != nil {
+				return nil, err
+			}
+			if n, b, err = msgp.ReadMapHeaderBytes(b); err != nil {
+				return nil, err
+			}
+			if n > 0 {
+				s.Meta = make(map[string]string, n)
+			}
+			for ; n > 0; n-- {
+				k := str()
+				s.Meta[k] = str()
+			}
+			if n, b, err = msgp.ReadMapHeaderBytes(b); err != nil {
+				return nil, err
+			}
+			if n > 0 {
+				s.Metrics = make(map[string]float64, n)
+			}
+			for ; n > 0; n-- {
+				k := str()
+				if s.Metrics[k], b, err = msgp.ReadFloat64Bytes(b); err != nil {
+					return nil, err
+				}
+			}
+			s.Type = str()
+			if err != nil {
+				return nil, err
+			}
+			traces[i][j] = s
+		}
+	}
+	if len(b) > 0 {
+		return nil, fmt.Errorf("%d trailing bytes", len(b))
+	}
+	return traces, nil
+}
+
+// TestPayloadV05 tests that the traces pushed into a v0.5 payload can be
+// decoded back, and that their strings are deduplicated.
+func TestPayloadV05(t *testing.T) {
+	for _, n := range []int{1, 10, 1 << 10} {
+		t.Run(strconv.Itoa(n), func(t *testing.T) {
+			assert := assert.New(t)
+			p := newPayloadV05()
+			lists := make(spanLists, n)
+			for i := 0; i < n; i++ {
+				list := newSpanList(i%5 + 1)
+				for j, s := range list {
+					s.Meta = map[string]string{"env": "prod", "index": strconv.Itoa(j)}
+					s.Metrics = map[string]float64{"_sampling_priority_v1": 1, "count": float64(i)}
+					s.Error = int32(i % 2)
+					s.Type = "web"
+				}
+				lists[i] = list
+				assert.NoError(p.push(list))
+			}
+			assert.Equal(n, p.itemCount())
+			size := p.size()
+
+			got, err := io.ReadAll(p)
+			assert.NoError(err)
+			assert.Equal(size, len(got))
+
+			traces, err := decodeV05(got)
+			assert.NoError(err)
+			assert.Len(traces, n)
+			for i, trace := range traces {
+				assert.Len(trace, len(lists[i]))
+				for j, s := range trace {
+					want := lists[i][j]
+					assert.Equal(want.Service, s.Service)
+					assert.Equal(want.Name, s.Name)
+					assert.Equal(want.Resource, s.Resource)
+					assert.Equal(want.Type, s.Type)
+					assert.Equal(want.TraceID, s.TraceID)
+					assert.Equal(want.SpanID, s.SpanID)
+					assert.Equal(want.ParentID, s.ParentID)
+					assert.Equal(want.Start, s.Start)
+					assert.Equal(want.Duration, s.Duration)
+					assert.Equal(want.Error, s.Error)
+					assert.Equal(want.Meta, s.Meta)
+					assert.Equal(want.Metrics, s.Metrics)
+				}
+			}
+			// each distinct string is only encoded once
+			assert.Len(p.strings.strings, len(p.strings.index))
+			assert.Less(len(p.strings.strings), 30)
+		})
+	}
+}
@@ -0,0 +1,155 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2022 Datadog, Inc.
+
+package tracer
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/tinylib/msgp/msgp"
+)
+
+// stringTable holds the strings of a v0.5 payload. In the v0.5 format, the
+// strings of the spans are replaced by their index in a table of strings
+// shared by all the traces of the payload, which is sent along with them:
+//
+//	[
+//		[string0, string1, ...],
+//		[trace0, trace1, ...]
+//	]
+//
+// Each span of a trace is then encoded as an array of 12 elements:
+//
+//	[service, name, resource, trace_id, span_id, parent_id, start, duration,
+//	 error, meta, metrics, type]
+//
+// where service, name, resource, type, and the keys and values of meta and
+// the keys of metrics are string table indexes.
+type stringTable struct {
+	index   map[string]uint32
+	strings []string
+	// size specifies the size of the encoded strings, in bytes.
+	size int
+}
+
+func newStringTable() *stringTable {
+	t := &stringTable{index: make(map[string]uint32)}
+	// the empty string is always the first entry of the table
+	t.add("")
+	return t
+}
+
+// add adds s to the table if it's not present yet, and returns its index.
+func (t *stringTable) add(s string) uint32 {
+	if i, ok := t.index[s]; ok {
+		return i
+	}
+	i := uint32(len(t.strings))
+	t.index[s] = i
+	t.strings = append(t.strings, s)
+	t.size += stringPrefixSize(len(s)) + len(s)
+	return i
+}
+
+// encodedSize returns the size of the encoded table, in bytes.
+func (t *stringTable) encodedSize() int {
+	return arrayHeaderSize(len(t.strings)) + t.size
+}
+
+// appendTo appends the msgpack-encoded table to b.
+func (t *stringTable) appendTo(b []byte) []byte {
+	b = msgp.AppendArrayHeader(b, uint32(len(t.strings)))
+	for _, s := range t.strings {
+		b = msgp.AppendString(b, s)
+	}
+	return b
+}
+
+// stringPrefixSize returns the size of the msgpack header of a string of
+// length n.
+func stringPrefixSize(n int) int {
+	switch {
+	case n < 32:
+		return 1
+	case n < 1<<8:
+		return 2
+	case n < 1<<16:
+		return 3
+	default:
+		return 5
+	}
+}
+
+// arrayHeaderSize returns the size of the msgpack header of an array of n
+// items.
+func arrayHeaderSize(n int) int {
+	switch {
+	case n <= 15:
+		return 1
+	case n < 1<<16:
+		return 3
+	default:
+		return 5
+	}
+}
+
+// encodeV05 appends the trace t encoded in the v0.5 format to buf, adding
+// its strings to the table st.
+func encodeV05(buf *bytes.Buffer, t spanList, st *stringTable) {
+	b := msgp.AppendArrayHeader(nil, uint32(len(t)))
+	for _, s := range t {
+		b = msgp.AppendArrayHeader(b, 12)
+		b = msgp.AppendUint32(b, st.add(s.Service))
+		b = msgp.AppendUint32(b, st.add(s.Name))
+		b = msgp.AppendUint32(b, st.add(s.Resource))
+		b = msgp.AppendUint64(b, s.TraceID)
+		b = msgp.AppendUint64(b, s.SpanID)
+		b = msgp.AppendUint64(b, s.ParentID)
+		b = msgp.AppendInt64(b, s.Start)
+		b = msgp.AppendInt64(b, s.Duration)
+		b = msgp.AppendInt32(b, s.Error)
+		// the v0.5 format has no field for the span links, which are sent
+		// in the keySpanLinks tag instead
+		var links string
+		if _, ok := s.Meta[keySpanLinks]; !ok && len(s.SpanLinks) > 0 {
+			links = spanLinksJSON(s.SpanLinks)
+		}
+		if links != "" {
+			b = msgp.AppendMapHeader(b, uint32(len(s.Meta)+1))
+			b = msgp.AppendUint32(b, st.add(keySpanLinks))
+			b = msgp.AppendUint32(b, st.add(links))
+		} else {
+			b = msgp.AppendMapHeader(b, uint32(len(s.Meta)))
+		}
+		for k, v := range s.Meta {
+			b = msgp.AppendUint32(b, st.add(k))
+			b = msgp.AppendUint32(b, st.add(v))
+		}
+		b = msgp.AppendMapHeader(b, uint32(len(s.Metrics)))
+		for k, v := range s.Metrics {
+			b = msgp.AppendUint32(b, st.add(k))
+			b = msgp.AppendFloat64(b, v)
+		}
+		b = msgp.AppendUint32(b, st.add(s.Type))
+	}
+	buf.Write(b)
+}
+
+// spanLinksJSON returns the JSON representation of the span links, with the
+// 128-bit trace IDs and the span IDs encoded as hex strings.
+func spanLinksJSON(links []spanLink) string {
+	var sb strings.Builder
+	sb.WriteByte('[')
+	for i, l := range links {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		fmt.Fprintf(&sb, `{"trace_id":"%016x%016x","span_id":"%016x"}`, l.TraceIDHigh, l.TraceID, l.SpanID)
+	}
+	sb.WriteByte(']')
+	return sb.String()
+}
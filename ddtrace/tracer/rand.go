@@ -16,10 +16,22 @@ import (
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/log"
 )
 
-// random holds a thread-safe source of random numbers.
-var random *rand.Rand
+var (
+	// defaultRandSource is the source of random numbers seeded using
+	// crypto/rand, once, when the package is initialized.
+	defaultRandSource = newRandSource()
 
-func init() {
+	// randSource is the thread-safe source of random. Its underlying source is
+	// replaced when starting a tracer configured using WithRandSource, and
+	// reset to defaultRandSource when starting a tracer without it.
+	randSource = &safeSource{source: defaultRandSource}
+
+	// random holds a thread-safe source of random numbers.
+	random = rand.New(randSource)
+)
+
+// newRandSource returns a new source of random numbers seeded using crypto/rand.
+func newRandSource() rand.Source {
 	var seed int64
 	n, err := cryptorand.Int(cryptorand.Reader, big.NewInt(math.MaxInt64))
 	if err == nil {
@@ -28,9 +40,7 @@ func init() {
 		log.Warn("cannot generate random seed: %v; using current time", err)
 		seed = time.Now().UnixNano()
 	}
-	random = rand.New(&safeSource{
-		source: rand.NewSource(seed),
-	})
+	return rand.NewSource(seed)
 }
 
 // safeSource holds a thread-safe implementation of rand.Source64.
@@ -54,3 +64,19 @@ func (rs *safeSource) Seed(seed int64) {
 	rs.source.Seed(seed)
 	rs.Unlock()
 }
+
+// setSource replaces the underlying source of random numbers.
+func (rs *safeSource) setSource(source rand.Source) {
+	rs.Lock()
+	rs.source = source
+	rs.Unlock()
+}
+
+// generateTraceIDUpper returns the higher-order 64 bits of a 128-bit trace ID.
+// They are made of the 32-bit unix timestamp in seconds of the given start time,
+// followed by 32 random bits. The lower-order 64 bits of the trace ID are the
+// root span ID, so that 128-bit trace IDs remain compatible with agents and
+// services only supporting 64-bit trace IDs.
+func generateTraceIDUpper(startTime int64) uint64 {
+	return uint64(startTime/int64(time.Second))<<32 | uint64(random.Uint32())
+}
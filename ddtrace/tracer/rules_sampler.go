@@ -46,6 +46,10 @@ func newRulesSampler(traceRules, spanRules []SamplingRule) *rulesSampler {
 
 func (r *rulesSampler) SampleTrace(s *span) bool { return r.traces.apply(s) }
 
+func (r *rulesSampler) SampleTraceOnFinish(s *span, allowed bool) bool {
+	return r.traces.applyTagsRules(s, allowed)
+}
+
 func (r *rulesSampler) SampleSpan(s *span) bool { return r.spans.apply(s) }
 
 func (r *rulesSampler) HasSpanRules() bool { return r.spans.enabled() }
@@ -53,7 +57,7 @@ func (r *rulesSampler) HasSpanRules() bool { return r.spans.enabled() }
 func (r *rulesSampler) TraceRateLimit() (float64, bool) { return r.traces.limit() }
 
 // SamplingRule is used for applying sampling rates to spans that match
-// the service name, operation name or both.
+// the service name, operation name, span tags or a combination of them.
 // For basic usage, consider using the helper functions ServiceRule, NameRule, etc.
 type SamplingRule struct {
 	// Service specifies the regex pattern that a span service name must match.
@@ -70,6 +74,12 @@ type SamplingRule struct {
 	// If not specified, the default is no limit.
 	MaxPerSecond float64
 
+	// Tags specifies the regex patterns that the values of the span tags of the
+	// given keys must match. A span lacking one of the tags doesn't match.
+	// As span tags are usually set after the span starts, trace sampling rules
+	// having tags are evaluated again when the root span of the trace finishes.
+	Tags map[string]*regexp.Regexp
+
 	ruleType     SamplingRuleType
 	exactService string
 	exactName    string
@@ -88,6 +98,19 @@ func (sr *SamplingRule) match(s *span) bool {
 	} else if sr.exactName != "" && sr.exactName != s.Name {
 		return false
 	}
+	for k, re := range sr.Tags {
+		v, ok := s.Meta[k]
+		if !ok {
+			m, ok := s.Metrics[k]
+			if !ok {
+				return false
+			}
+			v = strconv.FormatFloat(m, 'g', -1, 64)
+		}
+		if !re.MatchString(v) {
+			return false
+		}
+	}
 	return true
 }
 
@@ -154,6 +177,27 @@ func RateRule(rate float64) SamplingRule {
 	}
 }
 
+// TagsRule returns a SamplingRule that applies the provided sampling rate to
+// the traces whose root span matches the service and operation name glob
+// patterns provided, and whose tags of the given keys match the given glob
+// patterns. Empty service and operation name patterns match any value.
+// The rule is evaluated again when the root span finishes, so that it applies
+// to the tags set at any time during the span's lifetime.
+func TagsRule(service, name string, tags map[string]string, rate float64) SamplingRule {
+	rule := SamplingRule{
+		Service: globMatch(service),
+		Name:    globMatch(name),
+		Rate:    rate,
+	}
+	if len(tags) > 0 {
+		rule.Tags = make(map[string]*regexp.Regexp, len(tags))
+		for k, v := range tags {
+			rule.Tags[k] = globMatch(v)
+		}
+	}
+	return rule
+}
+
 // SpanNameServiceRule returns a SamplingRule of type SamplingRuleSpan that applies
 // the provided sampling rate to all spans matching the operation and service name glob patterns provided.
 // Operation and service fields must be valid glob patterns.
@@ -202,16 +246,24 @@ type traceRulesSampler struct {
 	rules      []SamplingRule // the rules to match spans with
 	globalRate float64        // a rate to apply when no rules match a span
 	limiter    *rateLimiter   // used to limit the volume of spans sampled
+	tagsRules  bool           // reports whether some rules match on span tags
 }
 
 // newTraceRulesSampler configures a *traceRulesSampler instance using the given set of rules.
 // Invalid rules or environment variable values are tolerated, by logging warnings and then ignoring them.
 func newTraceRulesSampler(rules []SamplingRule) *traceRulesSampler {
-	return &traceRulesSampler{
+	rs := &traceRulesSampler{
 		rules:      rules,
 		globalRate: globalSampleRate(),
 		limiter:    newRateLimiter(),
 	}
+	for _, rule := range rules {
+		if len(rule.Tags) > 0 {
+			rs.tagsRules = true
+			break
+		}
+	}
+	return rs
 }
 
 // globalSampleRate returns the sampling rate found in the DD_TRACE_SAMPLE_RATE environment variable.
@@ -267,6 +319,37 @@ func (rs *traceRulesSampler) apply(span *span) bool {
 	return true
 }
 
+// applyTagsRules applies the first rule matching the provided span when it
+// matches on span tags. It is meant to be called when the root span finishes,
+// so that the rules on tags set after the sampling decision was made at the
+// start of the trace still apply, with the same precedence. If the first
+// matching rule doesn't match on span tags, then it returns false and the span
+// is not modified, as the decision made at the start of the trace stands.
+// When allowed is true, the trace was already allowed by the rate limiter,
+// which isn't consulted again.
+func (rs *traceRulesSampler) applyTagsRules(span *span, allowed bool) bool {
+	if !rs.tagsRules {
+		return false
+	}
+	for _, rule := range rs.rules {
+		if rule.match(span) {
+			if len(rule.Tags) == 0 {
+				return false
+			}
+			if allowed {
+				span.SetTag(keyRulesSamplerAppliedRate, rule.Rate)
+				if !sampledByRate(span.TraceID, rule.Rate) {
+					span.setSamplingPriority(ext.PriorityUserReject, samplernames.RuleRate)
+				}
+				return true
+			}
+			rs.applyRule(span, rule.Rate, time.Now())
+			return true
+		}
+	}
+	return false
+}
+
 func (rs *traceRulesSampler) applyRule(span *span, rate float64, now time.Time) {
 	span.SetTag(keyRulesSamplerAppliedRate, rate)
 	if !sampledByRate(span.TraceID, rate) {
@@ -488,18 +571,33 @@ func samplingRulesFromEnv() (trace, span []SamplingRule, err error) {
 	return trace, span, err
 }
 
+// jsonRule is a sampling rule as found in the DD_TRACE_SAMPLING_RULES and
+// DD_SPAN_SAMPLING_RULES environment variables.
+type jsonRule struct {
+	Service      string            `json:"service"`
+	Name         string            `json:"name"`
+	Rate         json.Number       `json:"sample_rate"`
+	MaxPerSecond float64           `json:"max_per_second"`
+	Tags         map[string]string `json:"tags"`
+}
+
+// String returns the rule as reported by the parsing errors, its tags being
+// omitted when there are none.
+func (r jsonRule) String() string {
+	s := fmt.Sprintf("{Service:%s Name:%s Rate:%s MaxPerSecond:%v", r.Service, r.Name, r.Rate, r.MaxPerSecond)
+	if len(r.Tags) > 0 {
+		s += fmt.Sprintf(" Tags:%v", r.Tags)
+	}
+	return s + "}"
+}
+
 // unmarshalSamplingRules unmarshals JSON from b and returns the sampling rules found, attributing
 // the type t to them. If any errors are occurred, they are returned.
 func unmarshalSamplingRules(b []byte, spanType SamplingRuleType) ([]SamplingRule, error) {
 	if len(b) == 0 {
 		return nil, nil
 	}
-	var jsonRules []struct {
-		Service      string      `json:"service"`
-		Name         string      `json:"name"`
-		Rate         json.Number `json:"sample_rate"`
-		MaxPerSecond float64     `json:"max_per_second"`
-	}
+	var jsonRules []jsonRule
 	err := json.Unmarshal(b, &jsonRules)
 	if err != nil {
 		return nil, fmt.Errorf("error unmarshalling JSON: %v", err)
@@ -550,6 +648,8 @@ func unmarshalSamplingRules(b []byte, spanType SamplingRuleType) ([]SamplingRule
 			}
 
 			switch {
+			case len(v.Tags) > 0:
+				rules = append(rules, TagsRule(v.Service, v.Name, v.Tags, rate))
 			case v.Service != "" && v.Name != "":
 				rules = append(rules, NameServiceRule(v.Name, v.Service, rate))
 			case v.Service != "":
@@ -568,11 +668,12 @@ func unmarshalSamplingRules(b []byte, spanType SamplingRuleType) ([]SamplingRule
 // MarshalJSON implements the json.Marshaler interface.
 func (sr *SamplingRule) MarshalJSON() ([]byte, error) {
 	s := struct {
-		Service      string   `json:"service"`
-		Name         string   `json:"name"`
-		Rate         float64  `json:"sample_rate"`
-		Type         string   `json:"type"`
-		MaxPerSecond *float64 `json:"max_per_second,omitempty"`
+		Service      string            `json:"service"`
+		Name         string            `json:"name"`
+		Rate         float64           `json:"sample_rate"`
+		Type         string            `json:"type"`
+		MaxPerSecond *float64          `json:"max_per_second,omitempty"`
+		Tags         map[string]string `json:"tags,omitempty"`
 	}{}
 	if sr.exactService != "" {
 		s.Service = sr.exactService
@@ -589,5 +690,11 @@ func (sr *SamplingRule) MarshalJSON() ([]byte, error) {
 	if sr.MaxPerSecond != 0 {
 		s.MaxPerSecond = &sr.MaxPerSecond
 	}
+	if len(sr.Tags) > 0 {
+		s.Tags = make(map[string]string, len(sr.Tags))
+		for k, re := range sr.Tags {
+			s.Tags[k] = fmt.Sprintf("%s", re)
+		}
+	}
 	return json.Marshal(&s)
 }
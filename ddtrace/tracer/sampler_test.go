@@ -11,6 +11,7 @@ import (
 	"math"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 	"testing"
@@ -636,6 +637,130 @@ func TestRulesSampler(t *testing.T) {
 	})
 }
 
+func TestTagsRules(t *testing.T) {
+	makeSpan := func(op, svc string, tags map[string]string) *span {
+		s := newSpan(op, svc, "", random.Uint64(), random.Uint64(), 0)
+		for k, v := range tags {
+			s.SetTag(k, v)
+		}
+		return s
+	}
+	rules := []SamplingRule{
+		TagsRule("test-*", "", map[string]string{"tenant": "gold", ext.HTTPCode: "5??"}, 1.0),
+		TagsRule("", "http.*", map[string]string{"tenant": "gold"}, 0.0),
+		TagsRule("test-service", "", map[string]string{"retries": "3"}, 1.0),
+		ServiceRule("test-service", 0.0),
+	}
+
+	t.Run("match", func(t *testing.T) {
+		for _, tt := range []struct {
+			name string
+			svc  string
+			op   string
+			tags map[string]string
+			rate float64
+		}{
+			{name: "first-wins", svc: "test-service", op: "http.request", tags: map[string]string{"tenant": "gold", ext.HTTPCode: "503"}, rate: 1.0},
+			{name: "second", svc: "test-service", op: "http.request", tags: map[string]string{"tenant": "gold", ext.HTTPCode: "200"}, rate: 0.0},
+			{name: "other-service", svc: "other-service", op: "http.request", tags: map[string]string{"tenant": "gold", ext.HTTPCode: "503"}, rate: 0.0},
+			{name: "fallback", svc: "test-service", op: "http.request", tags: map[string]string{"tenant": "silver"}, rate: 0.0},
+		} {
+			t.Run(tt.name, func(t *testing.T) {
+				rs := newRulesSampler(rules, nil)
+				span := makeSpan(tt.op, tt.svc, tt.tags)
+				assert.True(t, rs.SampleTrace(span))
+				assert.Equal(t, tt.rate, span.Metrics[keyRulesSamplerAppliedRate])
+			})
+		}
+	})
+
+	t.Run("numeric-tag", func(t *testing.T) {
+		rs := newRulesSampler(rules[2:3], nil)
+		span := newSpan("http.request", "test-service", "", random.Uint64(), random.Uint64(), 0)
+		span.SetTag("retries", 3)
+		assert.True(t, rs.SampleTrace(span))
+		assert.Equal(t, 1.0, span.Metrics[keyRulesSamplerAppliedRate])
+	})
+
+	t.Run("no-match", func(t *testing.T) {
+		rs := newRulesSampler(rules[:3], nil)
+		span := makeSpan("grpc.request", "other-service", map[string]string{"tenant": "gold"})
+		assert.False(t, rs.SampleTrace(span))
+	})
+
+	t.Run("from-env", func(t *testing.T) {
+		os.Setenv("DD_TRACE_SAMPLING_RULES", `[{"service": "test-*", "tags": {"tenant": "gold"}, "sample_rate": 0.5}]`)
+		defer os.Unsetenv("DD_TRACE_SAMPLING_RULES")
+		rules, _, err := samplingRulesFromEnv()
+		assert.NoError(t, err)
+		assert.Len(t, rules, 1)
+		assert.True(t, rules[0].match(makeSpan("http.request", "test-service", map[string]string{"tenant": "gold"})))
+		assert.False(t, rules[0].match(makeSpan("http.request", "test-service", nil)))
+	})
+
+	t.Run("on-finish", func(t *testing.T) {
+		tracer, _, _, stop := startTestTracer(t, WithSamplingRules(rules))
+		defer stop()
+
+		// The tags are set after the sampling decision is made: the decision
+		// is made again when the root span finishes.
+		root := tracer.StartSpan("http.request", ServiceName("test-service")).(*span)
+		assert.Equal(t, float64(ext.PriorityUserReject), root.Metrics[keySamplingPriority])
+		child := tracer.StartSpan("db.query", ChildOf(root.Context())).(*span)
+		root.SetTag("tenant", "gold")
+		root.SetTag(ext.HTTPCode, "502")
+		child.Finish()
+		root.Finish()
+		assert.Equal(t, 1.0, root.Metrics[keyRulesSamplerAppliedRate])
+		assert.Equal(t, float64(ext.PriorityUserKeep), root.Metrics[keySamplingPriority])
+
+		// Without matching tags, the decision stands.
+		root = tracer.StartSpan("http.request", ServiceName("test-service")).(*span)
+		root.SetTag("tenant", "silver")
+		root.Finish()
+		assert.Equal(t, float64(ext.PriorityUserReject), root.Metrics[keySamplingPriority])
+
+		// Manual decisions are not overridden.
+		root = tracer.StartSpan("http.request", ServiceName("test-service")).(*span)
+		root.SetTag(ext.ManualDrop, true)
+		root.SetTag("tenant", "gold")
+		root.SetTag(ext.HTTPCode, "502")
+		root.Finish()
+		assert.Equal(t, float64(ext.PriorityUserReject), root.Metrics[keySamplingPriority])
+
+		// Without matching rules, the default sampler applies.
+		root = tracer.StartSpan("grpc.request", ServiceName("other-service")).(*span)
+		root.Finish()
+		assert.Equal(t, float64(ext.PriorityAutoKeep), root.Metrics[keySamplingPriority])
+
+		// Injected decisions are not overridden, as they were propagated.
+		root = tracer.StartSpan("http.request", ServiceName("test-service")).(*span)
+		carrier := TextMapCarrier{}
+		assert.NoError(t, tracer.Inject(root.Context(), carrier))
+		root.SetTag("tenant", "gold")
+		root.SetTag(ext.HTTPCode, "502")
+		root.Finish()
+		assert.Equal(t, float64(ext.PriorityUserReject), root.Metrics[keySamplingPriority])
+		assert.Equal(t, strconv.Itoa(ext.PriorityUserReject), carrier[DefaultPriorityHeader])
+	})
+
+	t.Run("on-finish-limiter", func(t *testing.T) {
+		tracer, _, _, stop := startTestTracer(t, WithSamplingRules(rules))
+		defer stop()
+
+		// The trace kept by the rule at its start is not counted twice by the
+		// rate limiter when the rule applies again on finish.
+		root := tracer.StartSpan("http.request", ServiceName("test-service"), Tag("tenant", "gold"), Tag(ext.HTTPCode, "502")).(*span)
+		assert.Equal(t, float64(ext.PriorityUserKeep), root.Metrics[keySamplingPriority])
+		root.Finish()
+		assert.Equal(t, float64(ext.PriorityUserKeep), root.Metrics[keySamplingPriority])
+		limiter := tracer.rulesSampling.traces.limiter
+		limiter.mu.Lock()
+		defer limiter.mu.Unlock()
+		assert.Equal(t, 1.0, limiter.seen)
+	})
+}
+
 func TestRulesSamplerConcurrency(t *testing.T) {
 	rules := []SamplingRule{
 		ServiceRule("test-service", 1.0),
@@ -933,17 +1058,17 @@ func TestSamplingRuleMarshall(t *testing.T) {
 		in  SamplingRule
 		out string
 	}{
-		{SamplingRule{nil, nil, 0, 0, 0, "srv", "ops", nil},
+		{SamplingRule{nil, nil, 0, 0, nil, 0, "srv", "ops", nil},
 			`{"service":"srv","name":"ops","sample_rate":0,"type":"trace(0)"}`},
-		{SamplingRule{regexp.MustCompile("srv.[0-9]+]"), nil, 0, 0, 0, "srv", "ops", nil},
+		{SamplingRule{regexp.MustCompile("srv.[0-9]+]"), nil, 0, 0, nil, 0, "srv", "ops", nil},
 			`{"service":"srv","name":"ops","sample_rate":0,"type":"trace(0)"}`},
-		{SamplingRule{regexp.MustCompile("srv.*"), regexp.MustCompile("ops.[0-9]+]"), 0, 0, 0, "", "", nil},
+		{SamplingRule{regexp.MustCompile("srv.*"), regexp.MustCompile("ops.[0-9]+]"), 0, 0, nil, 0, "", "", nil},
 			`{"service":"srv.*","name":"ops.[0-9]+]","sample_rate":0,"type":"trace(0)"}`},
-		{SamplingRule{regexp.MustCompile("srv.[0-9]+]"), regexp.MustCompile("ops.[0-9]+]"), 0.55, 0, 0, "", "", nil},
+		{SamplingRule{regexp.MustCompile("srv.[0-9]+]"), regexp.MustCompile("ops.[0-9]+]"), 0.55, 0, nil, 0, "", "", nil},
 			`{"service":"srv.[0-9]+]","name":"ops.[0-9]+]","sample_rate":0.55,"type":"trace(0)"}`},
-		{SamplingRule{regexp.MustCompile("srv.[0-9]+]"), regexp.MustCompile("ops.[0-9]+]"), 0.55, 0, 1, "", "", nil},
+		{SamplingRule{regexp.MustCompile("srv.[0-9]+]"), regexp.MustCompile("ops.[0-9]+]"), 0.55, 0, nil, 1, "", "", nil},
 			`{"service":"srv.[0-9]+]","name":"ops.[0-9]+]","sample_rate":0.55,"type":"span(1)"}`},
-		{SamplingRule{regexp.MustCompile("srv.[0-9]+]"), regexp.MustCompile("ops.[0-9]+]"), 0.55, 1000, 1, "", "", nil},
+		{SamplingRule{regexp.MustCompile("srv.[0-9]+]"), regexp.MustCompile("ops.[0-9]+]"), 0.55, 1000, nil, 1, "", "", nil},
 			`{"service":"srv.[0-9]+]","name":"ops.[0-9]+]","sample_rate":0.55,"type":"span(1)","max_per_second":1000}`},
 	} {
 		m, err := tt.in.MarshalJSON()
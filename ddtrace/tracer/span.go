@@ -75,6 +75,10 @@ type span struct {
 	ParentID uint64             `msg:"parent_id"`         // identifier of the span's direct parent
 	Error    int32              `msg:"error"`             // error status of the span; 0 means no errors
 
+	// SpanLinks holds the links to the spans this span is causally related to,
+	// without being their child.
+	SpanLinks []spanLink `msg:"span_links,omitempty"`
+
 	noDebugStack bool         `msg:"-"` // disables debug stack traces
 	finished     bool         `msg:"-"` // true if the span has been submitted to a tracer.
 	context      *spanContext `msg:"-"` // span propagation context
@@ -83,6 +87,10 @@ type span struct {
 	pprofCtxRestore context.Context `msg:"-"` // contains pprof.WithLabel labels of the parent span (if any) that need to be restored when this span finishes
 
 	taskEnd func() // ends execution tracer (runtime/trace) task, if started
+
+	// monotonicStart, when not zero, is the start time of the span carrying a
+	// monotonic clock reading, from which its duration is computed.
+	monotonicStart time.Time
 }
 
 // Context yields the SpanContext for this Span. Note that the return
@@ -209,6 +217,15 @@ func (s *span) SetUser(id string, opts ...UserMonitoringOption) {
 	}
 }
 
+// Root returns the root span of the trace the span belongs to, or the span
+// itself if the root is unknown, such as when the trace was started upstream.
+func (s *span) Root() Span {
+	if root := s.context.trace.root; root != nil {
+		return root
+	}
+	return s
+}
+
 // setSamplingPriorityLocked updates the sampling priority.
 // It also updates the trace's sampling priority.
 func (s *span) setSamplingPriorityLocked(priority int, sampler samplernames.SamplerName) {
@@ -379,7 +396,7 @@ func (s *span) setMetric(key string, v float64) {
 // Finish closes this Span (but not its children) providing the duration
 // of its part of the tracing session.
 func (s *span) Finish(opts ...ddtrace.FinishOption) {
-	t := now()
+	t := s.now()
 	if len(opts) > 0 {
 		cfg := ddtrace.FinishConfig{
 			NoDebugStack: s.noDebugStack,
@@ -403,6 +420,9 @@ func (s *span) Finish(opts ...ddtrace.FinishOption) {
 	if s.taskEnd != nil {
 		s.taskEnd()
 	}
+	if tr, ok := internal.GetGlobalTracer().(*tracer); ok {
+		tr.sampleOnFinish(s)
+	}
 	s.finish(t)
 
 	if s.pprofCtxRestore != nil {
@@ -410,6 +430,20 @@ func (s *span) Finish(opts ...ddtrace.FinishOption) {
 		// point are attributed correctly.
 		pprof.SetGoroutineLabels(s.pprofCtxRestore)
 	}
+	if tr, ok := internal.GetGlobalTracer().(*tracer); ok && tr.config.syncFlush && s.context.trace.root == s {
+		// send the trace before returning, as the runtime may freeze afterwards
+		tr.sendSync()
+	}
+}
+
+// now returns the current time to finish s at, in UNIX nanoseconds. When s has
+// a monotonic start time, it is measured from it, regardless of any adjustment
+// of the wall clock since s started.
+func (s *span) now() int64 {
+	if s.monotonicStart.IsZero() {
+		return now()
+	}
+	return s.Start + int64(monotonicNow().Sub(s.monotonicStart))
 }
 
 // SetOperationName sets or changes the operation name.
@@ -447,6 +481,15 @@ func (s *span) finish(finishTime int64) {
 	keep := true
 	if t, ok := internal.GetGlobalTracer().(*tracer); ok {
 		// we have an active tracer
+		if f := t.config.tagFilter; f != nil {
+			s.filterTags(f)
+		}
+		if o := t.config.obfuscator; o != nil {
+			o.obfuscate(s, t.obfuscator)
+		}
+		if n := t.config.maxTagValueLength; n > 0 {
+			s.truncateTags(n)
+		}
 		if t.config.canComputeStats() && shouldComputeStats(s) {
 			// the agent supports computed stats
 			select {
@@ -468,6 +511,89 @@ func (s *span) finish(finishTime int64) {
 	s.context.finish()
 }
 
+// tagValueEllipsis marks the tag values truncated by truncateTags.
+const tagValueEllipsis = "..."
+
+// truncateTags truncates the resource name and the string tag values of s to
+// n runes, except for the internal tags. It must be called with s locked.
+func (s *span) truncateTags(n int) {
+	s.Resource = truncateValue(s.Resource, n)
+	for k, v := range s.Meta {
+		if strings.HasPrefix(k, "_dd.") {
+			continue
+		}
+		s.Meta[k] = truncateValue(v, n)
+	}
+}
+
+// filterTags applies the tag filter f to the string and numeric tags of s,
+// except for the internal tags, prefixed with an underscore. The tags denied by
+// f are removed, and the others are set to the value returned by f. It must be
+// called with s locked.
+func (s *span) filterTags(f func(key string, value interface{}) (interface{}, bool)) {
+	type filteredTag struct {
+		key   string
+		value interface{}
+		keep  bool
+	}
+	// the filter is applied to every tag before setting any of them, as a
+	// rewritten tag can move from the string tags to the numeric ones
+	tags := make([]filteredTag, 0, len(s.Meta)+len(s.Metrics))
+	for k, v := range s.Meta {
+		if !strings.HasPrefix(k, "_") {
+			v, keep := f(k, v)
+			tags = append(tags, filteredTag{key: k, value: v, keep: keep})
+		}
+	}
+	for k, v := range s.Metrics {
+		if !strings.HasPrefix(k, "_") {
+			v, keep := f(k, v)
+			tags = append(tags, filteredTag{key: k, value: v, keep: keep})
+		}
+	}
+	for _, t := range tags {
+		s.setFilteredTag(t.key, t.value, t.keep)
+	}
+}
+
+// setFilteredTag sets the tag key to the value v returned by a tag filter, or
+// removes it when the filter denied it. It must be called with s locked.
+func (s *span) setFilteredTag(key string, v interface{}, keep bool) {
+	if !keep {
+		delete(s.Meta, key)
+		delete(s.Metrics, key)
+		return
+	}
+	switch v := v.(type) {
+	case string:
+		s.setMeta(key, v)
+	case bool:
+		s.setMeta(key, strconv.FormatBool(v))
+	default:
+		if f, ok := toFloat64(v); ok {
+			s.setMetric(key, f)
+		} else {
+			s.setMeta(key, fmt.Sprint(v))
+		}
+	}
+}
+
+// truncateValue truncates v to n runes, followed by tagValueEllipsis.
+func truncateValue(v string, n int) string {
+	if len(v) <= n {
+		// a string has at most as many runes as bytes
+		return v
+	}
+	var runes int
+	for i := range v {
+		if runes == n {
+			return v[:i] + tagValueEllipsis
+		}
+		runes++
+	}
+	return v
+}
+
 // newAggregableSpan creates a new summary for the span s, within an application
 // version version.
 func newAggregableSpan(s *span, obfuscator *obfuscate.Obfuscator) *aggregableSpan {
@@ -575,6 +701,26 @@ func (s *span) String() string {
 	return strings.Join(lines, "\n")
 }
 
+// spanLink is a link to another span, which a span is causally related to
+// without being its child, such as the span of a message processed in a batch.
+type spanLink struct {
+	TraceID     uint64 `msg:"trace_id"`      // lower 64 bits of the trace ID of the linked span
+	TraceIDHigh uint64 `msg:"trace_id_high"` // higher 64 bits of the trace ID of the linked span
+	SpanID      uint64 `msg:"span_id"`       // identifier of the linked span
+}
+
+// setLinks links s to the spans of the given span contexts. It must be called
+// with s locked, unless s is not shared yet.
+func (s *span) setLinks(links []ddtrace.SpanContext) {
+	for _, ctx := range links {
+		l := spanLink{TraceID: ctx.TraceID(), SpanID: ctx.SpanID()}
+		if c, ok := ctx.(*spanContext); ok {
+			l.TraceIDHigh = c.traceIDUpper
+		}
+		s.SpanLinks = append(s.SpanLinks, l)
+	}
+}
+
 // Format implements fmt.Formatter.
 func (s *span) Format(f fmt.State, c rune) {
 	switch c {
@@ -629,6 +775,11 @@ const (
 	keySingleSpanSamplingMPS = "_dd.span_sampling.max_per_second"
 	// keyPropagatedUserID holds the propagated user identifier, if user id propagation is enabled.
 	keyPropagatedUserID = "_dd.p.usr.id"
+	// keyTraceID128 holds the hex-encoded higher-order 64 bits of a 128-bit trace ID.
+	keyTraceID128 = "_dd.p.tid"
+	// keySpanLinks holds the JSON-encoded list of the span links, in the
+	// payload formats which have no field for them, such as v0.5.
+	keySpanLinks = "_dd.span_links"
 )
 
 // The following set of tags is used for user monitoring and set through calls to span.SetUser().
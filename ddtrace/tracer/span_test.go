@@ -8,6 +8,7 @@ package tracer
 import (
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"runtime"
 	"strings"
@@ -274,6 +275,18 @@ func (p *panicStringer) String() string {
 	panic("This should not be handled.")
 }
 
+func TestSpanRoot(t *testing.T) {
+	tracer := newTracer(withTransport(newDefaultTransport()))
+	defer tracer.Stop()
+
+	root := tracer.StartSpan("root").(*span)
+	child := tracer.StartSpan("child", ChildOf(root.Context())).(*span)
+	grandchild := tracer.StartSpan("grandchild", ChildOf(child.Context())).(*span)
+	assert.Equal(t, root, root.Root())
+	assert.Equal(t, root, child.Root())
+	assert.Equal(t, root, grandchild.Root())
+}
+
 func TestSpanSetTag(t *testing.T) {
 	assert := assert.New(t)
 
@@ -412,6 +425,174 @@ func TestSpanString(t *testing.T) {
 	assert.NotEqual("", span.String())
 }
 
+func TestSpanLinks(t *testing.T) {
+	tracer := newTracer(withTransport(newDefaultTransport()))
+	defer tracer.Stop()
+	link1 := tracer.StartSpan("batch.message").(*span)
+	link2 := tracer.StartSpan("batch.message").(*span)
+	link1.context.traceIDUpper = 0
+	link2.context.traceIDUpper = 0x1
+	s := tracer.StartSpan("batch.process", WithLink(link1.Context()), WithLink(nil), WithLink(link2.Context())).(*span)
+
+	want := []spanLink{
+		{TraceID: link1.TraceID, SpanID: link1.SpanID},
+		{TraceID: link2.TraceID, TraceIDHigh: 1, SpanID: link2.SpanID},
+	}
+	assert.Equal(t, want, s.SpanLinks)
+
+	t.Run("v0.4", func(t *testing.T) {
+		p, err := encode([][]*span{{s}})
+		assert.NoError(t, err)
+		traces, err := decode(p)
+		assert.NoError(t, err)
+		assert.Len(t, traces, 1)
+		assert.Len(t, traces[0], 1)
+		assert.Equal(t, want, traces[0][0].SpanLinks)
+		assert.NotContains(t, traces[0][0].Meta, keySpanLinks)
+	})
+
+	t.Run("v0.5", func(t *testing.T) {
+		p := newPayloadV05()
+		assert.NoError(t, p.push(spanList{s}))
+		b, err := io.ReadAll(p)
+		assert.NoError(t, err)
+		traces, err := decodeV05(b)
+		assert.NoError(t, err)
+		assert.Len(t, traces, 1)
+		assert.Len(t, traces[0], 1)
+		assert.Equal(t, fmt.Sprintf(`[{"trace_id":"%032x","span_id":"%016x"},{"trace_id":"%016x%016x","span_id":"%016x"}]`,
+			link1.TraceID, link1.SpanID, uint64(1), link2.TraceID, link2.SpanID), traces[0][0].Meta[keySpanLinks])
+		assert.NotContains(t, s.Meta, keySpanLinks, "the span must not be modified")
+	})
+
+	t.Run("none", func(t *testing.T) {
+		s := tracer.StartSpan("batch.process").(*span)
+		assert.Nil(t, s.SpanLinks)
+		p, err := encode([][]*span{{s}})
+		assert.NoError(t, err)
+		b, err := io.ReadAll(p)
+		assert.NoError(t, err)
+		assert.NotContains(t, string(b), "span_links")
+	})
+}
+
+func TestSpanTruncateTags(t *testing.T) {
+	t.Run("enabled", func(t *testing.T) {
+		tracer, _, _, stop := startTestTracer(t, WithMaxTagValueLength(5))
+		defer stop()
+
+		s := tracer.StartSpan("web.request", ResourceName("GET /users/123"), Tag("short", "abc"), Tag("unicode", "héllo wörld")).(*span)
+		s.SetTag("query", "SELECT * FROM users")
+		s.SetTag("_dd.internal", "not truncated")
+		s.setMetric("metric", 123456789)
+		s.Finish()
+
+		assert.Equal(t, "GET /...", s.Resource)
+		assert.Equal(t, "abc", s.Meta["short"])
+		assert.Equal(t, "héllo...", s.Meta["unicode"])
+		assert.Equal(t, "SELEC...", s.Meta["query"])
+		assert.Equal(t, "not truncated", s.Meta["_dd.internal"])
+		assert.Equal(t, float64(123456789), s.Metrics["metric"])
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		tracer, _, _, stop := startTestTracer(t)
+		defer stop()
+
+		s := tracer.StartSpan("web.request", ResourceName("GET /users/123")).(*span)
+		s.SetTag("query", "SELECT * FROM users")
+		s.Finish()
+
+		assert.Equal(t, "GET /users/123", s.Resource)
+		assert.Equal(t, "SELECT * FROM users", s.Meta["query"])
+	})
+}
+
+func TestSpanTagFilter(t *testing.T) {
+	filter := func(key string, value interface{}) (interface{}, bool) {
+		switch key {
+		case ext.SQLQuery:
+			return nil, false
+		case ext.HTTPURL:
+			return strings.SplitN(value.(string), "?", 2)[0], true
+		case "retries":
+			return value.(float64) * 2, true
+		default:
+			return value, true
+		}
+	}
+	tracer, transport, flush, stop := startTestTracer(t, WithTagFilter(filter), WithMaxTagValueLength(30))
+	defer stop()
+
+	root := tracer.StartSpan("web.request", Tag(ext.HTTPURL, "https://example.com/users?token=secret"))
+	child := tracer.StartSpan("db.query", ChildOf(root.Context()), Tag(ext.SQLQuery, "SELECT * FROM users WHERE password = 'secret'"))
+	child.SetTag("retries", 2)
+	child.SetTag("_dd.internal", "not filtered")
+	child.Finish()
+	root.Finish()
+	flush(1)
+
+	traces := transport.Traces()
+	assert.Len(t, traces, 1)
+	assert.Len(t, traces[0], 2)
+	for _, s := range traces[0] {
+		assert.NotContains(t, s.Meta, ext.SQLQuery)
+		for _, v := range s.Meta {
+			assert.NotContains(t, v, "secret")
+		}
+		switch s.Name {
+		case "web.request":
+			assert.Equal(t, "https://example.com/users", s.Meta[ext.HTTPURL])
+		case "db.query":
+			assert.Equal(t, float64(4), s.Metrics["retries"])
+			assert.Equal(t, "not filtered", s.Meta["_dd.internal"])
+		}
+	}
+	// the sampling decision isn't filtered
+	root.(*span).Lock()
+	defer root.(*span).Unlock()
+	assert.Contains(t, root.(*span).Metrics, keySamplingPriority)
+}
+
+func TestSpanMonotonicDuration(t *testing.T) {
+	start := time.Now()
+	defer func(old func() int64) { now = old }(now)
+	defer func(old func() time.Time) { monotonicNow = old }(monotonicNow)
+	// the wall clock is adjusted one second back while the spans run, whereas
+	// the monotonic clock keeps moving forward
+	monotonicNow = func() time.Time { return start }
+	now = func() int64 { return start.UnixNano() }
+	adjust := func() {
+		monotonicNow = func() time.Time { return start.Add(10 * time.Millisecond) }
+		now = func() int64 { return start.Add(-time.Second).UnixNano() }
+	}
+
+	t.Run("enabled", func(t *testing.T) {
+		tracer, _, _, stop := startTestTracer(t, WithMonotonicDurations())
+		defer stop()
+
+		s := tracer.StartSpan("web.request").(*span)
+		adjust()
+		s.Finish()
+		assert.Equal(t, start.UnixNano(), s.Start)
+		assert.Equal(t, int64(10*time.Millisecond), s.Duration)
+	})
+
+	monotonicNow = func() time.Time { return start }
+	now = func() int64 { return start.UnixNano() }
+
+	t.Run("disabled", func(t *testing.T) {
+		tracer, _, _, stop := startTestTracer(t)
+		defer stop()
+
+		s := tracer.StartSpan("web.request").(*span)
+		adjust()
+		s.Finish()
+		assert.Equal(t, start.UnixNano(), s.Start)
+		assert.Equal(t, int64(0), s.Duration)
+	})
+}
+
 const (
 	intUpperLimit = int64(1) << 53
 	intLowerLimit = -intUpperLimit
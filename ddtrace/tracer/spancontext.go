@@ -6,6 +6,7 @@
 package tracer
 
 import (
+	"fmt"
 	"strconv"
 	"sync"
 	"sync/atomic"
@@ -31,13 +32,15 @@ type spanContext struct {
 
 	// the below group should propagate cross-process
 
-	traceID uint64
-	spanID  uint64
+	traceID      uint64
+	traceIDUpper uint64 // higher-order 64 bits of a 128-bit trace ID, zero for 64-bit trace IDs
+	spanID       uint64
 
 	mu         sync.RWMutex // guards below fields
 	baggage    map[string]string
 	hasBaggage uint32 // atomic int for quick checking presence of baggage. 0 indicates no baggage, otherwise baggage exists.
 	origin     string // e.g. "synthetics"
+	tracestate string // the W3C tracestate entries of the other vendors, e.g. "rojo=00f067aa0ba902b7"
 }
 
 // newSpanContext creates a new SpanContext to serve as context for the given
@@ -53,7 +56,9 @@ func newSpanContext(span *span, parent *spanContext) *spanContext {
 	}
 	if parent != nil {
 		context.trace = parent.trace
+		context.traceIDUpper = parent.traceIDUpper
 		context.origin = parent.origin
+		context.tracestate = parent.tracestate
 		context.errors = parent.errors
 		parent.ForeachBaggageItem(func(k, v string) bool {
 			context.setBaggageItem(k, v)
@@ -78,6 +83,22 @@ func (c *spanContext) SpanID() uint64 { return c.spanID }
 // TraceID implements ddtrace.SpanContext.
 func (c *spanContext) TraceID() uint64 { return c.traceID }
 
+// TraceID128 returns the full trace ID as a 32-character hex string. The
+// higher-order 64 bits are zero when the trace ID is a 64-bit one.
+func (c *spanContext) TraceID128() string {
+	return fmt.Sprintf("%016x%016x", c.traceIDUpper, c.traceID)
+}
+
+// setTraceIDUpper sets the higher-order 64 bits of the trace ID and the
+// propagating tag used to convey them to downstream services.
+func (c *spanContext) setTraceIDUpper(upper uint64) {
+	if c.trace == nil {
+		c.trace = newTrace()
+	}
+	c.traceIDUpper = upper
+	c.trace.setPropagatingTag(keyTraceID128, fmt.Sprintf("%016x", upper))
+}
+
 // ForeachBaggageItem implements ddtrace.SpanContext.
 func (c *spanContext) ForeachBaggageItem(handler func(k, v string) bool) {
 	if atomic.LoadUint32(&c.hasBaggage) == 0 {
@@ -152,15 +173,17 @@ const (
 // priority, the root reference and a buffer of the spans which are part of the
 // trace, if these exist.
 type trace struct {
-	mu               sync.RWMutex      // guards below fields
-	spans            []*span           // all the spans that are part of this trace
-	tags             map[string]string // trace level tags
-	propagatingTags  map[string]string // trace level tags that will be propagated across service boundaries
-	finished         int               // the number of finished spans
-	full             bool              // signifies that the span buffer is full
-	priority         *float64          // sampling priority
-	locked           bool              // specifies if the sampling priority can be altered
-	samplingDecision samplingDecision  // samplingDecision indicates whether to send the trace to the agent.
+	mu               sync.RWMutex             // guards below fields
+	spans            []*span                  // all the spans that are part of this trace
+	tags             map[string]string        // trace level tags
+	propagatingTags  map[string]string        // trace level tags that will be propagated across service boundaries
+	finished         int                      // the number of finished spans
+	full             bool                     // signifies that the span buffer is full
+	priority         *float64                 // sampling priority
+	locked           bool                     // specifies if the sampling priority can be altered
+	propagated       bool                     // specifies if the sampling priority was injected or partially flushed
+	sampler          samplernames.SamplerName // the sampler which set the sampling priority
+	samplingDecision samplingDecision         // samplingDecision indicates whether to send the trace to the agent.
 
 	// root specifies the root of the trace, if known; it is nil when a span
 	// context is extracted from a carrier, at which point there are no spans in
@@ -249,6 +272,10 @@ func (t *trace) setSamplingPriorityLocked(p int, sampler samplernames.SamplerNam
 	if t.locked {
 		return
 	}
+	if t.priority == nil || *t.priority != float64(p) || sampler != samplernames.Unknown {
+		// the priority synced from the pushed spans keeps its known sampler
+		t.sampler = sampler
+	}
 	if t.priority == nil {
 		t.priority = new(float64)
 	}
@@ -292,6 +319,55 @@ func (t *trace) push(sp *span) {
 	}
 }
 
+// setTraceTags sets the trace-level tags on s, the first span of a chunk.
+func (t *trace) setTraceTags(s *span) {
+	for k, v := range t.tags {
+		s.setMeta(k, v)
+	}
+	for k, v := range t.propagatingTags {
+		s.setMeta(k, v)
+	}
+}
+
+// partialFlush flushes the finished spans of the unfinished trace once there
+// are enough of them, when partial flushing is enabled. The agent reassembles
+// the trace from its chunks, the first span of each one holding the trace-level
+// tags and the sampling priority.
+// It must be called with t.mu held.
+func (t *trace) partialFlush() {
+	tr, ok := internal.GetGlobalTracer().(*tracer)
+	if !ok {
+		return
+	}
+	if n := tr.config.partialFlushMinSpans; n <= 0 || t.finished < n {
+		return
+	}
+	finished := make([]*span, 0, t.finished)
+	leftover := make([]*span, 0, len(t.spans)-t.finished)
+	for _, s := range t.spans {
+		if s.finished {
+			finished = append(finished, s)
+		} else {
+			leftover = append(leftover, s)
+		}
+	}
+	if finished[0] != t.spans[0] {
+		t.setTraceTags(finished[0])
+	}
+	if t.priority != nil {
+		finished[0].setMetric(keySamplingPriority, *t.priority)
+	}
+	t.propagated = true
+	log.Debug("Partially flushing %d spans of trace %d, %d spans remaining.", len(finished), finished[0].TraceID, len(leftover))
+	atomic.AddUint32(&tr.spansFinished, uint32(len(finished)))
+	tr.pushTrace(&finishedTrace{
+		spans:    finished,
+		willSend: decisionKeep == samplingDecision(atomic.LoadUint32((*uint32)(&t.samplingDecision))),
+	})
+	t.spans = leftover
+	t.finished = 0
+}
+
 // finishedOne acknowledges that another span in the trace has finished, and checks
 // if the trace is complete, in which case it calls the onFinish function. It uses
 // the given priority, if non-nil, to mark the root span.
@@ -319,14 +395,10 @@ func (t *trace) finishedOne(s *span) {
 		// TODO(barbayar): make sure this doesn't happen in vain when switching to
 		// the new wire format. We won't need to set the tags on the first span
 		// in the chunk there.
-		for k, v := range t.tags {
-			s.setMeta(k, v)
-		}
-		for k, v := range t.propagatingTags {
-			s.setMeta(k, v)
-		}
+		t.setTraceTags(s)
 	}
 	if len(t.spans) != t.finished {
+		t.partialFlush()
 		return
 	}
 	defer func() {
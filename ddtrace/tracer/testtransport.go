@@ -0,0 +1,92 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2022 Datadog, Inc.
+
+package tracer
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"sync"
+)
+
+// TestTransport is a transport keeping the trace payloads sent by the tracer in
+// memory instead of sending them to the agent, so that the traces produced by
+// an application can be asserted in its tests without running an agent. Unlike
+// the mocktracer package, the real tracer is used, along with its sampling,
+// payload encoding and flushing. It is set with WithTestTransport.
+//
+// A TestTransport is safe for concurrent use.
+type TestTransport struct {
+	mu       sync.RWMutex
+	payloads [][]byte
+	traces   int
+}
+
+var _ transport = (*TestTransport)(nil)
+
+// NewTestTransport returns a new, empty TestTransport.
+func NewTestTransport() *TestTransport {
+	return new(TestTransport)
+}
+
+// WithTestTransport makes the tracer send its traces to the given transport,
+// which keeps them in memory, instead of the agent. The agent isn't queried for
+// its capabilities either, so that no agent is needed. Call FlushWithTimeout to
+// send the finished traces, and wait for them to be recorded, before asserting
+// them.
+func WithTestTransport(tt *TestTransport) StartOption {
+	return func(c *config) {
+		c.transport = tt
+	}
+}
+
+// send implements transport.
+func (tt *TestTransport) send(p *payload) (io.ReadCloser, error) {
+	return tt.sendWithContext(context.Background(), p)
+}
+
+// sendWithContext implements transport. The payload is recorded right away.
+func (tt *TestTransport) sendWithContext(_ context.Context, p *payload) (io.ReadCloser, error) {
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(p); err != nil {
+		return nil, err
+	}
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	tt.payloads = append(tt.payloads, buf.Bytes())
+	tt.traces += p.itemCount()
+	return io.NopCloser(strings.NewReader("{}")), nil
+}
+
+// sendStats implements transport. The stats are discarded.
+func (tt *TestTransport) sendStats(*statsPayload) error { return nil }
+
+// endpoint implements transport.
+func (tt *TestTransport) endpoint() string { return "test://" }
+
+// Payloads returns the payloads sent so far, encoded as they would have been
+// sent to the agent, which is in the msgpack v0.4 format unless another one is
+// set with WithTraceEncoder.
+func (tt *TestTransport) Payloads() [][]byte {
+	tt.mu.RLock()
+	defer tt.mu.RUnlock()
+	return append([][]byte(nil), tt.payloads...)
+}
+
+// TraceCount returns the number of traces sent so far.
+func (tt *TestTransport) TraceCount() int {
+	tt.mu.RLock()
+	defer tt.mu.RUnlock()
+	return tt.traces
+}
+
+// Reset discards the payloads sent so far.
+func (tt *TestTransport) Reset() {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	tt.payloads, tt.traces = nil, 0
+}
@@ -0,0 +1,48 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2022 Datadog, Inc.
+
+package tracer
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tinylib/msgp/msgp"
+
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/internal"
+)
+
+func TestTestTransport(t *testing.T) {
+	assert := assert.New(t)
+	tt := NewTestTransport()
+	Start(WithTestTransport(tt), WithLogStartup(false))
+	defer Stop()
+
+	for i := 0; i < 3; i++ {
+		root := StartSpan("root")
+		StartSpan("child", ChildOf(root.Context())).Finish()
+		root.Finish()
+	}
+	assert.NoError(FlushWithTimeout(time.Second))
+
+	assert.Equal(3, tt.TraceCount())
+	var traces spanLists
+	for _, p := range tt.Payloads() {
+		var got spanLists
+		assert.NoError(msgp.Decode(bytes.NewReader(p), &got))
+		traces = append(traces, got...)
+	}
+	assert.Len(traces, 3)
+	for _, trace := range traces {
+		assert.Len(trace, 2)
+	}
+	assert.False(internal.GetGlobalTracer().(*tracer).config.agent.Stats, "the agent must not be queried")
+
+	tt.Reset()
+	assert.Equal(0, tt.TraceCount())
+	assert.Empty(tt.Payloads())
+}
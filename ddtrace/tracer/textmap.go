@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -65,8 +66,16 @@ func (c TextMapCarrier) ForeachKey(handler func(key, val string) error) error {
 }
 
 const (
-	headerPropagationStyleInject  = "DD_PROPAGATION_STYLE_INJECT"
-	headerPropagationStyleExtract = "DD_PROPAGATION_STYLE_EXTRACT"
+	headerPropagationStyleInject  = "DD_TRACE_PROPAGATION_STYLE_INJECT"
+	headerPropagationStyleExtract = "DD_TRACE_PROPAGATION_STYLE_EXTRACT"
+	// headerPropagationStyle sets both the inject and extract styles, unless
+	// they are set by the more specific variables.
+	headerPropagationStyle = "DD_TRACE_PROPAGATION_STYLE"
+
+	// Deprecated names of headerPropagationStyleInject and
+	// headerPropagationStyleExtract, used when those aren't set.
+	headerPropagationStyleInjectDeprecated  = "DD_PROPAGATION_STYLE_INJECT"
+	headerPropagationStyleExtractDeprecated = "DD_PROPAGATION_STYLE_EXTRACT"
 )
 
 const (
@@ -97,12 +106,31 @@ const traceTagsHeader = "x-datadog-tags"
 // propagationExtractMaxSize limits the total size of incoming propagated tags to parse
 const propagationExtractMaxSize = 512
 
+const (
+	// defaultMaxBaggageItems is the default maximum number of propagated baggage items.
+	defaultMaxBaggageItems = 64
+	// defaultMaxBaggageBytes is the default maximum total size of the propagated
+	// baggage items, the size of an item being the length of its header name and
+	// value.
+	defaultMaxBaggageBytes = 8192
+)
+
 // PropagatorConfig defines the configuration for initializing a propagator.
 type PropagatorConfig struct {
 	// BaggagePrefix specifies the prefix that will be used to store baggage
 	// items in a map. It defaults to DefaultBaggageHeaderPrefix.
 	BaggagePrefix string
 
+	// MaxBaggageItems specifies the maximum number of baggage items which are
+	// injected or extracted. It defaults to 64.
+	MaxBaggageItems int
+
+	// MaxBaggageBytes specifies the maximum total size of the baggage items
+	// which are injected or extracted, the size of an item being the length of
+	// its header name, made of BaggagePrefix followed by its key, plus the
+	// length of its value. It defaults to 8192.
+	MaxBaggageBytes int
+
 	// TraceHeader specifies the map key that will be used to store the trace ID.
 	// It defaults to DefaultTraceIDHeader.
 	TraceHeader string
@@ -122,6 +150,16 @@ type PropagatorConfig struct {
 	// B3 specifies if B3 headers should be added for trace propagation.
 	// See https://github.com/openzipkin/b3-propagation
 	B3 bool
+
+	// B3SingleHeader specifies if the B3 single header (b3) should be added
+	// for trace propagation.
+	// See https://github.com/openzipkin/b3-propagation#single-header
+	B3SingleHeader bool
+
+	// TraceContext specifies if W3C trace context headers (traceparent and
+	// tracestate) should be added for trace propagation.
+	// See https://www.w3.org/TR/trace-context/
+	TraceContext bool
 }
 
 // NewPropagator returns a new propagator which uses TextMap to inject
@@ -134,6 +172,12 @@ func NewPropagator(cfg *PropagatorConfig, propagators ...Propagator) Propagator
 	if cfg.BaggagePrefix == "" {
 		cfg.BaggagePrefix = DefaultBaggageHeaderPrefix
 	}
+	if cfg.MaxBaggageItems <= 0 {
+		cfg.MaxBaggageItems = defaultMaxBaggageItems
+	}
+	if cfg.MaxBaggageBytes <= 0 {
+		cfg.MaxBaggageBytes = defaultMaxBaggageBytes
+	}
 	if cfg.TraceHeader == "" {
 		cfg.TraceHeader = DefaultTraceIDHeader
 	}
@@ -150,30 +194,43 @@ func NewPropagator(cfg *PropagatorConfig, propagators ...Propagator) Propagator
 		}
 	}
 	return &chainedPropagator{
-		injectors:  getPropagators(cfg, headerPropagationStyleInject),
-		extractors: getPropagators(cfg, headerPropagationStyleExtract),
+		injectors:  getPropagators(cfg, headerPropagationStyleInject, headerPropagationStyleInjectDeprecated, headerPropagationStyle),
+		extractors: getPropagators(cfg, headerPropagationStyleExtract, headerPropagationStyleExtractDeprecated, headerPropagationStyle),
 	}
 }
 
 // chainedPropagator implements Propagator and applies a list of injectors and extractors.
 // When injecting, all injectors are called to propagate the span context.
-// When extracting, it tries each extractor, selecting the first successful one.
+// When extracting, it tries each extractor, selecting the first successful one,
+// so that a corrupted span context in one format doesn't prevent extracting a
+// valid one from the next formats.
 type chainedPropagator struct {
 	injectors  []Propagator
 	extractors []Propagator
 }
 
 // getPropagators returns a list of propagators based on the list found in the
-// given environment variable. If the list doesn't contain any valid values the
-// default propagator will be returned. Any invalid values in the list will log
-// a warning and be ignored.
-func getPropagators(cfg *PropagatorConfig, env string) []Propagator {
+// first set of the given environment variables. If the list doesn't contain
+// any valid values the default propagator will be returned. Any invalid values
+// in the list will log a warning and be ignored.
+func getPropagators(cfg *PropagatorConfig, envs ...string) []Propagator {
 	dd := &propagator{cfg}
-	ps := os.Getenv(env)
+	var ps string
+	for _, env := range envs {
+		if ps = os.Getenv(env); ps != "" {
+			break
+		}
+	}
 	defaultPs := []Propagator{dd}
 	if cfg.B3 {
 		defaultPs = append(defaultPs, &propagatorB3{})
 	}
+	if cfg.B3SingleHeader {
+		defaultPs = append(defaultPs, &propagatorB3SingleHeader{})
+	}
+	if cfg.TraceContext {
+		defaultPs = append(defaultPs, &propagatorW3c{})
+	}
 	if ps == "" {
 		return defaultPs
 	}
@@ -181,8 +238,14 @@ func getPropagators(cfg *PropagatorConfig, env string) []Propagator {
 	if cfg.B3 {
 		list = append(list, &propagatorB3{})
 	}
+	if cfg.B3SingleHeader {
+		list = append(list, &propagatorB3SingleHeader{})
+	}
+	if cfg.TraceContext {
+		list = append(list, &propagatorW3c{})
+	}
 	for _, v := range strings.Split(ps, ",") {
-		switch strings.ToLower(v) {
+		switch strings.ToLower(strings.TrimSpace(v)) {
 		case "datadog":
 			list = append(list, dd)
 		case "b3", "b3multi":
@@ -190,6 +253,16 @@ func getPropagators(cfg *PropagatorConfig, env string) []Propagator {
 				// propagatorB3 hasn't already been added, add a new one.
 				list = append(list, &propagatorB3{})
 			}
+		case "b3 single header":
+			if !cfg.B3SingleHeader {
+				// propagatorB3SingleHeader hasn't already been added, add a new one.
+				list = append(list, &propagatorB3SingleHeader{})
+			}
+		case "tracecontext":
+			if !cfg.TraceContext {
+				// propagatorW3c hasn't already been added, add a new one.
+				list = append(list, &propagatorW3c{})
+			}
 		default:
 			log.Warn("unrecognized propagator: %s\n", v)
 		}
@@ -214,23 +287,48 @@ func (p *chainedPropagator) Inject(spanCtx ddtrace.SpanContext, carrier interfac
 	return nil
 }
 
-// Extract implements Propagator.
+// Extract implements Propagator. When no extractor succeeds, it returns the
+// error of the first one which didn't fail with ErrSpanContextNotFound.
 func (p *chainedPropagator) Extract(carrier interface{}) (ddtrace.SpanContext, error) {
-	for _, v := range p.extractors {
+	var firstErr error
+	for i, v := range p.extractors {
 		ctx, err := v.Extract(carrier)
 		if ctx != nil {
 			// first extractor returns
+			if sctx, ok := ctx.(*spanContext); ok && sctx.traceIDUpper == 0 {
+				p.extractTraceIDUpper(sctx, carrier, p.extractors[i+1:])
+			}
 			log.Debug("Extracted span context: %#v", ctx)
 			return ctx, nil
 		}
 		if err == ErrSpanContextNotFound {
 			continue
 		}
-		return nil, err
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr != nil {
+		return nil, firstErr
 	}
 	return nil, ErrSpanContextNotFound
 }
 
+// extractTraceIDUpper sets the higher-order 64 bits of the trace ID of ctx from
+// the first of the given extractors extracting the same trace ID along with
+// them. This recovers the 128-bit trace ID of the formats carrying it in their
+// trace ID header, such as B3, when it is missing from the selected context,
+// such as when the Datadog trace tags header isn't propagated.
+func (p *chainedPropagator) extractTraceIDUpper(ctx *spanContext, carrier interface{}, extractors []Propagator) {
+	for _, v := range extractors {
+		c, _ := v.Extract(carrier)
+		if sctx, ok := c.(*spanContext); ok && sctx.traceID == ctx.traceID && sctx.traceIDUpper != 0 {
+			ctx.setTraceIDUpper(sctx.traceIDUpper)
+			return
+		}
+	}
+}
+
 // propagator implements Propagator and injects/extracts span contexts
 // using datadog headers. Only TextMap carriers are supported.
 type propagator struct {
@@ -261,8 +359,13 @@ func (p *propagator) injectTextMap(spanCtx ddtrace.SpanContext, writer TextMapWr
 		writer.Set(originHeader, ctx.origin)
 	}
 	// propagate OpenTracing baggage
-	for k, v := range ctx.baggage {
-		writer.Set(p.cfg.BaggagePrefix+k, v)
+	baggage := make(map[string]string)
+	ctx.ForeachBaggageItem(func(k, v string) bool {
+		baggage[k] = v
+		return true
+	})
+	for _, k := range p.boundBaggage(baggage) {
+		writer.Set(p.cfg.BaggagePrefix+k, baggage[k])
 	}
 	if p.cfg.MaxTagsHeaderLen <= 0 {
 		return nil
@@ -273,6 +376,30 @@ func (p *propagator) injectTextMap(spanCtx ddtrace.SpanContext, writer TextMapWr
 	return nil
 }
 
+// boundBaggage returns the sorted keys of the given baggage items which fit in
+// the maximum number of items and size of the configuration, the items beyond
+// them being dropped.
+func (p *propagator) boundBaggage(baggage map[string]string) []string {
+	if len(baggage) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(baggage))
+	for k := range baggage {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	size := 0
+	for i, k := range keys {
+		// the item is only kept if its header fits in the remaining size
+		size += len(p.cfg.BaggagePrefix) + len(k) + len(baggage[k])
+		if i == p.cfg.MaxBaggageItems || size > p.cfg.MaxBaggageBytes {
+			log.Warn("Won't propagate %d baggage items: maximum number of items (%d) or size (%d bytes) reached.", len(keys)-i, p.cfg.MaxBaggageItems, p.cfg.MaxBaggageBytes)
+			return keys[:i]
+		}
+	}
+	return keys
+}
+
 // marshalPropagatingTags marshals all propagating tags included in ctx to a comma separated string
 func (p *propagator) marshalPropagatingTags(ctx *spanContext) string {
 	var sb strings.Builder
@@ -313,7 +440,10 @@ func (p *propagator) Extract(carrier interface{}) (ddtrace.SpanContext, error) {
 }
 
 func (p *propagator) extractTextMap(reader TextMapReader) (ddtrace.SpanContext, error) {
-	var ctx spanContext
+	var (
+		ctx     spanContext
+		baggage map[string]string
+	)
 	err := reader.ForeachKey(func(k, v string) error {
 		var err error
 		key := strings.ToLower(k)
@@ -340,7 +470,10 @@ func (p *propagator) extractTextMap(reader TextMapReader) (ddtrace.SpanContext,
 			unmarshalPropagatingTags(&ctx, v)
 		default:
 			if strings.HasPrefix(key, p.cfg.BaggagePrefix) {
-				ctx.setBaggageItem(strings.TrimPrefix(key, p.cfg.BaggagePrefix), v)
+				if baggage == nil {
+					baggage = make(map[string]string)
+				}
+				baggage[strings.TrimPrefix(key, p.cfg.BaggagePrefix)] = v
 			}
 		}
 		return nil
@@ -351,9 +484,35 @@ func (p *propagator) extractTextMap(reader TextMapReader) (ddtrace.SpanContext,
 	if ctx.traceID == 0 || (ctx.spanID == 0 && ctx.origin != "synthetics") {
 		return nil, ErrSpanContextNotFound
 	}
+	for _, k := range p.boundBaggage(baggage) {
+		ctx.setBaggageItem(k, baggage[k])
+	}
+	if ctx.trace != nil {
+		extractTraceIDUpper(&ctx)
+	}
 	return &ctx, nil
 }
 
+// extractTraceIDUpper sets the higher-order 64 bits of the trace ID of ctx from
+// its propagated _dd.p.tid tag, if any. A malformed tag is dropped so that it
+// doesn't get propagated further.
+func extractTraceIDUpper(ctx *spanContext) {
+	ctx.trace.mu.Lock()
+	defer ctx.trace.mu.Unlock()
+	v, ok := ctx.trace.propagatingTags[keyTraceID128]
+	if !ok {
+		return
+	}
+	upper, err := strconv.ParseUint(v, 16, 64)
+	if err != nil || len(v) != 16 {
+		log.Warn("Did not extract %s: invalid value %q.", keyTraceID128, v)
+		delete(ctx.trace.propagatingTags, keyTraceID128)
+		ctx.trace.setTag(keyPropagationError, "malformed_tid "+v)
+		return
+	}
+	ctx.traceIDUpper = upper
+}
+
 // unmarshalPropagatingTags unmarshals tags from v into ctx
 func unmarshalPropagatingTags(ctx *spanContext, v string) {
 	if ctx.trace == nil {
@@ -398,7 +557,11 @@ func (*propagatorB3) injectTextMap(spanCtx ddtrace.SpanContext, writer TextMapWr
 	if !ok || ctx.traceID == 0 || ctx.spanID == 0 {
 		return ErrInvalidSpanContext
 	}
-	writer.Set(b3TraceIDHeader, fmt.Sprintf("%016x", ctx.traceID))
+	if ctx.traceIDUpper != 0 {
+		writer.Set(b3TraceIDHeader, fmt.Sprintf("%016x%016x", ctx.traceIDUpper, ctx.traceID))
+	} else {
+		writer.Set(b3TraceIDHeader, fmt.Sprintf("%016x", ctx.traceID))
+	}
 	writer.Set(b3SpanIDHeader, fmt.Sprintf("%016x", ctx.spanID))
 	if p, ok := ctx.samplingPriority(); ok {
 		if p >= ext.PriorityAutoKeep {
@@ -427,6 +590,13 @@ func (*propagatorB3) extractTextMap(reader TextMapReader) (ddtrace.SpanContext,
 		switch key {
 		case b3TraceIDHeader:
 			if len(v) > 16 {
+				upper, err := strconv.ParseUint(v[:len(v)-16], 16, 64)
+				if err != nil {
+					return ErrSpanContextCorrupted
+				}
+				if upper != 0 {
+					ctx.setTraceIDUpper(upper)
+				}
 				v = v[len(v)-16:]
 			}
 			ctx.traceID, err = strconv.ParseUint(v, 16, 64)
@@ -456,3 +626,320 @@ func (*propagatorB3) extractTextMap(reader TextMapReader) (ddtrace.SpanContext,
 	}
 	return &ctx, nil
 }
+
+// b3SingleHeader is the B3 single header, holding the trace ID, span ID and
+// sampling decision in the form {TraceId}-{SpanId}-{SamplingState}.
+const b3SingleHeader = "b3"
+
+// propagatorB3SingleHeader implements Propagator and injects/extracts span
+// contexts using the B3 single header. Only TextMap carriers are supported.
+type propagatorB3SingleHeader struct{}
+
+func (p *propagatorB3SingleHeader) Inject(spanCtx ddtrace.SpanContext, carrier interface{}) error {
+	switch c := carrier.(type) {
+	case TextMapWriter:
+		return p.injectTextMap(spanCtx, c)
+	default:
+		return ErrInvalidCarrier
+	}
+}
+
+func (*propagatorB3SingleHeader) injectTextMap(spanCtx ddtrace.SpanContext, writer TextMapWriter) error {
+	ctx, ok := spanCtx.(*spanContext)
+	if !ok || ctx.traceID == 0 || ctx.spanID == 0 {
+		return ErrInvalidSpanContext
+	}
+	var sb strings.Builder
+	if ctx.traceIDUpper != 0 {
+		fmt.Fprintf(&sb, "%016x%016x-%016x", ctx.traceIDUpper, ctx.traceID, ctx.spanID)
+	} else {
+		fmt.Fprintf(&sb, "%016x-%016x", ctx.traceID, ctx.spanID)
+	}
+	if p, ok := ctx.samplingPriority(); ok {
+		if p >= ext.PriorityAutoKeep {
+			sb.WriteString("-1")
+		} else {
+			sb.WriteString("-0")
+		}
+	}
+	writer.Set(b3SingleHeader, sb.String())
+	return nil
+}
+
+func (p *propagatorB3SingleHeader) Extract(carrier interface{}) (ddtrace.SpanContext, error) {
+	switch c := carrier.(type) {
+	case TextMapReader:
+		return p.extractTextMap(c)
+	default:
+		return nil, ErrInvalidCarrier
+	}
+}
+
+func (*propagatorB3SingleHeader) extractTextMap(reader TextMapReader) (ddtrace.SpanContext, error) {
+	var ctx spanContext
+	err := reader.ForeachKey(func(k, v string) error {
+		if strings.ToLower(k) != b3SingleHeader {
+			return nil
+		}
+		parts := strings.Split(strings.TrimSpace(v), "-")
+		if len(parts) == 1 {
+			// a sampling decision only, without any span context
+			return nil
+		}
+		traceID := parts[0]
+		if len(traceID) > 16 {
+			upper, err := strconv.ParseUint(traceID[:len(traceID)-16], 16, 64)
+			if err != nil {
+				return ErrSpanContextCorrupted
+			}
+			if upper != 0 {
+				ctx.setTraceIDUpper(upper)
+			}
+			traceID = traceID[len(traceID)-16:]
+		}
+		var err error
+		if ctx.traceID, err = strconv.ParseUint(traceID, 16, 64); err != nil {
+			return ErrSpanContextCorrupted
+		}
+		if ctx.spanID, err = strconv.ParseUint(parts[1], 16, 64); err != nil {
+			return ErrSpanContextCorrupted
+		}
+		if len(parts) > 2 {
+			switch parts[2] {
+			case "0":
+				ctx.setSamplingPriority(ext.PriorityAutoReject, samplernames.Unknown)
+			case "1":
+				ctx.setSamplingPriority(ext.PriorityAutoKeep, samplernames.Unknown)
+			case "d":
+				// debug, forcing the trace to be sampled
+				ctx.setSamplingPriority(ext.PriorityUserKeep, samplernames.Unknown)
+			default:
+				return ErrSpanContextCorrupted
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if ctx.traceID == 0 || ctx.spanID == 0 {
+		return nil, ErrSpanContextNotFound
+	}
+	return &ctx, nil
+}
+
+const (
+	traceparentHeader = "traceparent"
+	tracestateHeader  = "tracestate"
+)
+
+// propagatorW3c implements Propagator and injects/extracts span contexts
+// using the W3C trace context headers. Only TextMap carriers are supported.
+// The Datadog entry of the tracestate header conveys the sampling priority and
+// the origin, followed by the entries of the other vendors, which are kept as
+// extracted.
+type propagatorW3c struct{}
+
+func (p *propagatorW3c) Inject(spanCtx ddtrace.SpanContext, carrier interface{}) error {
+	switch c := carrier.(type) {
+	case TextMapWriter:
+		return p.injectTextMap(spanCtx, c)
+	default:
+		return ErrInvalidCarrier
+	}
+}
+
+func (*propagatorW3c) injectTextMap(spanCtx ddtrace.SpanContext, writer TextMapWriter) error {
+	ctx, ok := spanCtx.(*spanContext)
+	if !ok || ctx.traceID == 0 || ctx.spanID == 0 {
+		return ErrInvalidSpanContext
+	}
+	flags := "00"
+	p, ok := ctx.samplingPriority()
+	if ok && p >= ext.PriorityAutoKeep {
+		flags = "01"
+	}
+	writer.Set(traceparentHeader, fmt.Sprintf("00-%016x%016x-%016x-%s", ctx.traceIDUpper, ctx.traceID, ctx.spanID, flags))
+	var state []string
+	if ok {
+		state = append(state, "s:"+strconv.Itoa(p))
+	}
+	if ctx.origin != "" {
+		state = append(state, "o:"+sanitizeTracestateValue(ctx.origin))
+	}
+	var entries []string
+	if len(state) > 0 {
+		entries = append(entries, "dd="+strings.Join(state, ";"))
+	}
+	if ctx.tracestate != "" {
+		entries = append(entries, strings.Split(ctx.tracestate, ",")...)
+	}
+	if len(entries) > maxTracestateEntries {
+		// the last entries are dropped, keeping the Datadog one
+		entries = entries[:maxTracestateEntries]
+	}
+	if len(entries) > 0 {
+		writer.Set(tracestateHeader, strings.Join(entries, ","))
+	}
+	return nil
+}
+
+// maxTracestateEntries is the maximum number of entries of the tracestate
+// header allowed by the W3C trace context specification.
+const maxTracestateEntries = 32
+
+// vendorTracestate returns the entries of the tracestate header value v which
+// don't belong to Datadog, in their original order.
+func vendorTracestate(v string) string {
+	var entries []string
+	for _, entry := range strings.Split(v, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || strings.HasPrefix(entry, "dd=") {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return strings.Join(entries, ",")
+}
+
+// sanitizeTracestateValue replaces the characters of v which aren't allowed in
+// the values of the Datadog tracestate entry with underscores.
+func sanitizeTracestateValue(v string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e || r == ',' || r == ';' || r == '=' || r == '~' {
+			return '_'
+		}
+		return r
+	}, v)
+}
+
+func (p *propagatorW3c) Extract(carrier interface{}) (ddtrace.SpanContext, error) {
+	switch c := carrier.(type) {
+	case TextMapReader:
+		return p.extractTextMap(c)
+	default:
+		return nil, ErrInvalidCarrier
+	}
+}
+
+func (*propagatorW3c) extractTextMap(reader TextMapReader) (ddtrace.SpanContext, error) {
+	var traceparent, tracestate string
+	err := reader.ForeachKey(func(k, v string) error {
+		switch strings.ToLower(k) {
+		case traceparentHeader:
+			traceparent = strings.TrimSpace(v)
+		case tracestateHeader:
+			if tracestate != "" {
+				// the header may be split across several fields
+				tracestate += ","
+			}
+			tracestate += v
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if traceparent == "" {
+		return nil, ErrSpanContextNotFound
+	}
+	var ctx spanContext
+	sampled, err := parseTraceparent(&ctx, traceparent)
+	if err != nil {
+		return nil, err
+	}
+	priority := ext.PriorityAutoReject
+	if sampled {
+		priority = ext.PriorityAutoKeep
+	}
+	ctx.tracestate = vendorTracestate(tracestate)
+	if p, origin, ok := parseTracestate(tracestate); ok {
+		if origin != "" {
+			ctx.origin = origin
+		}
+		// the Datadog priority is only used when it agrees with the sampled
+		// flag, which may have been updated by other tracers
+		if p != nil && (*p > 0) == sampled {
+			priority = *p
+		}
+	}
+	ctx.setSamplingPriority(priority, samplernames.Unknown)
+	return &ctx, nil
+}
+
+// parseTraceparent sets the trace and span IDs of ctx from the traceparent
+// header value v, and returns whether the trace is sampled.
+func parseTraceparent(ctx *spanContext, v string) (sampled bool, err error) {
+	parts := strings.Split(v, "-")
+	if len(parts) < 4 {
+		return false, ErrSpanContextCorrupted
+	}
+	version, traceID, spanID, flags := parts[0], parts[1], parts[2], parts[3]
+	if len(version) != 2 || version == "ff" || (version == "00" && len(parts) != 4) {
+		return false, ErrSpanContextCorrupted
+	}
+	if len(traceID) != 32 || len(spanID) != 16 || len(flags) != 2 || !isLowerHex(v[3:]) {
+		return false, ErrSpanContextCorrupted
+	}
+	upper, err := strconv.ParseUint(traceID[:16], 16, 64)
+	if err != nil {
+		return false, ErrSpanContextCorrupted
+	}
+	if ctx.traceID, err = strconv.ParseUint(traceID[16:], 16, 64); err != nil {
+		return false, ErrSpanContextCorrupted
+	}
+	if ctx.spanID, err = strconv.ParseUint(spanID, 16, 64); err != nil {
+		return false, ErrSpanContextCorrupted
+	}
+	if ctx.traceID == 0 || ctx.spanID == 0 {
+		// the all-zero IDs are invalid, and the lower-order 64 bits of
+		// the trace ID are required by the Datadog trace ID
+		return false, ErrSpanContextCorrupted
+	}
+	if upper != 0 {
+		ctx.setTraceIDUpper(upper)
+	}
+	f, err := strconv.ParseUint(flags, 16, 8)
+	if err != nil {
+		return false, ErrSpanContextCorrupted
+	}
+	return f&0x1 == 1, nil
+}
+
+// isLowerHex reports whether v only holds lowercase hexadecimal digits and
+// dashes.
+func isLowerHex(v string) bool {
+	for i := 0; i < len(v); i++ {
+		if c := v[i]; !(c >= '0' && c <= '9') && !(c >= 'a' && c <= 'f') && c != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+// parseTracestate returns the sampling priority and the origin held by the
+// Datadog entry of the tracestate header value v, and whether it was found.
+func parseTracestate(v string) (priority *int, origin string, ok bool) {
+	for _, entry := range strings.Split(v, ",") {
+		entry = strings.TrimSpace(entry)
+		if !strings.HasPrefix(entry, "dd=") {
+			continue
+		}
+		for _, field := range strings.Split(entry[len("dd="):], ";") {
+			i := strings.IndexByte(field, ':')
+			if i < 0 {
+				continue
+			}
+			switch key, val := field[:i], field[i+1:]; key {
+			case "s":
+				if p, err := strconv.Atoi(val); err == nil {
+					priority = &p
+				}
+			case "o":
+				origin = val
+			}
+		}
+		return priority, origin, true
+	}
+	return nil, "", false
+}
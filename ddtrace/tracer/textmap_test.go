@@ -154,6 +154,74 @@ func TestTextMapPropagatorInjectHeader(t *testing.T) {
 	assert.Equal(headers.Get(DefaultPriorityHeader), "0")
 }
 
+func TestTextMapPropagatorBaggageLimits(t *testing.T) {
+	t.Run("inject-items", func(t *testing.T) {
+		tracer := newTracer(WithPropagator(NewPropagator(&PropagatorConfig{MaxBaggageItems: 2})))
+		defer tracer.Stop()
+		root := tracer.StartSpan("web.request")
+		root.SetBaggageItem("c", "3")
+		root.SetBaggageItem("a", "1")
+		root.SetBaggageItem("b", "2")
+		headers := http.Header{}
+		assert.NoError(t, tracer.Inject(root.Context(), HTTPHeadersCarrier(headers)))
+		assert.Equal(t, "1", headers.Get("ot-baggage-a"))
+		assert.Equal(t, "2", headers.Get("ot-baggage-b"))
+		assert.NotContains(t, headers, "Ot-Baggage-C")
+	})
+
+	t.Run("inject-bytes", func(t *testing.T) {
+		// the size of an item is the length of its header name and value,
+		// such as 16 bytes for "ot-baggage-a: 1234"
+		for limit, want := range map[int][]string{
+			15: {},
+			16: {"Ot-Baggage-A"},
+			31: {"Ot-Baggage-A"},
+			32: {"Ot-Baggage-A", "Ot-Baggage-B"},
+		} {
+			tracer := newTracer(WithPropagator(NewPropagator(&PropagatorConfig{MaxBaggageBytes: limit})))
+			root := tracer.StartSpan("web.request")
+			root.SetBaggageItem("a", "1234")
+			root.SetBaggageItem("b", "5678")
+			headers := http.Header{}
+			assert.NoError(t, tracer.Inject(root.Context(), HTTPHeadersCarrier(headers)))
+			var got []string
+			for k := range headers {
+				if strings.HasPrefix(k, "Ot-Baggage-") {
+					got = append(got, k)
+				}
+			}
+			assert.ElementsMatch(t, want, got, "max %d bytes", limit)
+			tracer.Stop()
+		}
+	})
+
+	t.Run("extract", func(t *testing.T) {
+		tracer := newTracer(WithPropagator(NewPropagator(&PropagatorConfig{MaxBaggageItems: 1})))
+		defer tracer.Stop()
+		headers := TextMapCarrier(map[string]string{
+			DefaultTraceIDHeader:  "1",
+			DefaultParentIDHeader: "2",
+			"ot-baggage-b":        "2",
+			"ot-baggage-a":        "1",
+		})
+		sctx, err := tracer.Extract(headers)
+		assert.NoError(t, err)
+		var items []string
+		sctx.ForeachBaggageItem(func(k, v string) bool {
+			items = append(items, k+"="+v)
+			return true
+		})
+		assert.Equal(t, []string{"a=1"}, items)
+	})
+
+	t.Run("defaults", func(t *testing.T) {
+		cfg := &PropagatorConfig{}
+		NewPropagator(cfg)
+		assert.Equal(t, defaultMaxBaggageItems, cfg.MaxBaggageItems)
+		assert.Equal(t, defaultMaxBaggageBytes, cfg.MaxBaggageBytes)
+	})
+}
+
 func TestTextMapPropagatorOrigin(t *testing.T) {
 	src := TextMapCarrier(map[string]string{
 		originHeader:          "synthetics",
@@ -555,6 +623,448 @@ func TestB3(t *testing.T) {
 	})
 }
 
+func TestTraceID128BitPropagation(t *testing.T) {
+	t.Run("b3", func(t *testing.T) {
+		assert := assert.New(t)
+		tracer := newTracer(
+			WithTraceID128Bit(true),
+			WithPropagator(NewPropagator(&PropagatorConfig{B3: true})),
+		)
+		defer tracer.Stop()
+		root := tracer.StartSpan("web.request").(*span)
+		ctx := root.Context().(*spanContext)
+
+		headers := TextMapCarrier(map[string]string{})
+		assert.Nil(tracer.Inject(ctx, headers))
+		assert.Equal(fmt.Sprintf("%016x%016x", ctx.traceIDUpper, ctx.traceID), headers[b3TraceIDHeader])
+		assert.Len(headers[b3TraceIDHeader], 32)
+
+		extracted, err := tracer.Extract(headers)
+		assert.Nil(err)
+		sctx := extracted.(*spanContext)
+		assert.Equal(ctx.traceID, sctx.traceID)
+		assert.Equal(ctx.traceIDUpper, sctx.traceIDUpper)
+		assert.Equal(fmt.Sprintf("%016x", ctx.traceIDUpper), sctx.trace.propagatingTags[keyTraceID128])
+	})
+
+	t.Run("datadog", func(t *testing.T) {
+		assert := assert.New(t)
+		tracer := newTracer(WithTraceID128Bit(true))
+		defer tracer.Stop()
+		root := tracer.StartSpan("web.request").(*span)
+		ctx := root.Context().(*spanContext)
+
+		headers := TextMapCarrier(map[string]string{})
+		assert.Nil(tracer.Inject(ctx, headers))
+		// the trace ID header remains the lower 64 bits for backward compatibility
+		assert.Equal(strconv.FormatUint(ctx.traceID, 10), headers[DefaultTraceIDHeader])
+		assert.Contains(headers[traceTagsHeader], fmt.Sprintf("%s=%016x", keyTraceID128, ctx.traceIDUpper))
+
+		extracted, err := tracer.Extract(headers)
+		assert.Nil(err)
+		sctx := extracted.(*spanContext)
+		assert.Equal(ctx.traceID, sctx.traceID)
+		assert.Equal(ctx.traceIDUpper, sctx.traceIDUpper)
+
+		// spans continuing the trace keep the full trace ID
+		child := tracer.StartSpan("db.query", ChildOf(sctx)).(*span)
+		assert.Equal(ctx.TraceID128(), child.context.TraceID128())
+	})
+
+	t.Run("datadog-malformed", func(t *testing.T) {
+		assert := assert.New(t)
+		tracer := newTracer()
+		defer tracer.Stop()
+		headers := TextMapCarrier(map[string]string{
+			DefaultTraceIDHeader:  "1",
+			DefaultParentIDHeader: "1",
+			traceTagsHeader:       keyTraceID128 + "=not-hex",
+		})
+		extracted, err := tracer.Extract(headers)
+		assert.Nil(err)
+		sctx := extracted.(*spanContext)
+		assert.Equal(uint64(0), sctx.traceIDUpper)
+		assert.NotContains(sctx.trace.propagatingTags, keyTraceID128)
+		assert.Equal("malformed_tid not-hex", sctx.trace.tags[keyPropagationError])
+	})
+}
+
 func assertTraceTags(t *testing.T, expected, actual string) {
 	assert.ElementsMatch(t, strings.Split(expected, ","), strings.Split(actual, ","))
 }
+
+func TestW3C(t *testing.T) {
+	t.Run("inject", func(t *testing.T) {
+		tracer := newTracer(WithPropagator(NewPropagator(&PropagatorConfig{TraceContext: true})))
+		defer tracer.Stop()
+
+		root := tracer.StartSpan("web.request").(*span)
+		root.SetTag(ext.SamplingPriority, ext.PriorityUserKeep)
+		ctx := root.Context().(*spanContext)
+		ctx.traceIDUpper = 0x1
+		ctx.origin = "synthetics;browser"
+		headers := TextMapCarrier(map[string]string{})
+		assert.NoError(t, tracer.Inject(ctx, headers))
+
+		assert.Equal(t, fmt.Sprintf("00-%016x%016x-%016x-01", uint64(1), root.TraceID, root.SpanID), headers[traceparentHeader])
+		assert.Equal(t, "dd=s:2;o:synthetics_browser", headers[tracestateHeader])
+		// the Datadog headers are still injected
+		assert.Equal(t, strconv.FormatUint(root.TraceID, 10), headers[DefaultTraceIDHeader])
+	})
+
+	t.Run("vendors", func(t *testing.T) {
+		tracer := newTracer(WithPropagator(NewPropagator(&PropagatorConfig{TraceContext: true})))
+		defer tracer.Stop()
+
+		in := TextMapCarrier(map[string]string{
+			traceparentHeader: "00-00000000000000000000000000000001-0000000000000002-01",
+			tracestateHeader:  "rojo=00f067aa0ba902b7, dd=s:2;o:rum,congo=t61rcWkgMzE",
+		})
+		sctx, err := tracer.Extract(in)
+		assert.NoError(t, err)
+		assert.Equal(t, "rojo=00f067aa0ba902b7,congo=t61rcWkgMzE", sctx.(*spanContext).tracestate)
+
+		// the entries of the other vendors are propagated after the Datadog one
+		child := tracer.StartSpan("web.request", ChildOf(sctx))
+		out := TextMapCarrier(map[string]string{})
+		assert.NoError(t, tracer.Inject(child.Context(), out))
+		assert.Equal(t, "dd=s:2;o:rum,rojo=00f067aa0ba902b7,congo=t61rcWkgMzE", out[tracestateHeader])
+
+		// the last entries are dropped beyond the maximum
+		ctx := child.Context().(*spanContext)
+		vendors := make([]string, maxTracestateEntries)
+		for i := range vendors {
+			vendors[i] = fmt.Sprintf("v%d=%d", i, i)
+		}
+		ctx.tracestate = strings.Join(vendors, ",")
+		assert.NoError(t, tracer.Inject(ctx, out))
+		entries := strings.Split(out[tracestateHeader], ",")
+		assert.Len(t, entries, maxTracestateEntries)
+		assert.Equal(t, "dd=s:2;o:rum", entries[0])
+		assert.Equal(t, vendors[:maxTracestateEntries-1], entries[1:])
+	})
+
+	t.Run("extract", func(t *testing.T) {
+		os.Setenv("DD_PROPAGATION_STYLE_EXTRACT", "tracecontext")
+		defer os.Unsetenv("DD_PROPAGATION_STYLE_EXTRACT")
+		tracer := newTracer()
+		defer tracer.Stop()
+
+		for _, tc := range []struct {
+			name        string
+			traceparent string
+			tracestate  string
+			traceID     uint64
+			upper       uint64
+			spanID      uint64
+			priority    int
+			origin      string
+		}{
+			{
+				name:        "sampled",
+				traceparent: "00-00000000000000000000000000000001-0000000000000002-01",
+				traceID:     1,
+				spanID:      2,
+				priority:    ext.PriorityAutoKeep,
+			},
+			{
+				name:        "not-sampled",
+				traceparent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00",
+				traceID:     0xa3ce929d0e0e4736,
+				upper:       0x4bf92f3577b34da6,
+				spanID:      0x00f067aa0ba902b7,
+				priority:    ext.PriorityAutoReject,
+			},
+			{
+				name:        "tracestate",
+				traceparent: "00-00000000000000000000000000000001-0000000000000002-01",
+				tracestate:  "other=value,dd=s:2;o:synthetics",
+				traceID:     1,
+				spanID:      2,
+				priority:    ext.PriorityUserKeep,
+				origin:      "synthetics",
+			},
+			{
+				name:        "tracestate-disagrees",
+				traceparent: "00-00000000000000000000000000000001-0000000000000002-00",
+				tracestate:  "dd=s:2",
+				traceID:     1,
+				spanID:      2,
+				priority:    ext.PriorityAutoReject,
+			},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				headers := TextMapCarrier(map[string]string{traceparentHeader: tc.traceparent})
+				if tc.tracestate != "" {
+					headers[tracestateHeader] = tc.tracestate
+				}
+				sctx, err := tracer.Extract(headers)
+				assert.NoError(t, err)
+				ctx, ok := sctx.(*spanContext)
+				assert.True(t, ok)
+				assert.Equal(t, tc.traceID, ctx.traceID)
+				assert.Equal(t, tc.upper, ctx.traceIDUpper)
+				assert.Equal(t, tc.spanID, ctx.spanID)
+				p, ok := ctx.samplingPriority()
+				assert.True(t, ok)
+				assert.Equal(t, tc.priority, p)
+				assert.Equal(t, tc.origin, ctx.origin)
+			})
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		os.Setenv("DD_PROPAGATION_STYLE_EXTRACT", "tracecontext")
+		defer os.Unsetenv("DD_PROPAGATION_STYLE_EXTRACT")
+		tracer := newTracer()
+		defer tracer.Stop()
+
+		for _, traceparent := range []string{
+			"00-00000000000000000000000000000001-0000000000000002",
+			"ff-00000000000000000000000000000001-0000000000000002-01",
+			"00-0000000000000000000000000000001-0000000000000002-01",
+			"00-00000000000000000000000000000001-0000000000000002-01-extra",
+			"00-00000000000000000000000000000000-0000000000000002-01",
+			"00-00000000000000000000000000000001-0000000000000000-01",
+			"00-0000000000000000000000000000000A-0000000000000002-01",
+		} {
+			_, err := tracer.Extract(TextMapCarrier(map[string]string{traceparentHeader: traceparent}))
+			assert.Equal(t, ErrSpanContextCorrupted, err, traceparent)
+		}
+		_, err := tracer.Extract(TextMapCarrier(map[string]string{}))
+		assert.Equal(t, ErrSpanContextNotFound, err)
+	})
+}
+
+func TestB3SingleHeader(t *testing.T) {
+	t.Run("inject", func(t *testing.T) {
+		os.Setenv("DD_PROPAGATION_STYLE_INJECT", "b3 single header")
+		defer os.Unsetenv("DD_PROPAGATION_STYLE_INJECT")
+		tracer := newTracer()
+		defer tracer.Stop()
+
+		root := tracer.StartSpan("web.request").(*span)
+		root.SetTag(ext.SamplingPriority, ext.PriorityUserReject)
+		ctx := root.Context().(*spanContext)
+		headers := TextMapCarrier(map[string]string{})
+		assert.NoError(t, tracer.Inject(ctx, headers))
+		assert.Equal(t, fmt.Sprintf("%016x-%016x-0", root.TraceID, root.SpanID), headers[b3SingleHeader])
+		assert.NotContains(t, headers, DefaultTraceIDHeader)
+
+		ctx.traceIDUpper = 0x1
+		assert.NoError(t, tracer.Inject(ctx, headers))
+		assert.Equal(t, fmt.Sprintf("%016x%016x-%016x-0", uint64(1), root.TraceID, root.SpanID), headers[b3SingleHeader])
+	})
+
+	t.Run("extract", func(t *testing.T) {
+		os.Setenv("DD_PROPAGATION_STYLE_EXTRACT", "b3 single header")
+		defer os.Unsetenv("DD_PROPAGATION_STYLE_EXTRACT")
+		tracer := newTracer()
+		defer tracer.Stop()
+
+		for _, tc := range []struct {
+			in       string
+			traceID  uint64
+			upper    uint64
+			spanID   uint64
+			priority int
+			sampled  bool
+		}{
+			{"0000000000000001-0000000000000002", 1, 0, 2, 0, false},
+			{"0000000000000001-0000000000000002-1", 1, 0, 2, ext.PriorityAutoKeep, true},
+			{"0000000000000001-0000000000000002-0-0000000000000003", 1, 0, 2, ext.PriorityAutoReject, true},
+			{"0000000000000001-0000000000000002-d", 1, 0, 2, ext.PriorityUserKeep, true},
+			{"6e96719ded9c1864a21ba1551789e3f5-a1eb5bf36e56e50e-1", 0xa21ba1551789e3f5, 0x6e96719ded9c1864, 0xa1eb5bf36e56e50e, ext.PriorityAutoKeep, true},
+		} {
+			t.Run(tc.in, func(t *testing.T) {
+				sctx, err := tracer.Extract(TextMapCarrier(map[string]string{b3SingleHeader: tc.in}))
+				assert.NoError(t, err)
+				ctx, ok := sctx.(*spanContext)
+				assert.True(t, ok)
+				assert.Equal(t, tc.traceID, ctx.traceID)
+				assert.Equal(t, tc.upper, ctx.traceIDUpper)
+				assert.Equal(t, tc.spanID, ctx.spanID)
+				p, ok := ctx.samplingPriority()
+				assert.Equal(t, tc.sampled, ok)
+				assert.Equal(t, tc.priority, p)
+			})
+		}
+
+		_, err := tracer.Extract(TextMapCarrier(map[string]string{b3SingleHeader: "1"}))
+		assert.Equal(t, ErrSpanContextNotFound, err)
+		_, err = tracer.Extract(TextMapCarrier(map[string]string{b3SingleHeader: "xyz-0000000000000002-1"}))
+		assert.Equal(t, ErrSpanContextCorrupted, err)
+		_, err = tracer.Extract(TextMapCarrier(map[string]string{b3SingleHeader: "0000000000000001-0000000000000002-x"}))
+		assert.Equal(t, ErrSpanContextCorrupted, err)
+	})
+
+	t.Run("round-trip", func(t *testing.T) {
+		tracer := newTracer(WithPropagator(NewPropagator(&PropagatorConfig{B3: true, B3SingleHeader: true})))
+		defer tracer.Stop()
+
+		root := tracer.StartSpan("web.request").(*span)
+		root.SetTag(ext.SamplingPriority, ext.PriorityAutoKeep)
+		headers := TextMapCarrier(map[string]string{})
+		assert.NoError(t, tracer.Inject(root.Context(), headers))
+		assert.Contains(t, headers, b3TraceIDHeader)
+		assert.Contains(t, headers, b3SingleHeader)
+
+		// only keep the B3 single header
+		sctx, err := tracer.Extract(TextMapCarrier(map[string]string{b3SingleHeader: headers[b3SingleHeader]}))
+		assert.NoError(t, err)
+		assert.Equal(t, root.TraceID, sctx.TraceID())
+		assert.Equal(t, root.SpanID, sctx.SpanID())
+	})
+}
+
+func TestPropagationStyle(t *testing.T) {
+	headers := TextMapCarrier(map[string]string{
+		DefaultTraceIDHeader:  "1",
+		DefaultParentIDHeader: "1",
+		b3TraceIDHeader:       "0000000000000002",
+		b3SpanIDHeader:        "0000000000000002",
+		b3SingleHeader:        "0000000000000003-0000000000000003",
+	})
+	for _, tc := range []struct {
+		style   string
+		traceID uint64
+	}{
+		{"datadog,b3multi,b3 single header", 1},
+		{"b3multi,datadog", 2},
+		{"b3 single header, b3multi, datadog", 3},
+		{"unknown", 1},
+	} {
+		t.Run(tc.style, func(t *testing.T) {
+			os.Setenv("DD_TRACE_PROPAGATION_STYLE", tc.style)
+			defer os.Unsetenv("DD_TRACE_PROPAGATION_STYLE")
+			tracer := newTracer()
+			defer tracer.Stop()
+
+			// the first extractor of the list finding a span context wins
+			sctx, err := tracer.Extract(headers)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.traceID, sctx.TraceID())
+		})
+	}
+
+	t.Run("specific", func(t *testing.T) {
+		os.Setenv("DD_TRACE_PROPAGATION_STYLE", "b3multi")
+		defer os.Unsetenv("DD_TRACE_PROPAGATION_STYLE")
+		os.Setenv("DD_PROPAGATION_STYLE_EXTRACT", "b3 single header")
+		defer os.Unsetenv("DD_PROPAGATION_STYLE_EXTRACT")
+		tracer := newTracer()
+		defer tracer.Stop()
+
+		// DD_PROPAGATION_STYLE_EXTRACT takes precedence for extraction
+		sctx, err := tracer.Extract(headers)
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(3), sctx.TraceID())
+
+		// DD_TRACE_PROPAGATION_STYLE still applies to injection
+		out := TextMapCarrier(map[string]string{})
+		assert.NoError(t, tracer.Inject(sctx, out))
+		assert.Equal(t, "0000000000000003", out[b3TraceIDHeader])
+		assert.NotContains(t, out, b3SingleHeader)
+	})
+}
+
+func TestCompositePropagator(t *testing.T) {
+	t.Run("inject", func(t *testing.T) {
+		os.Setenv("DD_TRACE_PROPAGATION_STYLE_INJECT", "datadog,tracecontext,b3multi,b3 single header")
+		defer os.Unsetenv("DD_TRACE_PROPAGATION_STYLE_INJECT")
+		tracer := newTracer()
+		defer tracer.Stop()
+
+		root := tracer.StartSpan("web.request").(*span)
+		root.SetTag(ext.SamplingPriority, ext.PriorityAutoKeep)
+		headers := TextMapCarrier(map[string]string{})
+		assert.NoError(t, tracer.Inject(root.Context(), headers))
+
+		assert.Equal(t, strconv.FormatUint(root.TraceID, 10), headers[DefaultTraceIDHeader])
+		assert.Equal(t, strconv.FormatUint(root.SpanID, 10), headers[DefaultParentIDHeader])
+		assert.Equal(t, fmt.Sprintf("00-%032x-%016x-01", root.TraceID, root.SpanID), headers[traceparentHeader])
+		assert.Equal(t, fmt.Sprintf("%016x", root.TraceID), headers[b3TraceIDHeader])
+		assert.Equal(t, fmt.Sprintf("%016x", root.SpanID), headers[b3SpanIDHeader])
+		assert.Equal(t, fmt.Sprintf("%016x-%016x-1", root.TraceID, root.SpanID), headers[b3SingleHeader])
+	})
+
+	t.Run("extract", func(t *testing.T) {
+		os.Setenv("DD_TRACE_PROPAGATION_STYLE_EXTRACT", "tracecontext,datadog,b3multi")
+		defer os.Unsetenv("DD_TRACE_PROPAGATION_STYLE_EXTRACT")
+		tracer := newTracer()
+		defer tracer.Stop()
+
+		for _, tc := range []struct {
+			name    string
+			headers map[string]string
+			traceID uint64
+			err     error
+		}{
+			{
+				name: "first",
+				headers: map[string]string{
+					traceparentHeader:     "00-00000000000000000000000000000001-0000000000000001-01",
+					DefaultTraceIDHeader:  "2",
+					DefaultParentIDHeader: "2",
+				},
+				traceID: 1,
+			},
+			{
+				name: "missing",
+				headers: map[string]string{
+					DefaultTraceIDHeader:  "2",
+					DefaultParentIDHeader: "2",
+				},
+				traceID: 2,
+			},
+			{
+				name: "corrupted",
+				headers: map[string]string{
+					traceparentHeader: "00-corrupted",
+					b3TraceIDHeader:   "0000000000000003",
+					b3SpanIDHeader:    "0000000000000003",
+				},
+				traceID: 3,
+			},
+			{
+				name: "all-corrupted",
+				headers: map[string]string{
+					traceparentHeader:    "00-corrupted",
+					DefaultTraceIDHeader: "corrupted",
+				},
+				err: ErrSpanContextCorrupted,
+			},
+			{
+				name:    "none",
+				headers: map[string]string{},
+				err:     ErrSpanContextNotFound,
+			},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				sctx, err := tracer.Extract(TextMapCarrier(tc.headers))
+				if tc.err != nil {
+					assert.Equal(t, tc.err, err)
+					return
+				}
+				assert.NoError(t, err)
+				assert.Equal(t, tc.traceID, sctx.TraceID())
+			})
+		}
+	})
+
+	t.Run("deprecated", func(t *testing.T) {
+		os.Setenv("DD_PROPAGATION_STYLE_EXTRACT", "datadog")
+		defer os.Unsetenv("DD_PROPAGATION_STYLE_EXTRACT")
+		os.Setenv("DD_TRACE_PROPAGATION_STYLE_EXTRACT", "b3multi")
+		defer os.Unsetenv("DD_TRACE_PROPAGATION_STYLE_EXTRACT")
+		tracer := newTracer()
+		defer tracer.Stop()
+
+		// DD_TRACE_PROPAGATION_STYLE_EXTRACT takes precedence
+		_, err := tracer.Extract(TextMapCarrier(map[string]string{
+			DefaultTraceIDHeader:  "1",
+			DefaultParentIDHeader: "1",
+		}))
+		assert.Equal(t, ErrSpanContextNotFound, err)
+	})
+}
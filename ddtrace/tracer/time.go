@@ -15,3 +15,6 @@ var nowTime func() time.Time = func() time.Time { return time.Now() }
 
 // now returns the current UNIX time in nanoseconds, as computed by Time.UnixNano().
 var now func() int64 = func() int64 { return time.Now().UnixNano() }
+
+// monotonicNow returns the current time, carrying a monotonic clock reading.
+var monotonicNow func() time.Time = time.Now
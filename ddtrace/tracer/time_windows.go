@@ -46,3 +46,6 @@ var nowTime func() time.Time = func() func() time.Time {
 		return func() time.Time { return time.Unix(0, highPrecisionNow()) }
 	}
 }()
+
+// monotonicNow returns the current time, carrying a monotonic clock reading.
+var monotonicNow func() time.Time = time.Now
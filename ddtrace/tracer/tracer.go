@@ -7,6 +7,7 @@ package tracer
 
 import (
 	gocontext "context"
+	"errors"
 	"os"
 	"runtime/pprof"
 	rt "runtime/trace"
@@ -19,8 +20,10 @@ import (
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/internal"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/appsec"
+	"gopkg.in/DataDog/dd-trace-go.v1/internal/datastreams"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/log"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/remoteconfig"
+	"gopkg.in/DataDog/dd-trace-go.v1/internal/samplernames"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/traceprof"
 
 	"github.com/DataDog/datadog-agent/pkg/obfuscate"
@@ -43,6 +46,10 @@ type tracer struct {
 	// stats are enabled.
 	stats *concentrator
 
+	// dataStreams aggregates the latencies of the Data Streams Monitoring
+	// pathway checkpoints and sends them to the agent.
+	dataStreams *datastreams.Processor
+
 	// traceWriter is responsible for sending finished traces to their
 	// destination, such as the Trace Agent or Datadog Forwarder.
 	traceWriter traceWriter
@@ -54,6 +61,10 @@ type tracer struct {
 	// triggered and completed.
 	flush chan chan<- struct{}
 
+	// drain receives the requests to flush the finished traces and to confirm
+	// once they have been sent to their destination.
+	drain chan drainRequest
+
 	// stop causes the tracer to shut down when closed.
 	stop chan struct{}
 
@@ -73,6 +84,9 @@ type tracer struct {
 	// finished, and dropped
 	spansStarted, spansFinished, tracesDropped uint32
 
+	// tracesOverflowed counts the traces dropped because the buffer was full.
+	tracesOverflowed uint32
+
 	// Records the number of dropped P0 traces and spans.
 	droppedP0Traces, droppedP0Spans uint32
 
@@ -124,6 +138,7 @@ func Start(opts ...StartOption) {
 		return
 	}
 	internal.SetGlobalTracer(t)
+	datastreams.SetGlobalProcessor(t.dataStreams)
 	if t.config.logStartup {
 		logStartup(t)
 	}
@@ -134,12 +149,17 @@ func Start(opts ...StartOption) {
 	cfg.Env = t.config.env
 	cfg.HTTP = t.config.httpClient
 	cfg.ServiceName = t.config.serviceName
-	appsec.Start(appsec.WithRCConfig(cfg))
+	appsec.Start(append([]appsec.StartOption{appsec.WithRCConfig(cfg)}, t.config.appsecStartOptions...)...)
+	if err := appsec.StartupError(); err != nil {
+		// Report the misconfigured security rules which disabled AppSec
+		t.config.statsd.Incr("datadog.tracer.appsec.init_error", []string{"reason:" + err.Reason}, 1)
+	}
 }
 
 // Stop stops the started tracer. Subsequent calls are valid but become no-op.
 func Stop() {
 	internal.SetGlobalTracer(&internal.NoopTracer{})
+	datastreams.SetGlobalProcessor(nil)
 	log.Flush()
 }
 
@@ -186,11 +206,16 @@ func SetUser(s Span, id string, opts ...UserMonitoringOption) {
 	sp.SetUser(id, opts...)
 }
 
-// payloadQueueSize is the buffer size of the trace channel.
+// payloadQueueSize is the default buffer size of the trace channel.
 const payloadQueueSize = 1000
 
 func newUnstartedTracer(opts ...StartOption) *tracer {
 	c := newConfig(opts...)
+	if c.randSource != nil {
+		randSource.setSource(c.randSource)
+	} else {
+		randSource.setSource(defaultRandSource)
+	}
 	sampler := newPrioritySampler()
 	var writer traceWriter
 	if c.logToStdout {
@@ -211,13 +236,15 @@ func newUnstartedTracer(opts ...StartOption) *tracer {
 	t := &tracer{
 		config:           c,
 		traceWriter:      writer,
-		out:              make(chan *finishedTrace, payloadQueueSize),
+		out:              make(chan *finishedTrace, c.traceBufferLimit),
 		stop:             make(chan struct{}),
 		flush:            make(chan chan<- struct{}),
+		drain:            make(chan drainRequest),
 		rulesSampling:    newRulesSampler(c.traceRules, c.spanRules),
 		prioritySampling: sampler,
 		pid:              os.Getpid(),
 		stats:            newConcentrator(c, defaultStatsBucketSize),
+		dataStreams:      datastreams.NewProcessor(c.agentURL, c.httpClient, c.serviceName, c.env),
 		obfuscator: obfuscate.NewObfuscator(obfuscate.Config{
 			SQL: obfuscate.SQLConfig{
 				TableNames:       c.agent.HasFlag("table_names"),
@@ -247,7 +274,7 @@ func newTracer(opts ...StartOption) *tracer {
 	go func() {
 		defer t.wg.Done()
 		tick := t.config.tickChan
-		if tick == nil {
+		if tick == nil && !t.config.syncFlush {
 			ticker := time.NewTicker(flushInterval)
 			defer ticker.Stop()
 			tick = ticker.C
@@ -272,9 +299,14 @@ func newTracer(opts ...StartOption) *tracer {
 // the tracer on each invokation may create too much latency. In this
 // scenario, a tracer may be started and stopped by the parent process
 // whereas the invokation can make use of Flush to ensure any created spans
-// reach the agent.
+// reach the agent. With WithSyncFlush, Flush also waits for the traces to
+// be sent.
 func Flush() {
 	if t, ok := internal.GetGlobalTracer().(*tracer); ok {
+		if t.config.syncFlush {
+			t.sendSync()
+			return
+		}
 		t.flushSync()
 	}
 }
@@ -286,6 +318,67 @@ func (t *tracer) flushSync() {
 	<-done
 }
 
+// sendSync sends the finished traces and waits for the agent to receive them,
+// when the tracer is configured with WithSyncFlush.
+func (t *tracer) sendSync() {
+	select {
+	case <-t.stop:
+		return
+	default:
+	}
+	if err := t.drainSync(defaultHTTPTimeout); err != nil {
+		log.Error("Error sending the traces synchronously: %v", err)
+	}
+}
+
+// ErrFlushTimeout is returned by FlushWithTimeout when the traces could not be
+// sent before the timeout elapsed.
+var ErrFlushTimeout = errors.New("tracer: timed out flushing the traces")
+
+// FlushWithTimeout sends every finished trace not yet sent and waits until the
+// agent received them, or until the timeout elapses, in which case
+// ErrFlushTimeout is returned. FlushWithTimeout is in effect only if a tracer
+// is started.
+//
+// Unlike Flush, it waits for the traces to be actually sent, which makes it of
+// use on the shutdown of short-lived processes, such as serverless functions
+// or batch jobs, when the tracer cannot be stopped. Note that the traces it
+// still sends when the timeout elapses are aborted, and then lost.
+func FlushWithTimeout(timeout time.Duration) error {
+	if t, ok := internal.GetGlobalTracer().(*tracer); ok {
+		return t.drainSync(timeout)
+	}
+	return nil
+}
+
+// drainRequest is a request to the worker to flush the finished traces, and to
+// confirm on done once they were sent. The payload sent is aborted once ctx is
+// done.
+type drainRequest struct {
+	ctx  gocontext.Context
+	done chan<- struct{}
+}
+
+// drainSync triggers a flush of the finished traces and waits for them to be
+// sent, or for the timeout to elapse, in which case their send is aborted.
+func (t *tracer) drainSync(timeout time.Duration) error {
+	ctx, cancel := gocontext.WithTimeout(gocontext.Background(), timeout)
+	defer cancel()
+	// buffered so that the worker doesn't block when the timeout elapsed
+	done := make(chan struct{}, 1)
+	select {
+	case t.drain <- drainRequest{ctx: ctx, done: done}:
+	case <-ctx.Done():
+		return ErrFlushTimeout
+	}
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ErrFlushTimeout
+	}
+}
+
 // worker receives finished traces to be added into the payload, as well
 // as periodically flushes traces to the transport.
 func (t *tracer) worker(tick <-chan time.Time) {
@@ -308,21 +401,37 @@ func (t *tracer) worker(tick <-chan time.Time) {
 			// in Lambda so for that purpose this mechanism should suffice.
 			done <- struct{}{}
 
+		case req := <-t.drain:
+			t.config.statsd.Incr("datadog.tracer.flush_triggered", []string{"reason:invoked"}, 1)
+			t.drainFinishedTraces()
+			if w, ok := t.traceWriter.(asyncTraceWriter); ok {
+				w.flushContext(req.ctx)
+				w.wait()
+			} else {
+				t.traceWriter.flush()
+			}
+			req.done <- struct{}{}
+
 		case <-t.stop:
-		loop:
-			// the loop ensures that the payload channel is fully drained
-			// before the final flush to ensure no traces are lost (see #526)
-			for {
-				select {
-				case trace := <-t.out:
-					t.sampleFinishedTrace(trace)
-					if len(trace.spans) != 0 {
-						t.traceWriter.add(trace.spans)
-					}
-				default:
-					break loop
-				}
+			// the payload channel is fully drained before the final flush to
+			// ensure no traces are lost (see #526)
+			t.drainFinishedTraces()
+			return
+		}
+	}
+}
+
+// drainFinishedTraces adds the finished traces still waiting in the payload
+// channel to the trace writer.
+func (t *tracer) drainFinishedTraces() {
+	for {
+		select {
+		case trace := <-t.out:
+			t.sampleFinishedTrace(trace)
+			if len(trace.spans) != 0 {
+				t.traceWriter.add(trace.spans)
 			}
+		default:
 			return
 		}
 	}
@@ -371,9 +480,22 @@ func (t *tracer) pushTrace(trace *finishedTrace) {
 	default:
 	}
 	select {
+	case t.out <- trace:
+		return
+	default:
+	}
+	// the buffer is full: drop the oldest trace to make room for this one
+	select {
+	case <-t.out:
+	default:
+	}
+	atomic.AddUint32(&t.tracesOverflowed, 1)
+	log.Error("payload queue full (limit: %d), dropping the oldest traces", cap(t.out))
+	select {
 	case t.out <- trace:
 	default:
-		log.Error("payload queue full, dropping %d traces", len(trace.spans))
+		// another goroutine took the room made above
+		atomic.AddUint32(&t.tracesOverflowed, 1)
 	}
 }
 
@@ -383,11 +505,18 @@ func (t *tracer) StartSpan(operationName string, options ...ddtrace.StartSpanOpt
 	for _, fn := range options {
 		fn(&opts)
 	}
-	var startTime int64
-	if opts.StartTime.IsZero() {
-		startTime = now()
-	} else {
+	var (
+		startTime      int64
+		monotonicStart time.Time
+	)
+	switch {
+	case !opts.StartTime.IsZero():
 		startTime = opts.StartTime.UnixNano()
+	case t.config.monotonicDurations:
+		monotonicStart = monotonicNow()
+		startTime = monotonicStart.UnixNano()
+	default:
+		startTime = now()
 	}
 	var context *spanContext
 	// The default pprof context is taken from the start options and is
@@ -429,6 +558,7 @@ func (t *tracer) StartSpan(operationName string, options ...ddtrace.StartSpanOpt
 		taskEnd:      startExecutionTracerTask(operationName),
 		noDebugStack: t.config.noDebugStack,
 	}
+	span.monotonicStart = monotonicStart
 	if t.config.hostname != "" {
 		span.setMeta(keyHostname, t.config.hostname)
 	}
@@ -453,16 +583,22 @@ func (t *tracer) StartSpan(operationName string, options ...ddtrace.StartSpanOpt
 		}
 	}
 	span.context = newSpanContext(span, context)
+	if context == nil && t.config.traceID128Bit {
+		span.context.setTraceIDUpper(generateTraceIDUpper(startTime))
+	}
 	span.setMetric(ext.Pid, float64(t.pid))
 	span.setMeta("language", "go")
 
+	// add global tags, which the tags from options override
+	for k, v := range t.config.globalTags {
+		span.SetTag(k, v)
+	}
 	// add tags from options
 	for k, v := range opts.Tags {
 		span.SetTag(k, v)
 	}
-	// add global tags
-	for k, v := range t.config.globalTags {
-		span.SetTag(k, v)
+	if len(opts.Links) > 0 {
+		span.setLinks(opts.Links)
 	}
 	if t.config.serviceMappings != nil {
 		if newSvc, ok := t.config.serviceMappings[span.Service]; ok {
@@ -550,6 +686,7 @@ func (t *tracer) Stop() {
 		t.config.statsd.Incr("datadog.tracer.stopped", nil, 1)
 	})
 	t.stats.Stop()
+	t.dataStreams.Stop()
 	t.wg.Wait()
 	t.traceWriter.stop()
 	t.config.statsd.Close()
@@ -558,6 +695,13 @@ func (t *tracer) Stop() {
 
 // Inject uses the configured or default TextMap Propagator.
 func (t *tracer) Inject(ctx ddtrace.SpanContext, carrier interface{}) error {
+	if c, ok := ctx.(*spanContext); ok && c.trace != nil {
+		// the sampling decision leaves the process: it must not change anymore
+		// on finish, or downstream services would make a different one
+		c.trace.mu.Lock()
+		c.trace.propagated = true
+		c.trace.mu.Unlock()
+	}
 	return t.config.propagator.Inject(ctx, carrier)
 }
 
@@ -589,6 +733,36 @@ func (t *tracer) sample(span *span) {
 	t.prioritySampling.apply(span)
 }
 
+// sampleOnFinish applies the trace sampling rules matching on span tags to the
+// given span when it is the local root of its trace, as its tags might have
+// been set after the sampling decision was made. Decisions made by the user,
+// by AppSec or upstream are left untouched, as well as the decisions which
+// were already injected or partially flushed.
+func (t *tracer) sampleOnFinish(s *span) {
+	if !t.rulesSampling.traces.tagsRules {
+		return
+	}
+	trace := s.context.trace
+	if trace == nil || trace.root != s {
+		return
+	}
+	s.RLock()
+	skip := s.finished || s.ParentID != 0
+	s.RUnlock()
+	if skip {
+		return
+	}
+	trace.mu.RLock()
+	sampler, propagated := trace.sampler, trace.propagated
+	p, _ := trace.samplingPriorityLocked()
+	trace.mu.RUnlock()
+	if propagated || (sampler != samplernames.RuleRate && sampler != samplernames.AgentRate) {
+		return
+	}
+	// a trace kept by a rule was already allowed by the rate limiter
+	t.rulesSampling.SampleTraceOnFinish(s, sampler == samplernames.RuleRate && p == ext.PriorityUserKeep)
+}
+
 func startExecutionTracerTask(name string) func() {
 	if !rt.IsEnabled() {
 		return func() {}
@@ -11,6 +11,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math/rand"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -18,6 +19,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -28,6 +30,7 @@ import (
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/internal"
 	maininternal "gopkg.in/DataDog/dd-trace-go.v1/internal"
+	"gopkg.in/DataDog/dd-trace-go.v1/internal/datastreams"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/globalconfig"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/log"
 )
@@ -157,6 +160,39 @@ func TestTracerCleanStop(t *testing.T) {
 	wg.Wait()
 }
 
+func TestTracerDataStreams(t *testing.T) {
+	var pipelineStats int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v0.1/pipeline_stats" {
+			atomic.AddInt32(&pipelineStats, 1)
+		}
+	}))
+	defer srv.Close()
+
+	Start(WithAgentAddr(srv.Listener.Addr().String()), WithLogStartup(false))
+	datastreams.NewPathway(time.Now(), "service", "env", "direction:out", "topic:orders", "type:kafka")
+	// stopping the tracer flushes the checkpoints recorded by its processor
+	Stop()
+	assert.EqualValues(t, 1, atomic.LoadInt32(&pipelineStats))
+
+	// the checkpoints aren't recorded anymore once the tracer is stopped
+	datastreams.NewPathway(time.Now(), "service", "env", "direction:out", "topic:orders", "type:kafka")
+	Stop()
+	assert.EqualValues(t, 1, atomic.LoadInt32(&pipelineStats))
+}
+
+func TestTracerRandSource(t *testing.T) {
+	source := rand.NewSource(42)
+	newUnstartedTracer(WithRandSource(source))
+	assert.Equal(t, source, randSource.source)
+
+	// the default source is seeded once, and not on each tracer construction
+	newUnstartedTracer()
+	assert.Equal(t, defaultRandSource, randSource.source)
+	newUnstartedTracer()
+	assert.Equal(t, defaultRandSource, randSource.source)
+}
+
 func TestTracerStart(t *testing.T) {
 	t.Run("normal", func(t *testing.T) {
 		Start()
@@ -309,7 +345,7 @@ func TestSamplingDecision(t *testing.T) {
 		// client-side stats are also enabled.
 		tracer, _, _, stop := startTestTracer(t)
 		defer stop()
-		tracer.config.agent.DropP0s = true
+		tracer.config.agentCache.features.DropP0s = true
 		tracer.prioritySampling.defaultRate = 0
 		tracer.config.serviceName = "test_service"
 		span := tracer.StartSpan("name_1").(*span)
@@ -326,8 +362,8 @@ func TestSamplingDecision(t *testing.T) {
 		defer stop()
 		tracer.config.featureFlags = make(map[string]struct{})
 		tracer.config.featureFlags["discovery"] = struct{}{}
-		tracer.config.agent.DropP0s = true
-		tracer.config.agent.Stats = true
+		tracer.config.agentCache.features.DropP0s = true
+		tracer.config.agentCache.features.Stats = true
 		tracer.prioritySampling.defaultRate = 0
 		tracer.config.serviceName = "test_service"
 		span := tracer.StartSpan("name_1").(*span)
@@ -342,7 +378,7 @@ func TestSamplingDecision(t *testing.T) {
 	t.Run("events_sampled", func(t *testing.T) {
 		tracer, _, _, stop := startTestTracer(t)
 		defer stop()
-		tracer.config.agent.DropP0s = true
+		tracer.config.agentCache.features.DropP0s = true
 		tracer.prioritySampling.defaultRate = 0
 		tracer.config.serviceName = "test_service"
 		span := tracer.StartSpan("name_1").(*span)
@@ -390,7 +426,7 @@ func TestSamplingDecision(t *testing.T) {
 			assert.Equal(t, uint32(1), tracer.droppedP0Spans)
 		}()
 		defer stop()
-		tracer.config.agent.DropP0s = true
+		tracer.config.agentCache.features.DropP0s = true
 		tracer.config.featureFlags = make(map[string]struct{})
 		tracer.config.featureFlags["discovery"] = struct{}{}
 		tracer.config.sampler = NewRateSampler(0)
@@ -471,7 +507,7 @@ func TestSamplingDecision(t *testing.T) {
 		// The trace should be kept. No single spans extracted.
 		tracer, _, _, stop := startTestTracer(t)
 		defer stop()
-		tracer.config.agent.DropP0s = true
+		tracer.config.agentCache.features.DropP0s = true
 		tracer.config.featureFlags = make(map[string]struct{})
 		tracer.config.sampler = NewRateSampler(1)
 		tracer.prioritySampling.defaultRate = 1
@@ -695,6 +731,64 @@ func TestTracerStartChildSpan(t *testing.T) {
 	})
 }
 
+func TestTracerTraceID128Bit(t *testing.T) {
+	t.Run("disabled", func(t *testing.T) {
+		assert := assert.New(t)
+		tracer := newTracer()
+		defer tracer.Stop()
+		root := tracer.StartSpan("web.request").(*span)
+
+		assert.Equal(uint64(0), root.context.traceIDUpper)
+		assert.NotContains(root.context.trace.propagatingTags, keyTraceID128)
+		assert.Equal(fmt.Sprintf("%016x%016x", 0, root.TraceID), root.context.TraceID128())
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		assert := assert.New(t)
+		tracer := newTracer(WithTraceID128Bit(true))
+		defer tracer.Stop()
+		start := time.Now()
+		root := tracer.StartSpan("web.request", StartTime(start)).(*span)
+		child := tracer.StartSpan("db.query", ChildOf(root.Context())).(*span)
+
+		// the lower 64 bits remain the root span ID, as with 64-bit trace IDs
+		assert.Equal(root.SpanID, root.TraceID)
+		assert.Equal(root.TraceID, child.TraceID)
+		upper := root.context.traceIDUpper
+		assert.Equal(uint64(start.Unix()), upper>>32)
+		assert.Equal(upper, child.context.traceIDUpper)
+		assert.Equal(fmt.Sprintf("%016x", upper), root.context.trace.propagatingTags[keyTraceID128])
+
+		id := child.context.TraceID128()
+		assert.Len(id, 32)
+		assert.Equal(fmt.Sprintf("%016x%016x", upper, root.TraceID), id)
+	})
+
+	t.Run("env", func(t *testing.T) {
+		t.Setenv("DD_TRACE_128_BIT_TRACEID_GENERATION_ENABLED", "true")
+		tracer := newTracer()
+		defer tracer.Stop()
+		root := tracer.StartSpan("web.request").(*span)
+		assert.NotEqual(t, uint64(0), root.context.traceIDUpper)
+	})
+
+	t.Run("unique", func(t *testing.T) {
+		tracer := newTracer(WithTraceID128Bit(true))
+		defer tracer.Stop()
+		const n = 10000
+		ids := make(map[string]struct{}, n)
+		uppers := make(map[uint64]struct{}, n)
+		for i := 0; i < n; i++ {
+			root := tracer.StartSpan("web.request").(*span)
+			ids[root.context.TraceID128()] = struct{}{}
+			uppers[root.context.traceIDUpper] = struct{}{}
+		}
+		assert.Len(t, ids, n)
+		// the upper 64 bits alone should be unique enough
+		assert.Greater(t, len(uppers), n-5)
+	})
+}
+
 func TestTracerBaggagePropagation(t *testing.T) {
 	assert := assert.New(t)
 	tracer := newTracer()
@@ -859,6 +953,16 @@ func TestTracerSpanGlobalTags(t *testing.T) {
 	assert.Equal("value", child.Meta["key"])
 }
 
+func TestTracerSpanGlobalTagsOverride(t *testing.T) {
+	assert := assert.New(t)
+	tracer := newTracer(WithGlobalTag("key", "global"), WithGlobalTag("region", "us-east-1"), WithGlobalTag(ext.ServiceName, "global-service"))
+	defer tracer.Stop()
+	s := tracer.StartSpan("web.request", Tag("key", "span"), ServiceName("span-service")).(*span)
+	assert.Equal("span", s.Meta["key"])
+	assert.Equal("us-east-1", s.Meta["region"])
+	assert.Equal("span-service", s.Service)
+}
+
 func TestTracerSpanServiceMappings(t *testing.T) {
 	assert := assert.New(t)
 
@@ -1224,6 +1328,59 @@ func TestTracerAtomicFlush(t *testing.T) {
 	assert.Len(traces[0], 4, "all spans should show up at once")
 }
 
+func TestTracerPartialFlush(t *testing.T) {
+	t.Run("enabled", func(t *testing.T) {
+		assert := assert.New(t)
+		tracer, transport, flush, stop := startTestTracer(t, WithPartialFlushing(2))
+		defer stop()
+
+		root := tracer.newRootSpan("root", "service", "resource")
+		children := make([]*span, 5)
+		for i := range children {
+			children[i] = tracer.newChildSpan("child", root)
+		}
+		children[0].Finish()
+		children[1].Finish()
+
+		flush(1)
+		traces := transport.Traces()
+		assert.Len(traces, 1, "the finished spans should be flushed before the root finishes")
+		assert.Len(traces[0], 2)
+		assert.Len(root.context.trace.spans, 4, "the flushed spans should be removed from the trace")
+
+		for _, c := range children[2:] {
+			c.Finish()
+		}
+		root.Finish()
+
+		flush(2)
+		traces = transport.Traces()
+		assert.Len(traces, 2)
+		assert.Len(traces[0], 2)
+		assert.Len(traces[1], 2)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		assert := assert.New(t)
+		tracer, transport, flush, stop := startTestTracer(t, WithPartialFlushing(0))
+		defer stop()
+
+		root := tracer.newRootSpan("root", "service", "resource")
+		for i := 0; i < 5; i++ {
+			tracer.newChildSpan("child", root).Finish()
+		}
+		flush(-1)
+		time.Sleep(100 * time.Millisecond)
+		assert.Len(transport.Traces(), 0, "partial flushing is disabled")
+
+		root.Finish()
+		flush(1)
+		traces := transport.Traces()
+		assert.Len(traces, 1)
+		assert.Len(traces[0], 6)
+	})
+}
+
 // TestTracerTraceMaxSize tests a bug that was encountered in environments
 // creating a large volume of spans that reached the trace cap value (traceMaxSize).
 // The bug was that once the cap is reached, no more spans are pushed onto
@@ -1455,6 +1612,55 @@ func TestPushTrace(t *testing.T) {
 	assert.True(len(tp.Lines()) >= 1)
 }
 
+func TestTraceBufferLimit(t *testing.T) {
+	assert := assert.New(t)
+	tp := new(testLogger)
+	defer log.UseLogger(tp)()
+	var tg testStatsdClient
+
+	// the worker isn't started, as if it were blocked on an unreachable agent
+	tracer := newUnstartedTracer(WithTraceBufferLimit(2), withStatsdClient(&tg))
+	for i := 0; i < 5; i++ {
+		tracer.pushTrace(&finishedTrace{spans: make([]*span, i)})
+	}
+	assert.Len(tracer.out, 2)
+	assert.Len((<-tracer.out).spans, 3, "the oldest traces should be dropped")
+	assert.Len((<-tracer.out).spans, 4)
+	assert.Equal(uint32(3), atomic.LoadUint32(&tracer.tracesOverflowed))
+
+	log.Flush()
+	// other errors, such as failing to reach the agent, may be logged as well
+	var drops []string
+	for _, line := range tp.Lines() {
+		if strings.Contains(line, "payload queue full") {
+			drops = append(drops, line)
+		}
+	}
+	assert.Len(drops, 1, "the drops should be reported once per window")
+
+	go tracer.reportHealthMetrics(time.Millisecond)
+	tg.Wait(3, time.Second)
+	close(tracer.stop)
+	assert.Equal(int64(3), tg.Counts()["datadog.tracer.traces_dropped"])
+}
+
+func TestTracerSyncFlush(t *testing.T) {
+	assert := assert.New(t)
+	// the ticker of startTestTracer is never triggered in this test
+	tracer, transport, _, stop := startTestTracer(t, WithSyncFlush())
+	defer stop()
+
+	root := tracer.StartSpan("root")
+	child := tracer.StartSpan("child", ChildOf(root.Context()))
+	child.Finish()
+	assert.Equal(0, transport.Len(), "the trace should only be sent once its root finishes")
+
+	root.Finish()
+	traces := transport.Traces()
+	assert.Len(traces, 1, "the trace should be sent when its root finishes")
+	assert.Len(traces[0], 2)
+}
+
 func TestTracerFlush(t *testing.T) {
 	// https://github.com/DataDog/dd-trace-go/issues/377
 	tracer, transport, flush, stop := startTestTracer(t)
@@ -1798,6 +2004,10 @@ func (t *dummyTransport) Stats() []*statsPayload {
 	return t.stats
 }
 
+func (t *dummyTransport) sendWithContext(_ context.Context, p *payload) (io.ReadCloser, error) {
+	return t.send(p)
+}
+
 func (t *dummyTransport) send(p *payload) (io.ReadCloser, error) {
 	traces, err := decode(p)
 	if err != nil {
@@ -1950,6 +2160,65 @@ loop:
 	assert.Len(t, tw.Flushed(), 1)
 }
 
+func TestFlushWithTimeout(t *testing.T) {
+	t.Run("sent", func(t *testing.T) {
+		var received uint32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/v0.4/traces" {
+				return
+			}
+			// slow agent: the traces are received after a while
+			time.Sleep(50 * time.Millisecond)
+			n, _ := strconv.Atoi(r.Header.Get(traceCountHeader))
+			atomic.AddUint32(&received, uint32(n))
+		}))
+		defer srv.Close()
+		tr, _, _, stop := startTestTracer(t, withTransport(newHTTPTransport(srv.URL, defaultClient)))
+		defer stop()
+
+		for i := 0; i < 10; i++ {
+			tr.StartSpan("op").Finish()
+		}
+		assert.NoError(t, FlushWithTimeout(5*time.Second))
+		// the agent received the traces before the process would exit
+		assert.EqualValues(t, 10, atomic.LoadUint32(&received))
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		release := make(chan struct{})
+		aborted := make(chan struct{})
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/v0.4/traces" {
+				return
+			}
+			// the aborted connection is only noticed once the body is read
+			io.Copy(io.Discard, r.Body)
+			select {
+			case <-release:
+			case <-r.Context().Done():
+				close(aborted)
+			}
+		}))
+		defer srv.Close()
+		tr, _, _, stop := startTestTracer(t, withTransport(newHTTPTransport(srv.URL, defaultClient)))
+		defer stop()
+		defer close(release)
+
+		tr.StartSpan("op").Finish()
+		assert.Equal(t, ErrFlushTimeout, FlushWithTimeout(200*time.Millisecond))
+		// the send to the hung agent is aborted
+		select {
+		case <-aborted:
+		case <-time.After(time.Second):
+			t.Fatal("the send was not aborted")
+		}
+	})
+
+	t.Run("no-tracer", func(t *testing.T) {
+		assert.NoError(t, FlushWithTimeout(time.Millisecond))
+	})
+}
+
 func TestTakeStackTrace(t *testing.T) {
 	t.Run("n=12", func(t *testing.T) {
 		val := takeStacktrace(12, 0)
@@ -2077,7 +2346,7 @@ func BenchmarkSingleSpanRetention(b *testing.B) {
 	b.Run("no-rules", func(b *testing.B) {
 		tracer, _, _, stop := startTestTracer(b)
 		defer stop()
-		tracer.config.agent.DropP0s = true
+		tracer.config.agentCache.features.DropP0s = true
 		tracer.config.featureFlags = make(map[string]struct{})
 		tracer.config.featureFlags["discovery"] = struct{}{}
 		tracer.config.sampler = NewRateSampler(0)
@@ -2099,7 +2368,7 @@ func BenchmarkSingleSpanRetention(b *testing.B) {
 		defer os.Unsetenv("DD_SPAN_SAMPLING_RULES")
 		tracer, _, _, stop := startTestTracer(b)
 		defer stop()
-		tracer.config.agent.DropP0s = true
+		tracer.config.agentCache.features.DropP0s = true
 		tracer.config.featureFlags = make(map[string]struct{})
 		tracer.config.featureFlags["discovery"] = struct{}{}
 		tracer.config.sampler = NewRateSampler(0)
@@ -2125,7 +2394,7 @@ func BenchmarkSingleSpanRetention(b *testing.B) {
 		defer os.Unsetenv("DD_SPAN_SAMPLING_RULES")
 		tracer, _, _, stop := startTestTracer(b)
 		defer stop()
-		tracer.config.agent.DropP0s = true
+		tracer.config.agentCache.features.DropP0s = true
 		tracer.config.featureFlags = make(map[string]struct{})
 		tracer.config.featureFlags["discovery"] = struct{}{}
 		tracer.config.sampler = NewRateSampler(0)
@@ -7,8 +7,12 @@ package tracer
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"math/rand"
 	"net"
 	"net/http"
 	"os"
@@ -16,10 +20,12 @@ import (
 	"strconv"
 	"strings"
 	"sync/atomic"
+	"syscall"
 	"time"
 
 	traceinternal "gopkg.in/DataDog/dd-trace-go.v1/ddtrace/internal"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal"
+	"gopkg.in/DataDog/dd-trace-go.v1/internal/log"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/version"
 
 	"github.com/tinylib/msgp/msgp"
@@ -66,6 +72,9 @@ type transport interface {
 	// send sends the payload p to the agent using the transport set up.
 	// It returns a non-nil response body when no error occurred.
 	send(p *payload) (body io.ReadCloser, err error)
+	// sendWithContext sends the payload p like send, but aborts sending it as
+	// soon as ctx is done.
+	sendWithContext(ctx context.Context, p *payload) (body io.ReadCloser, err error)
 	// sendStats sends the given stats payload to the agent.
 	sendStats(s *statsPayload) error
 	// endpoint returns the URL to which the transport will send traces.
@@ -73,10 +82,36 @@ type transport interface {
 }
 
 type httpTransport struct {
+	agentURL string            // the base URL of the agent, to which the endpoints of the encoders are relative
 	traceURL string            // the delivery URL for traces
+	v05URL   string            // the delivery URL for traces encoded in the v0.5 format
 	statsURL string            // the delivery URL for stats
 	client   *http.Client      // the HTTP client used in the POST
 	headers  map[string]string // the Transport headers
+	compress bool              // whether trace payloads are gzip-compressed
+
+	retries       int           // the number of times a failed payload send is retried
+	retryInterval time.Duration // the base interval between retries, growing exponentially
+	retryAfter    int64         // unix nano time before which no payload should be sent; accessed atomically
+
+	observer func(SendStats) // when set, called with the statistics of each payload send
+}
+
+// SendStats holds the statistics of sending a trace payload to the agent, as
+// reported to the observer set with WithSendObserver.
+type SendStats struct {
+	// Bytes is the size in bytes of the payload sent, after compression.
+	Bytes int
+	// Traces is the number of traces of the payload.
+	Traces int
+	// StatusCode is the HTTP status code of the last response of the agent,
+	// or 0 when the agent couldn't be reached.
+	StatusCode int
+	// Duration is the time spent sending the payload, including the delays
+	// before sending and retrying it.
+	Duration time.Duration
+	// Err is the error the send failed with, if any.
+	Err error
 }
 
 // newTransport returns a new Transport implementation that sends traces to a
@@ -99,7 +134,9 @@ func newHTTPTransport(url string, client *http.Client) *httpTransport {
 		defaultHeaders["Datadog-Container-ID"] = cid
 	}
 	return &httpTransport{
+		agentURL: url,
 		traceURL: fmt.Sprintf("%s/v0.4/traces", url),
+		v05URL:   fmt.Sprintf("%s/v0.5/traces", url),
 		statsURL: fmt.Sprintf("%s/v0.6/stats", url),
 		client:   client,
 		headers:  defaultHeaders,
@@ -135,19 +172,64 @@ func (t *httpTransport) sendStats(p *statsPayload) error {
 }
 
 func (t *httpTransport) send(p *payload) (body io.ReadCloser, err error) {
-	req, err := http.NewRequest("POST", t.traceURL, p)
-	if err != nil {
-		return nil, fmt.Errorf("cannot create http request: %v", err)
+	return t.sendWithContext(context.Background(), p)
+}
+
+// sendWithContext sends the payload p to the agent like send, but aborts the
+// in-flight request, along with the delays before sending or retrying it, as
+// soon as ctx is done. The context error is then returned.
+func (t *httpTransport) sendWithContext(ctx context.Context, p *payload) (body io.ReadCloser, err error) {
+	var (
+		size   int // size of the payload sent
+		status int // status code of the last response
+	)
+	if t.observer != nil {
+		defer func(start time.Time) {
+			t.observer(SendStats{
+				Bytes:      size,
+				Traces:     p.itemCount(),
+				StatusCode: status,
+				Duration:   time.Since(start),
+				Err:        err,
+			})
+		}(time.Now())
 	}
-	for header, value := range t.headers {
-		req.Header.Set(header, value)
+	if wait := time.Until(time.Unix(0, atomic.LoadInt64(&t.retryAfter))); wait > 0 {
+		// the agent asked to slow down with a Retry-After header
+		log.Debug("Delaying payload send by %s as requested by the agent", wait)
+		if err := sleepContext(ctx, wait); err != nil {
+			return nil, err
+		}
 	}
-	req.Header.Set(traceCountHeader, strconv.Itoa(p.itemCount()))
-	req.Header.Set("Content-Length", strconv.Itoa(p.size()))
-	req.Header.Set(headerComputedTopLevel, "yes")
+	// the content type of the payloads of an encoder is only known once encoded
+	contentType := p.contentType()
+	var data []byte
+	size = p.size()
+	if t.compress || t.retries > 0 {
+		// the payload can only be read once, so it needs to be buffered in order
+		// to be compressed or sent more than once.
+		data, err = t.bufferPayload(p)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read payload: %v", err)
+		}
+		size = len(data)
+	}
+	header := make(http.Header, len(t.headers)+7)
+	for k, v := range t.headers {
+		header.Set(k, v)
+	}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	if t.compress {
+		header.Set("Content-Encoding", "gzip")
+	}
+	header.Set(traceCountHeader, strconv.Itoa(p.itemCount()))
+	header.Set("Content-Length", strconv.Itoa(size))
+	header.Set(headerComputedTopLevel, "yes")
 	if t, ok := traceinternal.GetGlobalTracer().(*tracer); ok {
 		if t.config.canComputeStats() {
-			req.Header.Set("Datadog-Client-Computed-Stats", "yes")
+			header.Set("Datadog-Client-Computed-Stats", "yes")
 		}
 		droppedTraces := int(atomic.SwapUint32(&t.droppedP0Traces, 0))
 		partialTraces := int(atomic.SwapUint32(&t.partialTraces, 0))
@@ -157,12 +239,62 @@ func (t *httpTransport) send(p *payload) (body io.ReadCloser, err error) {
 				[]string{fmt.Sprintf("partial:%s", strconv.FormatBool(partialTraces > 0))}, 1)
 			stats.Count("datadog.tracer.dropped_p0_spans", int64(droppedSpans), nil, 1)
 		}
-		req.Header.Set("Datadog-Client-Dropped-P0-Traces", strconv.Itoa(droppedTraces))
-		req.Header.Set("Datadog-Client-Dropped-P0-Spans", strconv.Itoa(droppedSpans))
+		header.Set("Datadog-Client-Dropped-P0-Traces", strconv.Itoa(droppedTraces))
+		header.Set("Datadog-Client-Dropped-P0-Spans", strconv.Itoa(droppedSpans))
+	}
+	url := t.traceURL
+	switch {
+	case p.enc != nil:
+		url = t.agentURL + p.enc.Endpoint()
+	case p.strings != nil:
+		url = t.v05URL
 	}
+	for attempt := 0; ; attempt++ {
+		var payload io.Reader = p
+		if data != nil {
+			payload = bytes.NewReader(data)
+		}
+		body, status, err = t.post(ctx, url, payload, header)
+		if err == nil || attempt >= t.retries || ctx.Err() != nil || !isRetryable(err) {
+			return body, err
+		}
+		wait := backoff(t.retryInterval, attempt)
+		var serr *statusError
+		if errors.As(err, &serr) && serr.retryAfter > 0 {
+			wait = serr.retryAfter
+		}
+		log.Debug("Sending payload failed (attempt %d/%d): %v; retrying in %s", attempt+1, t.retries+1, err, wait)
+		if err := sleepContext(ctx, wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// sleepContext pauses the current goroutine for the duration d, or until ctx
+// is done, in which case the context error is returned.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// post sends the given trace payload body to the agent url with the given
+// headers. It returns the status code of the response, or 0 when the agent
+// couldn't be reached.
+func (t *httpTransport) post(ctx context.Context, url string, body io.Reader, header http.Header) (io.ReadCloser, int, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, body)
+	if err != nil {
+		return nil, 0, fmt.Errorf("cannot create http request: %v", err)
+	}
+	req.Header = header.Clone()
 	response, err := t.client.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	if code := response.StatusCode; code >= 400 {
 		// error, check the body for context information and
@@ -170,13 +302,115 @@ func (t *httpTransport) send(p *payload) (body io.ReadCloser, err error) {
 		msg := make([]byte, 1000)
 		n, _ := response.Body.Read(msg)
 		response.Body.Close()
-		txt := http.StatusText(code)
+		serr := &statusError{code: code, msg: http.StatusText(code)}
 		if n > 0 {
-			return nil, fmt.Errorf("%s (Status: %s)", msg[:n], txt)
+			serr.msg = fmt.Sprintf("%s (Status: %s)", msg[:n], serr.msg)
+		}
+		if code == http.StatusTooManyRequests {
+			if d, ok := parseRetryAfter(response.Header.Get("Retry-After"), time.Now()); ok {
+				serr.retryAfter = d
+				atomic.StoreInt64(&t.retryAfter, time.Now().Add(d).UnixNano())
+			}
 		}
-		return nil, fmt.Errorf("%s", txt)
+		return nil, code, serr
+	}
+	return response.Body, response.StatusCode, nil
+}
+
+// maxRetryAfter is the maximum delay honored from a Retry-After header, so that
+// a misbehaving agent can't stall the flushes indefinitely.
+const maxRetryAfter = time.Minute
+
+// parseRetryAfter parses the value v of a Retry-After header, which is either
+// a number of seconds or an HTTP date, and returns the time to wait from now.
+// It returns false when v is invalid.
+func parseRetryAfter(v string, now time.Time) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	var d time.Duration
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		d = time.Duration(secs) * time.Second
+	} else if date, err := http.ParseTime(v); err == nil {
+		d = date.Sub(now)
+		if d < 0 {
+			d = 0
+		}
+	} else {
+		return 0, false
+	}
+	if d > maxRetryAfter {
+		d = maxRetryAfter
+	}
+	return d, true
+}
+
+// bufferPayload reads the payload p into memory, compressing it using gzip when
+// compression is enabled.
+func (t *httpTransport) bufferPayload(p *payload) ([]byte, error) {
+	var buf bytes.Buffer
+	if !t.compress {
+		buf.Grow(p.size())
+		_, err := buf.ReadFrom(p)
+		return buf.Bytes(), err
+	}
+	zw := gzip.NewWriter(&buf)
+	if _, err := io.Copy(zw, p); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// statusError is returned when the agent responds with an error status code.
+type statusError struct {
+	code       int
+	msg        string
+	retryAfter time.Duration // the delay requested by the agent along a 429 status code
+}
+
+func (e *statusError) Error() string { return e.msg }
+
+// isRetryable reports whether sending a payload failing with err is worth
+// retrying: when the agent could not be reached, timed out, is temporarily
+// unavailable or rate-limiting the tracer.
+func isRetryable(err error) bool {
+	var serr *statusError
+	if errors.As(err, &serr) {
+		switch serr.code {
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+	var nerr net.Error
+	return errors.As(err, &nerr) && nerr.Timeout()
+}
+
+// maxBackoff is the maximum interval computed by backoff, which the retries of
+// the high attempt numbers, whose interval would overflow, use.
+const maxBackoff = 30 * time.Second
+
+// backoff returns the time to wait before the given retry attempt, starting at
+// 0, growing exponentially from the base interval up to maxBackoff, with a
+// random jitter of up to half of the computed interval.
+func backoff(base time.Duration, attempt int) time.Duration {
+	if base <= 0 {
+		return 0
+	}
+	d := base << attempt
+	if attempt > 30 || d <= 0 || d > maxBackoff {
+		d = maxBackoff
 	}
-	return response.Body, nil
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
 }
 
 func (t *httpTransport) endpoint() string {
@@ -6,6 +6,11 @@
 package tracer
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"io"
 	"net"
@@ -16,9 +21,15 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/tinylib/msgp/msgp"
+
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/internal"
 )
 
 // getTestSpan returns a Span with different fields set
@@ -110,6 +121,88 @@ func TestResolveAgentAddr(t *testing.T) {
 	}
 }
 
+func TestResolveAgentURL(t *testing.T) {
+	defer func(old string) { defaultSocketAPM = old }(defaultSocketAPM)
+	defaultSocketAPM = filepath.Join(t.TempDir(), "apm.socket") // no default socket
+	for _, tt := range []struct {
+		inOpt                         StartOption
+		envURL, envHost, envPort, out string
+		uds                           bool
+	}{
+		{nil, "http://custom:1234", "", "", "http://custom:1234", false},
+		{nil, "https://custom:1234", "", "", "https://custom:1234", false},
+		{nil, "unix:///path/to/apm.socket", "", "", defaultURL, true},
+		{nil, "http://custom:1234", "ip.local", "9876", "http://custom:1234", false},
+		{nil, "https://custom:1234", "ip.local", "9876", "https://custom:1234", false},
+		{nil, "unix:///path/to/apm.socket", "ip.local", "9876", "http://ip.local:9876", true},
+		{nil, "bad://custom:1234", "ip.local", "", fmt.Sprintf("http://ip.local:%s", defaultPort), false},
+		{nil, "http://:1234", "ip.local", "", fmt.Sprintf("http://ip.local:%s", defaultPort), false},
+		{nil, "unix://", "", "1234", fmt.Sprintf("http://%s:1234", defaultHostname), false},
+		{WithAgentAddr("ip.other:8888"), "https://custom:1234", "", "", "http://ip.other:8888", false},
+	} {
+		t.Run("", func(t *testing.T) {
+			t.Setenv("DD_TRACE_AGENT_URL", tt.envURL)
+			t.Setenv("DD_AGENT_HOST", tt.envHost)
+			t.Setenv("DD_TRACE_AGENT_PORT", tt.envPort)
+			var opts []StartOption
+			if tt.inOpt != nil {
+				opts = append(opts, tt.inOpt)
+			}
+			c := newConfig(opts...)
+			assert.Equal(t, tt.out, c.agentURL)
+			if tt.uds {
+				assert.NotSame(t, defaultClient, c.httpClient)
+			} else {
+				assert.Same(t, defaultClient, c.httpClient)
+			}
+		})
+	}
+}
+
+func TestAgentSocketDetection(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "apm.socket")
+	defer func(old string) { defaultSocketAPM = old }(defaultSocketAPM)
+	defaultSocketAPM = socket
+
+	for name, tt := range map[string]struct {
+		socket           bool
+		opts             []StartOption
+		envHost, envPort string
+		uds              bool
+		url              string
+	}{
+		"absent":             {socket: false, uds: false, url: defaultURL},
+		"present":            {socket: true, uds: true, url: defaultURL},
+		"env-host":           {socket: true, envHost: "ip.local", uds: false, url: "http://ip.local:" + defaultPort},
+		"env-port":           {socket: true, envPort: "1234", uds: false, url: "http://" + defaultHostname + ":1234"},
+		"agent-addr":         {socket: true, opts: []StartOption{WithAgentAddr("host:1243")}, uds: false, url: "http://host:1243"},
+		"agent-addr-env":     {socket: true, opts: []StartOption{WithAgentAddr("host:1243")}, envHost: "ip.local", uds: false, url: "http://host:1243"},
+		"agent-addr-absent":  {socket: false, opts: []StartOption{WithAgentAddr("host:1243")}, uds: false, url: "http://host:1243"},
+		"explicit-uds":       {socket: false, opts: []StartOption{WithUDS("/tmp/agent.socket")}, uds: true, url: defaultURL},
+		"explicit-uds-addr":  {socket: true, opts: []StartOption{WithUDS("/tmp/agent.socket"), WithAgentAddr("host:1243")}, uds: true, url: "http://host:1243"},
+		"http-client-socket": {socket: true, opts: []StartOption{WithHTTPClient(defaultClient)}, uds: false, url: defaultURL},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if tt.socket {
+				f, err := os.Create(socket)
+				assert.NoError(t, err)
+				assert.NoError(t, f.Close())
+				defer os.Remove(socket)
+			}
+			t.Setenv("DD_AGENT_HOST", tt.envHost)
+			t.Setenv("DD_TRACE_AGENT_PORT", tt.envPort)
+
+			c := newConfig(tt.opts...)
+			assert.Equal(t, tt.url, c.agentURL)
+			if tt.uds {
+				assert.NotSame(t, defaultClient, c.httpClient)
+			} else {
+				assert.Same(t, defaultClient, c.httpClient)
+			}
+		})
+	}
+}
+
 func TestTransportResponse(t *testing.T) {
 	for name, tt := range map[string]struct {
 		status int
@@ -151,6 +244,281 @@ func TestTransportResponse(t *testing.T) {
 	}
 }
 
+func TestTransportRetries(t *testing.T) {
+	// newFlakyServer returns a server responding with the given status code to
+	// the first n requests, then succeeding, along with its hit counter.
+	newFlakyServer := func(n, status int) (*httptest.Server, *int32) {
+		var hits int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&hits, 1) <= int32(n) {
+				w.WriteHeader(status)
+				return
+			}
+			w.Write([]byte("OK"))
+		}))
+		return srv, &hits
+	}
+
+	for name, tt := range map[string]struct {
+		failures int
+		status   int
+		retries  int
+		hits     int32
+		err      bool
+	}{
+		"no-retries":   {failures: 1, status: http.StatusServiceUnavailable, retries: 0, hits: 1, err: true},
+		"502":          {failures: 2, status: http.StatusBadGateway, retries: 3, hits: 3},
+		"503":          {failures: 3, status: http.StatusServiceUnavailable, retries: 3, hits: 4},
+		"504":          {failures: 1, status: http.StatusGatewayTimeout, retries: 3, hits: 2},
+		"exhausted":    {failures: 5, status: http.StatusServiceUnavailable, retries: 2, hits: 3, err: true},
+		"not-retried":  {failures: 1, status: http.StatusBadRequest, retries: 3, hits: 1, err: true},
+		"not-retried2": {failures: 1, status: http.StatusInternalServerError, retries: 3, hits: 1, err: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			srv, hits := newFlakyServer(tt.failures, tt.status)
+			defer srv.Close()
+			transport := newHTTPTransport(srv.URL, defaultClient)
+			transport.retries = tt.retries
+			transport.retryInterval = time.Millisecond
+
+			p, err := encode(getTestTrace(1, 1))
+			assert.NoError(err)
+			rc, err := transport.send(p)
+			assert.Equal(tt.hits, atomic.LoadInt32(hits))
+			if tt.err {
+				assert.Error(err)
+				return
+			}
+			assert.NoError(err)
+			slurp, err := io.ReadAll(rc)
+			rc.Close()
+			assert.NoError(err)
+			assert.Equal("OK", string(slurp))
+		})
+	}
+
+	t.Run("payload", func(t *testing.T) {
+		// every attempt must send the full payload
+		assert := assert.New(t)
+		var bodies [][]byte
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			bodies = append(bodies, body)
+			if len(bodies) < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		}))
+		defer srv.Close()
+		transport := newHTTPTransport(srv.URL, defaultClient)
+		transport.retries = 3
+		transport.retryInterval = time.Millisecond
+
+		p, err := encode(getTestTrace(10, 10))
+		assert.NoError(err)
+		size := p.size()
+		_, err = transport.send(p)
+		assert.NoError(err)
+		assert.Len(bodies, 3)
+		for _, b := range bodies {
+			assert.Len(b, size)
+		}
+	})
+
+	t.Run("connection-refused", func(t *testing.T) {
+		assert := assert.New(t)
+		ln, err := net.Listen("tcp4", "127.0.0.1:0")
+		assert.NoError(err)
+		url := "http://" + ln.Addr().String()
+		ln.Close()
+		transport := newHTTPTransport(url, defaultClient)
+		transport.retries = 2
+		transport.retryInterval = 10 * time.Millisecond
+
+		p, err := encode(getTestTrace(1, 1))
+		assert.NoError(err)
+		start := time.Now()
+		_, err = transport.send(p)
+		assert.Error(err)
+		assert.True(isRetryable(err))
+		// two retries waiting at least half of 10ms and 20ms
+		assert.GreaterOrEqual(time.Since(start), 15*time.Millisecond)
+	})
+
+	t.Run("option", func(t *testing.T) {
+		os.Setenv("DD_TRACE_STARTUP_LOGS", "0")
+		defer os.Unsetenv("DD_TRACE_STARTUP_LOGS")
+		srv, hits := newFlakyServer(2, http.StatusServiceUnavailable)
+		defer srv.Close()
+		trc := newTracer(WithAgentAddr(strings.TrimPrefix(srv.URL, "http://")), WithSendRetries(2, time.Millisecond))
+		defer trc.Stop()
+		atomic.StoreInt32(hits, 0) // ignore the /info request
+
+		p, err := encode(getTestTrace(1, 1))
+		assert.NoError(t, err)
+		_, err = trc.config.transport.send(p)
+		assert.NoError(t, err)
+		assert.Equal(t, int32(3), atomic.LoadInt32(hits))
+	})
+}
+
+func TestTransportRetryAfter(t *testing.T) {
+	// newRateLimitingServer returns a server rate-limiting the first request
+	// with the given Retry-After header value, along with the times at which
+	// it received requests.
+	newRateLimitingServer := func(retryAfter string) (*httptest.Server, *[]time.Time) {
+		var hits []time.Time
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits = append(hits, time.Now())
+			if len(hits) == 1 {
+				w.Header().Set("Retry-After", retryAfter)
+				w.WriteHeader(http.StatusTooManyRequests)
+			}
+		}))
+		return srv, &hits
+	}
+
+	t.Run("retry", func(t *testing.T) {
+		assert := assert.New(t)
+		srv, hits := newRateLimitingServer("2")
+		defer srv.Close()
+		transport := newHTTPTransport(srv.URL, defaultClient)
+		transport.retries = 1
+		transport.retryInterval = time.Millisecond
+
+		p, err := encode(getTestTrace(1, 1))
+		assert.NoError(err)
+		_, err = transport.send(p)
+		assert.NoError(err)
+		assert.Len(*hits, 2)
+		assert.InDelta(2*time.Second, (*hits)[1].Sub((*hits)[0]), float64(500*time.Millisecond))
+	})
+
+	t.Run("next-send", func(t *testing.T) {
+		// without retries, the next send is delayed instead
+		assert := assert.New(t)
+		srv, hits := newRateLimitingServer("1")
+		defer srv.Close()
+		transport := newHTTPTransport(srv.URL, defaultClient)
+
+		p, err := encode(getTestTrace(1, 1))
+		assert.NoError(err)
+		_, err = transport.send(p)
+		assert.Error(err)
+
+		p, err = encode(getTestTrace(1, 1))
+		assert.NoError(err)
+		_, err = transport.send(p)
+		assert.NoError(err)
+		assert.Len(*hits, 2)
+		assert.InDelta(time.Second, (*hits)[1].Sub((*hits)[0]), float64(500*time.Millisecond))
+	})
+}
+
+func TestTransportSendWithContext(t *testing.T) {
+	// newSlowServer returns a server answering the trace requests only once
+	// released, along with the number of trace requests it received.
+	newSlowServer := func() (srv *httptest.Server, hits *int32, release func()) {
+		hits = new(int32)
+		released := make(chan struct{})
+		srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(hits, 1)
+			select {
+			case <-released:
+			case <-r.Context().Done():
+			}
+		}))
+		return srv, hits, func() { close(released) }
+	}
+
+	t.Run("cancel", func(t *testing.T) {
+		assert := assert.New(t)
+		srv, hits, release := newSlowServer()
+		defer srv.Close()
+		defer release()
+		transport := newHTTPTransport(srv.URL, &http.Client{})
+		transport.retries = 3
+		transport.retryInterval = time.Millisecond
+
+		p, err := encode(getTestTrace(1, 1))
+		assert.NoError(err)
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+		start := time.Now()
+		_, err = transport.sendWithContext(ctx, p)
+		assert.ErrorIs(err, context.Canceled)
+		// the send returns promptly, without being retried
+		assert.True(time.Since(start) < time.Second)
+		assert.EqualValues(1, atomic.LoadInt32(hits))
+	})
+
+	t.Run("retry-after", func(t *testing.T) {
+		assert := assert.New(t)
+		transport := newHTTPTransport("http://localhost:1", defaultClient)
+		atomic.StoreInt64(&transport.retryAfter, time.Now().Add(time.Minute).UnixNano())
+
+		p, err := encode(getTestTrace(1, 1))
+		assert.NoError(err)
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		_, err = transport.sendWithContext(ctx, p)
+		assert.ErrorIs(err, context.DeadlineExceeded)
+		assert.True(time.Since(start) < time.Second)
+	})
+
+	t.Run("send", func(t *testing.T) {
+		assert := assert.New(t)
+		srv, hits, release := newSlowServer()
+		defer srv.Close()
+		release()
+		transport := newHTTPTransport(srv.URL, defaultClient)
+
+		p, err := encode(getTestTrace(1, 1))
+		assert.NoError(err)
+		_, err = transport.send(p)
+		assert.NoError(err)
+		assert.EqualValues(1, atomic.LoadInt32(hits))
+	})
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2022, time.October, 21, 7, 28, 0, 0, time.UTC)
+	for in, want := range map[string]time.Duration{
+		"0":                             0,
+		"2":                             2 * time.Second,
+		"120":                           maxRetryAfter,
+		"Fri, 21 Oct 2022 07:28:30 GMT": 30 * time.Second,
+		"Fri, 21 Oct 2022 07:27:00 GMT": 0,
+	} {
+		d, ok := parseRetryAfter(in, now)
+		assert.True(t, ok, in)
+		assert.Equal(t, want, d, in)
+	}
+	for _, in := range []string{"", "-1", "soon", "1.5"} {
+		_, ok := parseRetryAfter(in, now)
+		assert.False(t, ok, in)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	base := 100 * time.Millisecond
+	for attempt := 0; attempt < 5; attempt++ {
+		d := base << attempt
+		for i := 0; i < 100; i++ {
+			wait := backoff(base, attempt)
+			assert.GreaterOrEqual(t, wait, d/2)
+			assert.LessOrEqual(t, wait, d)
+		}
+	}
+	assert.Equal(t, time.Duration(0), backoff(0, 3))
+	for _, attempt := range []int{9, 40, 63, 100} {
+		wait := backoff(base, attempt)
+		assert.GreaterOrEqual(t, wait, maxBackoff/2, attempt)
+		assert.LessOrEqual(t, wait, maxBackoff, attempt)
+	}
+}
+
 func TestTraceCountHeader(t *testing.T) {
 	assert := assert.New(t)
 
@@ -248,6 +616,354 @@ func TestWithHTTPClient(t *testing.T) {
 	assert.Equal(hits, 2)
 }
 
+// proxyRecorder is an HTTP proxy recording the hosts of the requests it
+// forwards. It supports both plain HTTP requests and CONNECT tunnels.
+type proxyRecorder struct {
+	mu    sync.Mutex
+	hosts []string
+}
+
+func (p *proxyRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	p.hosts = append(p.hosts, r.Method+" "+r.Host)
+	p.mu.Unlock()
+	if r.Method != http.MethodConnect {
+		r.RequestURI = ""
+		resp, err := http.DefaultTransport.RoundTrip(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+		for k, v := range resp.Header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+		return
+	}
+	dst, err := net.Dial("tcp", r.Host)
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	defer dst.Close()
+	src, _, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		return
+	}
+	defer src.Close()
+	io.WriteString(src, "HTTP/1.1 200 Connection established\r\n\r\n")
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(dst, src)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(src, dst)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+func (p *proxyRecorder) requests() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.hosts...)
+}
+
+func TestWithProxy(t *testing.T) {
+	os.Setenv("DD_TRACE_STARTUP_LOGS", "0")
+	defer os.Unsetenv("DD_TRACE_STARTUP_LOGS")
+	assert := assert.New(t)
+
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {
+		atomic.AddInt32(&hits, 1)
+	}))
+	defer srv.Close()
+	proxy := new(proxyRecorder)
+	proxySrv := httptest.NewServer(proxy)
+	defer proxySrv.Close()
+
+	u, err := url.Parse(srv.URL)
+	assert.NoError(err)
+	trc := newTracer(WithAgentAddr(u.Host), WithProxy(proxySrv.URL))
+	defer trc.Stop()
+
+	p, err := encode(getTestTrace(1, 1))
+	assert.NoError(err)
+	_, err = trc.config.transport.send(p)
+	assert.NoError(err)
+	assert.Contains(proxy.requests(), "GET "+u.Host)
+	assert.Contains(proxy.requests(), "POST "+u.Host)
+	assert.EqualValues(2, atomic.LoadInt32(&hits))
+	// the default client must not be modified
+	assert.Nil(defaultClient.Transport.(*http.Transport).TLSClientConfig)
+}
+
+func TestWithTLSConfig(t *testing.T) {
+	os.Setenv("DD_TRACE_STARTUP_LOGS", "0")
+	defer os.Unsetenv("DD_TRACE_STARTUP_LOGS")
+
+	var hits int32
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {
+		atomic.AddInt32(&hits, 1)
+	}))
+	defer srv.Close()
+	os.Setenv("DD_TRACE_AGENT_URL", srv.URL)
+	defer os.Unsetenv("DD_TRACE_AGENT_URL")
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+
+	send := func(opts ...StartOption) error {
+		trc := newTracer(opts...)
+		defer trc.Stop()
+		p, err := encode(getTestTrace(1, 1))
+		assert.NoError(t, err)
+		_, err = trc.config.transport.send(p)
+		return err
+	}
+
+	t.Run("untrusted", func(t *testing.T) {
+		atomic.StoreInt32(&hits, 0)
+		err := send()
+		assert.Error(t, err)
+		assert.Zero(t, atomic.LoadInt32(&hits))
+	})
+
+	t.Run("trusted", func(t *testing.T) {
+		atomic.StoreInt32(&hits, 0)
+		err := send(WithTLSConfig(&tls.Config{RootCAs: pool}))
+		assert.NoError(t, err)
+		assert.EqualValues(t, 2, atomic.LoadInt32(&hits))
+	})
+
+	t.Run("https-proxy-env", func(t *testing.T) {
+		atomic.StoreInt32(&hits, 0)
+		proxy := new(proxyRecorder)
+		proxySrv := httptest.NewServer(proxy)
+		defer proxySrv.Close()
+		os.Setenv("HTTPS_PROXY", proxySrv.URL)
+		defer os.Unsetenv("HTTPS_PROXY")
+
+		err := send(WithTLSConfig(&tls.Config{RootCAs: pool}))
+		assert.NoError(t, err)
+		assert.EqualValues(t, 2, atomic.LoadInt32(&hits))
+		host := strings.TrimPrefix(srv.URL, "https://")
+		reqs := proxy.requests()
+		assert.NotEmpty(t, reqs)
+		for _, r := range reqs {
+			assert.Equal(t, "CONNECT "+host, r)
+		}
+	})
+}
+
+func TestPayloadCompression(t *testing.T) {
+	os.Setenv("DD_TRACE_STARTUP_LOGS", "0")
+	defer os.Unsetenv("DD_TRACE_STARTUP_LOGS")
+
+	// newAgent returns a fake agent recording the trace requests it receives.
+	newAgent := func(t *testing.T, reqs chan<- *http.Request, bodies chan<- []byte) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/info" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			body, err := io.ReadAll(r.Body)
+			assert.NoError(t, err)
+			reqs <- r
+			bodies <- body
+		}))
+	}
+
+	t.Run("enabled", func(t *testing.T) {
+		assert := assert.New(t)
+		reqs, bodies := make(chan *http.Request, 1), make(chan []byte, 1)
+		srv := newAgent(t, reqs, bodies)
+		defer srv.Close()
+		trc := newTracer(WithAgentAddr(strings.TrimPrefix(srv.URL, "http://")), WithPayloadCompression(true))
+		defer trc.Stop()
+
+		traces := getTestTrace(10, 10)
+		p, err := encode(traces)
+		assert.NoError(err)
+		size := p.size()
+		_, err = trc.config.transport.send(p)
+		assert.NoError(err)
+
+		req, body := <-reqs, <-bodies
+		assert.Equal("gzip", req.Header.Get("Content-Encoding"))
+		assert.Equal(strconv.Itoa(len(body)), req.Header.Get("Content-Length"))
+		assert.Less(len(body), size)
+
+		// the agent must be able to decompress and decode the payload
+		zr, err := gzip.NewReader(strings.NewReader(string(body)))
+		assert.NoError(err)
+		var got spanLists
+		assert.NoError(msgp.Decode(zr, &got))
+		assert.Len(got, len(traces))
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		assert := assert.New(t)
+		reqs, bodies := make(chan *http.Request, 1), make(chan []byte, 1)
+		srv := newAgent(t, reqs, bodies)
+		defer srv.Close()
+		trc := newTracer(WithAgentAddr(strings.TrimPrefix(srv.URL, "http://")))
+		defer trc.Stop()
+
+		p, err := encode(getTestTrace(1, 1))
+		assert.NoError(err)
+		size := p.size()
+		_, err = trc.config.transport.send(p)
+		assert.NoError(err)
+
+		req, body := <-reqs, <-bodies
+		assert.Empty(req.Header.Get("Content-Encoding"))
+		assert.Len(body, size)
+	})
+}
+
+func TestSendObserver(t *testing.T) {
+	os.Setenv("DD_TRACE_STARTUP_LOGS", "0")
+	defer os.Unsetenv("DD_TRACE_STARTUP_LOGS")
+
+	// newAgent returns a fake agent responding with the given status code and
+	// recording the size of the trace payloads it receives.
+	newAgent := func(t *testing.T, status int, sizes chan<- int) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/info" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			body, err := io.ReadAll(r.Body)
+			assert.NoError(t, err)
+			sizes <- len(body)
+			w.WriteHeader(status)
+		}))
+	}
+
+	for _, tc := range []struct {
+		name     string
+		compress bool
+		status   int
+	}{
+		{name: "ok", status: http.StatusOK},
+		{name: "compressed", compress: true, status: http.StatusOK},
+		{name: "error", status: http.StatusBadRequest},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			assert := assert.New(t)
+			sizes := make(chan int, 1)
+			srv := newAgent(t, tc.status, sizes)
+			defer srv.Close()
+			var stats []SendStats
+			trc := newTracer(
+				WithAgentAddr(strings.TrimPrefix(srv.URL, "http://")),
+				WithPayloadCompression(tc.compress),
+				WithSendObserver(func(s SendStats) { stats = append(stats, s) }),
+			)
+			defer trc.Stop()
+
+			p, err := encode(getTestTrace(3, 2))
+			assert.NoError(err)
+			_, err = trc.config.transport.send(p)
+			if tc.status == http.StatusOK {
+				assert.NoError(err)
+			} else {
+				assert.Error(err)
+			}
+
+			size := <-sizes
+			assert.Len(stats, 1)
+			assert.Equal(size, stats[0].Bytes)
+			assert.Equal(3, stats[0].Traces)
+			assert.Equal(tc.status, stats[0].StatusCode)
+			assert.Greater(stats[0].Duration, time.Duration(0))
+			assert.Equal(err, stats[0].Err)
+		})
+	}
+
+	t.Run("unreachable", func(t *testing.T) {
+		var stats []SendStats
+		trc := newTracer(
+			WithAgentAddr("localhost:0"),
+			WithSendObserver(func(s SendStats) { stats = append(stats, s) }),
+		)
+		defer trc.Stop()
+
+		p, err := encode(getTestTrace(1, 1))
+		assert.NoError(t, err)
+		_, err = trc.config.transport.send(p)
+		assert.Error(t, err)
+		assert.Len(t, stats, 1)
+		assert.Equal(t, 0, stats[0].StatusCode)
+		assert.Equal(t, err, stats[0].Err)
+	})
+}
+
+func TestTransportV05(t *testing.T) {
+	os.Setenv("DD_TRACE_STARTUP_LOGS", "0")
+	defer os.Unsetenv("DD_TRACE_STARTUP_LOGS")
+
+	// sendTrace sends a trace to a fake agent reporting the given endpoints
+	// and returns the path and body of the trace request it receives.
+	sendTrace := func(t *testing.T, endpoints string) (string, []byte) {
+		type request struct {
+			path string
+			body []byte
+		}
+		reqs := make(chan request, 1)
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/info" {
+				fmt.Fprintf(w, `{"endpoints":[%s]}`, endpoints)
+				return
+			}
+			body, err := io.ReadAll(r.Body)
+			assert.NoError(t, err)
+			reqs <- request{r.URL.Path, body}
+		}))
+		defer srv.Close()
+		trc := newTracer(WithAgentAddr(strings.TrimPrefix(srv.URL, "http://")))
+		internal.SetGlobalTracer(trc)
+		defer internal.SetGlobalTracer(&internal.NoopTracer{})
+		s := trc.StartSpan("web.request", ServiceName("svc"), ResourceName("/"), Tag("env", "prod"))
+		s.Finish()
+		trc.Stop()
+		select {
+		case req := <-reqs:
+			return req.path, req.body
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for the trace request")
+			return "", nil
+		}
+	}
+
+	t.Run("supported", func(t *testing.T) {
+		path, body := sendTrace(t, `"/v0.4/traces","/v0.5/traces"`)
+		assert.Equal(t, "/v0.5/traces", path)
+		traces, err := decodeV05(body)
+		assert.NoError(t, err)
+		assert.Len(t, traces, 1)
+		assert.Len(t, traces[0], 1)
+		s := traces[0][0]
+		assert.Equal(t, "web.request", s.Name)
+		assert.Equal(t, "svc", s.Service)
+		assert.Equal(t, "/", s.Resource)
+		assert.Equal(t, "prod", s.Meta["env"])
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		path, body := sendTrace(t, `"/v0.4/traces"`)
+		assert.Equal(t, "/v0.4/traces", path)
+		var traces spanLists
+		assert.NoError(t, msgp.Decode(bytes.NewReader(body), &traces))
+		assert.Len(t, traces, 1)
+		assert.Equal(t, "web.request", traces[0][0].Name)
+	})
+}
+
 func TestWithUDS(t *testing.T) {
 	os.Setenv("DD_TRACE_STARTUP_LOGS", "0")
 	defer os.Unsetenv("DD_TRACE_STARTUP_LOGS")
@@ -7,6 +7,7 @@ package tracer
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"io"
@@ -30,6 +31,23 @@ type traceWriter interface {
 	stop()
 }
 
+// asyncTraceWriter is implemented by the trace writers sending the flushed
+// traces asynchronously.
+type asyncTraceWriter interface {
+	traceWriter
+
+	// flushContext is like flush, but aborts sending the buffered traces as
+	// soon as ctx is done.
+	flushContext(ctx context.Context)
+
+	// wait waits for the flushed traces to be sent.
+	wait()
+}
+
+// writerStopTimeout is the time the agent trace writer waits on stop for the
+// traces being sent, before aborting their sends; replaced in tests.
+var writerStopTimeout = 5 * time.Second
+
 type agentTraceWriter struct {
 	// config holds the tracer configuration
 	config *config
@@ -46,52 +64,118 @@ type agentTraceWriter struct {
 	// prioritySampling is the prioritySampler into which agentTraceWriter will
 	// read sampling rates sent by the agent
 	prioritySampling *prioritySampler
+
+	// ctx is the context of the payload sends, cancelled on stop so that the
+	// sends still in flight are aborted.
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 func newAgentTraceWriter(c *config, s *prioritySampler) *agentTraceWriter {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &agentTraceWriter{
 		config:           c,
-		payload:          newPayload(),
+		payload:          newPayloadFor(c),
 		climit:           make(chan struct{}, concurrentConnectionLimit),
 		prioritySampling: s,
+		ctx:              ctx,
+		cancel:           cancel,
 	}
 }
 
 func (h *agentTraceWriter) add(trace []*span) {
+	if n, first := sanitizeUTF8(trace); n > 0 {
+		h.config.statsd.Count("datadog.tracer.invalid_utf8_meta", int64(n), nil, 1)
+		log.Error("Sanitized %d meta keys or values with invalid UTF-8 of a trace, such as: %s", n, first)
+	}
+	m := h.payload.mark()
 	if err := h.payload.push(trace); err != nil {
 		h.config.statsd.Incr("datadog.tracer.traces_dropped", []string{"reason:encoding_error"}, 1)
 		log.Error("Error encoding msgpack: %v", err)
+		h.payload.rollback(m)
+		return
+	}
+	if h.payload.size() <= h.config.maxPayloadSize {
+		return
 	}
-	if h.payload.size() > payloadSizeLimit {
-		h.config.statsd.Incr("datadog.tracer.flush_triggered", []string{"reason:size"}, 1)
+	h.config.statsd.Incr("datadog.tracer.flush_triggered", []string{"reason:size"}, 1)
+	if m.count > 0 {
+		// the trace doesn't fit: send the previous ones and move it to the next payload
+		h.payload.rollback(m)
 		h.flush()
+		if err := h.payload.push(trace); err != nil {
+			h.config.statsd.Incr("datadog.tracer.traces_dropped", []string{"reason:encoding_error"}, 1)
+			log.Error("Error encoding msgpack: %v", err)
+			return
+		}
+		if h.payload.size() <= h.config.maxPayloadSize {
+			return
+		}
 	}
+	// the trace doesn't fit in a payload on its own; send it alone
+	h.flush()
 }
 
 func (h *agentTraceWriter) stop() {
 	h.config.statsd.Incr("datadog.tracer.flush_triggered", []string{"reason:shutdown"}, 1)
 	h.flush()
+	// abort the sends of a hung agent, or waiting to be retried, so that
+	// stopping doesn't hang too
+	timer := time.AfterFunc(writerStopTimeout, h.cancel)
+	defer timer.Stop()
+	h.wait()
+	h.cancel()
+}
+
+// wait waits for the payloads being sent to be done. It must not be called
+// concurrently with flush.
+func (h *agentTraceWriter) wait() {
 	h.wg.Wait()
 }
 
+// newPayloadFor returns a new payload encoded by the encoder set with
+// WithTraceEncoder, or in the most efficient format supported by the agent, as
+// last reported.
+func newPayloadFor(c *config) *payload {
+	switch e := c.encoder.(type) {
+	case nil:
+	case msgpackEncoder:
+		return e.newPayload()
+	default:
+		return newEncoderPayload(e)
+	}
+	if c.agentCache != nil && c.agentCache.current().V05 {
+		return newPayloadV05()
+	}
+	return newPayload()
+}
+
 // flush will push any currently buffered traces to the server.
 func (h *agentTraceWriter) flush() {
+	h.flushContext(h.ctx)
+}
+
+// flushContext implements asyncTraceWriter.
+func (h *agentTraceWriter) flushContext(ctx context.Context) {
 	if h.payload.itemCount() == 0 {
 		return
 	}
 	h.wg.Add(1)
 	h.climit <- struct{}{}
 	oldp := h.payload
-	h.payload = newPayload()
+	h.payload = newPayloadFor(h.config)
 	go func(p *payload) {
 		defer func(start time.Time) {
 			<-h.climit
 			h.wg.Done()
 			h.config.statsd.Timing("datadog.tracer.flush_duration", time.Since(start), nil, 1)
 		}(time.Now())
+		// refresh the agent features if they expired, off the hot path, so that
+		// the next payloads are encoded in the format supported by the agent.
+		h.config.features()
 		size, count := p.size(), p.itemCount()
 		log.Debug("Sending payload: size: %d traces: %d\n", size, count)
-		rc, err := h.config.transport.send(p)
+		rc, err := h.config.transport.sendWithContext(ctx, p)
 		if err != nil {
 			h.config.statsd.Count("datadog.tracer.traces_dropped", int64(count), []string{"reason:send_failed"}, 1)
 			log.Error("lost %d traces: %v", count, err)
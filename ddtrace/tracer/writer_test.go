@@ -7,11 +7,14 @@ package tracer
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"math"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
@@ -91,6 +94,139 @@ func TestEncodeFloat(t *testing.T) {
 
 }
 
+// sizeTransport is a dummyTransport recording the size of the payloads it receives.
+type sizeTransport struct {
+	*dummyTransport
+	mu    sync.Mutex
+	sizes []int
+}
+
+func (t *sizeTransport) send(p *payload) (io.ReadCloser, error) {
+	t.mu.Lock()
+	t.sizes = append(t.sizes, p.size())
+	t.mu.Unlock()
+	return t.dummyTransport.send(p)
+}
+
+func (t *sizeTransport) sendWithContext(_ context.Context, p *payload) (io.ReadCloser, error) {
+	return t.send(p)
+}
+
+func TestTraceWriterMaxPayloadSize(t *testing.T) {
+	const maxSize = 10000
+	newWriter := func() (*agentTraceWriter, *sizeTransport) {
+		transport := &sizeTransport{dummyTransport: newDummyTransport()}
+		c := newConfig(withTransport(transport), WithMaxPayloadSize(maxSize))
+		return newAgentTraceWriter(c, newPrioritySampler()), transport
+	}
+
+	t.Run("split", func(t *testing.T) {
+		assert := assert.New(t)
+		h, transport := newWriter()
+		var want []uint64
+		for i := 0; i < 50; i++ {
+			trace := []*span{makeSpan(10), makeSpan(10)}
+			trace[1].TraceID = trace[0].TraceID
+			want = append(want, trace[0].TraceID)
+			h.add(trace)
+		}
+		h.stop()
+
+		assert.Greater(len(transport.sizes), 1)
+		for _, size := range transport.sizes {
+			assert.LessOrEqual(size, maxSize)
+		}
+		// every trace was sent whole, once
+		var got []uint64
+		for _, trace := range transport.traces {
+			assert.Len(trace, 2)
+			assert.Equal(trace[0].TraceID, trace[1].TraceID)
+			got = append(got, trace[0].TraceID)
+		}
+		assert.ElementsMatch(want, got)
+	})
+
+	t.Run("oversized", func(t *testing.T) {
+		assert := assert.New(t)
+		h, transport := newWriter()
+		h.add([]*span{makeSpan(1)})
+		h.add([]*span{makeSpan(1000)})
+		h.add([]*span{makeSpan(1)})
+		h.stop()
+
+		// the oversized trace is sent on its own
+		assert.Len(transport.sizes, 3)
+		var oversized int
+		for _, size := range transport.sizes {
+			if size > maxSize {
+				oversized++
+			}
+		}
+		assert.Equal(1, oversized)
+		assert.Equal(3, transport.Len())
+	})
+}
+
+func TestTraceWriterInvalidUTF8(t *testing.T) {
+	assert := assert.New(t)
+	var tg testStatsdClient
+	transport := newDummyTransport()
+	c := newConfig(withTransport(transport), withStatsdClient(&tg))
+	h := newAgentTraceWriter(c, newPrioritySampler())
+	trace := []*span{makeSpan(1), makeSpan(1)}
+	trace[0].Meta["http.url"] = "/path\xff"
+	trace[1].Meta["key\xfe"] = "value\xfe"
+	h.add(trace)
+	h.stop()
+
+	// the trace is sent with its invalid meta sanitized
+	assert.Equal(1, transport.Len())
+	assert.Equal(int64(2), tg.Counts()["datadog.tracer.invalid_utf8_meta"])
+	assert.Equal("/path\uFFFD", trace[0].Meta["http.url"])
+	assert.Equal("value\uFFFD", trace[1].Meta["key\uFFFD"])
+}
+
+// hangingTransport is a transport whose sends hang until their context is
+// done, reporting its error on aborted.
+type hangingTransport struct {
+	*dummyTransport
+	aborted chan error
+}
+
+func (t *hangingTransport) sendWithContext(ctx context.Context, _ *payload) (io.ReadCloser, error) {
+	<-ctx.Done()
+	t.aborted <- ctx.Err()
+	return nil, ctx.Err()
+}
+
+func TestTraceWriterAbortSend(t *testing.T) {
+	newWriter := func() (*agentTraceWriter, *hangingTransport) {
+		transport := &hangingTransport{dummyTransport: newDummyTransport(), aborted: make(chan error, 1)}
+		h := newAgentTraceWriter(newConfig(withTransport(transport)), newPrioritySampler())
+		h.add([]*span{makeSpan(1)})
+		return h, transport
+	}
+
+	t.Run("context", func(t *testing.T) {
+		h, transport := newWriter()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		h.flushContext(ctx)
+		h.wait()
+		assert.ErrorIs(t, <-transport.aborted, context.DeadlineExceeded)
+	})
+
+	t.Run("stop", func(t *testing.T) {
+		defer func(d time.Duration) { writerStopTimeout = d }(writerStopTimeout)
+		writerStopTimeout = 10 * time.Millisecond
+		h, transport := newWriter()
+		start := time.Now()
+		h.stop()
+		assert.ErrorIs(t, <-transport.aborted, context.Canceled)
+		assert.Less(t, time.Since(start), time.Second)
+	})
+}
+
 func TestLogWriter(t *testing.T) {
 	t.Run("basic", func(t *testing.T) {
 		assert := assert.New(t)
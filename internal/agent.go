@@ -15,7 +15,9 @@ import (
 // AgentURLFromEnv determines the trace agent URL from environment variable
 // DD_TRACE_AGENT_URL. If the determined value is valid and the scheme is
 // supported (unix, http or https), it will return an *url.URL. Otherwise,
-// it returns nil.
+// it returns nil. A valid unix URL has an absolute socket path and no host,
+// as in unix:///var/run/datadog/apm.socket, and a valid http or https URL has
+// a host.
 func AgentURLFromEnv() *url.URL {
 	agentURL := os.Getenv("DD_TRACE_AGENT_URL")
 	if agentURL == "" {
@@ -27,7 +29,17 @@ func AgentURLFromEnv() *url.URL {
 		return nil
 	}
 	switch u.Scheme {
-	case "unix", "http", "https":
+	case "unix":
+		if u.Host != "" || u.Path == "" {
+			log.Warn("Invalid unix Agent URL %q: expecting an absolute socket path, such as unix:///var/run/datadog/apm.socket.", agentURL)
+			return nil
+		}
+		return u
+	case "http", "https":
+		if u.Hostname() == "" {
+			log.Warn("Invalid Agent URL %q: missing the agent host.", agentURL)
+			return nil
+		}
 		return u
 	default:
 		log.Warn("Unsupported protocol %q in Agent URL %q. Must be one of: http, https, unix.", u.Scheme, agentURL)
@@ -22,6 +22,10 @@ func TestAgentURLFromEnv2(t *testing.T) {
 		"http":     {input: "http://custom:1234", want: "http://custom:1234"},
 		"https":    {input: "https://custom:1234", want: "https://custom:1234"},
 		"unix":     {input: "unix:///path/to/custom.socket", want: "unix:///path/to/custom.socket"},
+		"no-host":  {input: "http://:1234", want: ""},
+		"no-host2": {input: "https:///path", want: ""},
+		"no-path":  {input: "unix://", want: ""},
+		"relative": {input: "unix://path/to/custom.socket", want: ""},
 	} {
 		t.Run(name, func(t *testing.T) {
 			t.Setenv("DD_TRACE_AGENT_URL", tc.input)
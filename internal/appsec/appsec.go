@@ -9,6 +9,7 @@
 package appsec
 
 import (
+	"errors"
 	"sync"
 
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/appsec/dyngo"
@@ -46,6 +47,10 @@ func Start(opts ...StartOption) {
 	for _, opt := range opts {
 		opt(cfg)
 	}
+	if cfg.err != nil {
+		logUnexpectedStartError(cfg.err)
+		return
+	}
 	appsec := newAppSec(cfg)
 	appsec.startRC()
 
@@ -59,6 +64,7 @@ func Start(opts ...StartOption) {
 			return
 		}
 	} else if err := appsec.start(); err != nil { // AppSec is specifically enabled
+		reportStartupError(err)
 		logUnexpectedStartError(err)
 		appsec.stopRC()
 		return
@@ -66,6 +72,17 @@ func Start(opts ...StartOption) {
 	setActiveAppSec(appsec)
 }
 
+// ReloadRules replaces the security rules of the running AppSec instance with
+// the given ones, without having to restart it.
+func ReloadRules(rules []byte) error {
+	mu.Lock()
+	defer mu.Unlock()
+	if activeAppSec == nil {
+		return errors.New("appsec is not started")
+	}
+	return activeAppSec.ReloadRules(rules)
+}
+
 // Implement the AppSec log message C1
 func logUnexpectedStartError(err error) {
 	log.Error("appsec: could not start because of an unexpected error: %v\nNo security activities will be collected. Please contact support at https://docs.datadoghq.com/help/ for help.", err)
@@ -94,7 +111,9 @@ func setActiveAppSec(a *appsec) {
 type appsec struct {
 	cfg           *Config
 	unregisterWAF dyngo.UnregisterFunc
+	wafHandle     *wafHandleWrapper
 	limiter       *TokenTicker
+	sampler       *attackSampler
 	rc            *remoteconfig.Client
 	started       bool
 }
@@ -118,6 +137,7 @@ func newAppSec(cfg *Config) *appsec {
 func (a *appsec) start() error {
 	a.limiter = NewTokenTicker(int64(a.cfg.traceRateLimit), int64(a.cfg.traceRateLimit))
 	a.limiter.Start()
+	a.sampler = newAttackSampler(a.cfg.attackSampling)
 	// Register the WAF operation event listener
 	unregisterWAF, err := a.registerWAF()
 	if err != nil {
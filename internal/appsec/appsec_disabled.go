@@ -8,7 +8,11 @@
 
 package appsec
 
-import "gopkg.in/DataDog/dd-trace-go.v1/internal/log"
+import (
+	"errors"
+
+	"gopkg.in/DataDog/dd-trace-go.v1/internal/log"
+)
 
 // Enabled returns true when AppSec is up and running. Meaning that the appsec build tag is enabled, the env var
 // DD_APPSEC_ENABLED is set to true, and the tracer is started.
@@ -34,5 +38,10 @@ func Start(...StartOption) {
 // Stop AppSec.
 func Stop() {}
 
+// ReloadRules returns an error as AppSec cannot be started without the appsec build tag.
+func ReloadRules([]byte) error {
+	return errors.New("appsec is not started")
+}
+
 // Static rule stubs when disabled.
 const staticRecommendedRules = ""
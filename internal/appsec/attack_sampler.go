@@ -0,0 +1,80 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2022 Datadog, Inc.
+
+//go:build appsec
+// +build appsec
+
+package appsec
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// attackSamplerWindow is the time window over which the attack sampler
+// threshold applies.
+const attackSamplerWindow = time.Second
+
+// attackSampler samples the traces with security events beyond a threshold
+// number of traces per window, in order to prevent an attack flood from
+// blowing up the trace volume, independently of the trace rate limiter. The
+// first trace of every triggered rule is always kept per window so that no
+// rule goes unreported. A nil *attackSampler keeps every trace.
+type attackSampler struct {
+	threshold uint
+	rate      float64
+	now       func() time.Time
+	random    func() float64
+
+	mu    sync.Mutex
+	start time.Time           // start of the current window
+	kept  uint                // traces kept in the current window
+	rules map[string]struct{} // rules reported in the current window
+}
+
+// newAttackSampler returns the attack sampler of the given configuration, or
+// nil when the sampling is disabled.
+func newAttackSampler(cfg AttackSamplingConfig) *attackSampler {
+	if cfg.Threshold == 0 {
+		return nil
+	}
+	return &attackSampler{
+		threshold: cfg.Threshold,
+		rate:      cfg.Rate,
+		now:       time.Now,
+		random:    rand.Float64,
+		rules:     make(map[string]struct{}),
+	}
+}
+
+// keep returns true when the trace whose security events triggered the given
+// rules should be kept.
+func (s *attackSampler) keep(rules []string) bool {
+	if s == nil {
+		return true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now := s.now(); now.Sub(s.start) >= attackSamplerWindow {
+		s.start = now
+		s.kept = 0
+		s.rules = make(map[string]struct{})
+	}
+	keep := s.kept < s.threshold
+	for _, rule := range rules {
+		if _, ok := s.rules[rule]; !ok {
+			s.rules[rule] = struct{}{}
+			keep = true
+		}
+	}
+	if !keep && s.random() < s.rate {
+		keep = true
+	}
+	if keep {
+		s.kept++
+	}
+	return keep
+}
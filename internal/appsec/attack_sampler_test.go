@@ -0,0 +1,78 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2022 Datadog, Inc.
+
+//go:build appsec
+// +build appsec
+
+package appsec
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAttackSampler(t *testing.T) {
+	// newTestSampler returns a sampler whose clock is controlled by the test
+	// and whose random values cycle over 0, 0.1, ..., 0.9 so that exactly one
+	// trace out of ten is kept at a 10% rate.
+	newTestSampler := func(threshold uint, rate float64) (*attackSampler, *time.Time) {
+		now := time.Unix(1e9, 0)
+		s := newAttackSampler(AttackSamplingConfig{Threshold: threshold, Rate: rate})
+		s.now = func() time.Time { return now }
+		var n int
+		s.random = func() float64 {
+			r := float64(n%10) / 10
+			n++
+			return r
+		}
+		return s, &now
+	}
+	// flood returns the number of kept traces out of n traces triggering the
+	// given rules.
+	flood := func(s *attackSampler, n int, rules ...string) (kept int) {
+		for i := 0; i < n; i++ {
+			if s.keep(rules) {
+				kept++
+			}
+		}
+		return kept
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		s := newAttackSampler(AttackSamplingConfig{Rate: 0.1})
+		require.Nil(t, s)
+		require.Equal(t, 1000, flood(s, 1000, "rule:type"))
+	})
+
+	t.Run("flood", func(t *testing.T) {
+		s, _ := newTestSampler(10, 0.1)
+		// the first 10 traces are kept, then 10% of the 990 others
+		require.Equal(t, 10+99, flood(s, 1000, "rule:type"))
+	})
+
+	t.Run("zero-rate", func(t *testing.T) {
+		s, _ := newTestSampler(10, 0)
+		require.Equal(t, 10, flood(s, 1000, "rule:type"))
+	})
+
+	t.Run("new-rule", func(t *testing.T) {
+		s, _ := newTestSampler(10, 0)
+		require.Equal(t, 10, flood(s, 1000, "rule-1:type"))
+		// the first trace of a rule is always kept
+		require.Equal(t, 1, flood(s, 1000, "rule-2:type"))
+		require.Equal(t, 1, flood(s, 1000, "rule-1:type", "rule-3:type"))
+	})
+
+	t.Run("window", func(t *testing.T) {
+		s, now := newTestSampler(10, 0)
+		require.Equal(t, 10, flood(s, 1000, "rule:type"))
+		*now = now.Add(attackSamplerWindow / 2)
+		require.Equal(t, 0, flood(s, 1000, "rule:type"))
+		*now = now.Add(attackSamplerWindow / 2)
+		require.Equal(t, 10, flood(s, 1000, "rule:type"))
+	})
+}
@@ -6,10 +6,12 @@
 package appsec
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 	"unicode"
 	"unicode/utf8"
@@ -19,18 +21,27 @@ import (
 )
 
 const (
-	enabledEnvVar         = "DD_APPSEC_ENABLED"
-	rulesEnvVar           = "DD_APPSEC_RULES"
-	wafTimeoutEnvVar      = "DD_APPSEC_WAF_TIMEOUT"
-	traceRateLimitEnvVar  = "DD_APPSEC_TRACE_RATE_LIMIT"
-	obfuscatorKeyEnvVar   = "DD_APPSEC_OBFUSCATION_PARAMETER_KEY_REGEXP"
-	obfuscatorValueEnvVar = "DD_APPSEC_OBFUSCATION_PARAMETER_VALUE_REGEXP"
+	enabledEnvVar          = "DD_APPSEC_ENABLED"
+	rulesEnvVar            = "DD_APPSEC_RULES"
+	wafTimeoutEnvVar       = "DD_APPSEC_WAF_TIMEOUT"
+	traceRateLimitEnvVar   = "DD_APPSEC_TRACE_RATE_LIMIT"
+	obfuscatorKeyEnvVar    = "DD_APPSEC_OBFUSCATION_PARAMETER_KEY_REGEXP"
+	obfuscatorValueEnvVar  = "DD_APPSEC_OBFUSCATION_PARAMETER_VALUE_REGEXP"
+	blockingEnvVar         = "DD_APPSEC_BLOCKING_ENABLED"
+	blockedStatusEnvVar    = "DD_APPSEC_HTTP_BLOCKED_STATUS"
+	blockedTemplateEnvVar  = "DD_APPSEC_HTTP_BLOCKED_TEMPLATE_JSON"
+	grpcMetadataKeysEnvVar = "DD_APPSEC_GRPC_METADATA_KEYS"
+	attackThresholdEnvVar  = "DD_APPSEC_ATTACK_SAMPLING_THRESHOLD"
+	attackRateEnvVar       = "DD_APPSEC_ATTACK_SAMPLING_RATE"
 )
 
 const (
 	defaultWAFTimeout           = 4 * time.Millisecond
 	defaultTraceRate            = 100 // up to 100 appsec traces/s
+	defaultAttackSamplingRate   = 0.1 // 10% of the attack traces beyond the sampling threshold
 	defaultObfuscatorKeyRegex   = `(?i)(?:p(?:ass)?w(?:or)?d|pass(?:_?phrase)?|secret|(?:api_?|private_?|public_?)key)|token|consumer_?(?:id|key|secret)|sign(?:ed|ature)|bearer|authorization`
+	defaultBlockedStatus        = 403
+	defaultBlockedTemplateJSON  = `{"errors":[{"title":"You've been blocked","detail":"Sorry, you cannot access this page. Please contact the customer service team. Security provided by Datadog."}]}`
 	defaultObfuscatorValueRegex = `(?i)(?:p(?:ass)?w(?:or)?d|pass(?:_?phrase)?|secret|(?:api_?|private_?|public_?|access_?|secret_?)key(?:_?id)?|token|consumer_?(?:id|key|secret)|sign(?:ed|ature)?|auth(?:entication|orization)?)(?:\s*=[^;]|"\s*:\s*"[^"]+")|bearer\s+[a-z0-9\._\-]+|token:[a-z0-9]{13}|gh[opsu]_[0-9a-zA-Z]{36}|ey[I-L][\w=-]+\.ey[I-L][\w=-]+(?:\.[\w.+\/=-]+)?|[\-]{5}BEGIN[a-z\s]+PRIVATE\sKEY[\-]{5}[^\-]+[\-]{5}END[a-z\s]+PRIVATE\sKEY|ssh-rsa\s*[a-z0-9\/\.+]{100,}`
 )
 
@@ -47,8 +58,17 @@ type Config struct {
 	traceRateLimit uint
 	// Obfuscator configuration parameters
 	obfuscator ObfuscatorConfig
+	// Blocking mode configuration
+	blocking BlockingConfig
+	// gRPC metadata keys whose values are also passed to the WAF under their
+	// own address `grpc.server.request.metadata.<key>`.
+	grpcMetadataKeys []string
+	// Sampling of the attack traces beyond a threshold rate
+	attackSampling AttackSamplingConfig
 	// rc is the remote configuration client used to receive product configuration updates. Nil if rc is disabled (default)
 	rc *remoteconfig.ClientConfig
+	// err is the first invalid start option error, preventing AppSec from starting.
+	err error
 }
 
 // WithRCConfig sets the AppSec remote config client configuration to the specified cfg
@@ -58,12 +78,153 @@ func WithRCConfig(cfg remoteconfig.ClientConfig) StartOption {
 	}
 }
 
+// WithWAFTimeout sets the maximum WAF execution time of every WAF run. The
+// environment variable DD_APPSEC_WAF_TIMEOUT takes precedence over this option
+// when both are set. Non-strictly positive durations are ignored.
+func WithWAFTimeout(timeout time.Duration) StartOption {
+	return func(c *Config) {
+		if os.Getenv(wafTimeoutEnvVar) != "" {
+			log.Debug("appsec: %s is set, ignoring the WAF timeout start option", wafTimeoutEnvVar)
+			return
+		}
+		if timeout <= 0 {
+			log.Error("appsec: unexpected WAF timeout start option value %s: expecting a strictly positive duration", timeout)
+			return
+		}
+		c.wafTimeout = timeout
+	}
+}
+
+// WithRateLimit sets the maximum number of AppSec traces per second. The
+// environment variable DD_APPSEC_TRACE_RATE_LIMIT takes precedence over this
+// option when both are set. Non-strictly positive rates are ignored.
+func WithRateLimit(rate int) StartOption {
+	return func(c *Config) {
+		if os.Getenv(traceRateLimitEnvVar) != "" {
+			log.Debug("appsec: %s is set, ignoring the rate limit start option", traceRateLimitEnvVar)
+			return
+		}
+		if rate <= 0 {
+			log.Error("appsec: unexpected rate limit start option value %d: expecting a value strictly greater than 0", rate)
+			return
+		}
+		c.traceRateLimit = uint(rate)
+	}
+}
+
+// WithObfuscatorKeyRegex sets the regular expression used by the WAF to
+// redact the values of the parameters whose key matches it from the security
+// events. The regular expression is compiled right away so that an invalid one
+// is reported early, and then prevents AppSec from starting. The environment
+// variable DD_APPSEC_OBFUSCATION_PARAMETER_KEY_REGEXP takes precedence over
+// this option when both are set.
+func WithObfuscatorKeyRegex(regex string) StartOption {
+	return withObfuscatorRegex(obfuscatorKeyEnvVar, regex, func(c *Config) *string { return &c.obfuscator.KeyRegex })
+}
+
+// WithObfuscatorValueRegex sets the regular expression used by the WAF to
+// redact the parameter values matching it from the security events. The
+// regular expression is compiled right away so that an invalid one is reported
+// early, and then prevents AppSec from starting. The environment variable
+// DD_APPSEC_OBFUSCATION_PARAMETER_VALUE_REGEXP takes precedence over this
+// option when both are set.
+func WithObfuscatorValueRegex(regex string) StartOption {
+	return withObfuscatorRegex(obfuscatorValueEnvVar, regex, func(c *Config) *string { return &c.obfuscator.ValueRegex })
+}
+
+// withObfuscatorRegex returns the start option setting the obfuscator regular
+// expression returned by field, unless the environment variable envVar is set.
+// An invalid regex is logged and recorded as the configuration error.
+func withObfuscatorRegex(envVar, regex string, field func(*Config) *string) StartOption {
+	if _, err := regexp.Compile(regex); err != nil {
+		err = fmt.Errorf("invalid obfuscator regular expression start option `%s`: %v", regex, err)
+		log.Error("appsec: %v", err)
+		return func(c *Config) {
+			if c.err == nil {
+				c.err = err
+			}
+		}
+	}
+	return func(c *Config) {
+		if _, set := os.LookupEnv(envVar); set {
+			log.Debug("appsec: %s is set, ignoring the obfuscator regular expression start option", envVar)
+			return
+		}
+		*field(c) = regex
+	}
+}
+
+// WithGRPCMetadataKeys sets the list of gRPC metadata keys whose values are
+// passed to the WAF under their own address `grpc.server.request.metadata.<key>`,
+// in addition to the whole metadata map passed under the address
+// `grpc.server.request.metadata`. This allows security rules to target specific
+// metadata keys. The keys are case-insensitive. The environment variable
+// DD_APPSEC_GRPC_METADATA_KEYS takes precedence over this option when both are
+// set.
+func WithGRPCMetadataKeys(keys ...string) StartOption {
+	return func(c *Config) {
+		if os.Getenv(grpcMetadataKeysEnvVar) != "" {
+			log.Debug("appsec: %s is set, ignoring the gRPC metadata keys start option", grpcMetadataKeysEnvVar)
+			return
+		}
+		c.grpcMetadataKeys = normalizeGRPCMetadataKeys(keys)
+	}
+}
+
+// WithAttackSampling enables the sampling of the traces with security events
+// beyond threshold traces per second, keeping only the given rate of them so
+// that an attack flood doesn't blow up the trace volume. The first trace of
+// every triggered rule is still kept every second. The environment variables
+// DD_APPSEC_ATTACK_SAMPLING_THRESHOLD and DD_APPSEC_ATTACK_SAMPLING_RATE take
+// precedence over this option when set. Non-strictly positive thresholds and
+// rates out of the [0, 1] range are ignored.
+func WithAttackSampling(threshold int, rate float64) StartOption {
+	return func(c *Config) {
+		if os.Getenv(attackThresholdEnvVar) != "" {
+			log.Debug("appsec: %s is set, ignoring the attack sampling threshold start option", attackThresholdEnvVar)
+		} else if threshold <= 0 {
+			log.Error("appsec: unexpected attack sampling threshold start option value %d: expecting a value strictly greater than 0", threshold)
+		} else {
+			c.attackSampling.Threshold = uint(threshold)
+		}
+		if os.Getenv(attackRateEnvVar) != "" {
+			log.Debug("appsec: %s is set, ignoring the attack sampling rate start option", attackRateEnvVar)
+		} else if rate < 0 || rate > 1 {
+			log.Error("appsec: unexpected attack sampling rate start option value %f: expecting a value between 0 and 1", rate)
+		} else {
+			c.attackSampling.Rate = rate
+		}
+	}
+}
+
+// AttackSamplingConfig holds the sampling configuration of the traces with
+// security events. The sampling is disabled when Threshold is zero, and every
+// trace with security events is then kept, within the trace rate limit.
+type AttackSamplingConfig struct {
+	// Threshold is the number of traces per second with security events
+	// which are kept before sampling them.
+	Threshold uint
+	// Rate is the rate of traces with security events which are kept beyond
+	// the threshold.
+	Rate float64
+}
+
 // ObfuscatorConfig wraps the key and value regexp to be passed to the WAF to perform obfuscation.
 type ObfuscatorConfig struct {
 	KeyRegex   string
 	ValueRegex string
 }
 
+// BlockingConfig holds the blocking mode configuration. When enabled, the HTTP requests matching a WAF rule with a
+// blocking action are aborted before reaching the handler and answered with the configured status code and body.
+type BlockingConfig struct {
+	Enabled bool
+	// Status is the HTTP status code of the blocking response.
+	Status int
+	// Body is the JSON body of the blocking response.
+	Body []byte
+}
+
 // isEnabled returns true when appsec is enabled when the environment variable
 // It also returns whether the env var is actually set in the env or not
 // DD_APPSEC_ENABLED is set to true.
@@ -83,11 +244,18 @@ func newConfig() (*Config, error) {
 	if err != nil {
 		return nil, err
 	}
+	blocking, err := readBlockingConfig()
+	if err != nil {
+		return nil, err
+	}
 	return &Config{
-		rules:          rules,
-		wafTimeout:     readWAFTimeoutConfig(),
-		traceRateLimit: readRateLimitConfig(),
-		obfuscator:     readObfuscatorConfig(),
+		rules:            rules,
+		wafTimeout:       readWAFTimeoutConfig(),
+		traceRateLimit:   readRateLimitConfig(),
+		obfuscator:       readObfuscatorConfig(),
+		blocking:         blocking,
+		grpcMetadataKeys: readGRPCMetadataKeysConfig(),
+		attackSampling:   readAttackSamplingConfig(),
 	}, nil
 }
 
@@ -128,13 +296,36 @@ func readRateLimitConfig() (rate uint) {
 		logEnvVarParsingError(traceRateLimitEnvVar, value, err, rate)
 		return
 	}
-	if rate == 0 {
+	if parsed == 0 {
 		logUnexpectedEnvVarValue(traceRateLimitEnvVar, parsed, "expecting a value strictly greater than 0", rate)
 		return
 	}
 	return uint(parsed)
 }
 
+func readAttackSamplingConfig() (cfg AttackSamplingConfig) {
+	cfg.Rate = defaultAttackSamplingRate
+	if value := os.Getenv(attackThresholdEnvVar); value != "" {
+		threshold, err := strconv.ParseUint(value, 10, 0)
+		if err != nil {
+			logEnvVarParsingError(attackThresholdEnvVar, value, err, cfg.Threshold)
+		} else {
+			cfg.Threshold = uint(threshold)
+		}
+	}
+	if value := os.Getenv(attackRateEnvVar); value != "" {
+		rate, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			logEnvVarParsingError(attackRateEnvVar, value, err, cfg.Rate)
+		} else if rate < 0 || rate > 1 {
+			logUnexpectedEnvVarValue(attackRateEnvVar, rate, "expecting a value between 0 and 1", cfg.Rate)
+		} else {
+			cfg.Rate = rate
+		}
+	}
+	return cfg
+}
+
 func readObfuscatorConfig() ObfuscatorConfig {
 	keyRE := readObfuscatorConfigRegexp(obfuscatorKeyEnvVar, defaultObfuscatorKeyRegex)
 	valueRE := readObfuscatorConfigRegexp(obfuscatorValueEnvVar, defaultObfuscatorValueRegex)
@@ -155,6 +346,32 @@ func readObfuscatorConfigRegexp(name, defaultValue string) string {
 	return val
 }
 
+func readGRPCMetadataKeysConfig() []string {
+	value := os.Getenv(grpcMetadataKeysEnvVar)
+	if value == "" {
+		return nil
+	}
+	return normalizeGRPCMetadataKeys(strings.Split(value, ","))
+}
+
+// normalizeGRPCMetadataKeys returns the given metadata keys lowercased, as
+// gRPC metadata keys are, without the empty and duplicate ones.
+func normalizeGRPCMetadataKeys(keys []string) (normalized []string) {
+	seen := make(map[string]struct{}, len(keys))
+	for _, k := range keys {
+		k = strings.ToLower(strings.TrimSpace(k))
+		if k == "" {
+			continue
+		}
+		if _, ok := seen[k]; ok {
+			continue
+		}
+		seen[k] = struct{}{}
+		normalized = append(normalized, k)
+	}
+	return normalized
+}
+
 func readRulesConfig() (rules []byte, err error) {
 	rules = []byte(staticRecommendedRules)
 	filepath := os.Getenv(rulesEnvVar)
@@ -173,6 +390,47 @@ func readRulesConfig() (rules []byte, err error) {
 	return buf, nil
 }
 
+func readBlockingConfig() (cfg BlockingConfig, err error) {
+	cfg = BlockingConfig{
+		Status: defaultBlockedStatus,
+		Body:   []byte(defaultBlockedTemplateJSON),
+	}
+	if value := os.Getenv(blockingEnvVar); value != "" {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			logEnvVarParsingError(blockingEnvVar, value, err, cfg.Enabled)
+		} else {
+			cfg.Enabled = enabled
+		}
+	}
+	if value := os.Getenv(blockedStatusEnvVar); value != "" {
+		status, err := strconv.Atoi(value)
+		if err != nil {
+			logEnvVarParsingError(blockedStatusEnvVar, value, err, cfg.Status)
+		} else if status < 100 || status > 599 {
+			logUnexpectedEnvVarValue(blockedStatusEnvVar, status, "expecting a valid HTTP status code", cfg.Status)
+		} else {
+			cfg.Status = status
+		}
+	}
+	filepath := os.Getenv(blockedTemplateEnvVar)
+	if filepath == "" {
+		return cfg, nil
+	}
+	buf, err := os.ReadFile(filepath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			log.Error("appsec: could not find the blocked response template file in path %s: %v.", filepath, err)
+		}
+		return cfg, err
+	}
+	if !json.Valid(buf) {
+		return cfg, fmt.Errorf("the blocked response template file %s is not valid json", filepath)
+	}
+	cfg.Body = buf
+	return cfg, nil
+}
+
 func logEnvVarParsingError(name, value string, err error, defaultValue interface{}) {
 	log.Error("appsec: could not parse the env var %s=%s as a duration: %v. Using default value %v.", name, value, err, defaultValue)
 }
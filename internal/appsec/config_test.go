@@ -25,6 +25,13 @@ func TestConfig(t *testing.T) {
 			KeyRegex:   defaultObfuscatorKeyRegex,
 			ValueRegex: defaultObfuscatorValueRegex,
 		},
+		blocking: BlockingConfig{
+			Status: defaultBlockedStatus,
+			Body:   []byte(defaultBlockedTemplateJSON),
+		},
+		attackSampling: AttackSamplingConfig{
+			Rate: defaultAttackSamplingRate,
+		},
 	}
 
 	t.Run("default", func(t *testing.T) {
@@ -184,6 +191,207 @@ func TestConfig(t *testing.T) {
 		})
 	})
 
+	t.Run("start-options", func(t *testing.T) {
+		t.Run("waf-timeout", func(t *testing.T) {
+			expCfg := *expectedDefaultConfig
+			expCfg.wafTimeout = 10 * time.Millisecond
+			restoreEnv := cleanEnv()
+			defer restoreEnv()
+			cfg, err := newConfig()
+			require.NoError(t, err)
+			WithWAFTimeout(10 * time.Millisecond)(cfg)
+			require.Equal(t, &expCfg, cfg)
+		})
+
+		t.Run("waf-timeout-env-precedence", func(t *testing.T) {
+			expCfg := *expectedDefaultConfig
+			expCfg.wafTimeout = 5 * time.Second
+			restoreEnv := cleanEnv()
+			defer restoreEnv()
+			require.NoError(t, os.Setenv(wafTimeoutEnvVar, "5s"))
+			cfg, err := newConfig()
+			require.NoError(t, err)
+			WithWAFTimeout(10 * time.Millisecond)(cfg)
+			require.Equal(t, &expCfg, cfg)
+		})
+
+		t.Run("waf-timeout-negative", func(t *testing.T) {
+			restoreEnv := cleanEnv()
+			defer restoreEnv()
+			cfg, err := newConfig()
+			require.NoError(t, err)
+			WithWAFTimeout(-time.Second)(cfg)
+			require.Equal(t, expectedDefaultConfig, cfg)
+		})
+
+		t.Run("rate-limit", func(t *testing.T) {
+			expCfg := *expectedDefaultConfig
+			expCfg.traceRateLimit = 42
+			restoreEnv := cleanEnv()
+			defer restoreEnv()
+			cfg, err := newConfig()
+			require.NoError(t, err)
+			WithRateLimit(42)(cfg)
+			require.Equal(t, &expCfg, cfg)
+		})
+
+		t.Run("rate-limit-env-precedence", func(t *testing.T) {
+			expCfg := *expectedDefaultConfig
+			expCfg.traceRateLimit = 1234
+			restoreEnv := cleanEnv()
+			defer restoreEnv()
+			require.NoError(t, os.Setenv(traceRateLimitEnvVar, "1234"))
+			cfg, err := newConfig()
+			require.NoError(t, err)
+			WithRateLimit(42)(cfg)
+			require.Equal(t, &expCfg, cfg)
+		})
+
+		t.Run("rate-limit-zero", func(t *testing.T) {
+			restoreEnv := cleanEnv()
+			defer restoreEnv()
+			cfg, err := newConfig()
+			require.NoError(t, err)
+			WithRateLimit(0)(cfg)
+			require.Equal(t, expectedDefaultConfig, cfg)
+		})
+	})
+
+	t.Run("attack-sampling", func(t *testing.T) {
+		t.Run("env-var", func(t *testing.T) {
+			expCfg := *expectedDefaultConfig
+			expCfg.attackSampling = AttackSamplingConfig{Threshold: 50, Rate: 0.25}
+			restoreEnv := cleanEnv()
+			defer restoreEnv()
+			require.NoError(t, os.Setenv(attackThresholdEnvVar, "50"))
+			require.NoError(t, os.Setenv(attackRateEnvVar, "0.25"))
+			cfg, err := newConfig()
+			require.NoError(t, err)
+			require.Equal(t, &expCfg, cfg)
+		})
+
+		t.Run("invalid-env-var", func(t *testing.T) {
+			restoreEnv := cleanEnv()
+			defer restoreEnv()
+			require.NoError(t, os.Setenv(attackThresholdEnvVar, "-1"))
+			require.NoError(t, os.Setenv(attackRateEnvVar, "1.5"))
+			cfg, err := newConfig()
+			require.NoError(t, err)
+			require.Equal(t, expectedDefaultConfig, cfg)
+		})
+
+		t.Run("start-option", func(t *testing.T) {
+			expCfg := *expectedDefaultConfig
+			expCfg.attackSampling = AttackSamplingConfig{Threshold: 10, Rate: 0.5}
+			restoreEnv := cleanEnv()
+			defer restoreEnv()
+			cfg, err := newConfig()
+			require.NoError(t, err)
+			WithAttackSampling(10, 0.5)(cfg)
+			require.Equal(t, &expCfg, cfg)
+		})
+
+		t.Run("start-option-env-precedence", func(t *testing.T) {
+			expCfg := *expectedDefaultConfig
+			expCfg.attackSampling = AttackSamplingConfig{Threshold: 50, Rate: 0.5}
+			restoreEnv := cleanEnv()
+			defer restoreEnv()
+			require.NoError(t, os.Setenv(attackThresholdEnvVar, "50"))
+			cfg, err := newConfig()
+			require.NoError(t, err)
+			WithAttackSampling(10, 0.5)(cfg)
+			require.Equal(t, &expCfg, cfg)
+		})
+	})
+
+	t.Run("blocking", func(t *testing.T) {
+		t.Run("enabled", func(t *testing.T) {
+			expCfg := *expectedDefaultConfig
+			expCfg.blocking.Enabled = true
+			restoreEnv := cleanEnv()
+			defer restoreEnv()
+			require.NoError(t, os.Setenv(blockingEnvVar, "true"))
+			cfg, err := newConfig()
+			require.NoError(t, err)
+			require.Equal(t, &expCfg, cfg)
+		})
+
+		t.Run("not-parsable", func(t *testing.T) {
+			restoreEnv := cleanEnv()
+			defer restoreEnv()
+			require.NoError(t, os.Setenv(blockingEnvVar, "not a bool"))
+			cfg, err := newConfig()
+			require.NoError(t, err)
+			require.Equal(t, expectedDefaultConfig, cfg)
+		})
+
+		t.Run("status", func(t *testing.T) {
+			expCfg := *expectedDefaultConfig
+			expCfg.blocking.Status = 418
+			restoreEnv := cleanEnv()
+			defer restoreEnv()
+			require.NoError(t, os.Setenv(blockedStatusEnvVar, "418"))
+			cfg, err := newConfig()
+			require.NoError(t, err)
+			require.Equal(t, &expCfg, cfg)
+		})
+
+		t.Run("invalid-status", func(t *testing.T) {
+			restoreEnv := cleanEnv()
+			defer restoreEnv()
+			require.NoError(t, os.Setenv(blockedStatusEnvVar, "1000"))
+			cfg, err := newConfig()
+			require.NoError(t, err)
+			require.Equal(t, expectedDefaultConfig, cfg)
+		})
+
+		t.Run("template-file", func(t *testing.T) {
+			restoreEnv := cleanEnv()
+			defer restoreEnv()
+			file, err := os.CreateTemp("", "example-*")
+			require.NoError(t, err)
+			defer func() {
+				file.Close()
+				os.Remove(file.Name())
+			}()
+			expectedBody := `{"blocked":true}`
+			expCfg := *expectedDefaultConfig
+			expCfg.blocking.Body = []byte(expectedBody)
+			_, err = file.WriteString(expectedBody)
+			require.NoError(t, err)
+			os.Setenv(blockedTemplateEnvVar, file.Name())
+			cfg, err := newConfig()
+			require.NoError(t, err)
+			require.Equal(t, &expCfg, cfg)
+		})
+
+		t.Run("template-file-not-json", func(t *testing.T) {
+			restoreEnv := cleanEnv()
+			defer restoreEnv()
+			file, err := os.CreateTemp("", "example-*")
+			require.NoError(t, err)
+			defer func() {
+				file.Close()
+				os.Remove(file.Name())
+			}()
+			_, err = file.WriteString("not json")
+			require.NoError(t, err)
+			os.Setenv(blockedTemplateEnvVar, file.Name())
+			cfg, err := newConfig()
+			require.Error(t, err)
+			require.Nil(t, cfg)
+		})
+
+		t.Run("template-file-not-found", func(t *testing.T) {
+			restoreEnv := cleanEnv()
+			defer restoreEnv()
+			os.Setenv(blockedTemplateEnvVar, "i do not exist")
+			cfg, err := newConfig()
+			require.Error(t, err)
+			require.Nil(t, cfg)
+		})
+	})
+
 	t.Run("obfuscator", func(t *testing.T) {
 		t.Run("key-regexp", func(t *testing.T) {
 			t.Run("env-var-normal", func(t *testing.T) {
@@ -246,16 +454,92 @@ func TestConfig(t *testing.T) {
 				require.Equal(t, expectedDefaultConfig, cfg)
 			})
 		})
+
+		t.Run("options", func(t *testing.T) {
+			t.Run("normal", func(t *testing.T) {
+				expCfg := *expectedDefaultConfig
+				expCfg.obfuscator.KeyRegex = "key"
+				expCfg.obfuscator.ValueRegex = "value"
+				restoreEnv := cleanEnv()
+				defer restoreEnv()
+				cfg, err := newConfig()
+				require.NoError(t, err)
+				WithObfuscatorKeyRegex("key")(cfg)
+				WithObfuscatorValueRegex("value")(cfg)
+				require.Equal(t, &expCfg, cfg)
+			})
+			t.Run("env-var-precedence", func(t *testing.T) {
+				expCfg := *expectedDefaultConfig
+				expCfg.obfuscator.KeyRegex = "env-key"
+				expCfg.obfuscator.ValueRegex = "env-value"
+				restoreEnv := cleanEnv()
+				defer restoreEnv()
+				require.NoError(t, os.Setenv(obfuscatorKeyEnvVar, "env-key"))
+				require.NoError(t, os.Setenv(obfuscatorValueEnvVar, "env-value"))
+				cfg, err := newConfig()
+				require.NoError(t, err)
+				WithObfuscatorKeyRegex("key")(cfg)
+				WithObfuscatorValueRegex("value")(cfg)
+				require.Equal(t, &expCfg, cfg)
+			})
+			t.Run("compile-error", func(t *testing.T) {
+				restoreEnv := cleanEnv()
+				defer restoreEnv()
+				cfg, err := newConfig()
+				require.NoError(t, err)
+				WithObfuscatorKeyRegex("+")(cfg)
+				WithObfuscatorValueRegex("(")(cfg)
+				require.Error(t, cfg.err)
+				require.Contains(t, cfg.err.Error(), "`+`")
+				require.Equal(t, expectedDefaultConfig.obfuscator, cfg.obfuscator)
+			})
+		})
+	})
+
+	t.Run("grpc-metadata-keys", func(t *testing.T) {
+		t.Run("env-var", func(t *testing.T) {
+			restoreEnv := cleanEnv()
+			defer restoreEnv()
+			require.NoError(t, os.Setenv(grpcMetadataKeysEnvVar, " Authorization,x-api-key,,authorization "))
+			cfg, err := newConfig()
+			require.NoError(t, err)
+			require.Equal(t, []string{"authorization", "x-api-key"}, cfg.grpcMetadataKeys)
+		})
+
+		t.Run("option", func(t *testing.T) {
+			restoreEnv := cleanEnv()
+			defer restoreEnv()
+			cfg, err := newConfig()
+			require.NoError(t, err)
+			WithGRPCMetadataKeys("X-Api-Key", "")(cfg)
+			require.Equal(t, []string{"x-api-key"}, cfg.grpcMetadataKeys)
+		})
+
+		t.Run("env-var-precedence", func(t *testing.T) {
+			restoreEnv := cleanEnv()
+			defer restoreEnv()
+			require.NoError(t, os.Setenv(grpcMetadataKeysEnvVar, "authorization"))
+			cfg, err := newConfig()
+			require.NoError(t, err)
+			WithGRPCMetadataKeys("x-api-key")(cfg)
+			require.Equal(t, []string{"authorization"}, cfg.grpcMetadataKeys)
+		})
 	})
 }
 
 func cleanEnv() func() {
 	env := map[string]string{
-		wafTimeoutEnvVar:      os.Getenv(wafTimeoutEnvVar),
-		rulesEnvVar:           os.Getenv(rulesEnvVar),
-		traceRateLimitEnvVar:  os.Getenv(traceRateLimitEnvVar),
-		obfuscatorKeyEnvVar:   os.Getenv(obfuscatorKeyEnvVar),
-		obfuscatorValueEnvVar: os.Getenv(obfuscatorValueEnvVar),
+		wafTimeoutEnvVar:       os.Getenv(wafTimeoutEnvVar),
+		rulesEnvVar:            os.Getenv(rulesEnvVar),
+		traceRateLimitEnvVar:   os.Getenv(traceRateLimitEnvVar),
+		obfuscatorKeyEnvVar:    os.Getenv(obfuscatorKeyEnvVar),
+		obfuscatorValueEnvVar:  os.Getenv(obfuscatorValueEnvVar),
+		blockingEnvVar:         os.Getenv(blockingEnvVar),
+		blockedStatusEnvVar:    os.Getenv(blockedStatusEnvVar),
+		blockedTemplateEnvVar:  os.Getenv(blockedTemplateEnvVar),
+		grpcMetadataKeysEnvVar: os.Getenv(grpcMetadataKeysEnvVar),
+		attackThresholdEnvVar:  os.Getenv(attackThresholdEnvVar),
+		attackRateEnvVar:       os.Getenv(attackRateEnvVar),
 	}
 	for k, _ := range env {
 		if err := os.Unsetenv(k); err != nil {
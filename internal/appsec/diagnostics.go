@@ -0,0 +1,97 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2022 Datadog, Inc.
+
+package appsec
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"gopkg.in/DataDog/dd-trace-go.v1/internal/log"
+)
+
+// Reasons of the WAF initialization errors.
+const (
+	// InitErrorNoAddresses is the reason of the WAF initialization error when
+	// the security rules don't use any address.
+	InitErrorNoAddresses = "no_addresses"
+	// InitErrorUnsupportedAddresses is the reason of the WAF initialization
+	// error when none of the addresses used by the security rules are supported.
+	InitErrorUnsupportedAddresses = "unsupported_addresses"
+)
+
+// InitError is the error returned when the WAF cannot be initialized because
+// of the addresses used by the security rules, which disables AppSec.
+type InitError struct {
+	// Reason is either InitErrorNoAddresses or InitErrorUnsupportedAddresses.
+	Reason string
+	// Unsupported lists the addresses of the rules which are not supported.
+	Unsupported []string
+}
+
+func (e *InitError) Error() string {
+	if e.Reason == InitErrorNoAddresses {
+		return "no addresses found in the rule"
+	}
+	return fmt.Sprintf("the addresses present in the rule are not supported: %v", e.Unsupported)
+}
+
+var (
+	// startupErr is the WAF initialization error which prevented AppSec from
+	// starting, if any.
+	startupErr   *InitError
+	startupErrMu sync.RWMutex
+	// diagnosticOnce makes sure the startup diagnostic is only logged once
+	// per process.
+	diagnosticOnce sync.Once
+)
+
+// wafContextErrors is the number of times a WAF context couldn't be created
+// since it was last reported. Besides the expected concurrent release of the
+// WAF handle, persistent errors can reveal a leaked or closed WAF handle.
+var wafContextErrors uint64
+
+// WAFContextErrors returns the number of times a WAF context couldn't be
+// created since the previous call, which the tracer reports as a count metric.
+func WAFContextErrors() uint64 {
+	return atomic.SwapUint64(&wafContextErrors, 0)
+}
+
+// StartupError returns the WAF initialization error which prevented AppSec
+// from starting, or nil if there was none. It allows to report a misconfigured
+// ruleset, as AppSec is otherwise silently disabled.
+func StartupError() *InitError {
+	startupErrMu.RLock()
+	defer startupErrMu.RUnlock()
+	return startupErr
+}
+
+// reportStartupError records err when it is a WAF initialization error so
+// that it is returned by StartupError, and logs a diagnostic describing it
+// the first time it happens.
+func reportStartupError(err error) {
+	initErr, ok := err.(*InitError)
+	if !ok {
+		return
+	}
+	startupErrMu.Lock()
+	startupErr = initErr
+	startupErrMu.Unlock()
+	diagnosticOnce.Do(func() {
+		log.Error("appsec: DIAGNOSTICS %s", startupDiagnostic(initErr))
+	})
+}
+
+// startupDiagnostic returns the message describing the given WAF initialization
+// error to the operators.
+func startupDiagnostic(err *InitError) string {
+	switch err.Reason {
+	case InitErrorNoAddresses:
+		return "AppSec is disabled: the security rules do not use any address, so that no request data can be monitored. Please check the ruleset configured with DD_APPSEC_RULES."
+	default:
+		return fmt.Sprintf("AppSec is disabled: none of the addresses used by the security rules are supported by this version of the tracer: %v. Please check the ruleset configured with DD_APPSEC_RULES.", err.Unsupported)
+	}
+}
@@ -0,0 +1,92 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2022 Datadog, Inc.
+
+//go:build appsec
+// +build appsec
+
+package appsec
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gopkg.in/DataDog/dd-trace-go.v1/internal/log"
+)
+
+func TestStartupDiagnostics(t *testing.T) {
+	resetStartupError := func() {
+		startupErrMu.Lock()
+		startupErr = nil
+		startupErrMu.Unlock()
+		diagnosticOnce = sync.Once{}
+	}
+
+	for _, tc := range []struct {
+		name          string
+		addresses     []string
+		reason        string
+		unsupported   []string
+		diagnosticHas string
+	}{
+		{
+			name:          "no-addresses",
+			addresses:     nil,
+			reason:        InitErrorNoAddresses,
+			diagnosticHas: "the security rules do not use any address",
+		},
+		{
+			name:          "unsupported-addresses",
+			addresses:     []string{"server.request.unknown", "grpc.server.unknown"},
+			reason:        InitErrorUnsupportedAddresses,
+			unsupported:   []string{"server.request.unknown", "grpc.server.unknown"},
+			diagnosticHas: "none of the addresses used by the security rules are supported by this version of the tracer: [server.request.unknown grpc.server.unknown]",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			resetStartupError()
+			defer resetStartupError()
+			rl := new(log.RecordLogger)
+			defer log.UseLogger(rl)()
+
+			_, _, err := checkRuleAddresses(tc.addresses, nil)
+			var initErr *InitError
+			require.True(t, errors.As(err, &initErr))
+			require.Equal(t, tc.reason, initErr.Reason)
+			require.Equal(t, tc.unsupported, initErr.Unsupported)
+
+			// Reporting the error several times only logs the diagnostic once
+			reportStartupError(err)
+			reportStartupError(err)
+			log.Flush()
+			require.Equal(t, initErr, StartupError())
+
+			var diagnostics []string
+			for _, l := range rl.Logs() {
+				if strings.Contains(l, "appsec: DIAGNOSTICS") {
+					diagnostics = append(diagnostics, l)
+				}
+			}
+			require.Len(t, diagnostics, 1)
+			require.Contains(t, diagnostics[0], tc.diagnosticHas)
+		})
+	}
+
+	t.Run("partially-supported", func(t *testing.T) {
+		httpAddrs, grpcAddrs, err := checkRuleAddresses([]string{serverRequestRawURIAddr, grpcServerRequestMessage, "server.request.unknown"}, nil)
+		require.NoError(t, err)
+		require.Equal(t, []string{serverRequestRawURIAddr}, httpAddrs)
+		require.Equal(t, []string{grpcServerRequestMessage}, grpcAddrs)
+	})
+
+	t.Run("other-errors", func(t *testing.T) {
+		resetStartupError()
+		reportStartupError(errors.New("unexpected"))
+		require.Nil(t, StartupError())
+	})
+}
@@ -45,6 +45,9 @@ type (
 		// Message received by the gRPC handler.
 		// Corresponds to the address `grpc.server.request.metadata`.
 		Metadata map[string][]string
+		// Method is the full name of the RPC method, in the form
+		// `/package.Service/Method`.
+		Method string
 	}
 	// HandlerOperationRes is the grpc handler results. Empty as of today.
 	HandlerOperationRes struct{}
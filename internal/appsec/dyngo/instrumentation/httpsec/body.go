@@ -0,0 +1,141 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2022 Datadog, Inc.
+
+package httpsec
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// DefaultMaxBodySize is the default maximum size of the request bodies parsed
+// by MonitorBody.
+const DefaultMaxBodySize = 1 << 20 // 1MB
+
+// ErrBodyTooLarge is the error returned when the request body to parse is
+// larger than the maximum size allowed.
+var ErrBodyTooLarge = errors.New("appsec: http request body too large to be monitored")
+
+// MonitorBody reads and parses the body of the given request according to its
+// content type, and runs the security monitoring rules on the parsed body by
+// starting and finishing the SDK body operation. The body of the request is
+// restored so that it can still be read by the handler. Bodies larger than
+// maxSize bytes, or DefaultMaxBodySize when maxSize is not positive, are not
+// parsed and ErrBodyTooLarge is returned. The bodies of unsupported content
+// types are ignored.
+// This function should not be called when AppSec is disabled in order to
+// get preciser error logs.
+func MonitorBody(r *http.Request, maxSize int64) error {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil
+	}
+	if maxSize <= 0 {
+		maxSize = DefaultMaxBodySize
+	}
+	// Read one more byte than allowed to detect the bodies that are too large
+	raw, err := io.ReadAll(io.LimitReader(r.Body, maxSize+1))
+	// Restore the body with the bytes read so far, followed by the rest of it
+	r.Body = readCloser{Reader: io.MultiReader(bytes.NewReader(raw), r.Body), Closer: r.Body}
+	if err != nil {
+		return err
+	}
+	if int64(len(raw)) > maxSize {
+		return ErrBodyTooLarge
+	}
+	body, err := ParseBody(r.Header.Get("Content-Type"), raw)
+	if err != nil || body == nil {
+		return err
+	}
+	MonitorParsedBody(r.Context(), body)
+	return nil
+}
+
+// ParseBody parses the given raw request body according to its content type
+// into the structured value expected by the `server.request.body` address:
+//   - JSON bodies are parsed into their map[string]interface{}, []interface{}
+//     or scalar value,
+//   - URL-encoded and multipart form bodies are parsed into a
+//     map[string]interface{} of the form field names to their []string values.
+//     The contents of the files of multipart forms are not included.
+//
+// A nil value and no error are returned for the unsupported content types.
+func ParseBody(contentType string, raw []byte) (interface{}, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, nil
+	}
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		var body interface{}
+		if err := json.Unmarshal(raw, &body); err != nil {
+			return nil, err
+		}
+		return body, nil
+	case mediaType == "application/x-www-form-urlencoded":
+		values, err := url.ParseQuery(string(raw))
+		if err != nil {
+			return nil, err
+		}
+		return makeFormBody(values), nil
+	case mediaType == "multipart/form-data":
+		values, err := parseMultipartForm(raw, params["boundary"])
+		if err != nil {
+			return nil, err
+		}
+		return makeFormBody(values), nil
+	default:
+		return nil, nil
+	}
+}
+
+// parseMultipartForm returns the values of the form fields of the given
+// multipart body, skipping the file parts.
+func parseMultipartForm(raw []byte, boundary string) (url.Values, error) {
+	if boundary == "" {
+		return nil, errors.New("missing multipart boundary")
+	}
+	values := make(url.Values)
+	mr := multipart.NewReader(bytes.NewReader(raw), boundary)
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return values, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := part.FormName()
+		if name == "" || part.FileName() != "" {
+			continue
+		}
+		v, err := io.ReadAll(part)
+		if err != nil {
+			return nil, err
+		}
+		values.Add(name, string(v))
+	}
+}
+
+func makeFormBody(values url.Values) map[string]interface{} {
+	body := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		body[k] = v
+	}
+	return body
+}
+
+// readCloser combines the reader of a restored request body with the closer
+// of the original one.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,139 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2022 Datadog, Inc.
+
+package httpsec
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBody(t *testing.T) {
+	var multipartBody bytes.Buffer
+	mw := multipart.NewWriter(&multipartBody)
+	require.NoError(t, mw.WriteField("name", "value"))
+	require.NoError(t, mw.WriteField("name", "other value"))
+	fw, err := mw.CreateFormFile("file", "file.txt")
+	require.NoError(t, err)
+	fw.Write([]byte("file content"))
+	require.NoError(t, mw.Close())
+
+	for _, tc := range []struct {
+		name        string
+		contentType string
+		body        string
+		expected    interface{}
+		err         bool
+	}{
+		{
+			name:        "json",
+			contentType: "application/json; charset=utf-8",
+			body:        `{"name":"value","list":[1,"two"],"nested":{"bool":true}}`,
+			expected: map[string]interface{}{
+				"name":   "value",
+				"list":   []interface{}{1.0, "two"},
+				"nested": map[string]interface{}{"bool": true},
+			},
+		},
+		{
+			name:        "json-suffix",
+			contentType: "application/vnd.api+json",
+			body:        `["value"]`,
+			expected:    []interface{}{"value"},
+		},
+		{
+			name:        "json-malformed",
+			contentType: "application/json",
+			body:        `{"name":`,
+			err:         true,
+		},
+		{
+			name:        "urlencoded",
+			contentType: "application/x-www-form-urlencoded",
+			body:        "name=value&name=other+value&empty=",
+			expected: map[string]interface{}{
+				"name":  []string{"value", "other value"},
+				"empty": []string{""},
+			},
+		},
+		{
+			name:        "multipart",
+			contentType: mw.FormDataContentType(),
+			body:        multipartBody.String(),
+			expected: map[string]interface{}{
+				"name": []string{"value", "other value"},
+			},
+		},
+		{
+			name:        "multipart-no-boundary",
+			contentType: "multipart/form-data",
+			body:        multipartBody.String(),
+			err:         true,
+		},
+		{
+			name:        "unsupported",
+			contentType: "text/plain",
+			body:        "value",
+		},
+		{
+			name: "missing-content-type",
+			body: `{"name":"value"}`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			body, err := ParseBody(tc.contentType, []byte(tc.body))
+			if tc.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, body)
+		})
+	}
+}
+
+func TestMonitorBody(t *testing.T) {
+	const body = `{"name":"value"}`
+
+	t.Run("restored", func(t *testing.T) {
+		req, err := http.NewRequest("POST", "/", strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		require.NoError(t, MonitorBody(req, 0))
+		b, err := io.ReadAll(req.Body)
+		require.NoError(t, err)
+		require.Equal(t, body, string(b))
+	})
+
+	t.Run("too-large", func(t *testing.T) {
+		req, err := http.NewRequest("POST", "/", strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		require.Equal(t, ErrBodyTooLarge, MonitorBody(req, int64(len(body)-1)))
+		// The handler can still read the whole body
+		b, err := io.ReadAll(req.Body)
+		require.NoError(t, err)
+		require.Equal(t, body, string(b))
+	})
+
+	t.Run("max-size", func(t *testing.T) {
+		req, err := http.NewRequest("POST", "/", strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		require.NoError(t, MonitorBody(req, int64(len(body))))
+	})
+
+	t.Run("no-body", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/", nil)
+		require.NoError(t, err)
+		require.NoError(t, MonitorBody(req, 0))
+	})
+}
@@ -17,6 +17,7 @@ import (
 	"net/http"
 	"reflect"
 	"strings"
+	"sync"
 
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/appsec/dyngo"
@@ -38,12 +39,19 @@ type (
 		Query map[string][]string
 		// PathParams corresponds to the address `server.request.path_params`
 		PathParams map[string]string
+		// ClientIP corresponds to the address `http.client_ip`
+		ClientIP string
 	}
 
 	// HandlerOperationRes is the HTTP handler operation results.
 	HandlerOperationRes struct {
 		// Status corresponds to the address `server.response.status`.
 		Status int
+		// Headers are the response headers, as set by the handler. They
+		// correspond to the address `server.response.headers.no_cookies` once
+		// their names lowercased and the cookies removed, which is left to the
+		// event listeners interested in it.
+		Headers map[string][]string
 	}
 
 	// SDKBodyOperationArgs is the SDK body operation arguments.
@@ -54,6 +62,37 @@ type (
 
 	// SDKBodyOperationRes is the SDK body operation results.
 	SDKBodyOperationRes struct{}
+
+	// SDKUserOperationArgs is the SDK user operation arguments.
+	SDKUserOperationArgs struct {
+		// UserID corresponds to the address `usr.id`.
+		UserID string
+	}
+
+	// SDKUserOperationRes is the SDK user operation results.
+	SDKUserOperationRes struct{}
+
+	// SDKResolverOperationArgs is the SDK GraphQL resolver operation
+	// arguments.
+	SDKResolverOperationArgs struct {
+		// Resolver is the name of the resolved field.
+		Resolver string
+		// Args are the arguments of the resolver, made available to the
+		// address `graphql.server.resolver` under the resolver name.
+		Args map[string]interface{}
+	}
+
+	// SDKResolverOperationRes is the SDK GraphQL resolver operation results.
+	SDKResolverOperationRes struct{}
+
+	// BlockingAction is the HTTP response to send instead of calling the
+	// handler when the request gets blocked.
+	BlockingAction struct {
+		// Status is the HTTP status code of the response.
+		Status int
+		// Body is the JSON body of the response.
+		Body []byte
+	}
 )
 
 // MonitorParsedBody starts and finishes the SDK body operation.
@@ -68,15 +107,42 @@ func MonitorParsedBody(ctx context.Context, body interface{}) {
 	}
 }
 
+// MonitorUser starts and finishes the SDK user operation, making the
+// authenticated user id available to the security rules of the request.
+// This function should not be called when AppSec is disabled in order to
+// get preciser error logs.
+func MonitorUser(ctx context.Context, userID string) {
+	if parent := fromContext(ctx); parent != nil {
+		op := StartSDKUserOperation(parent, SDKUserOperationArgs{UserID: userID})
+		op.Finish()
+	} else {
+		log.Debug("appsec: user id monitoring ignored: could not find the http handler instrumentation metadata in the request context: the request handler is not being monitored by a middleware function or the provided context is not the expected request context")
+	}
+}
+
+// MonitorResolver starts and finishes the SDK GraphQL resolver operation,
+// making the arguments of the resolver of the given field available to the
+// security rules of the request.
+// This function should not be called when AppSec is disabled in order to
+// get preciser error logs.
+func MonitorResolver(ctx context.Context, resolver string, args map[string]interface{}) {
+	if parent := fromContext(ctx); parent != nil {
+		op := StartSDKResolverOperation(parent, SDKResolverOperationArgs{Resolver: resolver, Args: args})
+		op.Finish()
+	} else {
+		log.Debug("appsec: graphql resolver monitoring ignored: could not find the http handler instrumentation metadata in the request context: the request handler is not being monitored by a middleware function or the provided context is not the expected request context")
+	}
+}
+
 // WrapHandler wraps the given HTTP handler with the abstract HTTP operation defined by HandlerOperationArgs and
 // HandlerOperationRes.
 func WrapHandler(handler http.Handler, span ddtrace.Span, pathParams map[string]string) http.Handler {
 	instrumentation.SetAppSecEnabledTags(span)
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		SetIPTags(span, r)
+		clientIP := SetIPTags(span, r)
 
-		args := MakeHandlerOperationArgs(r, pathParams)
+		args := makeHandlerOperationArgs(r, pathParams, clientIP)
 		ctx, op := StartOperation(r.Context(), args)
 		r = r.WithContext(ctx)
 		defer func() {
@@ -85,7 +151,7 @@ func WrapHandler(handler http.Handler, span ddtrace.Span, pathParams map[string]
 				status = mw.Status()
 			}
 
-			events := op.Finish(HandlerOperationRes{Status: status})
+			events := op.Finish(HandlerOperationRes{Status: status, Headers: w.Header()})
 			instrumentation.SetTags(span, op.Tags())
 			if len(events) == 0 {
 				return
@@ -98,14 +164,32 @@ func WrapHandler(handler http.Handler, span ddtrace.Span, pathParams map[string]
 			SetSecurityEventTags(span, events, remoteIP, args.Headers, w.Header())
 		}()
 
+		// Abort the request when an event listener asked for it
+		if action := op.blockingAction(); action != nil {
+			writeBlockingResponse(w, action)
+			return
+		}
 		handler.ServeHTTP(w, r)
 	})
 }
 
+// writeBlockingResponse writes the HTTP response of the given blocking action.
+func writeBlockingResponse(w http.ResponseWriter, action *BlockingAction) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(action.Status)
+	w.Write(action.Body)
+}
+
 // MakeHandlerOperationArgs creates the HandlerOperationArgs out of a standard
 // http.Request along with the given current span. It returns an empty structure
 // when appsec is disabled.
 func MakeHandlerOperationArgs(r *http.Request, pathParams map[string]string) HandlerOperationArgs {
+	return makeHandlerOperationArgs(r, pathParams, ClientIP(r))
+}
+
+// makeHandlerOperationArgs is like MakeHandlerOperationArgs but with the
+// already resolved client IP of the request.
+func makeHandlerOperationArgs(r *http.Request, pathParams map[string]string, clientIP string) HandlerOperationArgs {
 	headers := make(http.Header, len(r.Header))
 	for k, v := range r.Header {
 		k := strings.ToLower(k)
@@ -123,6 +207,7 @@ func MakeHandlerOperationArgs(r *http.Request, pathParams map[string]string) Han
 		Cookies:    cookies,
 		Query:      r.URL.Query(), // TODO(Julio-Guerra): avoid actively parsing the query values thanks to dynamic instrumentation
 		PathParams: pathParams,
+		ClientIP:   clientIP,
 	}
 }
 
@@ -149,6 +234,9 @@ type (
 		dyngo.Operation
 		instrumentation.TagsHolder
 		instrumentation.SecurityEventsHolder
+
+		blocking *BlockingAction
+		mu       sync.Mutex // blocking action mutex
 	}
 
 	// SDKBodyOperation type representing an SDK body. It must be created with
@@ -157,6 +245,20 @@ type (
 		dyngo.Operation
 	}
 
+	// SDKUserOperation type representing the SDK call setting the
+	// authenticated user. It must be created with StartSDKUserOperation() and
+	// finished with its Finish() method.
+	SDKUserOperation struct {
+		dyngo.Operation
+	}
+
+	// SDKResolverOperation type representing the call to a GraphQL resolver.
+	// It must be created with StartSDKResolverOperation() and finished with
+	// its Finish() method.
+	SDKResolverOperation struct {
+		dyngo.Operation
+	}
+
 	contextKey struct{}
 )
 
@@ -187,6 +289,24 @@ func (op *Operation) Finish(res HandlerOperationRes) []json.RawMessage {
 	return op.Events()
 }
 
+// SetBlockingAction tells the HTTP handler to abort the request and to
+// respond with the given blocking action instead of calling the handler.
+// It only has an effect when called by an OnHandlerOperationStart event
+// listener, as the handler is otherwise already running.
+// Thread safe.
+func (op *Operation) SetBlockingAction(action BlockingAction) {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	op.blocking = &action
+}
+
+// blockingAction returns the blocking action set by an event listener, if any.
+func (op *Operation) blockingAction() *BlockingAction {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	return op.blocking
+}
+
 // StartSDKBodyOperation starts the SDKBody operation and emits a start event
 func StartSDKBodyOperation(parent *Operation, args SDKBodyOperationArgs) *SDKBodyOperation {
 	op := &SDKBodyOperation{Operation: dyngo.NewOperation(parent)}
@@ -199,6 +319,30 @@ func (op *SDKBodyOperation) Finish() {
 	dyngo.FinishOperation(op, SDKBodyOperationRes{})
 }
 
+// StartSDKUserOperation starts the SDKUser operation and emits a start event
+func StartSDKUserOperation(parent *Operation, args SDKUserOperationArgs) *SDKUserOperation {
+	op := &SDKUserOperation{Operation: dyngo.NewOperation(parent)}
+	dyngo.StartOperation(op, args)
+	return op
+}
+
+// Finish finishes the SDKUser operation and emits a finish event
+func (op *SDKUserOperation) Finish() {
+	dyngo.FinishOperation(op, SDKUserOperationRes{})
+}
+
+// StartSDKResolverOperation starts the SDKResolver operation and emits a start event
+func StartSDKResolverOperation(parent *Operation, args SDKResolverOperationArgs) *SDKResolverOperation {
+	op := &SDKResolverOperation{Operation: dyngo.NewOperation(parent)}
+	dyngo.StartOperation(op, args)
+	return op
+}
+
+// Finish finishes the SDKResolver operation and emits a finish event
+func (op *SDKResolverOperation) Finish() {
+	dyngo.FinishOperation(op, SDKResolverOperationRes{})
+}
+
 // HTTP handler operation's start and finish event callback function types.
 type (
 	// OnHandlerOperationStart function type, called when an HTTP handler
@@ -213,13 +357,29 @@ type (
 	// OnSDKBodyOperationFinish function type, called when an SDK body
 	// operation finishes.
 	OnSDKBodyOperationFinish func(*SDKBodyOperation, SDKBodyOperationRes)
+	// OnSDKUserOperationStart function type, called when an SDK user
+	// operation starts.
+	OnSDKUserOperationStart func(*SDKUserOperation, SDKUserOperationArgs)
+	// OnSDKUserOperationFinish function type, called when an SDK user
+	// operation finishes.
+	OnSDKUserOperationFinish func(*SDKUserOperation, SDKUserOperationRes)
+	// OnSDKResolverOperationStart function type, called when an SDK GraphQL
+	// resolver operation starts.
+	OnSDKResolverOperationStart func(*SDKResolverOperation, SDKResolverOperationArgs)
+	// OnSDKResolverOperationFinish function type, called when an SDK GraphQL
+	// resolver operation finishes.
+	OnSDKResolverOperationFinish func(*SDKResolverOperation, SDKResolverOperationRes)
 )
 
 var (
-	handlerOperationArgsType = reflect.TypeOf((*HandlerOperationArgs)(nil)).Elem()
-	handlerOperationResType  = reflect.TypeOf((*HandlerOperationRes)(nil)).Elem()
-	sdkBodyOperationArgsType = reflect.TypeOf((*SDKBodyOperationArgs)(nil)).Elem()
-	sdkBodyOperationResType  = reflect.TypeOf((*SDKBodyOperationRes)(nil)).Elem()
+	handlerOperationArgsType     = reflect.TypeOf((*HandlerOperationArgs)(nil)).Elem()
+	handlerOperationResType      = reflect.TypeOf((*HandlerOperationRes)(nil)).Elem()
+	sdkBodyOperationArgsType     = reflect.TypeOf((*SDKBodyOperationArgs)(nil)).Elem()
+	sdkBodyOperationResType      = reflect.TypeOf((*SDKBodyOperationRes)(nil)).Elem()
+	sdkUserOperationArgsType     = reflect.TypeOf((*SDKUserOperationArgs)(nil)).Elem()
+	sdkUserOperationResType      = reflect.TypeOf((*SDKUserOperationRes)(nil)).Elem()
+	sdkResolverOperationArgsType = reflect.TypeOf((*SDKResolverOperationArgs)(nil)).Elem()
+	sdkResolverOperationResType  = reflect.TypeOf((*SDKResolverOperationRes)(nil)).Elem()
 )
 
 // ListenedType returns the type a OnHandlerOperationStart event listener
@@ -261,3 +421,47 @@ func (OnSDKBodyOperationFinish) ListenedType() reflect.Type { return sdkBodyOper
 func (f OnSDKBodyOperationFinish) Call(op dyngo.Operation, v interface{}) {
 	f(op.(*SDKBodyOperation), v.(SDKBodyOperationRes))
 }
+
+// ListenedType returns the type a OnSDKUserOperationStart event listener
+// listens to, which is the SDKUserOperationArgs type.
+func (OnSDKUserOperationStart) ListenedType() reflect.Type { return sdkUserOperationArgsType }
+
+// Call calls the underlying event listener function by performing the
+// type-assertion on v whose type is the one returned by ListenedType().
+func (f OnSDKUserOperationStart) Call(op dyngo.Operation, v interface{}) {
+	f(op.(*SDKUserOperation), v.(SDKUserOperationArgs))
+}
+
+// ListenedType returns the type a OnSDKUserOperationFinish event listener
+// listens to, which is the SDKUserOperationRes type.
+func (OnSDKUserOperationFinish) ListenedType() reflect.Type { return sdkUserOperationResType }
+
+// Call calls the underlying event listener function by performing the
+// type-assertion on v whose type is the one returned by ListenedType().
+func (f OnSDKUserOperationFinish) Call(op dyngo.Operation, v interface{}) {
+	f(op.(*SDKUserOperation), v.(SDKUserOperationRes))
+}
+
+// ListenedType returns the type a OnSDKResolverOperationStart event listener
+// listens to, which is the SDKResolverOperationArgs type.
+func (OnSDKResolverOperationStart) ListenedType() reflect.Type {
+	return sdkResolverOperationArgsType
+}
+
+// Call calls the underlying event listener function by performing the
+// type-assertion on v whose type is the one returned by ListenedType().
+func (f OnSDKResolverOperationStart) Call(op dyngo.Operation, v interface{}) {
+	f(op.(*SDKResolverOperation), v.(SDKResolverOperationArgs))
+}
+
+// ListenedType returns the type a OnSDKResolverOperationFinish event listener
+// listens to, which is the SDKResolverOperationRes type.
+func (OnSDKResolverOperationFinish) ListenedType() reflect.Type {
+	return sdkResolverOperationResType
+}
+
+// Call calls the underlying event listener function by performing the
+// type-assertion on v whose type is the one returned by ListenedType().
+func (f OnSDKResolverOperationFinish) Call(op dyngo.Operation, v interface{}) {
+	f(op.(*SDKResolverOperation), v.(SDKResolverOperationRes))
+}
@@ -0,0 +1,124 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2022 Datadog, Inc.
+
+package httpsec
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+
+	"gopkg.in/DataDog/dd-trace-go.v1/internal/appsec/dyngo"
+)
+
+// ErrRoundTripBlocked is the error returned by ProtectRoundTrip when the
+// outbound HTTP request got blocked by a security rule.
+var ErrRoundTripBlocked = errors.New("appsec: outbound http request blocked by a security rule")
+
+// Abstract outbound HTTP request operation definition.
+type (
+	// RoundTripOperationArgs is the outbound HTTP request operation arguments.
+	RoundTripOperationArgs struct {
+		// URL corresponds to the address `server.io.net.url`.
+		URL string
+	}
+
+	// RoundTripOperationRes is the outbound HTTP request operation results.
+	RoundTripOperationRes struct{}
+
+	// RoundTripOperation type representing an outbound HTTP request sent
+	// while handling an HTTP request. It must be created with
+	// StartRoundTripOperation() and finished with its Finish() method.
+	RoundTripOperation struct {
+		dyngo.Operation
+		blocked bool
+		mu      sync.Mutex // blocked mutex
+	}
+)
+
+// ProtectRoundTrip starts and finishes the operation of the outbound HTTP
+// request to the given URL, and returns ErrRoundTripBlocked when it must not
+// be sent. It does nothing when ctx is not the context of an HTTP request
+// monitored by AppSec, such as the outbound requests sent outside of the HTTP
+// handlers.
+// This function should not be called when AppSec is disabled.
+func ProtectRoundTrip(ctx context.Context, url string) error {
+	parent := fromContext(ctx)
+	if parent == nil {
+		return nil
+	}
+	op := StartRoundTripOperation(parent, RoundTripOperationArgs{URL: url})
+	op.Finish()
+	if op.isBlocked() {
+		return ErrRoundTripBlocked
+	}
+	return nil
+}
+
+// StartRoundTripOperation starts the outbound HTTP request operation and emits
+// a start event.
+func StartRoundTripOperation(parent *Operation, args RoundTripOperationArgs) *RoundTripOperation {
+	op := &RoundTripOperation{Operation: dyngo.NewOperation(parent)}
+	dyngo.StartOperation(op, args)
+	return op
+}
+
+// Finish finishes the outbound HTTP request operation and emits a finish event.
+func (op *RoundTripOperation) Finish() {
+	dyngo.FinishOperation(op, RoundTripOperationRes{})
+}
+
+// Block tells the instrumentation to abort the outbound HTTP request. It only
+// has an effect when called by an OnRoundTripOperationStart event listener.
+// Thread safe.
+func (op *RoundTripOperation) Block() {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	op.blocked = true
+}
+
+// isBlocked returns whether an event listener blocked the request.
+func (op *RoundTripOperation) isBlocked() bool {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	return op.blocked
+}
+
+// Outbound HTTP request operation's start and finish event callback function
+// types.
+type (
+	// OnRoundTripOperationStart function type, called when an outbound HTTP
+	// request operation starts.
+	OnRoundTripOperationStart func(*RoundTripOperation, RoundTripOperationArgs)
+	// OnRoundTripOperationFinish function type, called when an outbound HTTP
+	// request operation finishes.
+	OnRoundTripOperationFinish func(*RoundTripOperation, RoundTripOperationRes)
+)
+
+var (
+	roundTripOperationArgsType = reflect.TypeOf((*RoundTripOperationArgs)(nil)).Elem()
+	roundTripOperationResType  = reflect.TypeOf((*RoundTripOperationRes)(nil)).Elem()
+)
+
+// ListenedType returns the type a OnRoundTripOperationStart event listener
+// listens to, which is the RoundTripOperationArgs type.
+func (OnRoundTripOperationStart) ListenedType() reflect.Type { return roundTripOperationArgsType }
+
+// Call calls the underlying event listener function by performing the
+// type-assertion on v whose type is the one returned by ListenedType().
+func (f OnRoundTripOperationStart) Call(op dyngo.Operation, v interface{}) {
+	f(op.(*RoundTripOperation), v.(RoundTripOperationArgs))
+}
+
+// ListenedType returns the type a OnRoundTripOperationFinish event listener
+// listens to, which is the RoundTripOperationRes type.
+func (OnRoundTripOperationFinish) ListenedType() reflect.Type { return roundTripOperationResType }
+
+// Call calls the underlying event listener function by performing the
+// type-assertion on v whose type is the one returned by ListenedType().
+func (f OnRoundTripOperationFinish) Call(op dyngo.Operation, v interface{}) {
+	f(op.(*RoundTripOperation), v.(RoundTripOperationRes))
+}
@@ -21,6 +21,9 @@ import (
 const (
 	// envClientIPHeader is the name of the env var used to specify the IP header to be used for client IP collection.
 	envClientIPHeader = "DD_TRACE_CLIENT_IP_HEADER"
+	// envTrustedProxies is the name of the env var used to specify the comma-separated list of IP addresses and
+	// networks of the proxies trusted to report the client IP in the IP headers.
+	envTrustedProxies = "DD_TRACE_CLIENT_IP_TRUSTED_PROXIES"
 	// multipleIPHeaders sets the multiple ip header tag used internally to tell the backend an error occurred when
 	// retrieving an HTTP request client IP.
 	multipleIPHeaders = "_dd.multiple-ip-headers"
@@ -54,12 +57,44 @@ var (
 		"accept-encoding",
 		"accept-language")
 	clientIPHeader string
+	// trustedProxies is the list of networks of the proxies trusted to report the client IP in the IP headers. When
+	// empty, every proxy is trusted.
+	trustedProxies []netaddrIPPrefix
 )
 
 func init() {
 	// Required by sort.SearchStrings
 	sort.Strings(collectedHTTPHeaders[:])
 	clientIPHeader = os.Getenv(envClientIPHeader)
+	trustedProxies = parseTrustedProxies(os.Getenv(envTrustedProxies))
+}
+
+// parseTrustedProxies parses the given comma-separated list of IP addresses and networks. Invalid entries are
+// ignored.
+func parseTrustedProxies(s string) []netaddrIPPrefix {
+	var prefixes []netaddrIPPrefix
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		cidr := v
+		if !strings.Contains(v, "/") {
+			// a single IP address is the network made of it only
+			if ip, err := netaddrParseIP(v); err == nil && ip.Is4() {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+		prefix, err := netaddrParseIPPrefix(cidr)
+		if err != nil {
+			log.Warn("appsec: ignoring invalid trusted proxy %q in %s", v, envTrustedProxies)
+			continue
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes
 }
 
 // SetSecurityEventTags sets the AppSec-specific span tags when a security event occurred into the service entry span.
@@ -103,18 +138,52 @@ func ippref(s string) *netaddrIPPrefix {
 	return nil
 }
 
-// SetIPTags sets the IP related span tags for a given request
+// SetIPTags sets the IP related span tags for a given request, and returns the client IP it resolved, if any.
 // See https://docs.datadoghq.com/tracing/configure_data_security#configuring-a-client-ip-header for more information.
-func SetIPTags(span instrumentation.TagSetter, r *http.Request) {
+func SetIPTags(span instrumentation.TagSetter, r *http.Request) (clientIP string) {
+	ip, headers, ips := resolveClientIP(r)
+	if ip.IsValid() {
+		clientIP = ip.String()
+		span.SetTag(ext.HTTPClientIP, clientIP)
+	}
+	if len(ips) > 1 {
+		for i := range ips {
+			span.SetTag(ext.HTTPRequestHeaders+"."+headers[i], ips[i])
+		}
+		span.SetTag(multipleIPHeaders, strings.Join(headers, ","))
+	}
+	return clientIP
+}
+
+// ClientIP returns the IP address of the client of the given request, or an empty string when it cannot be resolved.
+// See resolveClientIP for the details of the resolution.
+func ClientIP(r *http.Request) string {
+	if ip, _, _ := resolveClientIP(r); ip.IsValid() {
+		return ip.String()
+	}
+	return ""
+}
+
+// resolveClientIP resolves the IP address of the client of the given request out of its IP headers and its remote
+// address, along with the IP headers found and their values. The client IP is invalid when it cannot be resolved,
+// including when several IP headers are found, as it is then ambiguous.
+//
+// When no trusted proxies are configured, the client IP is the first global IP address of the IP header, or the
+// remote address when global and no IP header is found. Otherwise, the remote address is the client IP unless it is
+// a trusted proxy, in which case the client IP is the rightmost IP address of the IP header that is not a trusted
+// proxy, ie. the one the first trusted proxy received the request from.
+func resolveClientIP(r *http.Request) (ip netaddrIP, headers, ips []string) {
+	remoteIP := parseIP(r.RemoteAddr)
+	if len(trustedProxies) > 0 && !isTrustedProxy(remoteIP) {
+		// The IP headers were not set by a trusted proxy and can be forged by the client: the remote address is the
+		// client IP.
+		return remoteIP, nil, nil
+	}
+
 	ipHeaders := defaultIPHeaders
 	if len(clientIPHeader) > 0 {
 		ipHeaders = []string{clientIPHeader}
 	}
-
-	var (
-		headers []string
-		ips     []string
-	)
 	for _, hdr := range ipHeaders {
 		if v := r.Header.Get(hdr); v != "" {
 			headers = append(headers, hdr)
@@ -122,24 +191,47 @@ func SetIPTags(span instrumentation.TagSetter, r *http.Request) {
 		}
 	}
 
-	if l := len(ips); l == 0 {
-		if remoteIP := parseIP(r.RemoteAddr); remoteIP.IsValid() && isGlobal(remoteIP) {
-			span.SetTag(ext.HTTPClientIP, remoteIP.String())
+	switch len(ips) {
+	case 0:
+		if remoteIP.IsValid() && isGlobal(remoteIP) {
+			ip = remoteIP
+		}
+	case 1:
+		hops := strings.Split(ips[0], ",")
+		if len(trustedProxies) == 0 {
+			for _, hop := range hops {
+				if hopIP := parseIP(strings.TrimSpace(hop)); hopIP.IsValid() && isGlobal(hopIP) {
+					ip = hopIP
+					break
+				}
+			}
+			break
 		}
-	} else if l == 1 {
-		for _, ipstr := range strings.Split(ips[0], ",") {
-			ip := parseIP(strings.TrimSpace(ipstr))
-			if ip.IsValid() && isGlobal(ip) {
-				span.SetTag(ext.HTTPClientIP, ip.String())
+		for i := len(hops) - 1; i >= 0; i-- {
+			hopIP := parseIP(strings.TrimSpace(hops[i]))
+			if !hopIP.IsValid() {
+				continue
+			}
+			ip = hopIP
+			if !isTrustedProxy(hopIP) {
 				break
 			}
 		}
-	} else {
-		for i := range ips {
-			span.SetTag(ext.HTTPRequestHeaders+"."+headers[i], ips[i])
+	}
+	return ip, headers, ips
+}
+
+// isTrustedProxy returns true when ip belongs to one of the trusted proxy networks.
+func isTrustedProxy(ip netaddrIP) bool {
+	if !ip.IsValid() {
+		return false
+	}
+	for _, n := range trustedProxies {
+		if n.Contains(ip) {
+			return true
 		}
-		span.SetTag(multipleIPHeaders, strings.Join(headers, ","))
 	}
+	return false
 }
 
 func parseIP(s string) netaddrIP {
@@ -8,6 +8,7 @@ package httpsec
 import (
 	"math/rand"
 	"net/http"
+	"net/url"
 	"testing"
 
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
@@ -239,6 +240,91 @@ func TestIPHeaders(t *testing.T) {
 	}
 }
 
+func TestTrustedProxies(t *testing.T) {
+	// Make sure to restore the real value of trustedProxies at the end of the test
+	defer func(p []netaddrIPPrefix) { trustedProxies = p }(trustedProxies)
+	trustedProxies = parseTrustedProxies("10.0.0.0/8, 203.0.113.7, invalid")
+	require.Len(t, trustedProxies, 2)
+
+	for _, tc := range []struct {
+		name       string
+		remoteAddr string
+		xff        string
+		expectedIP string
+	}{
+		{
+			name:       "multiple-hops",
+			remoteAddr: "10.0.0.1:1234",
+			xff:        "198.51.100.1, 192.0.2.1, 203.0.113.7, 10.1.2.3",
+			expectedIP: "192.0.2.1",
+		},
+		{
+			name:       "spoofed-hop",
+			remoteAddr: "203.0.113.7:1234",
+			xff:        "8.8.8.8, 192.0.2.1",
+			expectedIP: "192.0.2.1",
+		},
+		{
+			name:       "invalid-hop",
+			remoteAddr: "10.0.0.1:1234",
+			xff:        "192.0.2.1, not-an-ip",
+			expectedIP: "192.0.2.1",
+		},
+		{
+			name:       "private-client",
+			remoteAddr: "10.0.0.1:1234",
+			xff:        "192.168.1.1",
+			expectedIP: "192.168.1.1",
+		},
+		{
+			name:       "trusted-hops-only",
+			remoteAddr: "10.0.0.1:1234",
+			xff:        "10.0.0.5, 203.0.113.7",
+			expectedIP: "10.0.0.5",
+		},
+		{
+			name:       "untrusted-proxy",
+			remoteAddr: "198.51.100.9:4321",
+			xff:        "192.0.2.1",
+			expectedIP: "198.51.100.9",
+		},
+		{
+			name:       "untrusted-private-proxy",
+			remoteAddr: "192.168.1.1:4321",
+			xff:        "192.0.2.1",
+			expectedIP: "192.168.1.1",
+		},
+		{
+			name:       "no-header",
+			remoteAddr: "10.0.0.1:1234",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := http.Request{Header: http.Header{}, RemoteAddr: tc.remoteAddr}
+			if tc.xff != "" {
+				r.Header.Set("X-Forwarded-For", tc.xff)
+			}
+			require.Equal(t, tc.expectedIP, ClientIP(&r))
+			var span mockspan
+			SetIPTags(&span, &r)
+			if tc.expectedIP == "" {
+				require.Nil(t, span.Tag(ext.HTTPClientIP))
+			} else {
+				require.Equal(t, tc.expectedIP, span.Tag(ext.HTTPClientIP))
+			}
+		})
+	}
+}
+
+func TestMakeHandlerOperationArgsClientIP(t *testing.T) {
+	r := http.Request{
+		Header:     http.Header{"X-Forwarded-For": []string{"10.0.0.1, 8.8.8.8"}},
+		RemoteAddr: "127.0.0.1:1234",
+		URL:        &url.URL{Path: "/"},
+	}
+	require.Equal(t, "8.8.8.8", MakeHandlerOperationArgs(&r, nil).ClientIP)
+}
+
 func randIPv4() netaddrIP {
 	return netaddrIPv4(uint8(rand.Uint32()), uint8(rand.Uint32()), uint8(rand.Uint32()), uint8(rand.Uint32()))
 }
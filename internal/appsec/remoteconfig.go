@@ -11,6 +11,7 @@ package appsec
 import (
 	"encoding/json"
 	"fmt"
+	"sync"
 
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/appsec/waf"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/log"
@@ -84,8 +85,28 @@ func (a *appsec) asmFeaturesCallback(u remoteconfig.ProductUpdate) map[string]rc
 }
 
 type wafHandleWrapper struct {
-	handle interface {
-		UpdateRulesData([]rc.ASMDataRuleData) error
+	handle rulesDataUpdater
+	// rulesData is the last rules data successfully applied to the handle. It
+	// allows to apply it again when the handle gets swapped.
+	rulesData []rc.ASMDataRuleData
+	mu        sync.Mutex
+}
+
+type rulesDataUpdater interface {
+	UpdateRulesData([]rc.ASMDataRuleData) error
+}
+
+// swap replaces the WAF handle with the given one and applies the current
+// rules data to it.
+func (h *wafHandleWrapper) swap(handle rulesDataUpdater) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.handle = handle
+	if h.rulesData == nil {
+		return
+	}
+	if err := handle.UpdateRulesData(h.rulesData); err != nil {
+		log.Error("appsec: could not apply the current rules data to the new WAF handle: %v", err)
 	}
 }
 
@@ -126,9 +147,13 @@ func (h *wafHandleWrapper) asmDataCallback(u remoteconfig.ProductUpdate) map[str
 			rulesData = append(rulesData, data)
 		}
 	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
 	if err := h.handle.UpdateRulesData(rulesData); err != nil {
 		log.Debug("appsec: Remote config: could not update WAF rule data: %v.", err)
 		statuses = statusesFromUpdate(u, false, err)
+	} else {
+		h.rulesData = rulesData
 	}
 	return statuses
 }
@@ -224,7 +249,7 @@ func (a *appsec) enableRemoteActivation() error {
 	return nil
 }
 
-func (a *appsec) enableRCBlocking(handle wafHandleWrapper) error {
+func (a *appsec) enableRCBlocking(handle *wafHandleWrapper) error {
 	if a.rc == nil {
 		return fmt.Errorf("no valid remote configuration client")
 	}
@@ -205,7 +205,7 @@ func TestASMDataCallback(t *testing.T) {
 	} {
 		t.Run(tc.name, func(t *testing.T) {
 			u := chanUpdater{resChan: make(chan []rc.ASMDataRuleData, 4096)}
-			handle := wafHandleWrapper{&u}
+			handle := wafHandleWrapper{handle: &u}
 			defer close(u.resChan)
 			statuses := handle.asmDataCallback(tc.update)
 			// Check results by rule data ID since ordering is not guaranteed
@@ -11,8 +11,9 @@ package appsec
 import (
 	"encoding/json"
 	"errors"
-	"fmt"
+	"mime"
 	"sort"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -35,119 +36,224 @@ const (
 	wafDurationExtTag    = "_dd.appsec.waf.duration_ext"
 	wafTimeoutTag        = "_dd.appsec.waf.timeouts"
 	wafVersionTag        = "_dd.appsec.waf.version"
+	blockedRequestTag    = "appsec.blocked"
+	grpcMethodTag        = "appsec.grpc.method"
+
+	triggeredRulesTag      = "_dd.appsec.triggered_rules"
+	triggeredRulesCountTag = "_dd.appsec.triggered_rules.count"
 )
 
+// blockAction is the name of the WAF action telling to block the request.
+const blockAction = "block"
+
 // Register the WAF event listener.
 func (a *appsec) registerWAF() (unreg dyngo.UnregisterFunc, err error) {
+	unreg, handle, err := a.newWAF(a.cfg.rules)
+	if err != nil {
+		return nil, err
+	}
+	a.wafHandle = &wafHandleWrapper{handle: handle}
+	if err := a.enableRCBlocking(a.wafHandle); err != nil {
+		log.Error("appsec: Remote config: cannot enable blocking, rules data won't be updated: %v", err)
+	}
+	return unreg, nil
+}
+
+// ReloadRules replaces the security rules of the running WAF with the given
+// ones. The new WAF event listeners are registered before the current ones get
+// unregistered so that no request goes unmonitored during the swap. The
+// current WAF handle is closed, but only gets released once the WAF contexts
+// of the in-flight requests using it are closed.
+func (a *appsec) ReloadRules(rules []byte) error {
+	if !a.started {
+		return errors.New("appsec is not started")
+	}
+	unreg, handle, err := a.newWAF(rules)
+	if err != nil {
+		return err
+	}
+	a.wafHandle.swap(handle)
+	unregOld := a.unregisterWAF
+	a.unregisterWAF = unreg
+	a.cfg.rules = rules
+	unregOld()
+	return nil
+}
+
+// newWAF instantiates a WAF handle with the given rules and registers the WAF
+// event listeners using it.
+func (a *appsec) newWAF(rules []byte) (unreg dyngo.UnregisterFunc, handle *waf.Handle, err error) {
 	// Check the WAF is healthy
 	if err := waf.Health(); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	// Instantiate the WAF
-	waf, err := waf.NewHandle(a.cfg.rules, a.cfg.obfuscator.KeyRegex, a.cfg.obfuscator.ValueRegex)
+	handle, err = waf.NewHandle(rules, a.cfg.obfuscator.KeyRegex, a.cfg.obfuscator.ValueRegex)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	// Close the WAF in case of an error in what's following
 	defer func() {
 		if err != nil {
-			waf.Close()
+			handle.Close()
 		}
 	}()
 
-	// Check if there are addresses in the rule
-	ruleAddresses := waf.Addresses()
-	if len(ruleAddresses) == 0 {
-		return nil, errors.New("no addresses found in the rule")
-	}
 	// Check there are supported addresses in the rule
-	httpAddresses, grpcAddresses, notSupported := supportedAddresses(ruleAddresses)
-	if len(httpAddresses) == 0 && len(grpcAddresses) == 0 {
-		return nil, fmt.Errorf("the addresses present in the rule are not supported: %v", notSupported)
-	} else if len(notSupported) > 0 {
-		log.Debug("appsec: the addresses present in the rule are partially supported: not supported=%v", notSupported)
+	httpAddresses, grpcAddresses, err := checkRuleAddresses(handle.Addresses(), a.cfg.grpcMetadataKeys)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	// Register the WAF event listener
 	var unregisterHTTP, unregisterGRPC dyngo.UnregisterFunc
 	if len(httpAddresses) > 0 {
 		log.Debug("appsec: registering http waf listening to addresses %v", httpAddresses)
-		unregisterHTTP = dyngo.Register(newHTTPWAFEventListener(waf, httpAddresses, a.cfg.wafTimeout, a.limiter))
+		unregisterHTTP = dyngo.Register(newHTTPWAFEventListener(handle, httpAddresses, a.cfg.wafTimeout, a.limiter, a.sampler, a.cfg.blocking))
 	}
 	if len(grpcAddresses) > 0 {
 		log.Debug("appsec: registering grpc waf listening to addresses %v", grpcAddresses)
-		unregisterGRPC = dyngo.Register(newGRPCWAFEventListener(waf, grpcAddresses, a.cfg.wafTimeout, a.limiter))
-	}
-
-	if err := a.enableRCBlocking(wafHandleWrapper{waf}); err != nil {
-		log.Error("appsec: Remote config: cannot enable blocking, rules data won't be updated: %v", err)
+		unregisterGRPC = dyngo.Register(newGRPCWAFEventListener(handle, grpcAddresses, a.cfg.wafTimeout, a.limiter, a.sampler))
 	}
 
 	// Return an unregistration function that will also release the WAF instance.
 	return func() {
-		defer waf.Close()
+		defer handle.Close()
 		if unregisterHTTP != nil {
 			unregisterHTTP()
 		}
 		if unregisterGRPC != nil {
 			unregisterGRPC()
 		}
-	}, nil
+	}, handle, nil
 }
 
 // newWAFEventListener returns the WAF event listener to register in order to enable it.
-func newHTTPWAFEventListener(handle *waf.Handle, addresses []string, timeout time.Duration, limiter Limiter) dyngo.EventListener {
+func newHTTPWAFEventListener(handle *waf.Handle, addresses []string, timeout time.Duration, limiter Limiter, sampler *attackSampler, blocking BlockingConfig) dyngo.EventListener {
 	var monitorRulesOnce sync.Once // per instantiation
 
 	return httpsec.OnHandlerOperationStart(func(op *httpsec.Operation, args httpsec.HandlerOperationArgs) {
-		var body interface{}
+		wafCtx := waf.NewContext(handle)
+		if wafCtx == nil {
+			// The WAF event listener got concurrently released
+			atomic.AddUint64(&wafContextErrors, 1)
+			return
+		}
 
-		op.On(httpsec.OnSDKBodyOperationStart(func(op *httpsec.SDKBodyOperation, args httpsec.SDKBodyOperationArgs) {
-			body = args.Body
-		}))
+		var (
+			events  []json.RawMessage
+			blocked bool
+			mu      sync.Mutex // events and blocked mutex
+		)
+		// run runs the WAF on the given values and keeps track of the resulting security events.
+		run := func(values map[string]interface{}) (block bool) {
+			matches, actions := runWAF(wafCtx, values, timeout)
+			if len(matches) == 0 {
+				return false
+			}
+			log.Debug("appsec: attack detected by the waf")
+			mu.Lock()
+			defer mu.Unlock()
+			events = append(events, matches)
+			return blocking.Enabled && hasBlockAction(actions)
+		}
 
-		// At the moment, AppSec doesn't block the requests, and so we can use the fact we are in monitoring-only mode
-		// to call the WAF only once at the end of the handler operation.
-		op.On(httpsec.OnHandlerOperationFinish(func(op *httpsec.Operation, res httpsec.HandlerOperationRes) {
-			wafCtx := waf.NewContext(handle)
-			if wafCtx == nil {
-				// The WAF event listener got concurrently released
-				return
+		// Run the WAF on the request addresses as soon as the handler operation starts so that the request can be
+		// blocked before reaching the handler.
+		values := make(map[string]interface{}, len(addresses))
+		for _, addr := range addresses {
+			switch addr {
+			case serverRequestRawURIAddr:
+				values[serverRequestRawURIAddr] = args.RequestURI
+			case serverRequestHeadersNoCookiesAddr:
+				if headers := args.Headers; headers != nil {
+					values[serverRequestHeadersNoCookiesAddr] = headers
+				}
+			case serverRequestCookiesAddr:
+				if cookies := args.Cookies; cookies != nil {
+					values[serverRequestCookiesAddr] = cookies
+				}
+			case serverRequestQueryAddr:
+				if query := args.Query; query != nil {
+					values[serverRequestQueryAddr] = query
+				}
+			case serverRequestPathParams:
+				if pathParams := args.PathParams; pathParams != nil {
+					values[serverRequestPathParams] = pathParams
+				}
+			case httpClientIPAddr:
+				if args.ClientIP != "" {
+					values[httpClientIPAddr] = args.ClientIP
+				}
 			}
-			defer wafCtx.Close()
+		}
+		if len(values) > 0 && run(values) {
+			blocked = true
+			op.AddTag(blockedRequestTag, true)
+			op.SetBlockingAction(httpsec.BlockingAction{Status: blocking.Status, Body: blocking.Body})
+		}
 
-			// Run the WAF on the rule addresses available in the request args
-			values := make(map[string]interface{}, len(addresses))
-			for _, addr := range addresses {
-				switch addr {
-				case serverRequestRawURIAddr:
-					values[serverRequestRawURIAddr] = args.RequestURI
-				case serverRequestHeadersNoCookiesAddr:
-					if headers := args.Headers; headers != nil {
-						values[serverRequestHeadersNoCookiesAddr] = headers
-					}
-				case serverRequestCookiesAddr:
-					if cookies := args.Cookies; cookies != nil {
-						values[serverRequestCookiesAddr] = cookies
-					}
-				case serverRequestQueryAddr:
-					if query := args.Query; query != nil {
-						values[serverRequestQueryAddr] = query
-					}
-				case serverRequestPathParams:
-					if pathParams := args.PathParams; pathParams != nil {
-						values[serverRequestPathParams] = pathParams
-					}
-				case serverRequestBody:
-					if body != nil {
-						values[serverRequestBody] = body
+		if !blocked && hasAddress(addresses, serverRequestBody) {
+			var contentType string
+			if hasAddress(addresses, serverRequestBodyContentType) {
+				contentType = makeBodyContentType(args.Headers)
+			}
+			op.On(httpsec.OnSDKBodyOperationStart(func(_ *httpsec.SDKBodyOperation, args httpsec.SDKBodyOperationArgs) {
+				if args.Body != nil {
+					values := map[string]interface{}{serverRequestBody: args.Body}
+					if contentType != "" {
+						values[serverRequestBodyContentType] = contentType
 					}
-				case serverResponseStatusAddr:
-					values[serverResponseStatusAddr] = res.Status
+					run(values)
+				}
+			}))
+		}
+
+		if !blocked && hasAddress(addresses, userIDAddr) {
+			op.On(httpsec.OnSDKUserOperationStart(func(_ *httpsec.SDKUserOperation, args httpsec.SDKUserOperationArgs) {
+				if args.UserID != "" {
+					run(map[string]interface{}{userIDAddr: args.UserID})
+				}
+			}))
+		}
+
+		if !blocked && hasAddress(addresses, graphqlServerResolverAddr) {
+			op.On(httpsec.OnSDKResolverOperationStart(func(_ *httpsec.SDKResolverOperation, args httpsec.SDKResolverOperationArgs) {
+				if len(args.Args) > 0 {
+					run(map[string]interface{}{graphqlServerResolverAddr: map[string]interface{}{args.Resolver: args.Args}})
+				}
+			}))
+		}
+
+		if !blocked && hasAddress(addresses, serverIONetURLAddr) {
+			op.On(httpsec.OnRoundTripOperationStart(func(rtOp *httpsec.RoundTripOperation, args httpsec.RoundTripOperationArgs) {
+				if run(map[string]interface{}{serverIONetURLAddr: args.URL}) {
+					// Only the outbound request is blocked, but its security
+					// events must still be reported to explain why.
+					mu.Lock()
+					blocked = true
+					mu.Unlock()
+					rtOp.Block()
+				}
+			}))
+		}
+
+		op.On(httpsec.OnHandlerOperationFinish(func(op *httpsec.Operation, res httpsec.HandlerOperationRes) {
+			defer wafCtx.Close()
+
+			values := make(map[string]interface{}, 2)
+			if hasAddress(addresses, serverResponseStatusAddr) {
+				values[serverResponseStatusAddr] = res.Status
+			}
+			if hasAddress(addresses, serverResponseHeadersNoCookiesAddr) {
+				if headers := makeResponseHeaders(res.Headers); headers != nil {
+					values[serverResponseHeadersNoCookiesAddr] = headers
 				}
 			}
-			matches := runWAF(wafCtx, values, timeout)
+			if len(values) > 0 {
+				run(values)
+			}
 
 			// Add WAF metrics.
 			rInfo := handle.RulesetInfo()
@@ -160,13 +266,14 @@ func newHTTPWAFEventListener(handle *waf.Handle, addresses []string, timeout tim
 				op.AddTag(ext.ManualKeep, samplernames.AppSec)
 			})
 
-			// Log the attacks if any
-			if len(matches) == 0 {
-				return
-			}
-			log.Debug("appsec: attack detected by the waf")
-			if limiter.Allow() {
-				op.AddSecurityEvents(matches)
+			// Log the attacks if any. A blocked request always reports its security events in order to explain why it
+			// was blocked.
+			mu.Lock()
+			blocked := blocked
+			mu.Unlock()
+			if len(events) > 0 && (blocked || (sampler.keep(triggeredRules(events)) && limiter.Allow())) {
+				op.AddSecurityEvents(events...)
+				addTriggeredRulesTags(op, events)
 			}
 		}))
 	})
@@ -174,19 +281,34 @@ func newHTTPWAFEventListener(handle *waf.Handle, addresses []string, timeout tim
 
 // newGRPCWAFEventListener returns the WAF event listener to register in order
 // to enable it.
-func newGRPCWAFEventListener(handle *waf.Handle, _ []string, timeout time.Duration, limiter Limiter) dyngo.EventListener {
+func newGRPCWAFEventListener(handle *waf.Handle, addresses []string, timeout time.Duration, limiter Limiter, sampler *attackSampler) dyngo.EventListener {
 	var monitorRulesOnce sync.Once // per instantiation
 
+	// Map the metadata keys to their address when the rules use them
+	metadataKeysAddresses := make(map[string]string)
+	for _, addr := range addresses {
+		if key := strings.TrimPrefix(addr, grpcServerRequestMetadata+"."); key != addr {
+			metadataKeysAddresses[key] = addr
+		}
+	}
+
 	return grpcsec.OnHandlerOperationStart(func(op *grpcsec.HandlerOperation, handlerArgs grpcsec.HandlerOperationArgs) {
+		// A single WAF context is used for the whole RPC lifetime so that every
+		// received message is evaluated within the same context, rather than
+		// instantiating and releasing a WAF context per message.
+		wafCtx := waf.NewContext(handle)
+		if wafCtx == nil {
+			// The WAF event listener got concurrently released
+			atomic.AddUint64(&wafContextErrors, 1)
+			return
+		}
+
 		// Limit the maximum number of security events, as a streaming RPC could
 		// receive unlimited number of messages where we could find security events
 		const maxWAFEventsPerRequest = 10
 		var (
-			nbEvents          uint32
-			logOnce           sync.Once // per request
-			overallRuntimeNs  waf.AtomicU64
-			internalRuntimeNs waf.AtomicU64
-			nbTimeouts        waf.AtomicU64
+			nbEvents uint32
+			logOnce  sync.Once // per request
 
 			events []json.RawMessage
 			mu     sync.Mutex // events mutex
@@ -199,20 +321,6 @@ func newGRPCWAFEventListener(handle *waf.Handle, _ []string, timeout time.Durati
 				})
 				return
 			}
-			// The current workaround of the WAF context limitations is to
-			// simply instantiate and release the WAF context for the operation
-			// lifetime so that:
-			//   1. We avoid growing the memory usage of the context every time
-			//      a grpc.server.request.message value is added to it during
-			//      the RPC lifetime.
-			//   2. We avoid the limitation of 1 event per attack type.
-			// TODO(Julio-Guerra): a future libddwaf API should solve this out.
-			wafCtx := waf.NewContext(handle)
-			if wafCtx == nil {
-				// The WAF event listener got concurrently released
-				return
-			}
-			defer wafCtx.Close()
 			// Run the WAF on the rule addresses available in the args
 			// Note that we don't check if the address is present in the rules
 			// as we only support one at the moment, so this callback cannot be
@@ -220,17 +328,13 @@ func newGRPCWAFEventListener(handle *waf.Handle, _ []string, timeout time.Durati
 			values := map[string]interface{}{grpcServerRequestMessage: res.Message}
 			if md := handlerArgs.Metadata; len(md) > 0 {
 				values[grpcServerRequestMetadata] = md
+				for key, addr := range metadataKeysAddresses {
+					if v := md[key]; len(v) > 0 {
+						values[addr] = v
+					}
+				}
 			}
-			event := runWAF(wafCtx, values, timeout)
-
-			// WAF run durations are WAF context bound. As of now we need to keep track of those externally since
-			// we use a new WAF context for each callback. When we are able to re-use the same WAF context across
-			// callbacks, we can get rid of these variables and simply use the WAF bindings in OnHandlerOperationFinish.
-			overall, internal := wafCtx.TotalRuntime()
-			overallRuntimeNs.Add(overall)
-			internalRuntimeNs.Add(internal)
-			nbTimeouts.Add(wafCtx.TotalTimeouts())
-
+			event, _ := runWAF(wafCtx, values, timeout)
 			if len(event) == 0 {
 				return
 			}
@@ -242,8 +346,11 @@ func newGRPCWAFEventListener(handle *waf.Handle, _ []string, timeout time.Durati
 		}))
 
 		op.On(grpcsec.OnHandlerOperationFinish(func(op *grpcsec.HandlerOperation, _ grpcsec.HandlerOperationRes) {
+			defer wafCtx.Close()
+
 			rInfo := handle.RulesetInfo()
-			addWAFMonitoringTags(op, rInfo.Version, overallRuntimeNs.Load(), internalRuntimeNs.Load(), nbTimeouts.Load())
+			overallRuntimeNs, internalRuntimeNs := wafCtx.TotalRuntime()
+			addWAFMonitoringTags(op, rInfo.Version, overallRuntimeNs, internalRuntimeNs, wafCtx.TotalTimeouts())
 
 			// Log the following metrics once per instantiation of a WAF handle
 			monitorRulesOnce.Do(func() {
@@ -252,35 +359,101 @@ func newGRPCWAFEventListener(handle *waf.Handle, _ []string, timeout time.Durati
 			})
 
 			// Log the events if any
-			if len(events) > 0 && limiter.Allow() {
+			if len(events) > 0 && sampler.keep(triggeredRules(events)) && limiter.Allow() {
 				op.AddSecurityEvents(events...)
+				addTriggeredRulesTags(op, events)
+				// Attribute the security events to the targeted RPC method
+				if handlerArgs.Method != "" {
+					op.AddTag(grpcMethodTag, handlerArgs.Method)
+				}
 			}
 		}))
 	})
 }
 
-func runWAF(wafCtx *waf.Context, values map[string]interface{}, timeout time.Duration) []byte {
-	matches, _, err := wafCtx.Run(values, timeout)
+func runWAF(wafCtx *waf.Context, values map[string]interface{}, timeout time.Duration) ([]byte, []string) {
+	matches, actions, err := wafCtx.Run(values, timeout)
 	if err != nil {
 		if err == waf.ErrTimeout {
 			log.Debug("appsec: waf timeout value of %s reached", timeout)
 		} else {
 			log.Error("appsec: unexpected waf error: %v", err)
-			return nil
+			return nil, nil
+		}
+	}
+	return matches, actions
+}
+
+// hasBlockAction returns true when the list of actions returned by the WAF contains the block action.
+func hasBlockAction(actions []string) bool {
+	for _, action := range actions {
+		if action == blockAction {
+			return true
+		}
+	}
+	return false
+}
+
+// hasAddress returns true when addr is part of the given list of addresses.
+func hasAddress(addresses []string, addr string) bool {
+	for _, a := range addresses {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// makeResponseHeaders returns the given response headers following the
+// specification of the rule address `server.response.headers.no_cookies`, ie.
+// with lowercase names and without the cookies.
+func makeResponseHeaders(h map[string][]string) map[string][]string {
+	if len(h) == 0 {
+		return nil
+	}
+	headers := make(map[string][]string, len(h))
+	for k, v := range h {
+		k := strings.ToLower(k)
+		if k == "set-cookie" {
+			continue
+		}
+		headers[k] = v
+	}
+	return headers
+}
+
+// makeBodyContentType returns the media type of the request body following the
+// specification of the rule address `server.request.body.content_type`, ie.
+// the lowercase media type of the Content-Type header without its parameters.
+// An empty string is returned when the header is missing, malformed or when
+// its values disagree, as the body cannot be reliably typed.
+func makeBodyContentType(headers map[string][]string) string {
+	var contentType string
+	for _, v := range headers["content-type"] {
+		mediaType, _, err := mime.ParseMediaType(v)
+		if err != nil || (contentType != "" && contentType != mediaType) {
+			return ""
 		}
+		contentType = mediaType
 	}
-	return matches
+	return contentType
 }
 
 // HTTP rule addresses currently supported by the WAF
 const (
-	serverRequestRawURIAddr           = "server.request.uri.raw"
-	serverRequestHeadersNoCookiesAddr = "server.request.headers.no_cookies"
-	serverRequestCookiesAddr          = "server.request.cookies"
-	serverRequestQueryAddr            = "server.request.query"
-	serverRequestPathParams           = "server.request.path_params"
-	serverRequestBody                 = "server.request.body"
-	serverResponseStatusAddr          = "server.response.status"
+	serverRequestRawURIAddr            = "server.request.uri.raw"
+	serverRequestHeadersNoCookiesAddr  = "server.request.headers.no_cookies"
+	serverRequestCookiesAddr           = "server.request.cookies"
+	serverRequestQueryAddr             = "server.request.query"
+	serverRequestPathParams            = "server.request.path_params"
+	serverRequestBody                  = "server.request.body"
+	serverRequestBodyContentType       = "server.request.body.content_type"
+	serverResponseStatusAddr           = "server.response.status"
+	serverResponseHeadersNoCookiesAddr = "server.response.headers.no_cookies"
+	httpClientIPAddr                   = "http.client_ip"
+	userIDAddr                         = "usr.id"
+	graphqlServerResolverAddr          = "graphql.server.resolver"
+	serverIONetURLAddr                 = "server.io.net.url"
 )
 
 // List of HTTP rule addresses currently supported by the WAF
@@ -291,7 +464,13 @@ var httpAddresses = []string{
 	serverRequestQueryAddr,
 	serverRequestPathParams,
 	serverRequestBody,
+	serverRequestBodyContentType,
 	serverResponseStatusAddr,
+	serverResponseHeadersNoCookiesAddr,
+	httpClientIPAddr,
+	userIDAddr,
+	graphqlServerResolverAddr,
+	serverIONetURLAddr,
 }
 
 // gRPC rule addresses currently supported by the WAF
@@ -306,21 +485,50 @@ var grpcAddresses = []string{
 	grpcServerRequestMetadata,
 }
 
+// grpcMetadataKeyAddr returns the rule address of the values of the given gRPC
+// metadata key, in the form `grpc.server.request.metadata.<key>`.
+func grpcMetadataKeyAddr(key string) string {
+	return grpcServerRequestMetadata + "." + key
+}
+
 func init() {
 	// sort the address lists to avoid mistakes and use sort.SearchStrings()
 	sort.Strings(httpAddresses)
 	sort.Strings(grpcAddresses)
 }
 
+// checkRuleAddresses returns the supported HTTP and gRPC addresses among the
+// given rule addresses, or an *InitError when the rule has no addresses or
+// when none of them are supported. The addresses of the given gRPC metadata
+// keys are also supported.
+func checkRuleAddresses(ruleAddresses, grpcMetadataKeys []string) (httpAddrs, grpcAddrs []string, err error) {
+	if len(ruleAddresses) == 0 {
+		return nil, nil, &InitError{Reason: InitErrorNoAddresses}
+	}
+	httpAddrs, grpcAddrs, notSupported := supportedAddresses(ruleAddresses, grpcMetadataKeys)
+	if len(httpAddrs) == 0 && len(grpcAddrs) == 0 {
+		return nil, nil, &InitError{Reason: InitErrorUnsupportedAddresses, Unsupported: notSupported}
+	} else if len(notSupported) > 0 {
+		log.Debug("appsec: the addresses present in the rule are partially supported: not supported=%v", notSupported)
+	}
+	return httpAddrs, grpcAddrs, nil
+}
+
 // supportedAddresses returns the list of addresses we actually support from the
-// given rule addresses.
-func supportedAddresses(ruleAddresses []string) (supportedHTTP, supportedGRPC, notSupported []string) {
+// given rule addresses, including the addresses of the given gRPC metadata keys.
+func supportedAddresses(ruleAddresses, grpcMetadataKeys []string) (supportedHTTP, supportedGRPC, notSupported []string) {
+	metadataKeysAddresses := make(map[string]struct{}, len(grpcMetadataKeys))
+	for _, k := range grpcMetadataKeys {
+		metadataKeysAddresses[grpcMetadataKeyAddr(k)] = struct{}{}
+	}
 	// Filter the supported addresses only
 	for _, addr := range ruleAddresses {
 		if i := sort.SearchStrings(httpAddresses, addr); i < len(httpAddresses) && httpAddresses[i] == addr {
 			supportedHTTP = append(supportedHTTP, addr)
 		} else if i := sort.SearchStrings(grpcAddresses, addr); i < len(grpcAddresses) && grpcAddresses[i] == addr {
 			supportedGRPC = append(supportedGRPC, addr)
+		} else if _, ok := metadataKeysAddresses[addr]; ok {
+			supportedGRPC = append(supportedGRPC, addr)
 		} else {
 			notSupported = append(notSupported, addr)
 		}
@@ -347,6 +555,50 @@ func addRulesMonitoringTags(th tagsHolder, rInfo waf.RulesetInfo) {
 	th.AddTag(wafVersionTag, waf.Version())
 }
 
+// Add the tags listing the rules triggered by the given WAF matches, in the
+// form of a comma-separated list of `<rule id>:<rule type>` values, along with
+// the number of triggered rules.
+func addTriggeredRulesTags(th tagsHolder, events []json.RawMessage) {
+	rules := triggeredRules(events)
+	if len(rules) == 0 {
+		return
+	}
+	th.AddTag(triggeredRulesTag, strings.Join(rules, ","))
+	th.AddTag(triggeredRulesCountTag, float64(len(rules)))
+}
+
+// triggeredRules returns the distinct rules triggered by the given WAF matches,
+// in the form of `<rule id>:<rule type>` values.
+func triggeredRules(events []json.RawMessage) (rules []string) {
+	seen := make(map[string]struct{})
+	for _, event := range events {
+		var matches []struct {
+			Rule struct {
+				ID   string `json:"id"`
+				Tags struct {
+					Type string `json:"type"`
+				} `json:"tags"`
+			} `json:"rule"`
+		}
+		if err := json.Unmarshal(event, &matches); err != nil {
+			log.Error("appsec: could not parse the waf matches `%s`: %v", string(event), err)
+			continue
+		}
+		for _, match := range matches {
+			if match.Rule.ID == "" {
+				continue
+			}
+			rule := match.Rule.ID + ":" + match.Rule.Tags.Type
+			if _, ok := seen[rule]; ok {
+				continue
+			}
+			seen[rule] = struct{}{}
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
 // Add the tags related to the monitoring of the WAF
 func addWAFMonitoringTags(th tagsHolder, rulesVersion string, overallRuntimeNs, internalRuntimeNs, timeouts uint64) {
 	// Rules version is set for every request to help the backend associate WAF duration metrics with rule version
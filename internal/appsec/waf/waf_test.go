@@ -1601,3 +1601,50 @@ func BenchmarkEncoder(b *testing.B) {
 		})
 	}
 }
+
+// BenchmarkContextReuse compares running the WAF on a stream of messages with a
+// new WAF context per message against a single WAF context reused for the
+// whole stream, such as the gRPC WAF event listener does.
+func BenchmarkContextReuse(b *testing.B) {
+	defer requireZeroNBLiveCObjects(b)
+
+	waf, err := newDefaultHandle(newArachniTestRule([]ruleInput{{Address: "grpc.server.request.message"}}, nil))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer waf.Close()
+
+	const nbMessages = 10
+	values := map[string]interface{}{
+		"grpc.server.request.message": map[string]interface{}{
+			"name":  "my name",
+			"value": []string{"a", "b", "c"},
+		},
+	}
+
+	b.Run("per-message", func(b *testing.B) {
+		b.ReportAllocs()
+		for n := 0; n < b.N; n++ {
+			for i := 0; i < nbMessages; i++ {
+				wafCtx := NewContext(waf)
+				if _, _, err := wafCtx.Run(values, time.Second); err != nil {
+					b.Fatal(err)
+				}
+				wafCtx.Close()
+			}
+		}
+	})
+
+	b.Run("single-context", func(b *testing.B) {
+		b.ReportAllocs()
+		for n := 0; n < b.N; n++ {
+			wafCtx := NewContext(waf)
+			for i := 0; i < nbMessages; i++ {
+				if _, _, err := wafCtx.Run(values, time.Second); err != nil {
+					b.Fatal(err)
+				}
+			}
+			wafCtx.Close()
+		}
+	})
+}
@@ -9,10 +9,12 @@
 package appsec_test
 
 import (
+	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
 	"strings"
 	"testing"
 
@@ -20,6 +22,7 @@ import (
 	httptrace "gopkg.in/DataDog/dd-trace-go.v1/contrib/net/http"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/mocktracer"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/appsec"
+	"gopkg.in/DataDog/dd-trace-go.v1/internal/appsec/dyngo/instrumentation/httpsec"
 
 	"github.com/stretchr/testify/require"
 )
@@ -172,3 +175,601 @@ func TestWAF(t *testing.T) {
 		require.NotContains(t, event, sensitivePayloadValue)
 	})
 }
+
+// TestBlocking validates the blocking mode of the WAF protecting a net/http server: a request matching a rule with the
+// block action must be aborted before reaching the handler, and the security event must still be reported.
+func TestBlocking(t *testing.T) {
+	rules, err := os.CreateTemp("", "rules-*.json")
+	require.NoError(t, err)
+	defer func() {
+		rules.Close()
+		os.Remove(rules.Name())
+	}()
+	_, err = rules.WriteString(blockingRule)
+	require.NoError(t, err)
+
+	t.Setenv("DD_APPSEC_RULES", rules.Name())
+	t.Setenv("DD_APPSEC_BLOCKING_ENABLED", "true")
+	appsec.Start()
+	defer appsec.Stop()
+
+	if !appsec.Enabled() {
+		t.Skip("appsec disabled")
+	}
+
+	// Start and trace an HTTP server
+	var called bool
+	mux := httptrace.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		w.Write([]byte("Hello World!\n"))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	t.Run("block", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+		called = false
+
+		req, err := http.NewRequest("POST", srv.URL, nil)
+		require.NoError(t, err)
+		req.Header.Set("User-Agent", "Arachni/v1")
+		res, err := srv.Client().Do(req)
+		require.NoError(t, err)
+
+		// Check that the handler was not called and the blocking response was sent instead
+		require.False(t, called)
+		require.Equal(t, 403, res.StatusCode)
+		require.Equal(t, "application/json", res.Header.Get("Content-Type"))
+		b, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		require.Contains(t, string(b), "You've been blocked")
+
+		finished := mt.FinishedSpans()
+		require.Len(t, finished, 1)
+		event := finished[0].Tag("_dd.appsec.json")
+		require.NotNil(t, event)
+		require.Contains(t, event, "ua0-600-12x")
+		require.Equal(t, true, finished[0].Tag("appsec.blocked"))
+		require.Equal(t, "403", finished[0].Tag("http.status_code"))
+	})
+
+	t.Run("no-block", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+		called = false
+
+		req, err := http.NewRequest("POST", srv.URL, nil)
+		require.NoError(t, err)
+		res, err := srv.Client().Do(req)
+		require.NoError(t, err)
+
+		require.True(t, called)
+		require.Equal(t, 200, res.StatusCode)
+
+		finished := mt.FinishedSpans()
+		require.Len(t, finished, 1)
+		require.Nil(t, finished[0].Tag("_dd.appsec.json"))
+		require.Nil(t, finished[0].Tag("appsec.blocked"))
+	})
+}
+
+const blockingRule = `{
+  "version": "2.1",
+  "rules": [
+    {
+      "id": "ua0-600-12x",
+      "name": "Arachni",
+      "tags": {
+        "type": "security_scanner",
+        "category": "attack_attempt"
+      },
+      "conditions": [
+        {
+          "operator": "match_regex",
+          "parameters": {
+            "inputs": [
+              { "address": "server.request.headers.no_cookies", "key_path": [ "user-agent" ] }
+            ],
+            "regex": "^Arachni"
+          }
+        }
+      ],
+      "transformers": [],
+      "on_match": [ "block" ]
+    }
+  ]
+}`
+
+// TestBodyContentType validates that the content type of the request body is evaluated by the WAF along with the
+// body, so that the body rules can be limited to some content types.
+func TestBodyContentType(t *testing.T) {
+	rules, err := os.CreateTemp("", "rules-*.json")
+	require.NoError(t, err)
+	defer func() {
+		rules.Close()
+		os.Remove(rules.Name())
+	}()
+	_, err = rules.WriteString(jsonBodyRule)
+	require.NoError(t, err)
+
+	t.Setenv("DD_APPSEC_RULES", rules.Name())
+	appsec.Start()
+	defer appsec.Stop()
+
+	if !appsec.Enabled() {
+		t.Skip("appsec disabled")
+	}
+
+	mux := httptrace.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		pAppsec.MonitorParsedHTTPBody(r.Context(), map[string]interface{}{"name": "attack"})
+		w.Write([]byte("Hello Body!\n"))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	// sendRequest sends a request with the given body content type and returns the resulting service entry span
+	sendRequest := func(t *testing.T, contentType string) mocktracer.Span {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+		req, err := http.NewRequest("POST", srv.URL, strings.NewReader(`{"name":"attack"}`))
+		require.NoError(t, err)
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		res, err := srv.Client().Do(req)
+		require.NoError(t, err)
+		require.Equal(t, 200, res.StatusCode)
+		finished := mt.FinishedSpans()
+		require.Len(t, finished, 1)
+		return finished[0]
+	}
+
+	t.Run("json", func(t *testing.T) {
+		event := sendRequest(t, "application/json; charset=utf-8").Tag("_dd.appsec.json")
+		require.NotNil(t, event)
+		require.Contains(t, event, "json-body-001")
+	})
+
+	t.Run("not-json", func(t *testing.T) {
+		require.Nil(t, sendRequest(t, "text/plain").Tag("_dd.appsec.json"))
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		require.Nil(t, sendRequest(t, "").Tag("_dd.appsec.json"))
+	})
+}
+
+const jsonBodyRule = `{
+  "version": "2.1",
+  "rules": [
+    {
+      "id": "json-body-001",
+      "name": "Attack in a JSON body",
+      "tags": {
+        "type": "attack_tool",
+        "category": "attack_attempt"
+      },
+      "conditions": [
+        {
+          "operator": "match_regex",
+          "parameters": {
+            "inputs": [
+              { "address": "server.request.body.content_type" }
+            ],
+            "regex": "^application/json$"
+          }
+        },
+        {
+          "operator": "match_regex",
+          "parameters": {
+            "inputs": [
+              { "address": "server.request.body" }
+            ],
+            "regex": "^attack$"
+          }
+        }
+      ],
+      "transformers": []
+    }
+  ]
+}`
+
+// TestRoundTripBlocking validates that the outbound HTTP requests sent by a monitored net/http handler are evaluated by
+// the WAF, and that the ones matching a rule with the block action are aborted before being sent.
+func TestRoundTripBlocking(t *testing.T) {
+	rules, err := os.CreateTemp("", "rules-*.json")
+	require.NoError(t, err)
+	defer func() {
+		rules.Close()
+		os.Remove(rules.Name())
+	}()
+	_, err = rules.WriteString(ssrfRule)
+	require.NoError(t, err)
+
+	t.Setenv("DD_APPSEC_RULES", rules.Name())
+	t.Setenv("DD_APPSEC_BLOCKING_ENABLED", "true")
+	appsec.Start()
+	defer appsec.Stop()
+
+	if !appsec.Enabled() {
+		t.Skip("appsec disabled")
+	}
+
+	// Start an allowed remote server and a traced HTTP server fetching the URL given in its query
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Hello World!\n"))
+	}))
+	defer remote.Close()
+	client := httptrace.WrapClient(&http.Client{})
+	mux := httptrace.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		req, err := http.NewRequestWithContext(r.Context(), "GET", r.URL.Query().Get("url"), nil)
+		require.NoError(t, err)
+		res, err := client.Do(req)
+		if err != nil {
+			if errors.Is(err, httpsec.ErrRoundTripBlocked) {
+				w.WriteHeader(http.StatusForbidden)
+			} else {
+				w.WriteHeader(http.StatusBadGateway)
+			}
+			return
+		}
+		res.Body.Close()
+		w.WriteHeader(res.StatusCode)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	// fetch asks the server to fetch the given URL and returns its response status along with the finished spans
+	fetch := func(t *testing.T, target string) (int, []mocktracer.Span) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+		res, err := srv.Client().Get(srv.URL + "/?url=" + url.QueryEscape(target))
+		require.NoError(t, err)
+		res.Body.Close()
+		return res.StatusCode, mt.FinishedSpans()
+	}
+
+	t.Run("block", func(t *testing.T) {
+		status, finished := fetch(t, "http://169.254.169.254/latest/meta-data/")
+		require.Equal(t, http.StatusForbidden, status)
+		require.Len(t, finished, 2)
+		client, server := finished[0], finished[1]
+		require.NotNil(t, client.Tag("error"))
+		event := server.Tag("_dd.appsec.json")
+		require.NotNil(t, event)
+		require.Contains(t, event, "ssrf-001")
+		require.Contains(t, event, "server.io.net.url")
+	})
+
+	t.Run("no-block", func(t *testing.T) {
+		status, finished := fetch(t, remote.URL)
+		require.Equal(t, http.StatusOK, status)
+		require.Len(t, finished, 2)
+		require.Nil(t, finished[0].Tag("error"))
+		require.Nil(t, finished[1].Tag("_dd.appsec.json"))
+	})
+}
+
+const ssrfRule = `{
+  "version": "2.1",
+  "rules": [
+    {
+      "id": "ssrf-001",
+      "name": "Request to the cloud metadata service",
+      "tags": {
+        "type": "ssrf",
+        "category": "vulnerability_trigger"
+      },
+      "conditions": [
+        {
+          "operator": "match_regex",
+          "parameters": {
+            "inputs": [
+              { "address": "server.io.net.url" }
+            ],
+            "regex": "^https?://169\\.254\\.169\\.254"
+          }
+        }
+      ],
+      "transformers": [],
+      "on_match": [ "block" ]
+    }
+  ]
+}`
+
+// TestReloadRules validates that reloading the security rules at run time makes the WAF evaluate the new requests
+// against the new rules, including the ones listening to addresses that were not listened to so far.
+func TestReloadRules(t *testing.T) {
+	rules, err := os.CreateTemp("", "rules-*.json")
+	require.NoError(t, err)
+	defer func() {
+		rules.Close()
+		os.Remove(rules.Name())
+	}()
+	_, err = rules.WriteString(strings.Replace(blockingRule, `"block"`, "", 1))
+	require.NoError(t, err)
+
+	t.Setenv("DD_APPSEC_RULES", rules.Name())
+	appsec.Start()
+	defer appsec.Stop()
+
+	if !appsec.Enabled() {
+		t.Skip("appsec disabled")
+	}
+
+	mux := httptrace.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Hello World!\n"))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	// sendRequest sends a request having a malicious query parameter and returns the resulting security event tag
+	sendRequest := func(t *testing.T) interface{} {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+		res, err := srv.Client().Get(srv.URL + "/?q=reloaded-attack")
+		require.NoError(t, err)
+		require.Equal(t, 200, res.StatusCode)
+		finished := mt.FinishedSpans()
+		require.Len(t, finished, 1)
+		return finished[0].Tag("_dd.appsec.json")
+	}
+
+	// The initial rule doesn't listen to the query
+	require.Nil(t, sendRequest(t))
+
+	require.NoError(t, appsec.ReloadRules([]byte(queryRule)))
+	event := sendRequest(t)
+	require.NotNil(t, event)
+	require.Contains(t, event, "query-001")
+
+	// Invalid rules are rejected and the current ones are kept
+	require.Error(t, appsec.ReloadRules([]byte("not a rule")))
+	event = sendRequest(t)
+	require.NotNil(t, event)
+	require.Contains(t, event, "query-001")
+}
+
+const queryRule = `{
+  "version": "2.1",
+  "rules": [
+    {
+      "id": "query-001",
+      "name": "Reloaded query rule",
+      "tags": {
+        "type": "test",
+        "category": "attack_attempt"
+      },
+      "conditions": [
+        {
+          "operator": "match_regex",
+          "parameters": {
+            "inputs": [
+              { "address": "server.request.query" }
+            ],
+            "regex": "^reloaded-attack$"
+          }
+        }
+      ],
+      "transformers": []
+    }
+  ]
+}`
+
+// TestResponseHeaders validates that the WAF evaluates the response headers set by the handler.
+func TestResponseHeaders(t *testing.T) {
+	rules, err := os.CreateTemp("", "rules-*.json")
+	require.NoError(t, err)
+	defer func() {
+		rules.Close()
+		os.Remove(rules.Name())
+	}()
+	_, err = rules.WriteString(responseHeadersRule)
+	require.NoError(t, err)
+
+	t.Setenv("DD_APPSEC_RULES", rules.Name())
+	appsec.Start()
+	defer appsec.Stop()
+
+	if !appsec.Enabled() {
+		t.Skip("appsec disabled")
+	}
+
+	mux := httptrace.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Debug-Token", r.URL.Query().Get("token"))
+		w.Header().Set("Set-Cookie", "debug-token=leaked")
+		w.Write([]byte("Hello World!\n"))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	// sendRequest sends a request making the handler respond with the given debug token and returns the resulting
+	// security event tag
+	sendRequest := func(t *testing.T, token string) interface{} {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+		res, err := srv.Client().Get(srv.URL + "/?token=" + url.QueryEscape(token))
+		require.NoError(t, err)
+		require.Equal(t, 200, res.StatusCode)
+		finished := mt.FinishedSpans()
+		require.Len(t, finished, 1)
+		return finished[0].Tag("_dd.appsec.json")
+	}
+
+	t.Run("match", func(t *testing.T) {
+		event := sendRequest(t, "leaked")
+		require.NotNil(t, event)
+		require.Contains(t, event, "resp-headers-001")
+	})
+
+	t.Run("no-match", func(t *testing.T) {
+		require.Nil(t, sendRequest(t, "none"))
+	})
+}
+
+const responseHeadersRule = `{
+  "version": "2.1",
+  "rules": [
+    {
+      "id": "resp-headers-001",
+      "name": "Debug token leak",
+      "tags": {
+        "type": "test",
+        "category": "attack_attempt"
+      },
+      "conditions": [
+        {
+          "operator": "match_regex",
+          "parameters": {
+            "inputs": [
+              { "address": "server.response.headers.no_cookies", "key_path": [ "x-debug-token" ] }
+            ],
+            "regex": "leaked"
+          }
+        }
+      ],
+      "transformers": []
+    }
+  ]
+}`
+
+// TestUserID validates that the user id set with the SDK is monitored by the rules listening to the usr.id address.
+func TestUserID(t *testing.T) {
+	rules, err := os.CreateTemp("", "rules-*.json")
+	require.NoError(t, err)
+	defer func() {
+		rules.Close()
+		os.Remove(rules.Name())
+	}()
+	_, err = rules.WriteString(userIDRule)
+	require.NoError(t, err)
+
+	t.Setenv("DD_APPSEC_RULES", rules.Name())
+	appsec.Start()
+	defer appsec.Stop()
+
+	if !appsec.Enabled() {
+		t.Skip("appsec disabled")
+	}
+
+	mux := httptrace.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		pAppsec.SetUser(r.Context(), r.URL.Query().Get("user"))
+		w.Write([]byte("Hello World!\n"))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	// sendRequest sends a request authenticated as the given user and returns the resulting service entry span
+	sendRequest := func(t *testing.T, user string) mocktracer.Span {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+		res, err := srv.Client().Get(srv.URL + "/?user=" + url.QueryEscape(user))
+		require.NoError(t, err)
+		require.Equal(t, 200, res.StatusCode)
+		finished := mt.FinishedSpans()
+		require.Len(t, finished, 1)
+		return finished[0]
+	}
+
+	t.Run("match", func(t *testing.T) {
+		span := sendRequest(t, "attacker")
+		require.Equal(t, "attacker", span.Tag("usr.id"))
+		event := span.Tag("_dd.appsec.json")
+		require.NotNil(t, event)
+		require.Contains(t, event, "usr-id-001")
+	})
+
+	t.Run("no-match", func(t *testing.T) {
+		span := sendRequest(t, "legit")
+		require.Equal(t, "legit", span.Tag("usr.id"))
+		require.Nil(t, span.Tag("_dd.appsec.json"))
+	})
+}
+
+const userIDRule = `{
+  "version": "2.1",
+  "rules": [
+    {
+      "id": "usr-id-001",
+      "name": "Known attacker",
+      "tags": {
+        "type": "block_user",
+        "category": "security_response"
+      },
+      "conditions": [
+        {
+          "operator": "match_regex",
+          "parameters": {
+            "inputs": [
+              { "address": "usr.id" }
+            ],
+            "regex": "^attacker$"
+          }
+        }
+      ],
+      "transformers": []
+    }
+  ]
+}`
+
+// TestObfuscatorValueRegex validates that the parameter values matching the obfuscator value regular expression are
+// redacted from the security events.
+func TestObfuscatorValueRegex(t *testing.T) {
+	rules, err := os.CreateTemp("", "rules-*.json")
+	require.NoError(t, err)
+	defer func() {
+		rules.Close()
+		os.Remove(rules.Name())
+	}()
+	_, err = rules.WriteString(strings.Replace(queryRule, "^reloaded-attack$", "attack", 1))
+	require.NoError(t, err)
+	t.Setenv("DD_APPSEC_RULES", rules.Name())
+
+	// sendRequest starts appsec with the given options and sends a request having a malicious query parameter, and
+	// returns the resulting security event tag
+	sendRequest := func(t *testing.T, opts ...appsec.StartOption) interface{} {
+		appsec.Start(opts...)
+		defer appsec.Stop()
+		if !appsec.Enabled() {
+			t.Skip("appsec disabled")
+		}
+
+		mux := httptrace.NewServeMux()
+		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("Hello World!\n"))
+		})
+		srv := httptest.NewServer(mux)
+		defer srv.Close()
+
+		mt := mocktracer.Start()
+		defer mt.Stop()
+		res, err := srv.Client().Get(srv.URL + "/?q=patient-1234-attack")
+		require.NoError(t, err)
+		require.Equal(t, 200, res.StatusCode)
+		finished := mt.FinishedSpans()
+		require.Len(t, finished, 1)
+		event := finished[0].Tag("_dd.appsec.json")
+		require.NotNil(t, event)
+		require.Contains(t, event, "query-001")
+		return event
+	}
+
+	t.Run("default", func(t *testing.T) {
+		event := sendRequest(t)
+		require.Contains(t, event, "patient-1234-attack")
+	})
+
+	t.Run("custom", func(t *testing.T) {
+		event := sendRequest(t, appsec.WithObfuscatorValueRegex(`patient-\d+`))
+		require.NotContains(t, event, "patient-1234")
+		// The event is JSON-encoded with its HTML characters escaped
+		require.Contains(t, event, `\u003cRedacted\u003e`)
+	})
+}
@@ -9,12 +9,17 @@
 package appsec
 
 import (
+	"context"
+	"encoding/json"
 	"testing"
 
 	"github.com/stretchr/testify/require"
 
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/appsec/dyngo/instrumentation"
+	"gopkg.in/DataDog/dd-trace-go.v1/internal/appsec/dyngo/instrumentation/grpcsec"
+	"gopkg.in/DataDog/dd-trace-go.v1/internal/appsec/dyngo/instrumentation/httpsec"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/appsec/waf"
+	"gopkg.in/DataDog/dd-trace-go.v1/internal/log"
 )
 
 // Test that internal functions used to set span tags use the correct types
@@ -38,3 +43,216 @@ func TestTagsTypes(t *testing.T) {
 		require.Contains(t, tags, tag)
 	}
 }
+
+func TestTriggeredRulesTags(t *testing.T) {
+	t.Run("matches", func(t *testing.T) {
+		th := instrumentation.NewTagsHolder()
+		addTriggeredRulesTags(&th, []json.RawMessage{
+			json.RawMessage(`[{"rule":{"id":"crs-930-110","name":"LFI","tags":{"type":"lfi","category":"attack_attempt"}},"rule_matches":[]}]`),
+			json.RawMessage(`[{"rule":{"id":"crs-942-270","tags":{"type":"sql_injection"}}},{"rule":{"id":"crs-930-110","tags":{"type":"lfi"}}}]`),
+		})
+		tags := th.Tags()
+		require.Equal(t, "crs-930-110:lfi,crs-942-270:sql_injection", tags[triggeredRulesTag])
+		require.Equal(t, float64(2), tags[triggeredRulesCountTag])
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		th := instrumentation.NewTagsHolder()
+		addTriggeredRulesTags(&th, []json.RawMessage{
+			json.RawMessage(`not json`),
+			json.RawMessage(`[{"rule":{"id":"ua0-600-12x","tags":{"type":"security_scanner"}}}]`),
+		})
+		tags := th.Tags()
+		require.Equal(t, "ua0-600-12x:security_scanner", tags[triggeredRulesTag])
+		require.Equal(t, float64(1), tags[triggeredRulesCountTag])
+	})
+
+	t.Run("no-matches", func(t *testing.T) {
+		th := instrumentation.NewTagsHolder()
+		addTriggeredRulesTags(&th, []json.RawMessage{json.RawMessage(`{}`)})
+		require.Empty(t, th.Tags())
+	})
+}
+
+// levelLogger is a log.LeveledLogger recording the error messages.
+type levelLogger struct {
+	log.RecordLogger
+	errors []string
+}
+
+func (l *levelLogger) Debug(string)     {}
+func (l *levelLogger) Info(string)      {}
+func (l *levelLogger) Warn(string)      {}
+func (l *levelLogger) Error(msg string) { l.errors = append(l.errors, msg) }
+
+// TestLeveledLogger validates that the WAF errors are logged at the error level of a leveled logger.
+func TestLeveledLogger(t *testing.T) {
+	l := &levelLogger{}
+	defer log.UseLogger(l)()
+
+	th := instrumentation.NewTagsHolder()
+	addTriggeredRulesTags(&th, []json.RawMessage{json.RawMessage(`not json`)})
+	log.Flush()
+
+	require.Len(t, l.errors, 1)
+	require.Contains(t, l.errors[0], "appsec: could not parse the waf matches `not json`")
+	require.Empty(t, l.Logs())
+}
+
+func TestWAFContextErrors(t *testing.T) {
+	if err := waf.Health(); err != nil {
+		t.Skipf("waf disabled: %v", err)
+	}
+	// Closing the handle makes the WAF context creation fail in the listeners
+	handle, err := waf.NewHandle([]byte(staticRecommendedRules), "", "")
+	require.NoError(t, err)
+	handle.Close()
+
+	t.Run("http", func(t *testing.T) {
+		WAFContextErrors()
+		listener := newHTTPWAFEventListener(handle, []string{serverRequestRawURIAddr}, defaultWAFTimeout, NewTokenTicker(0, 10), nil, BlockingConfig{})
+		args := httpsec.HandlerOperationArgs{RequestURI: "/"}
+		_, op := httpsec.StartOperation(context.Background(), args)
+		listener.(httpsec.OnHandlerOperationStart).Call(op, args)
+		require.Equal(t, uint64(1), WAFContextErrors())
+		require.Equal(t, uint64(0), WAFContextErrors(), "the count must be reset once reported")
+	})
+
+	t.Run("grpc", func(t *testing.T) {
+		WAFContextErrors()
+		listener := newGRPCWAFEventListener(handle, []string{grpcServerRequestMessage}, defaultWAFTimeout, NewTokenTicker(0, 10), nil)
+		args := grpcsec.HandlerOperationArgs{}
+		op := grpcsec.StartHandlerOperation(args, nil)
+		listener.(grpcsec.OnHandlerOperationStart).Call(op, args)
+		require.Equal(t, uint64(1), WAFContextErrors())
+		require.Equal(t, uint64(0), WAFContextErrors(), "the count must be reset once reported")
+	})
+}
+
+func TestGRPCMethodTag(t *testing.T) {
+	if err := waf.Health(); err != nil {
+		t.Skipf("waf disabled: %v", err)
+	}
+	handle, err := waf.NewHandle([]byte(staticRecommendedRules), "", "")
+	require.NoError(t, err)
+	defer handle.Close()
+
+	listener := newGRPCWAFEventListener(handle, []string{grpcServerRequestMessage}, defaultWAFTimeout, NewTokenTicker(10, 10), nil)
+	for _, tc := range []struct {
+		name    string
+		message string
+		tag     interface{}
+	}{
+		{name: "attack", message: "<script>alert('xss');</script>", tag: "/grpc.Fixture/Ping"},
+		{name: "no-attack", message: "hello", tag: nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			args := grpcsec.HandlerOperationArgs{Method: "/grpc.Fixture/Ping"}
+			op := grpcsec.StartHandlerOperation(args, nil)
+			listener.(grpcsec.OnHandlerOperationStart).Call(op, args)
+			grpcsec.StartReceiveOperation(grpcsec.ReceiveOperationArgs{}, op).Finish(grpcsec.ReceiveOperationRes{Message: tc.message})
+			op.Finish(grpcsec.HandlerOperationRes{})
+			require.Equal(t, tc.tag, op.Tags()[grpcMethodTag])
+		})
+	}
+}
+
+func TestGRPCMetadataKeys(t *testing.T) {
+	const authorizationAddr = "grpc.server.request.metadata.authorization"
+
+	t.Run("supported-addresses", func(t *testing.T) {
+		// The metadata key address is only supported when the key is configured
+		_, _, err := checkRuleAddresses([]string{authorizationAddr}, nil)
+		require.Error(t, err)
+		_, grpcAddrs, err := checkRuleAddresses([]string{authorizationAddr, grpcServerRequestMessage}, []string{"authorization"})
+		require.NoError(t, err)
+		require.Equal(t, []string{authorizationAddr, grpcServerRequestMessage}, grpcAddrs)
+	})
+
+	t.Run("waf", func(t *testing.T) {
+		if err := waf.Health(); err != nil {
+			t.Skipf("waf disabled: %v", err)
+		}
+		handle, err := waf.NewHandle([]byte(grpcMetadataKeyRule), "", "")
+		require.NoError(t, err)
+		defer handle.Close()
+
+		_, grpcAddrs, err := checkRuleAddresses(handle.Addresses(), []string{"authorization"})
+		require.NoError(t, err)
+		listener := newGRPCWAFEventListener(handle, grpcAddrs, defaultWAFTimeout, NewTokenTicker(10, 10), nil)
+
+		for _, tc := range []struct {
+			name     string
+			metadata map[string][]string
+			match    bool
+		}{
+			{name: "match", metadata: map[string][]string{"authorization": {"Basic YWRtaW46YWRtaW4="}}, match: true},
+			{name: "other-key", metadata: map[string][]string{"x-authorization": {"Basic YWRtaW46YWRtaW4="}}, match: false},
+			{name: "no-match", metadata: map[string][]string{"authorization": {"Bearer token"}}, match: false},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				args := grpcsec.HandlerOperationArgs{Metadata: tc.metadata}
+				op := grpcsec.StartHandlerOperation(args, nil)
+				listener.(grpcsec.OnHandlerOperationStart).Call(op, args)
+				grpcsec.StartReceiveOperation(grpcsec.ReceiveOperationArgs{}, op).Finish(grpcsec.ReceiveOperationRes{Message: "hello"})
+				events := op.Finish(grpcsec.HandlerOperationRes{})
+				if tc.match {
+					require.Len(t, events, 1)
+					require.Contains(t, string(events[0]), "grpc-metadata-001")
+				} else {
+					require.Empty(t, events)
+				}
+			})
+		}
+	})
+}
+
+const grpcMetadataKeyRule = `{
+  "version": "2.1",
+  "rules": [
+    {
+      "id": "grpc-metadata-001",
+      "name": "Basic authentication",
+      "tags": {
+        "type": "test",
+        "category": "attack_attempt"
+      },
+      "conditions": [
+        {
+          "operator": "match_regex",
+          "parameters": {
+            "inputs": [
+              { "address": "grpc.server.request.metadata.authorization" },
+              { "address": "grpc.server.request.message" }
+            ],
+            "regex": "^Basic "
+          }
+        }
+      ],
+      "transformers": []
+    }
+  ]
+}`
+
+func TestMakeBodyContentType(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		values   []string
+		expected string
+	}{
+		{name: "missing"},
+		{name: "media-type", values: []string{"application/json"}, expected: "application/json"},
+		{name: "parameters", values: []string{"Application/JSON; charset=utf-8"}, expected: "application/json"},
+		{name: "malformed", values: []string{"application/json; charset"}},
+		{name: "same-values", values: []string{"text/plain", "text/plain; charset=utf-8"}, expected: "text/plain"},
+		{name: "ambiguous", values: []string{"application/json", "text/plain"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			headers := map[string][]string{}
+			if tc.values != nil {
+				headers["content-type"] = tc.values
+			}
+			require.Equal(t, tc.expected, makeBodyContentType(headers))
+		})
+	}
+}
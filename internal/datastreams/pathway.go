@@ -0,0 +1,129 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2022 Datadog, Inc.
+
+// Package datastreams contains the pathway checkpoints logic shared by the
+// integrations supporting Data Streams Monitoring, and the processor reporting
+// the latencies of the checkpoints to the agent.
+package datastreams
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"sort"
+	"time"
+)
+
+// PropagationKey is the key of the message header carrying the encoded pathway.
+const PropagationKey = "dd-pathway-ctx"
+
+// Pathway is the path followed by a message through the services and queues
+// it went through, identified by a hash of all the checkpoints set along it.
+type Pathway struct {
+	hash         uint64
+	pathwayStart time.Time
+	edgeStart    time.Time
+}
+
+// NewPathway starts a new pathway at the given time, with a first checkpoint
+// identified by the given service, env and edge tags.
+func NewPathway(now time.Time, service, env string, edgeTags ...string) Pathway {
+	p := Pathway{pathwayStart: now, edgeStart: now}
+	return p.SetCheckpoint(now, service, env, edgeTags...)
+}
+
+// SetCheckpoint returns the pathway resulting from adding to p the checkpoint
+// identified by the given service, env and edge tags at the given time. The
+// checkpoint is recorded by the global processor, if any.
+func (p Pathway) SetCheckpoint(now time.Time, service, env string, edgeTags ...string) Pathway {
+	child := Pathway{
+		hash:         pathwayHash(nodeHash(service, env, edgeTags), p.hash),
+		pathwayStart: p.pathwayStart,
+		edgeStart:    now,
+	}
+	if proc := getGlobalProcessor(); proc != nil {
+		proc.add(checkpoint{
+			edgeTags:       edgeTags,
+			hash:           child.hash,
+			parentHash:     p.hash,
+			timestamp:      now.UnixNano(),
+			pathwayLatency: now.Sub(p.pathwayStart).Nanoseconds(),
+			edgeLatency:    now.Sub(p.edgeStart).Nanoseconds(),
+		})
+	}
+	return child
+}
+
+// Hash returns the hash identifying the pathway.
+func (p Pathway) Hash() uint64 { return p.hash }
+
+// PathwayStart returns the time at which the pathway started.
+func (p Pathway) PathwayStart() time.Time { return p.pathwayStart }
+
+// EdgeStart returns the time of the last checkpoint of the pathway.
+func (p Pathway) EdgeStart() time.Time { return p.edgeStart }
+
+// Encode encodes the pathway to be propagated in message headers, as its hash
+// followed by the varint-encoded pathway and edge start times in milliseconds.
+func (p Pathway) Encode() []byte {
+	b := make([]byte, 8+2*binary.MaxVarintLen64)
+	binary.LittleEndian.PutUint64(b, p.hash)
+	n := 8
+	n += binary.PutVarint(b[n:], p.pathwayStart.UnixNano()/int64(time.Millisecond))
+	n += binary.PutVarint(b[n:], p.edgeStart.UnixNano()/int64(time.Millisecond))
+	return b[:n]
+}
+
+// ErrInvalidPathway is returned when decoding a malformed pathway.
+var ErrInvalidPathway = errors.New("datastreams: invalid encoded pathway")
+
+// Decode decodes a pathway encoded using Encode.
+func Decode(b []byte) (Pathway, error) {
+	if len(b) < 8 {
+		return Pathway{}, ErrInvalidPathway
+	}
+	hash := binary.LittleEndian.Uint64(b)
+	b = b[8:]
+	pathwayStart, n := binary.Varint(b)
+	if n <= 0 {
+		return Pathway{}, ErrInvalidPathway
+	}
+	b = b[n:]
+	edgeStart, n := binary.Varint(b)
+	if n <= 0 {
+		return Pathway{}, ErrInvalidPathway
+	}
+	return Pathway{
+		hash:         hash,
+		pathwayStart: time.Unix(0, pathwayStart*int64(time.Millisecond)),
+		edgeStart:    time.Unix(0, edgeStart*int64(time.Millisecond)),
+	}, nil
+}
+
+// nodeHash returns the hash identifying a checkpoint, independently of the
+// order of its edge tags.
+func nodeHash(service, env string, edgeTags []string) uint64 {
+	tags := make([]string, len(edgeTags))
+	copy(tags, edgeTags)
+	sort.Strings(tags)
+	h := fnv.New64()
+	h.Write([]byte(service))
+	h.Write([]byte(env))
+	for _, t := range tags {
+		h.Write([]byte(t))
+	}
+	return h.Sum64()
+}
+
+// pathwayHash returns the hash of the pathway made of the checkpoint of the
+// given node hash following the pathway of the given parent hash.
+func pathwayHash(nodeHash, parentHash uint64) uint64 {
+	b := make([]byte, 16)
+	binary.LittleEndian.PutUint64(b, nodeHash)
+	binary.LittleEndian.PutUint64(b[8:], parentHash)
+	h := fnv.New64()
+	h.Write(b)
+	return h.Sum64()
+}
@@ -0,0 +1,50 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2022 Datadog, Inc.
+
+package datastreams
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPathway(t *testing.T) {
+	start := time.Unix(1666000000, 0)
+	p := NewPathway(start, "producer", "prod", "direction:out", "topic:orders", "type:kafka")
+	assert.NotZero(t, p.Hash())
+	assert.Equal(t, start, p.PathwayStart())
+	assert.Equal(t, start, p.EdgeStart())
+
+	t.Run("checkpoint", func(t *testing.T) {
+		now := start.Add(time.Second)
+		next := p.SetCheckpoint(now, "consumer", "prod", "direction:in", "topic:orders", "type:kafka")
+		assert.NotEqual(t, p.Hash(), next.Hash())
+		assert.Equal(t, start, next.PathwayStart())
+		assert.Equal(t, now, next.EdgeStart())
+
+		// the hash doesn't depend on the order of the edge tags, but on the parent pathway
+		same := p.SetCheckpoint(now, "consumer", "prod", "type:kafka", "topic:orders", "direction:in")
+		assert.Equal(t, next.Hash(), same.Hash())
+		other := NewPathway(start, "other", "prod").SetCheckpoint(now, "consumer", "prod", "direction:in", "topic:orders", "type:kafka")
+		assert.NotEqual(t, next.Hash(), other.Hash())
+	})
+
+	t.Run("encoding", func(t *testing.T) {
+		decoded, err := Decode(p.Encode())
+		assert.NoError(t, err)
+		assert.Equal(t, p.Hash(), decoded.Hash())
+		assert.True(t, p.PathwayStart().Equal(decoded.PathwayStart()))
+		assert.True(t, p.EdgeStart().Equal(decoded.EdgeStart()))
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, b := range [][]byte{nil, []byte("short"), p.Encode()[:8]} {
+			_, err := Decode(b)
+			assert.Equal(t, ErrInvalidPathway, err)
+		}
+	})
+}
@@ -0,0 +1,136 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2022 Datadog, Inc.
+
+package datastreams
+
+import "github.com/tinylib/msgp/msgp"
+
+// statsPayload is the payload of the pathway stats sent to the agent, which
+// forwards it to the Data Streams Monitoring intake.
+type statsPayload struct {
+	Env           string
+	Service       string
+	Stats         []statsBucket
+	TracerVersion string
+	Lang          string
+}
+
+// statsBucket holds the stats of the pathway checkpoints set in a time bucket.
+type statsBucket struct {
+	Start    uint64 // start of the bucket, in nanoseconds since the epoch
+	Duration uint64 // duration of the bucket, in nanoseconds
+	Stats    []statsPoint
+}
+
+// statsPoint holds the latencies of the checkpoints of a pathway in a bucket.
+type statsPoint struct {
+	EdgeTags       []string
+	Hash           uint64
+	ParentHash     uint64
+	PathwayLatency []byte // protobuf-encoded DDSketch of the pathway latencies, in seconds
+	EdgeLatency    []byte // protobuf-encoded DDSketch of the edge latencies, in seconds
+}
+
+// EncodeMsg implements msgp.Encodable.
+func (p *statsPayload) EncodeMsg(w *msgp.Writer) error {
+	if err := w.WriteMapHeader(5); err != nil {
+		return err
+	}
+	if err := writeString(w, "Env", p.Env); err != nil {
+		return err
+	}
+	if err := writeString(w, "Service", p.Service); err != nil {
+		return err
+	}
+	if err := w.WriteString("Stats"); err != nil {
+		return err
+	}
+	if err := w.WriteArrayHeader(uint32(len(p.Stats))); err != nil {
+		return err
+	}
+	for i := range p.Stats {
+		if err := p.Stats[i].EncodeMsg(w); err != nil {
+			return err
+		}
+	}
+	if err := writeString(w, "TracerVersion", p.TracerVersion); err != nil {
+		return err
+	}
+	return writeString(w, "Lang", p.Lang)
+}
+
+// EncodeMsg implements msgp.Encodable.
+func (b *statsBucket) EncodeMsg(w *msgp.Writer) error {
+	if err := w.WriteMapHeader(3); err != nil {
+		return err
+	}
+	if err := writeUint64(w, "Start", b.Start); err != nil {
+		return err
+	}
+	if err := writeUint64(w, "Duration", b.Duration); err != nil {
+		return err
+	}
+	if err := w.WriteString("Stats"); err != nil {
+		return err
+	}
+	if err := w.WriteArrayHeader(uint32(len(b.Stats))); err != nil {
+		return err
+	}
+	for i := range b.Stats {
+		if err := b.Stats[i].EncodeMsg(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EncodeMsg implements msgp.Encodable.
+func (p *statsPoint) EncodeMsg(w *msgp.Writer) error {
+	if err := w.WriteMapHeader(5); err != nil {
+		return err
+	}
+	if err := w.WriteString("EdgeTags"); err != nil {
+		return err
+	}
+	if err := w.WriteArrayHeader(uint32(len(p.EdgeTags))); err != nil {
+		return err
+	}
+	for _, t := range p.EdgeTags {
+		if err := w.WriteString(t); err != nil {
+			return err
+		}
+	}
+	if err := writeUint64(w, "Hash", p.Hash); err != nil {
+		return err
+	}
+	if err := writeUint64(w, "ParentHash", p.ParentHash); err != nil {
+		return err
+	}
+	if err := writeBytes(w, "PathwayLatency", p.PathwayLatency); err != nil {
+		return err
+	}
+	return writeBytes(w, "EdgeLatency", p.EdgeLatency)
+}
+
+func writeString(w *msgp.Writer, key, v string) error {
+	if err := w.WriteString(key); err != nil {
+		return err
+	}
+	return w.WriteString(v)
+}
+
+func writeUint64(w *msgp.Writer, key string, v uint64) error {
+	if err := w.WriteString(key); err != nil {
+		return err
+	}
+	return w.WriteUint64(v)
+}
+
+func writeBytes(w *msgp.Writer, key string, v []byte) error {
+	if err := w.WriteString(key); err != nil {
+		return err
+	}
+	return w.WriteBytes(v)
+}
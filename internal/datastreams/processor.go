@@ -0,0 +1,289 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2022 Datadog, Inc.
+
+package datastreams
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/DataDog/dd-trace-go.v1/internal/log"
+	"gopkg.in/DataDog/dd-trace-go.v1/internal/version"
+
+	"github.com/DataDog/sketches-go/ddsketch"
+	"github.com/tinylib/msgp/msgp"
+	"google.golang.org/protobuf/proto"
+)
+
+// bucketDuration is the span of time covered by a stats bucket, which is also
+// the interval at which the buckets are flushed.
+const bucketDuration = 10 * time.Second
+
+// checkpoint is a checkpoint set on a pathway, as recorded by the processor.
+type checkpoint struct {
+	edgeTags       []string
+	hash           uint64
+	parentHash     uint64
+	timestamp      int64 // in nanoseconds since the epoch
+	pathwayLatency int64 // in nanoseconds
+	edgeLatency    int64 // in nanoseconds
+}
+
+// group aggregates the latencies of the checkpoints of a pathway.
+type group struct {
+	edgeTags       []string
+	parentHash     uint64
+	pathwayLatency *ddsketch.DDSketch
+	edgeLatency    *ddsketch.DDSketch
+}
+
+// Processor aggregates the latencies of the pathway checkpoints in time
+// buckets, and flushes them to the /v0.1/pipeline_stats endpoint of the agent.
+// Its goroutines are only started with the first checkpoint recorded.
+type Processor struct {
+	in chan checkpoint
+
+	// mu guards below fields
+	mu sync.Mutex
+
+	// buckets maps the start of the buckets, in nanoseconds, to the groups
+	// of the checkpoints in the bucket, keyed by the hash of their pathway.
+	buckets map[int64]map[uint64]*group
+
+	startOnce sync.Once
+	started   bool          // whether the goroutines were started
+	stop      chan struct{} // closing this channel triggers shutdown
+	wg        sync.WaitGroup
+	dropped   uint64 // number of checkpoints dropped as the input buffer was full; accessed atomically
+
+	url     string       // the URL of the pipeline stats endpoint of the agent
+	client  *http.Client // the HTTP client used to send the payloads
+	env     string
+	service string
+}
+
+// NewProcessor returns a new Processor sending the pathway stats of the given
+// service and env to the agent at agentURL, using client.
+func NewProcessor(agentURL string, client *http.Client, service, env string) *Processor {
+	return &Processor{
+		in:      make(chan checkpoint, 10000),
+		buckets: make(map[int64]map[uint64]*group),
+		stop:    make(chan struct{}),
+		url:     agentURL + "/v0.1/pipeline_stats",
+		client:  client,
+		env:     env,
+		service: service,
+	}
+}
+
+var (
+	processorMu sync.RWMutex
+	processor   *Processor
+)
+
+// SetGlobalProcessor sets the processor recording the checkpoints set using
+// Pathway.SetCheckpoint and NewPathway. A nil p stops recording them.
+func SetGlobalProcessor(p *Processor) {
+	processorMu.Lock()
+	defer processorMu.Unlock()
+	processor = p
+}
+
+func getGlobalProcessor() *Processor {
+	processorMu.RLock()
+	defer processorMu.RUnlock()
+	return processor
+}
+
+// add records the checkpoint c, starting the processor on first use.
+func (p *Processor) add(c checkpoint) {
+	p.startOnce.Do(p.start)
+	select {
+	case p.in <- c:
+	default:
+		if atomic.AddUint64(&p.dropped, 1) == 1 {
+			log.Warn("Data Streams Monitoring buffer full, dropping checkpoints.")
+		}
+	}
+}
+
+func (p *Processor) start() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.started = true
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		tick := time.NewTicker(bucketDuration)
+		defer tick.Stop()
+		for {
+			select {
+			case c := <-p.in:
+				p.aggregate(c)
+			case now := <-tick.C:
+				p.flush(now, false)
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the processor, flushing all the recorded checkpoints, and blocks
+// until the operation completes. No checkpoint is recorded afterwards.
+func (p *Processor) Stop() {
+	p.startOnce.Do(func() {})
+	p.mu.Lock()
+	started := p.started
+	p.started = false
+	p.mu.Unlock()
+	if !started {
+		return
+	}
+	close(p.stop)
+	p.wg.Wait()
+	for {
+		select {
+		case c := <-p.in:
+			p.aggregate(c)
+		default:
+			p.flush(time.Now(), true)
+			return
+		}
+	}
+}
+
+// aggregate adds the checkpoint c to the stats of its bucket.
+func (p *Processor) aggregate(c checkpoint) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	btime := c.timestamp - c.timestamp%int64(bucketDuration)
+	b, ok := p.buckets[btime]
+	if !ok {
+		b = make(map[uint64]*group)
+		p.buckets[btime] = b
+	}
+	g, ok := b[c.hash]
+	if !ok {
+		g = &group{edgeTags: c.edgeTags, parentHash: c.parentHash}
+		g.pathwayLatency, g.edgeLatency = newSketch(), newSketch()
+		b[c.hash] = g
+	}
+	if err := g.pathwayLatency.Add(seconds(c.pathwayLatency)); err != nil {
+		log.Debug("Error adding pathway latency to sketch: %v", err)
+	}
+	if err := g.edgeLatency.Add(seconds(c.edgeLatency)); err != nil {
+		log.Debug("Error adding edge latency to sketch: %v", err)
+	}
+}
+
+func newSketch() *ddsketch.DDSketch {
+	// 1% relative accuracy, as for the trace stats
+	s, err := ddsketch.LogCollapsingLowestDenseDDSketch(0.01, 2048)
+	if err != nil {
+		log.Error("Error when creating ddsketch: %v", err)
+	}
+	return s
+}
+
+// seconds converts ns to seconds, flooring the negative latencies resulting
+// from the clock skews between the hosts along a pathway, which the sketches
+// can't hold.
+func seconds(ns int64) float64 {
+	if ns < 0 {
+		return 0
+	}
+	return float64(ns) / float64(time.Second)
+}
+
+// flush sends the stats of the buckets which ended by now to the agent, or
+// all of them when includeCurrent is true, such as during shutdown.
+func (p *Processor) flush(now time.Time, includeCurrent bool) {
+	sp := p.payload(now.UnixNano(), includeCurrent)
+	if len(sp.Stats) == 0 {
+		return
+	}
+	if err := p.send(&sp); err != nil {
+		log.Error("Error sending Data Streams Monitoring payload: %v", err)
+	}
+}
+
+func (p *Processor) payload(now int64, includeCurrent bool) statsPayload {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	sp := statsPayload{
+		Env:           p.env,
+		Service:       p.service,
+		TracerVersion: version.Tag,
+		Lang:          "go",
+	}
+	for btime, b := range p.buckets {
+		if !includeCurrent && btime > now-int64(bucketDuration) {
+			// do not flush the current bucket
+			continue
+		}
+		sb := statsBucket{
+			Start:    uint64(btime),
+			Duration: uint64(bucketDuration),
+			Stats:    make([]statsPoint, 0, len(b)),
+		}
+		for hash, g := range b {
+			pathwayLatency, err := proto.Marshal(g.pathwayLatency.ToProto())
+			if err != nil {
+				log.Error("Could not export pathway latency sketch: %v", err)
+				continue
+			}
+			edgeLatency, err := proto.Marshal(g.edgeLatency.ToProto())
+			if err != nil {
+				log.Error("Could not export edge latency sketch: %v", err)
+				continue
+			}
+			sb.Stats = append(sb.Stats, statsPoint{
+				EdgeTags:       g.edgeTags,
+				Hash:           hash,
+				ParentHash:     g.parentHash,
+				PathwayLatency: pathwayLatency,
+				EdgeLatency:    edgeLatency,
+			})
+		}
+		sp.Stats = append(sp.Stats, sb)
+		delete(p.buckets, btime)
+	}
+	return sp
+}
+
+// send sends the gzip-compressed payload sp to the agent.
+func (p *Processor) send(sp *statsPayload) error {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if err := msgp.Encode(gz, sp); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", p.url, &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/msgpack")
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set("Datadog-Meta-Lang", "go")
+	req.Header.Set("Datadog-Meta-Tracer-Version", version.Tag)
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if code := resp.StatusCode; code >= 400 {
+		return fmt.Errorf("%s", http.StatusText(code))
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2022 Datadog, Inc.
+
+package datastreams
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tinylib/msgp/msgp"
+)
+
+func TestProcessor(t *testing.T) {
+	var payloads []map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v0.1/pipeline_stats", r.URL.Path)
+		assert.Equal(t, "gzip", r.Header.Get("Content-Encoding"))
+		gz, err := gzip.NewReader(r.Body)
+		assert.NoError(t, err)
+		var buf bytes.Buffer
+		_, err = msgp.CopyToJSON(&buf, gz)
+		assert.NoError(t, err)
+		var p map[string]interface{}
+		assert.NoError(t, json.Unmarshal(buf.Bytes(), &p))
+		payloads = append(payloads, p)
+	}))
+	defer srv.Close()
+
+	proc := NewProcessor(srv.URL, srv.Client(), "service", "prod")
+	SetGlobalProcessor(proc)
+	defer SetGlobalProcessor(nil)
+
+	start := time.Now()
+	produced := NewPathway(start, "producer", "prod", "direction:out", "topic:orders", "type:kafka")
+	consumed := produced.SetCheckpoint(start.Add(time.Second), "consumer", "prod", "direction:in", "topic:orders", "type:kafka")
+	proc.Stop()
+
+	// checkpoints set after the processor stopped are not recorded
+	consumed.SetCheckpoint(start.Add(2*time.Second), "consumer", "prod", "direction:out", "topic:invoices", "type:kafka")
+	proc.Stop()
+
+	assert.Len(t, payloads, 1)
+	p := payloads[0]
+	assert.Equal(t, "service", p["Service"])
+	assert.Equal(t, "prod", p["Env"])
+	assert.Equal(t, "go", p["Lang"])
+	var points []map[string]interface{}
+	for _, b := range p["Stats"].([]interface{}) {
+		for _, pt := range b.(map[string]interface{})["Stats"].([]interface{}) {
+			points = append(points, pt.(map[string]interface{}))
+		}
+	}
+	assert.Len(t, points, 2)
+	hashes := map[float64]float64{}
+	for _, pt := range points {
+		hashes[pt["Hash"].(float64)] = pt["ParentHash"].(float64)
+		assert.NotEmpty(t, pt["PathwayLatency"])
+		assert.NotEmpty(t, pt["EdgeLatency"])
+	}
+	assert.Equal(t, map[float64]float64{
+		float64(produced.Hash()): 0,
+		float64(consumed.Hash()): float64(produced.Hash()),
+	}, hashes)
+}
+
+func TestProcessorNotStarted(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("unexpected payload")
+	}))
+	defer srv.Close()
+
+	// stopping a processor which recorded no checkpoint sends nothing
+	NewProcessor(srv.URL, srv.Client(), "service", "prod").Stop()
+}
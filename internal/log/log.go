@@ -37,6 +37,22 @@ type Logger interface {
 	Log(msg string)
 }
 
+// LeveledLogger implementations are Loggers which receive the messages along
+// with their level, such as structured loggers, rather than a line prefixed
+// with it. This interface is duplicated here to avoid a cyclic dependency
+// between this package and ddtrace
+type LeveledLogger interface {
+	Logger
+	// Debug prints the given debug message.
+	Debug(msg string)
+	// Info prints the given informational message.
+	Info(msg string)
+	// Warn prints the given warning message.
+	Warn(msg string)
+	// Error prints the given error message.
+	Error(msg string)
+}
+
 var (
 	mu     sync.RWMutex // guards below fields
 	level               = LevelWarn
@@ -181,10 +197,26 @@ func flushLocked() {
 }
 
 func printMsg(lvl, format string, a ...interface{}) {
-	msg := fmt.Sprintf("%s %s: %s", prefixMsg, lvl, fmt.Sprintf(format, a...))
 	mu.RLock()
-	logger.Log(msg)
-	mu.RUnlock()
+	defer mu.RUnlock()
+	l, ok := logger.(LeveledLogger)
+	if !ok {
+		logger.Log(fmt.Sprintf("%s %s: %s", prefixMsg, lvl, fmt.Sprintf(format, a...)))
+		return
+	}
+	msg := fmt.Sprintf("%s: %s", prefixMsg, fmt.Sprintf(format, a...))
+	switch lvl {
+	case "DEBUG":
+		l.Debug(msg)
+	case "INFO":
+		l.Info(msg)
+	case "WARN":
+		l.Warn(msg)
+	case "ERROR":
+		l.Error(msg)
+	default:
+		l.Log(msg)
+	}
 }
 
 type defaultLogger struct{ l *log.Logger }
@@ -127,6 +127,38 @@ func TestLog(t *testing.T) {
 	})
 }
 
+// testLeveledLogger implements a mock LeveledLogger recording the messages
+// along with their level.
+type testLeveledLogger struct {
+	testLogger
+}
+
+func (tp *testLeveledLogger) Debug(msg string) { tp.Log("debug|" + msg) }
+func (tp *testLeveledLogger) Info(msg string)  { tp.Log("info|" + msg) }
+func (tp *testLeveledLogger) Warn(msg string)  { tp.Log("warn|" + msg) }
+func (tp *testLeveledLogger) Error(msg string) { tp.Log("error|" + msg) }
+
+func TestLeveledLogger(t *testing.T) {
+	defer func(old Logger) { UseLogger(old) }(logger)
+	tp := &testLeveledLogger{}
+	UseLogger(tp)
+	defer func(old Level) { level = old }(level)
+	SetLevel(LevelDebug)
+
+	Debug("message %d", 1)
+	Info("message %d", 2)
+	Warn("message %d", 3)
+	Error("message %d", 4)
+	Flush()
+
+	lines := tp.Lines()
+	assert.Len(t, lines, 4)
+	assert.Equal(t, "debug|"+prefixMsg+": message 1", lines[0])
+	assert.Equal(t, "info|"+prefixMsg+": message 2", lines[1])
+	assert.Equal(t, "warn|"+prefixMsg+": message 3", lines[2])
+	assert.True(t, strings.HasPrefix(lines[3], "error|"+prefixMsg+": message 4"), lines[3])
+}
+
 func BenchmarkError(b *testing.B) {
 	Error("k %s", "a") // warm up cache
 	for i := 0; i < b.N; i++ {